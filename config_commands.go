@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/jaxxstorm/portal/internal/config"
+	"github.com/jaxxstorm/portal/internal/startup"
+	"github.com/jaxxstorm/portal/internal/tailscale"
+)
+
+// runCommand runs a portal subcommand and returns the process exit code.
+func runCommand(cfg *config.Config) int {
+	switch cfg.Command {
+	case config.CommandStatus:
+		return runStatus(cfg)
+	case config.CommandStop:
+		return runStop(cfg)
+	case config.CommandLogs:
+		return runLogs(cfg)
+	case config.CommandCtl:
+		return runCtl(cfg)
+	case config.CommandConfigShow:
+		if err := config.Show(os.Stdout, cfg); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			return 1
+		}
+	case config.CommandConfigInit:
+		path := cfg.CommandArg
+		if path == "" {
+			path = cfg.ConfigFile
+		}
+		if err := config.WriteStarterConfig(path, cfg.Force); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			return 1
+		}
+		fmt.Printf("Wrote %s\n", path)
+	case config.CommandConfigValidate:
+		return validateConfigFiles(cfg)
+	case config.CommandConfigExplain:
+		daemonAvailable := false
+		if !cfg.ForceTsnet && cfg.AuthKey == "" {
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			daemonAvailable = tailscale.NewClient(zap.NewNop()).IsAvailable(ctx)
+			cancel()
+		}
+		useLocalDaemon := daemonAvailable && !cfg.ForceTsnet && cfg.AuthKey == ""
+		if err := startup.Explain(os.Stdout, cfg, useLocalDaemon, startup.BackendReason(cfg, daemonAvailable)); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			return 1
+		}
+	default:
+		fmt.Fprintf(os.Stderr, "Error: unknown command %q\n", cfg.Command)
+		return 1
+	}
+	return 0
+}
+
+// validateConfigFiles checks the file named on the command line, or else the
+// config files portal would read, and prints every problem found.
+func validateConfigFiles(cfg *config.Config) int {
+	var paths []string
+	switch {
+	case cfg.CommandArg != "":
+		paths = []string{cfg.CommandArg}
+	default:
+		if _, err := os.Stat(cfg.ConfigFile); err == nil {
+			paths = append(paths, cfg.ConfigFile)
+		}
+		if cfg.ProjectConfig != "" {
+			paths = append(paths, cfg.ProjectConfig)
+		}
+	}
+	if len(paths) == 0 {
+		fmt.Printf("No config files found (looked for %s and %s)\n", cfg.ConfigFile, config.ProjectConfigName)
+		return 0
+	}
+
+	code := 0
+	for _, path := range paths {
+		problems := config.ValidateFile(path)
+		if len(problems) == 0 {
+			fmt.Printf("%s: ok\n", path)
+			continue
+		}
+		code = 1
+		for _, problem := range problems {
+			fmt.Printf("%s: %v\n", path, problem)
+		}
+	}
+	return code
+}
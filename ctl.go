@@ -0,0 +1,232 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/jaxxstorm/portal/internal/config"
+	"github.com/jaxxstorm/portal/internal/control"
+	"github.com/jaxxstorm/portal/internal/logging"
+	"github.com/jaxxstorm/portal/internal/proxy"
+	"github.com/jaxxstorm/portal/internal/session"
+	"github.com/jaxxstorm/portal/internal/startup"
+	"github.com/jaxxstorm/portal/internal/tailscale"
+)
+
+// ctlTimeout bounds a portal ctl request. Changing Funnel waits on
+// tailscaled, so it is not instant.
+const ctlTimeout = 30 * time.Second
+
+// controlPortal is the running portal as the control API drives it.
+type controlPortal struct {
+	*proxy.Server
+	registration *session.Registration
+	cancel       context.CancelFunc
+
+	// serveClient set up tailscale serve through the local daemon. It is
+	// nil until then, and with tsnet.
+	serveClient atomic.Pointer[tailscale.Client]
+	// funnelSourceMode is the source mode Funnel access control needs, as
+	// chosen for a portal started with --funnel.
+	funnelSourceMode proxy.SourceMode
+}
+
+// SetFunnel changes Funnel on the node's serve config and in the proxy.
+// Funnel access control is switched on before Funnel opens and off after
+// it closes, so Funnel traffic is never let through unchecked.
+func (p *controlPortal) SetFunnel(ctx context.Context, enabled bool) error {
+	client := p.serveClient.Load()
+	if client == nil {
+		return errors.New("funnel can only be changed when portal serves through the local tailscaled; restart portal to change it")
+	}
+	if enabled == p.FunnelEnabled() {
+		return nil
+	}
+	// The proxy listener and serve entry are set up for one source mode at
+	// startup; access control enforced with another could trust the wrong
+	// source IP.
+	if enabled && p.FunnelAccessControlled() && p.SourceMode() != p.funnelSourceMode {
+		return fmt.Errorf("funnel access control needs source mode %s but portal started with %s; restart portal with --funnel", p.funnelSourceMode, p.SourceMode())
+	}
+
+	if enabled {
+		p.SetFunnelEnabled(true)
+	}
+	if err := client.SetFunnel(ctx, enabled); err != nil {
+		p.SetFunnelEnabled(!enabled)
+		return err
+	}
+	p.SetFunnelEnabled(enabled)
+
+	state := p.GetEndpointState()
+	state.Exposure = startup.ExposureTailnet
+	if enabled {
+		state.Exposure = startup.ExposureFunnel
+	}
+	p.SetEndpointState(state)
+	return nil
+}
+
+// SetTargetPort retargets the proxy and updates the session record.
+func (p *controlPortal) SetTargetPort(port int) error {
+	if err := p.Server.SetTargetPort(port); err != nil {
+		return err
+	}
+	p.recordTarget()
+	return nil
+}
+
+// SetMock switches mock mode and updates the session record.
+func (p *controlPortal) SetMock(enabled bool) error {
+	if err := p.Server.SetMock(enabled); err != nil {
+		return err
+	}
+	p.recordTarget()
+	return nil
+}
+
+// recordTarget saves the new target so portal status shows it straight
+// away. A failed save is retried by the next refresh.
+func (p *controlPortal) recordTarget() {
+	if p.registration == nil {
+		return
+	}
+	_ = p.registration.Update(func(record *session.Record) {
+		record.Target = sessionTarget(p.Target())
+	})
+}
+
+// Shutdown stops portal as a signal would.
+func (p *controlPortal) Shutdown() {
+	p.cancel()
+}
+
+// serveControl serves the control API on the session's socket, and returns
+// a function that stops it. portal runs without the API if the session is
+// not registered or the socket cannot be created.
+func (p *controlPortal) serveControl(logger *zap.Logger) func() {
+	if p.registration == nil {
+		return func() {}
+	}
+
+	server := control.NewServer(p, logger)
+	socket := p.registration.ControlSocket()
+	if err := server.Listen(socket); err != nil {
+		logger.Warn("Control API is not available",
+			logging.Component("control_api"),
+			logging.Error(err),
+		)
+		return func() {}
+	}
+	if err := p.registration.Update(func(record *session.Record) {
+		record.ControlSocket = socket
+	}); err != nil {
+		logger.Warn("Failed to update session record",
+			logging.Component("session_registry"),
+			logging.Error(err),
+		)
+	}
+	logger.Debug("Control API listening",
+		logging.Component("control_api"),
+		zap.String("socket", socket),
+	)
+
+	return func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := server.Close(ctx); err != nil {
+			logger.Warn("Failed to stop control API",
+				logging.Component("control_api"),
+				logging.Error(err),
+			)
+		}
+	}
+}
+
+// runCtl sends one action to a running portal's control API, prints the
+// JSON result, and returns the process exit code.
+func runCtl(cfg *config.Config) int {
+	record, code := findSession(cfg.CommandArg)
+	if record == nil {
+		return code
+	}
+	if record.ControlSocket == "" {
+		fmt.Fprintf(os.Stderr, "Error: %s (pid %d) has no control API\n", sessionLabel(record), record.PID)
+		return 1
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), ctlTimeout)
+	defer cancel()
+
+	result, err := ctlAction(ctx, control.NewClient(record.ControlSocket), cfg.CommandArgs)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	if result == nil {
+		return 0
+	}
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(result); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+// ctlAction runs the action named by args[0], with its value in args[1],
+// and returns what to print, if anything.
+func ctlAction(ctx context.Context, client *control.Client, args []string) (any, error) {
+	action, value := args[0], ""
+	if len(args) > 1 {
+		value = args[1]
+	}
+	takesValue := action == "target" || action == "mock" || action == "funnel"
+	if takesValue && value == "" {
+		return nil, fmt.Errorf("%s needs a value", action)
+	}
+	if !takesValue && value != "" {
+		return nil, fmt.Errorf("%s takes no value", action)
+	}
+
+	switch action {
+	case "state":
+		return client.State(ctx)
+	case "captures":
+		return client.Captures(ctx)
+	case "clear-captures":
+		return nil, client.ClearCaptures(ctx)
+	case "target":
+		port, err := strconv.Atoi(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid port %q: must be a positive integer", value)
+		}
+		return client.SetTargetPort(ctx, port)
+	case "mock", "funnel":
+		var enabled bool
+		switch value {
+		case "on":
+			enabled = true
+		case "off":
+		default:
+			return nil, fmt.Errorf("invalid %s value %q: must be on or off", action, value)
+		}
+		if action == "mock" {
+			return client.SetMock(ctx, enabled)
+		}
+		return client.SetFunnel(ctx, enabled)
+	case "shutdown":
+		return nil, client.Shutdown(ctx)
+	default:
+		return nil, fmt.Errorf("unknown action %q: use state, captures, clear-captures, target, mock, funnel or shutdown", action)
+	}
+}
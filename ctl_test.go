@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"go.uber.org/zap"
+
+	"github.com/jaxxstorm/portal/internal/access"
+	"github.com/jaxxstorm/portal/internal/control"
+	"github.com/jaxxstorm/portal/internal/model"
+	"github.com/jaxxstorm/portal/internal/proxy"
+	"github.com/jaxxstorm/portal/internal/tailscale"
+)
+
+func TestCtlActionRejectsBadArguments(t *testing.T) {
+	// Arguments are checked before the socket is dialled.
+	client := control.NewClient("/nonexistent/portal.sock")
+	tests := []struct {
+		args []string
+		want string
+	}{
+		{[]string{"restart"}, "unknown action"},
+		{[]string{"target"}, "needs a value"},
+		{[]string{"target", "http"}, "invalid port"},
+		{[]string{"funnel", "yes"}, "must be on or off"},
+		{[]string{"state", "now"}, "takes no value"},
+	}
+	for _, tt := range tests {
+		t.Run(strings.Join(tt.args, " "), func(t *testing.T) {
+			if _, err := ctlAction(t.Context(), client, tt.args); err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Fatalf("expected an error containing %q, got %v", tt.want, err)
+			}
+		})
+	}
+}
+
+func TestControlPortalFunnelNeedsLocalDaemon(t *testing.T) {
+	portal := &controlPortal{
+		Server: proxy.NewServer(proxy.Config{Mode: model.ModeMock, UseTUI: true, Logger: zap.NewNop()}),
+		cancel: func() {},
+	}
+	if err := portal.SetFunnel(context.Background(), true); err == nil || !strings.Contains(err.Error(), "local tailscaled") {
+		t.Fatalf("expected funnel to need the local daemon, got %v", err)
+	}
+	if portal.FunnelEnabled() {
+		t.Fatal("expected funnel access control to stay off")
+	}
+}
+
+func TestControlPortalFunnelRefusesStartupSourceModeMismatch(t *testing.T) {
+	allowlist, err := access.ParseEntry("203.0.113.0/24")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A root-path portal started tailnet-only proxies in serve mode, but
+	// --funnel with an allowlist would have used PROXY protocol.
+	portal := &controlPortal{
+		Server: proxy.NewServer(proxy.Config{
+			Mode:            model.ModeMock,
+			UseTUI:          true,
+			Logger:          zap.NewNop(),
+			FunnelAllowlist: []access.Entry{allowlist},
+			SourceMode:      proxy.SourceModeServe,
+		}),
+		cancel:           func() {},
+		funnelSourceMode: proxy.SourceModeProxyProtocol,
+	}
+	portal.serveClient.Store(new(tailscale.Client))
+
+	if err := portal.SetFunnel(context.Background(), true); err == nil || !strings.Contains(err.Error(), "restart portal with --funnel") {
+		t.Fatalf("expected funnel to be refused, got %v", err)
+	}
+	if portal.FunnelEnabled() {
+		t.Fatal("expected funnel access control to stay off")
+	}
+}
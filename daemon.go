@@ -0,0 +1,369 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/jaxxstorm/portal/internal/config"
+	"github.com/jaxxstorm/portal/internal/control"
+	"github.com/jaxxstorm/portal/internal/model"
+	"github.com/jaxxstorm/portal/internal/process"
+	"github.com/jaxxstorm/portal/internal/session"
+)
+
+const (
+	// backgroundEnv marks the portal that portal start runs in the
+	// background, so that it serves instead of starting another.
+	backgroundEnv = "PORTAL_START_CHILD"
+
+	// startTimeout is how long portal start waits for the URL.
+	startTimeout = 2 * time.Minute
+	// stopTimeout is how long portal stop waits for a portal to exit.
+	stopTimeout = 15 * time.Second
+)
+
+// runningInBackground reports whether this process was started by portal
+// start.
+func runningInBackground() bool {
+	return os.Getenv(backgroundEnv) != ""
+}
+
+// sessionName is the name portal start gives a session when --name is not
+// set: the profile, "mock", or the port.
+func sessionName(cfg *config.Config) string {
+	switch {
+	case cfg.Name != "":
+		return cfg.Name
+	case cfg.Profile != "":
+		return cfg.Profile
+	case cfg.Mock:
+		return "mock"
+	default:
+		return strconv.Itoa(cfg.Port)
+	}
+}
+
+// runStart runs portal again with the same arguments as a detached process
+// without the TUI, waits until its URL is ready, and returns the process exit
+// code.
+func runStart(cfg *config.Config) int {
+	name := sessionName(cfg)
+	if err := config.ValidateName(name); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v; set one with --name\n", err)
+		return 1
+	}
+
+	dir, err := session.DefaultDir()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	if existing, err := session.Find(dir, name); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	} else if existing != nil {
+		fmt.Fprintf(os.Stderr, "Error: name %q is already used by a running portal (pid %d); pick another with --name\n", name, existing.PID)
+		return 1
+	}
+
+	logFile, err := startLogFile(cfg, name)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	// Errors printed before logging is set up land in the log file too.
+	stderr, err := os.OpenFile(logFile, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: failed to open log file: %v\n", err)
+		return 1
+	}
+	defer stderr.Close()
+
+	executable, err := os.Executable()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: failed to find the portal executable: %v\n", err)
+		return 1
+	}
+	// Flags win over the environment, so any the user passed still apply.
+	cmd := exec.Command(executable, os.Args[1:]...)
+	cmd.Env = append(os.Environ(),
+		backgroundEnv+"=1",
+		"PORTAL_NAME="+name,
+		"PORTAL_NO_TUI=true",
+		"PORTAL_LOG_FILE="+logFile,
+	)
+	cmd.Stderr = stderr
+	process.Detach(cmd)
+	if err := cmd.Start(); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: failed to start portal: %v\n", err)
+		return 1
+	}
+	pid := cmd.Process.Pid
+
+	exited := make(chan error, 1)
+	go func() { exited <- cmd.Wait() }()
+
+	fmt.Printf("Starting %s (pid %d)...\n", name, pid)
+	record, err := waitForReady(dir, pid, exited, startTimeout)
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		fmt.Fprintf(os.Stderr, "Error: %s is not ready after %s and is still starting; see portal logs %s\n", name, startTimeout, name)
+		return 1
+	case err != nil:
+		fmt.Fprintf(os.Stderr, "Error: %s exited before it was ready (%v); see portal logs %s\n", name, err, name)
+		return 1
+	case record.Readiness == model.EndpointReadinessFailed:
+		_ = process.Terminate(pid)
+		fmt.Fprintf(os.Stderr, "Error: %s failed to start: %s; see portal logs %s\n", name, record.Error, name)
+		return 1
+	}
+
+	fmt.Printf("Started %s (pid %d)\n", name, pid)
+	fmt.Printf("  URL:    %s\n", record.URL)
+	if record.WebUIURL != "" {
+		fmt.Printf("  Web UI: %s\n", record.WebUIURL)
+	}
+	fmt.Printf("  Logs:   portal logs %s\n", name)
+	fmt.Printf("  Stop:   portal stop %s\n", name)
+	return 0
+}
+
+// startLogFile returns the log file for a background portal, emptying the
+// default one so that portal logs shows only the current run. A --log-file
+// chosen by the user is left as it is.
+func startLogFile(cfg *config.Config, name string) (string, error) {
+	if cfg.LogFile != "" {
+		return filepath.Abs(cfg.LogFile)
+	}
+
+	dir, err := session.DefaultLogDir()
+	if err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return "", fmt.Errorf("failed to create log directory %s: %w", dir, err)
+	}
+	path := filepath.Join(dir, name+".log")
+	file, err := os.Create(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to create log file %s: %w", path, err)
+	}
+	return path, file.Close()
+}
+
+// waitForReady polls the session registry until the portal with pid is
+// ready or has failed. It returns the process's exit error if it exits
+// first, and context.DeadlineExceeded if timeout passes.
+func waitForReady(dir string, pid int, exited <-chan error, timeout time.Duration) (*session.Record, error) {
+	ticker := time.NewTicker(200 * time.Millisecond)
+	defer ticker.Stop()
+	deadline := time.After(timeout)
+
+	for {
+		select {
+		case err := <-exited:
+			if err == nil {
+				err = errors.New("exit status 0")
+			}
+			return nil, err
+		case <-deadline:
+			return nil, context.DeadlineExceeded
+		case <-ticker.C:
+		}
+
+		records, err := session.List(dir)
+		if err != nil {
+			return nil, err
+		}
+		for _, record := range records {
+			if record.PID != pid {
+				continue
+			}
+			if record.Readiness == model.EndpointReadinessReady || record.Readiness == model.EndpointReadinessFailed {
+				return &record, nil
+			}
+		}
+	}
+}
+
+// runStop stops a running portal and waits for it to exit, and returns the
+// process exit code. The portal is asked to shut down through its control
+// API, which also proves the PID is still that portal's; a portal without
+// one is not stopped, since its PID may now belong to another process.
+func runStop(cfg *config.Config) int {
+	record, code := findSession(cfg.CommandArg)
+	if record == nil {
+		return code
+	}
+	label := sessionLabel(record)
+
+	if record.ControlSocket == "" {
+		fmt.Fprintf(os.Stderr, "Error: %s (pid %d) has no control API, so portal cannot confirm the process is a portal; stop it where it runs\n", label, record.PID)
+		return 1
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	err := control.NewClient(record.ControlSocket).Shutdown(ctx)
+	cancel()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: failed to stop %s (pid %d): %v\n", label, record.PID, err)
+		return 1
+	}
+
+	deadline := time.Now().Add(stopTimeout)
+	for process.Alive(record.PID) {
+		if time.Now().After(deadline) {
+			fmt.Fprintf(os.Stderr, "Error: %s (pid %d) did not exit within %s\n", label, record.PID, stopTimeout)
+			return 1
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	fmt.Printf("Stopped %s (pid %d)\n", label, record.PID)
+	return 0
+}
+
+// findSession returns the record of the running portal called name, or
+// with that PID when no portal has the name, so unnamed portals can be
+// reached too. When there is none it prints why and returns the exit code
+// to use.
+func findSession(name string) (*session.Record, int) {
+	if err := config.ValidateName(name); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return nil, 1
+	}
+	dir, err := session.DefaultDir()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return nil, 1
+	}
+	record, err := session.Find(dir, name)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return nil, 1
+	}
+	if record == nil {
+		if pid, err := strconv.Atoi(name); err == nil {
+			records, err := session.List(dir)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+				return nil, 1
+			}
+			for _, candidate := range records {
+				if candidate.PID == pid {
+					return &candidate, 0
+				}
+			}
+		}
+		fmt.Fprintf(os.Stderr, "Error: no running portal named %q; see portal status\n", name)
+		return nil, 1
+	}
+	return record, 0
+}
+
+// sessionLabel is how messages refer to a running portal.
+func sessionLabel(record *session.Record) string {
+	if record.Name == "" {
+		return "portal"
+	}
+	return record.Name
+}
+
+// runLogs prints the log file of the portal called name, following it if
+// asked, and returns the process exit code. The log of a portal that has
+// exited is still printed if portal start wrote it to the default place.
+func runLogs(cfg *config.Config) int {
+	name := cfg.CommandArg
+	if err := config.ValidateName(name); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	dir, err := session.DefaultDir()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	record, err := session.Find(dir, name)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+
+	var path string
+	alive := func() bool { return false }
+	if record != nil {
+		if record.LogFile == "" {
+			fmt.Fprintf(os.Stderr, "Error: %s (pid %d) has no log file; run it with portal start or --log-file\n", name, record.PID)
+			return 1
+		}
+		path = record.LogFile
+		alive = record.Live
+	} else {
+		logDir, err := session.DefaultLogDir()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			return 1
+		}
+		path = filepath.Join(logDir, name+".log")
+		if _, err := os.Stat(path); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: no running portal named %q and no log file at %s\n", name, path)
+			return 1
+		}
+	}
+
+	if !cfg.Follow {
+		alive = func() bool { return false }
+	}
+	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer cancel()
+	if err := followLog(ctx, path, os.Stdout, alive, 250*time.Millisecond); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+// followLog copies path to w, then keeps copying what is appended every
+// interval while alive reports true and ctx is not done. A file that
+// shrinks, because the portal was started again, is read from the start.
+func followLog(ctx context.Context, path string, w io.Writer, alive func() bool, interval time.Duration) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to open log file: %w", err)
+	}
+	defer file.Close()
+
+	var offset int64
+	for {
+		// Checked before reading, so that lines written just before the
+		// portal exited are still printed.
+		running := alive()
+
+		if info, err := file.Stat(); err == nil && info.Size() < offset {
+			if offset, err = file.Seek(0, io.SeekStart); err != nil {
+				return fmt.Errorf("failed to read log file: %w", err)
+			}
+		}
+		n, err := io.Copy(w, file)
+		offset += n
+		if err != nil {
+			return fmt.Errorf("failed to read log file: %w", err)
+		}
+
+		if !running {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(interval):
+		}
+	}
+}
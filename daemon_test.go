@@ -0,0 +1,90 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/jaxxstorm/portal/internal/config"
+)
+
+func TestSessionName(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  config.Config
+		want string
+	}{
+		{"flag", config.Config{Name: "api", Profile: "web", Port: 8080}, "api"},
+		{"profile", config.Config{Profile: "web", Port: 8080}, "web"},
+		{"mock", config.Config{Mock: true}, "mock"},
+		{"port", config.Config{Port: 8080}, "8080"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := sessionName(&tt.cfg); got != tt.want {
+				t.Fatalf("sessionName() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFollowLogPrintsAppendedLinesUntilExit(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "api.log")
+	if err := os.WriteFile(path, []byte("first\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	// The portal writes a line and exits on the second check.
+	checks := 0
+	alive := func() bool {
+		checks++
+		if checks == 2 {
+			file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			file.WriteString("second\n")
+			file.Close()
+			return false
+		}
+		return true
+	}
+
+	var out bytes.Buffer
+	if err := followLog(t.Context(), path, &out, alive, time.Millisecond); err != nil {
+		t.Fatalf("followLog failed: %v", err)
+	}
+	if out.String() != "first\nsecond\n" {
+		t.Fatalf("expected both lines, got %q", out.String())
+	}
+}
+
+func TestFollowLogRestartsTruncatedFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "api.log")
+	if err := os.WriteFile(path, []byte("old run, longer line\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	checks := 0
+	alive := func() bool {
+		checks++
+		if checks == 2 {
+			// portal start empties the log for a new run.
+			if err := os.WriteFile(path, []byte("new run\n"), 0o600); err != nil {
+				t.Fatal(err)
+			}
+			return false
+		}
+		return true
+	}
+
+	var out bytes.Buffer
+	if err := followLog(t.Context(), path, &out, alive, time.Millisecond); err != nil {
+		t.Fatalf("followLog failed: %v", err)
+	}
+	if out.String() != "old run, longer line\nnew run\n" {
+		t.Fatalf("expected the new run to be read from the start, got %q", out.String())
+	}
+}
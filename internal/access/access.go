@@ -0,0 +1,153 @@
+// Package access matches request sources against allowlist and denylist
+// entries: IP addresses, CIDR blocks, countries, autonomous systems, and sets
+// of these loaded from files or bundled presets.
+package access
+
+import (
+	"fmt"
+	"net/netip"
+	"strconv"
+	"strings"
+
+	"github.com/jaxxstorm/portal/internal/model"
+)
+
+// Entry prefixes for GeoIP matches.
+const (
+	countryPrefix = "country:"
+	asnPrefix     = "asn:"
+)
+
+// Entry matches sources by exactly one of an IP prefix, a country, an ASN or
+// a set.
+type Entry struct {
+	Prefix  netip.Prefix
+	Country string // ISO 3166-1 alpha-2 code, upper case
+	ASN     uint
+	Set     *Set
+}
+
+// ParseEntry parses an IP address, a CIDR block, country:<code>,
+// asn:<number>, file:<path>[#key] or preset:<name>. A bare address matches
+// only that address. File and preset entries are loaded immediately.
+func ParseEntry(value string) (Entry, error) {
+	value = strings.TrimSpace(value)
+	lower := strings.ToLower(value)
+
+	switch {
+	case strings.HasPrefix(lower, filePrefix):
+		set, err := LoadFile(value[len(filePrefix):])
+		if err != nil {
+			return Entry{}, err
+		}
+		return Entry{Set: set}, nil
+	case strings.HasPrefix(lower, presetPrefix):
+		set, err := LoadPreset(value[len(presetPrefix):])
+		if err != nil {
+			return Entry{}, err
+		}
+		return Entry{Set: set}, nil
+	}
+	return parseStaticEntry(value)
+}
+
+// parseStaticEntry parses every entry form except sets.
+func parseStaticEntry(value string) (Entry, error) {
+	value = strings.TrimSpace(value)
+	lower := strings.ToLower(value)
+
+	switch {
+	case strings.HasPrefix(lower, countryPrefix):
+		code := strings.ToUpper(strings.TrimSpace(value[len(countryPrefix):]))
+		if len(code) != 2 || !isLetters(code) {
+			return Entry{}, fmt.Errorf("country must be a two-letter ISO code")
+		}
+		return Entry{Country: code}, nil
+	case strings.HasPrefix(lower, asnPrefix):
+		number := strings.TrimPrefix(strings.ToUpper(strings.TrimSpace(value[len(asnPrefix):])), "AS")
+		asn, err := strconv.ParseUint(number, 10, 32)
+		if err != nil || asn == 0 {
+			return Entry{}, fmt.Errorf("asn must be a positive number such as 13335 or AS13335")
+		}
+		return Entry{ASN: uint(asn)}, nil
+	case strings.Contains(value, "/"):
+		prefix, err := netip.ParsePrefix(value)
+		if err != nil {
+			return Entry{}, err
+		}
+		return Entry{Prefix: prefix.Masked()}, nil
+	}
+
+	addr, err := netip.ParseAddr(value)
+	if err != nil {
+		return Entry{}, err
+	}
+	addr = addr.Unmap()
+	return Entry{Prefix: netip.PrefixFrom(addr, addr.BitLen())}, nil
+}
+
+// NeedsGeo reports whether the entry matches on GeoIP data.
+func (e Entry) NeedsGeo() bool {
+	if e.Set != nil {
+		return NeedsGeo(e.Set.Entries())
+	}
+	return e.Country != "" || e.ASN != 0
+}
+
+// Matches reports whether the source addr, with GeoIP data geo (nil when
+// unknown), matches the entry.
+func (e Entry) Matches(addr netip.Addr, geo *model.GeoInfo) bool {
+	switch {
+	case e.Set != nil:
+		return e.Set.match(addr, geo)
+	case e.Country != "":
+		return geo != nil && geo.Country == e.Country
+	case e.ASN != 0:
+		return geo != nil && geo.ASN == e.ASN
+	default:
+		return e.Prefix.Contains(addr)
+	}
+}
+
+// String formats the entry as ParseEntry accepts it.
+func (e Entry) String() string {
+	switch {
+	case e.Set != nil:
+		return e.Set.String()
+	case e.Country != "":
+		return countryPrefix + e.Country
+	case e.ASN != 0:
+		return asnPrefix + strconv.FormatUint(uint64(e.ASN), 10)
+	default:
+		return e.Prefix.String()
+	}
+}
+
+// Match returns the first entry that matches the source.
+func Match(entries []Entry, addr netip.Addr, geo *model.GeoInfo) (Entry, bool) {
+	for _, entry := range entries {
+		if entry.Matches(addr, geo) {
+			return entry, true
+		}
+	}
+	return Entry{}, false
+}
+
+// NeedsGeo reports whether any entry matches on GeoIP data.
+func NeedsGeo(entries []Entry) bool {
+	for _, entry := range entries {
+		if entry.NeedsGeo() {
+			return true
+		}
+	}
+	return false
+}
+
+func isLetters(value string) bool {
+	for _, r := range value {
+		if r < 'A' || r > 'Z' {
+			return false
+		}
+	}
+	return true
+}
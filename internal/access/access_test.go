@@ -0,0 +1,103 @@
+package access
+
+import (
+	"net/netip"
+	"testing"
+
+	"github.com/jaxxstorm/portal/internal/model"
+)
+
+func TestParseEntry(t *testing.T) {
+	for input, want := range map[string]string{
+		"203.0.113.10":       "203.0.113.10/32",
+		"198.51.100.7/24":    "198.51.100.0/24",
+		"2001:db8::1":        "2001:db8::1/128",
+		"::ffff:203.0.113.9": "203.0.113.9/32",
+		"country:nl":         "country:NL",
+		" Country: US ":      "country:US",
+		"asn:13335":          "asn:13335",
+		"ASN:AS64500":        "asn:64500",
+	} {
+		entry, err := ParseEntry(input)
+		if err != nil || entry.String() != want {
+			t.Fatalf("ParseEntry(%q) = %v, %v; want %s", input, entry, err, want)
+		}
+	}
+
+	for _, input := range []string{"", "not-an-ip", "203.0.113.0/40", "country:USA", "country:1A", "asn:0", "asn:cloudflare"} {
+		if _, err := ParseEntry(input); err == nil {
+			t.Fatalf("expected ParseEntry(%q) to fail", input)
+		}
+	}
+}
+
+func TestMatch(t *testing.T) {
+	entries := []Entry{
+		{Prefix: netip.MustParsePrefix("203.0.113.0/24")},
+		{Country: "NL"},
+		{ASN: 64500},
+	}
+	addr := netip.MustParseAddr("198.51.100.4")
+
+	if entry, ok := Match(entries, netip.MustParseAddr("203.0.113.10"), nil); !ok || entry.Prefix.Bits() != 24 {
+		t.Fatalf("expected prefix match, got %v %v", entry, ok)
+	}
+	if _, ok := Match(entries, addr, nil); ok {
+		t.Fatal("expected GeoIP entries not to match without GeoIP data")
+	}
+	if entry, ok := Match(entries, addr, &model.GeoInfo{Country: "NL"}); !ok || entry.Country != "NL" {
+		t.Fatalf("expected country match, got %v %v", entry, ok)
+	}
+	if entry, ok := Match(entries, addr, &model.GeoInfo{Country: "US", ASN: 64500}); !ok || entry.ASN != 64500 {
+		t.Fatalf("expected ASN match, got %v %v", entry, ok)
+	}
+	if !NeedsGeo(entries) || NeedsGeo(entries[:1]) {
+		t.Fatal("expected NeedsGeo only when country or ASN entries are present")
+	}
+}
+
+func TestRuleAppliesAndDecides(t *testing.T) {
+	github := Rule{
+		Name:       "github",
+		PathPrefix: "/hooks/github",
+		Methods:    []string{"POST"},
+		Allow:      []Entry{{Prefix: netip.MustParsePrefix("192.30.252.0/22")}},
+		Deny:       []Entry{{Prefix: netip.MustParsePrefix("192.30.252.7/32")}},
+		Default:    ActionDeny,
+	}
+	admin := Rule{Name: "admin", PathPrefix: "/admin", Default: ActionDeny}
+	rules := []Rule{github, admin}
+
+	for _, tc := range []struct {
+		method, path string
+		want         string
+	}{
+		{"POST", "/hooks/github", "github"},
+		{"post", "/hooks/github/push", "github"},
+		{"GET", "/hooks/github", ""},
+		{"POST", "/hooks/githubx", ""},
+		{"GET", "/admin/users", "admin"},
+		{"GET", "/", ""},
+	} {
+		rule, ok := MatchRule(rules, tc.method, tc.path)
+		if rule.Name != tc.want || ok != (tc.want != "") {
+			t.Fatalf("MatchRule(%s %s) = %q, %v; want %q", tc.method, tc.path, rule.Name, ok, tc.want)
+		}
+	}
+
+	if action, entry, matched := github.Decide(netip.MustParseAddr("192.30.252.10"), nil); action != ActionAllow || !matched || entry.Prefix.Bits() != 22 {
+		t.Fatalf("expected allow entry match, got %s %v %v", action, entry, matched)
+	}
+	if action, _, matched := github.Decide(netip.MustParseAddr("192.30.252.7"), nil); action != ActionDeny || !matched {
+		t.Fatalf("expected deny entry to win over allow entry, got %s %v", action, matched)
+	}
+	if action, _, matched := github.Decide(netip.MustParseAddr("203.0.113.1"), nil); action != ActionDeny || matched {
+		t.Fatalf("expected default deny, got %s %v", action, matched)
+	}
+	if action, _, matched := github.Decide(netip.Addr{}, nil); action != ActionDeny || matched {
+		t.Fatalf("expected unresolved source to fall to the default, got %s %v", action, matched)
+	}
+	if !(Rule{Deny: []Entry{{Country: "NL"}}}).NeedsGeo() || github.NeedsGeo() {
+		t.Fatal("expected NeedsGeo only for rules with country or ASN entries")
+	}
+}
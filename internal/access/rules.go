@@ -0,0 +1,68 @@
+package access
+
+import (
+	"net/netip"
+	"slices"
+	"strings"
+
+	"github.com/jaxxstorm/portal/internal/model"
+)
+
+// Rule actions.
+const (
+	ActionAllow = "allow"
+	ActionDeny  = "deny"
+)
+
+// Rule scopes access control to requests under a path prefix. Within a rule
+// the deny entries are checked first, then the allow entries, and Default
+// decides sources that match neither.
+type Rule struct {
+	Name       string
+	PathPrefix string
+	Methods    []string // upper case; empty matches every method
+	Allow      []Entry
+	Deny       []Entry
+	Default    string // ActionAllow or ActionDeny
+}
+
+// Applies reports whether the rule covers a request. Prefixes match whole
+// path segments, so /hooks/github covers /hooks/github/push but not
+// /hooks/githubx.
+func (r Rule) Applies(method, path string) bool {
+	if len(r.Methods) > 0 && !slices.Contains(r.Methods, strings.ToUpper(method)) {
+		return false
+	}
+	prefix := strings.TrimSuffix(r.PathPrefix, "/")
+	return prefix == "" || path == prefix || strings.HasPrefix(path, prefix+"/")
+}
+
+// Decide returns the rule's action for a source and the entry that decided
+// it. matched is false when the rule's default decided. Entries never match
+// an unresolved source, whose addr is the zero value.
+func (r Rule) Decide(addr netip.Addr, geo *model.GeoInfo) (action string, entry Entry, matched bool) {
+	if addr.IsValid() {
+		if entry, ok := Match(r.Deny, addr, geo); ok {
+			return ActionDeny, entry, true
+		}
+		if entry, ok := Match(r.Allow, addr, geo); ok {
+			return ActionAllow, entry, true
+		}
+	}
+	return r.Default, Entry{}, false
+}
+
+// NeedsGeo reports whether any of the rule's entries match on GeoIP data.
+func (r Rule) NeedsGeo() bool {
+	return NeedsGeo(r.Allow) || NeedsGeo(r.Deny)
+}
+
+// MatchRule returns the first rule that covers a request.
+func MatchRule(rules []Rule, method, path string) (Rule, bool) {
+	for _, rule := range rules {
+		if rule.Applies(method, path) {
+			return rule, true
+		}
+	}
+	return Rule{}, false
+}
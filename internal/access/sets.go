@@ -0,0 +1,194 @@
+package access
+
+import (
+	"bufio"
+	"bytes"
+	"embed"
+	"encoding/json"
+	"fmt"
+	"net/netip"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/jaxxstorm/portal/internal/model"
+)
+
+// Entry prefixes for sets.
+const (
+	filePrefix   = "file:"
+	presetPrefix = "preset:"
+)
+
+//go:embed presets/*.txt
+var presetFiles embed.FS
+
+// Presets returns the names of the bundled presets.
+func Presets() []string {
+	files, _ := presetFiles.ReadDir("presets")
+	names := make([]string, 0, len(files))
+	for _, file := range files {
+		names = append(names, strings.TrimSuffix(file.Name(), ".txt"))
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Set is a group of entries loaded from a local file or a bundled preset.
+// File sets can be reloaded while portal runs.
+type Set struct {
+	source string // as written: file:<path>[#key] or preset:<name>
+	path   string // absolute file path; empty for presets
+	key    string // JSON object key holding the list
+
+	mu      sync.RWMutex
+	entries []Entry
+}
+
+// LoadPreset returns the bundled preset called name.
+func LoadPreset(name string) (*Set, error) {
+	name = strings.ToLower(strings.TrimSpace(name))
+	data, err := presetFiles.ReadFile(path.Join("presets", name+".txt"))
+	if err != nil {
+		return nil, fmt.Errorf("unknown preset %q (available: %s)", name, strings.Join(Presets(), ", "))
+	}
+	entries, err := parseSetData(data, "")
+	if err != nil {
+		return nil, fmt.Errorf("preset %s: %w", name, err)
+	}
+	return &Set{source: presetPrefix + name, entries: entries}, nil
+}
+
+// LoadFile loads a set from a file of one entry per line, with # comments,
+// or from JSON: a list of entries, or an object whose key names the list,
+// as in file:meta.json#hooks for GitHub's meta API.
+func LoadFile(spec string) (*Set, error) {
+	spec = strings.TrimSpace(spec)
+	filePath, key, _ := strings.Cut(spec, "#")
+	if filePath == "" {
+		return nil, fmt.Errorf("file path is required")
+	}
+	absPath, err := filepath.Abs(filePath)
+	if err != nil {
+		return nil, err
+	}
+
+	set := &Set{source: filePrefix + spec, path: absPath, key: key}
+	if err := set.Reload(); err != nil {
+		return nil, err
+	}
+	return set, nil
+}
+
+// Reload re-reads a file set. On error the previous entries are kept.
+// Reloading a preset does nothing.
+func (s *Set) Reload() error {
+	if s.path == "" {
+		return nil
+	}
+	data, err := os.ReadFile(s.path)
+	if err != nil {
+		return err
+	}
+	entries, err := parseSetData(data, s.key)
+	if err != nil {
+		return fmt.Errorf("%s: %w", s.path, err)
+	}
+
+	s.mu.Lock()
+	s.entries = entries
+	s.mu.Unlock()
+	return nil
+}
+
+// Path returns the file a set was loaded from, or "" for presets.
+func (s *Set) Path() string {
+	return s.path
+}
+
+// String returns the set as written in config.
+func (s *Set) String() string {
+	return s.source
+}
+
+// Entries returns the set's current entries.
+func (s *Set) Entries() []Entry {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.entries
+}
+
+func (s *Set) match(addr netip.Addr, geo *model.GeoInfo) bool {
+	_, ok := Match(s.Entries(), addr, geo)
+	return ok
+}
+
+// FileSets returns the file sets among entries, for watching.
+func FileSets(entries []Entry) []*Set {
+	var sets []*Set
+	for _, entry := range entries {
+		if entry.Set != nil && entry.Set.Path() != "" {
+			sets = append(sets, entry.Set)
+		}
+	}
+	return sets
+}
+
+func parseSetData(data []byte, key string) ([]Entry, error) {
+	trimmed := bytes.TrimSpace(data)
+	if len(trimmed) > 0 && (trimmed[0] == '[' || trimmed[0] == '{') {
+		return parseSetJSON(trimmed, key)
+	}
+	if key != "" {
+		return nil, fmt.Errorf("#%s selects a JSON key, but the file is not JSON", key)
+	}
+
+	var entries []Entry
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for line := 1; scanner.Scan(); line++ {
+		value, _, _ := strings.Cut(scanner.Text(), "#")
+		if value = strings.TrimSpace(value); value == "" {
+			continue
+		}
+		entry, err := parseStaticEntry(value)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: invalid entry %q", line, value)
+		}
+		entries = append(entries, entry)
+	}
+	return entries, scanner.Err()
+}
+
+func parseSetJSON(data []byte, key string) ([]Entry, error) {
+	var values []string
+	if key == "" {
+		if err := json.Unmarshal(data, &values); err != nil {
+			return nil, fmt.Errorf("JSON must be a list of entries, or select an object key with #<key>: %w", err)
+		}
+	} else {
+		var object map[string]json.RawMessage
+		if err := json.Unmarshal(data, &object); err != nil {
+			return nil, fmt.Errorf("#%s selects a key, but the JSON is not an object: %w", key, err)
+		}
+		raw, ok := object[key]
+		if !ok {
+			return nil, fmt.Errorf("JSON object has no %q key", key)
+		}
+		if err := json.Unmarshal(raw, &values); err != nil {
+			return nil, fmt.Errorf("JSON key %q must be a list of entries: %w", key, err)
+		}
+	}
+
+	entries := make([]Entry, 0, len(values))
+	for _, value := range values {
+		entry, err := parseStaticEntry(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid entry %q", value)
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
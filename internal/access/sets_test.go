@@ -0,0 +1,128 @@
+package access
+
+import (
+	"context"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseEntryLoadsFileSets(t *testing.T) {
+	dir := t.TempDir()
+	listPath := filepath.Join(dir, "ranges.txt")
+	writeFile(t, listPath, "# webhook senders\n203.0.113.0/24\n\n198.51.100.7 # single host\n")
+	metaPath := filepath.Join(dir, "meta.json")
+	writeFile(t, metaPath, `{"hooks": ["192.30.252.0/22", "2606:50c0::/32"], "web": ["140.82.112.0/20"]}`)
+
+	for spec, want := range map[string][]string{
+		"file:" + listPath:            {"203.0.113.10", "198.51.100.7"},
+		"file:" + metaPath + "#hooks": {"192.30.252.1", "2606:50c0::1"},
+	} {
+		entry, err := ParseEntry(spec)
+		if err != nil {
+			t.Fatalf("ParseEntry(%q): %v", spec, err)
+		}
+		if entry.String() != spec {
+			t.Fatalf("expected entry to format as %q, got %q", spec, entry.String())
+		}
+		for _, addr := range want {
+			if !entry.Matches(netip.MustParseAddr(addr), nil) {
+				t.Fatalf("expected %s to match %s", spec, addr)
+			}
+		}
+		if entry.Matches(netip.MustParseAddr("140.82.112.1"), nil) {
+			t.Fatalf("expected %s not to match an address outside the set", spec)
+		}
+	}
+
+	for spec, want := range map[string]string{
+		"file:" + filepath.Join(dir, "missing.txt"): "no such file",
+		"file:" + metaPath:                          "select an object key",
+		"file:" + metaPath + "#api":                 `no "api" key`,
+		"file:" + listPath + "#hooks":               "not JSON",
+		"preset:unknown":                            "unknown preset",
+	} {
+		if _, err := ParseEntry(spec); err == nil || !strings.Contains(err.Error(), want) {
+			t.Fatalf("expected ParseEntry(%q) error containing %q, got %v", spec, want, err)
+		}
+	}
+
+	writeFile(t, listPath, "203.0.113.0/24\nnot-an-ip\n")
+	if _, err := ParseEntry("file:" + listPath); err == nil || !strings.Contains(err.Error(), `line 2: invalid entry "not-an-ip"`) {
+		t.Fatalf("expected line-numbered error, got %v", err)
+	}
+}
+
+func TestPresets(t *testing.T) {
+	names := Presets()
+	if strings.Join(names, ",") != "github-hooks,gitlab-webhooks,stripe-webhooks" {
+		t.Fatalf("unexpected presets %v", names)
+	}
+	for _, name := range names {
+		entry, err := ParseEntry("preset:" + name)
+		if err != nil || len(entry.Set.Entries()) == 0 {
+			t.Fatalf("preset %s failed to load: %v", name, err)
+		}
+		if entry.Set.Path() != "" || entry.Set.Reload() != nil {
+			t.Fatalf("expected preset %s to be static", name)
+		}
+	}
+
+	entry, err := ParseEntry("Preset:GitHub-Hooks")
+	if err != nil || !entry.Matches(netip.MustParseAddr("192.30.252.10"), nil) {
+		t.Fatalf("expected github-hooks preset to match a GitHub address, got %v", err)
+	}
+	if len(FileSets([]Entry{entry})) != 0 {
+		t.Fatal("expected presets not to be watched")
+	}
+}
+
+func TestWatchReloadsChangedFiles(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ranges.txt")
+	writeFile(t, path, "203.0.113.0/24\n")
+	entry, err := ParseEntry("file:" + path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	reloaded := make(chan error, 4)
+	if err := Watch(ctx, FileSets([]Entry{entry}), func(_ *Set, err error) { reloaded <- err }); err != nil {
+		t.Fatalf("watch: %v", err)
+	}
+
+	writeFile(t, path, "198.51.100.0/24\n")
+	waitForReload(t, reloaded, false)
+	if !entry.Matches(netip.MustParseAddr("198.51.100.4"), nil) || entry.Matches(netip.MustParseAddr("203.0.113.4"), nil) {
+		t.Fatal("expected reloaded ranges to replace the old ones")
+	}
+
+	writeFile(t, path, "broken\n")
+	waitForReload(t, reloaded, true)
+	if !entry.Matches(netip.MustParseAddr("198.51.100.4"), nil) {
+		t.Fatal("expected a failed reload to keep the previous ranges")
+	}
+}
+
+func waitForReload(t *testing.T, reloaded <-chan error, wantErr bool) {
+	t.Helper()
+	select {
+	case err := <-reloaded:
+		if (err != nil) != wantErr {
+			t.Fatalf("unexpected reload result %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for reload")
+	}
+}
+
+func writeFile(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
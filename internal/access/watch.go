@@ -0,0 +1,26 @@
+package access
+
+import (
+	"context"
+
+	"github.com/jaxxstorm/portal/internal/filewatch"
+)
+
+// Watch reloads file sets whenever their files change, until ctx is done.
+// onReload is called after every reload with its result.
+func Watch(ctx context.Context, sets []*Set, onReload func(set *Set, err error)) error {
+	byPath := make(map[string][]*Set)
+	paths := make([]string, 0, len(sets))
+	for _, set := range sets {
+		if _, ok := byPath[set.Path()]; !ok {
+			paths = append(paths, set.Path())
+		}
+		byPath[set.Path()] = append(byPath[set.Path()], set)
+	}
+
+	return filewatch.Watch(ctx, paths, func(path string) {
+		for _, set := range byPath[path] {
+			onReload(set, set.Reload())
+		}
+	})
+}
@@ -0,0 +1,136 @@
+package blocklist
+
+import (
+	"net/netip"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Auto-ban reasons, also used as metric labels.
+const (
+	ReasonScannerPath = "scanner_path"
+	ReasonNotFound    = "repeated_404"
+)
+
+const (
+	// DefaultNotFoundThreshold and DefaultNotFoundWindow ban a source after
+	// 20 not-found responses within a minute.
+	DefaultNotFoundThreshold = 20
+	DefaultNotFoundWindow    = time.Minute
+
+	// maxNotFoundSources bounds the sources tracked for repeated 404s;
+	// expired counts are swept once it is reached.
+	maxNotFoundSources = 10000
+)
+
+// DefaultScannerPaths are paths probed by vulnerability scanners that clients
+// of a typical app never request.
+var DefaultScannerPaths = []string{
+	"/.env",
+	"/.git/config",
+	"/.git/HEAD",
+	"/.aws/credentials",
+	"/.DS_Store",
+	"/wp-login.php",
+	"/wp-config.php",
+	"/xmlrpc.php",
+	"/phpinfo.php",
+	"/server-status",
+}
+
+// AutoBanConfig controls the auto-ban heuristics.
+type AutoBanConfig struct {
+	ScannerPaths      []string      // path suffixes that ban on first request
+	NotFoundThreshold int           // 404s within NotFoundWindow that ban a source; 0 disables
+	NotFoundWindow    time.Duration // defaults to DefaultNotFoundWindow
+}
+
+// AutoBan decides when a source should be added to the blocklist.
+type AutoBan struct {
+	scannerPaths []string
+	threshold    int
+	window       time.Duration
+	notFound     map[netip.Prefix][]time.Time
+	now          func() time.Time
+	mu           sync.Mutex
+}
+
+// NewAutoBan creates auto-ban heuristics from config.
+func NewAutoBan(config AutoBanConfig) *AutoBan {
+	window := config.NotFoundWindow
+	if window <= 0 {
+		window = DefaultNotFoundWindow
+	}
+	return &AutoBan{
+		scannerPaths: config.ScannerPaths,
+		threshold:    config.NotFoundThreshold,
+		window:       window,
+		notFound:     make(map[netip.Prefix][]time.Time),
+		now:          time.Now,
+	}
+}
+
+// ScannerPath returns the configured scanner path that path ends with.
+// Matching on the suffix also catches probes under a prefix, such as
+// /app/.env.
+func (a *AutoBan) ScannerPath(path string) (string, bool) {
+	for _, scannerPath := range a.scannerPaths {
+		if strings.HasSuffix(path, scannerPath) {
+			return scannerPath, true
+		}
+	}
+	return "", false
+}
+
+// Window returns how far back not-found responses are counted.
+func (a *AutoBan) Window() time.Duration {
+	return a.window
+}
+
+// Threshold returns the number of not-found responses that bans a source.
+func (a *AutoBan) Threshold() int {
+	return a.threshold
+}
+
+// RecordNotFound counts a not-found response to addr and reports whether
+// addr has reached the threshold within the window. Responses are counted
+// per AutoBanPrefix, so an IPv6 client cannot spread them across its /64.
+func (a *AutoBan) RecordNotFound(addr netip.Addr) bool {
+	if a.threshold <= 0 {
+		return false
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	source := AutoBanPrefix(addr)
+	now := a.now()
+	cutoff := now.Add(-a.window)
+	if _, ok := a.notFound[source]; !ok && len(a.notFound) >= maxNotFoundSources {
+		a.sweep(cutoff)
+	}
+
+	recent := a.notFound[source]
+	for len(recent) > 0 && !recent[0].After(cutoff) {
+		recent = recent[1:]
+	}
+	recent = append(recent, now)
+
+	if len(recent) >= a.threshold {
+		delete(a.notFound, source)
+		return true
+	}
+	a.notFound[source] = recent
+	return false
+}
+
+// sweep drops sources with no not-found responses after cutoff. Callers
+// hold a.mu.
+func (a *AutoBan) sweep(cutoff time.Time) {
+	for source, times := range a.notFound {
+		if len(times) == 0 || !times[len(times)-1].After(cutoff) {
+			delete(a.notFound, source)
+		}
+	}
+}
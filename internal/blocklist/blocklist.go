@@ -0,0 +1,364 @@
+// Package blocklist keeps the runtime list of source IPs and CIDRs that portal
+// refuses, and the heuristics that add scanners to it automatically.
+package blocklist
+
+import (
+	"cmp"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Sources of blocklist entries.
+const (
+	SourceManual = "manual"
+	SourceAuto   = "auto"
+)
+
+// Entry is a blocked IP address or CIDR block.
+type Entry struct {
+	Prefix    netip.Prefix `json:"prefix"`
+	Reason    string       `json:"reason,omitempty"`
+	Source    string       `json:"source"`
+	CreatedAt time.Time    `json:"created_at"`
+}
+
+// DefaultMaxAutoEntries bounds the auto-ban entries kept; the oldest is
+// evicted to make room for a new one. Manual entries are never evicted.
+const DefaultMaxAutoEntries = 10000
+
+// List is a set of blocked prefixes, saved to a JSON file on every change
+// so bans survive restarts. A List with no path is kept in memory only.
+type List struct {
+	path    string
+	maxAuto int
+
+	mu      sync.RWMutex
+	entries map[netip.Prefix]listEntry
+	// lengths counts the entries with each prefix length, IPv4 first, so
+	// Match looks up only the lengths in use.
+	lengths [2][129]int
+	// autoOrder holds auto entries oldest first. Removed entries are left
+	// in place and skipped on eviction.
+	autoOrder []listEntry
+	autoCount int
+	seq       uint64
+
+	// saveMu orders file writes, which happen outside mu so that requests
+	// are not held up by the disk.
+	saveMu sync.Mutex
+	saved  uint64
+}
+
+// listEntry is an entry and its position in the order entries were added.
+type listEntry struct {
+	Entry
+	seq uint64
+}
+
+type fileFormat struct {
+	Entries []Entry `json:"entries"`
+}
+
+// Open loads the blocklist saved at path. A missing file yields an empty
+// list that is created on the first change.
+func Open(path string) (*List, error) {
+	list := NewMemory()
+	list.path = path
+	if path == "" {
+		return list, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return list, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read blocklist %s: %w", path, err)
+	}
+
+	var stored fileFormat
+	if err := json.Unmarshal(data, &stored); err != nil {
+		return nil, fmt.Errorf("failed to parse blocklist %s: %w", path, err)
+	}
+	for _, entry := range stored.Entries {
+		entry.Prefix = entry.Prefix.Masked()
+		if _, ok := list.entries[entry.Prefix]; !ok {
+			list.insert(entry)
+		}
+	}
+	list.evictAuto()
+	list.saved = list.seq
+	return list, nil
+}
+
+// NewMemory returns an empty blocklist that is not persisted.
+func NewMemory() *List {
+	return &List{
+		maxAuto: DefaultMaxAutoEntries,
+		entries: make(map[netip.Prefix]listEntry),
+	}
+}
+
+// Path returns the file the list is saved to, or "" when kept in memory.
+func (l *List) Path() string {
+	return l.path
+}
+
+// Add blocks prefix. It reports false without changing the list when the
+// prefix is already blocked. Adding an auto entry when the list holds the
+// maximum evicts the oldest auto entry.
+func (l *List) Add(prefix netip.Prefix, reason, source string) (Entry, bool, error) {
+	prefix = prefix.Masked()
+
+	l.mu.Lock()
+	if entry, ok := l.entries[prefix]; ok {
+		l.mu.Unlock()
+		return entry.Entry, false, nil
+	}
+	added := l.insert(Entry{Prefix: prefix, Reason: strings.TrimSpace(reason), Source: source, CreatedAt: time.Now().UTC()})
+	l.evictAuto()
+	l.mu.Unlock()
+
+	if err := l.persist(); err != nil {
+		l.mu.Lock()
+		if current, ok := l.entries[prefix]; ok && current.seq == added.seq {
+			l.delete(prefix)
+		}
+		l.mu.Unlock()
+		return Entry{}, false, err
+	}
+	return added.Entry, true, nil
+}
+
+// Remove unblocks prefix. It reports false when the prefix was not blocked.
+func (l *List) Remove(prefix netip.Prefix) (bool, error) {
+	prefix = prefix.Masked()
+
+	l.mu.Lock()
+	removed, ok := l.entries[prefix]
+	if !ok {
+		l.mu.Unlock()
+		return false, nil
+	}
+	l.delete(prefix)
+	l.mu.Unlock()
+
+	if err := l.persist(); err != nil {
+		l.mu.Lock()
+		if _, ok := l.entries[prefix]; !ok {
+			l.restore(removed)
+		}
+		l.mu.Unlock()
+		return false, err
+	}
+	return true, nil
+}
+
+// Match returns the most specific entry blocking addr, if any.
+func (l *List) Match(addr netip.Addr) (Entry, bool) {
+	addr = addr.Unmap()
+	family := familyOf(addr)
+
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	for bits := addr.BitLen(); bits >= 0; bits-- {
+		if l.lengths[family][bits] == 0 {
+			continue
+		}
+		prefix, err := addr.Prefix(bits)
+		if err != nil {
+			continue
+		}
+		if entry, ok := l.entries[prefix]; ok {
+			return entry.Entry, true
+		}
+	}
+	return Entry{}, false
+}
+
+// Entries returns a copy of the blocked prefixes in the order they were added.
+func (l *List) Entries() []Entry {
+	l.mu.RLock()
+	snapshot := l.snapshot()
+	l.mu.RUnlock()
+	return ordered(snapshot)
+}
+
+// insert adds entry as the newest entry. Callers hold l.mu.
+func (l *List) insert(entry Entry) listEntry {
+	l.seq++
+	added := listEntry{Entry: entry, seq: l.seq}
+	l.restore(added)
+	return added
+}
+
+// restore puts back an entry with its original position. Callers hold l.mu.
+func (l *List) restore(entry listEntry) {
+	l.entries[entry.Prefix] = entry
+	l.lengths[familyOf(entry.Prefix.Addr())][entry.Prefix.Bits()]++
+	if entry.Source == SourceAuto {
+		l.autoCount++
+		restored := len(l.autoOrder) > 0 && entry.seq < l.autoOrder[len(l.autoOrder)-1].seq
+		l.autoOrder = append(l.autoOrder, entry)
+		if restored {
+			sortBySeq(l.autoOrder)
+		}
+	}
+	l.seq = max(l.seq, entry.seq)
+}
+
+// delete removes the entry for prefix. Callers hold l.mu.
+func (l *List) delete(prefix netip.Prefix) {
+	entry := l.entries[prefix]
+	delete(l.entries, prefix)
+	l.lengths[familyOf(prefix.Addr())][prefix.Bits()]--
+	if entry.Source == SourceAuto {
+		l.autoCount--
+	}
+	l.seq++
+}
+
+// evictAuto drops the oldest auto entries beyond the maximum, and compacts
+// autoOrder once most of it is stale. Callers hold l.mu.
+func (l *List) evictAuto() {
+	for l.autoCount > l.maxAuto && len(l.autoOrder) > 0 {
+		oldest := l.autoOrder[0]
+		l.autoOrder = l.autoOrder[1:]
+		if current, ok := l.entries[oldest.Prefix]; ok && current.seq == oldest.seq {
+			l.delete(oldest.Prefix)
+		}
+	}
+	if len(l.autoOrder) > 2*l.autoCount+64 {
+		l.autoOrder = slices.DeleteFunc(l.autoOrder, func(entry listEntry) bool {
+			current, ok := l.entries[entry.Prefix]
+			return !ok || current.seq != entry.seq
+		})
+	}
+}
+
+// snapshot copies the entries. Callers hold l.mu.
+func (l *List) snapshot() []listEntry {
+	entries := make([]listEntry, 0, len(l.entries))
+	for _, entry := range l.entries {
+		entries = append(entries, entry)
+	}
+	return entries
+}
+
+// ordered returns entries in the order they were added.
+func ordered(snapshot []listEntry) []Entry {
+	sortBySeq(snapshot)
+	entries := make([]Entry, len(snapshot))
+	for i, entry := range snapshot {
+		entries[i] = entry.Entry
+	}
+	return entries
+}
+
+func sortBySeq(entries []listEntry) {
+	slices.SortFunc(entries, func(a, b listEntry) int { return cmp.Compare(a.seq, b.seq) })
+}
+
+// persist writes the current list atomically, unless a later call already
+// wrote it.
+func (l *List) persist() error {
+	if l.path == "" {
+		return nil
+	}
+
+	l.saveMu.Lock()
+	defer l.saveMu.Unlock()
+
+	l.mu.RLock()
+	version := l.seq
+	snapshot := l.snapshot()
+	l.mu.RUnlock()
+	if version == l.saved {
+		return nil
+	}
+
+	if err := l.save(ordered(snapshot)); err != nil {
+		return err
+	}
+	l.saved = version
+	return nil
+}
+
+// save writes entries to the list's file atomically.
+func (l *List) save(entries []Entry) error {
+	data, err := json.MarshalIndent(fileFormat{Entries: entries}, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode blocklist: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(l.path), 0o700); err != nil {
+		return fmt.Errorf("failed to create blocklist directory: %w", err)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(l.path), ".blocklist-*.json")
+	if err != nil {
+		return fmt.Errorf("failed to save blocklist %s: %w", l.path, err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(append(data, '\n')); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to save blocklist %s: %w", l.path, err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to save blocklist %s: %w", l.path, err)
+	}
+	if err := os.Rename(tmp.Name(), l.path); err != nil {
+		return fmt.Errorf("failed to save blocklist %s: %w", l.path, err)
+	}
+	return nil
+}
+
+// familyOf indexes List.lengths: 0 for IPv4, 1 for IPv6.
+func familyOf(addr netip.Addr) int {
+	if addr.Is4() {
+		return 0
+	}
+	return 1
+}
+
+// AutoBanPrefix returns the prefix auto-ban blocks for addr: the address
+// itself for IPv4, and its /64 for IPv6, since a single IPv6 client can
+// rotate through the addresses of its /64.
+func AutoBanPrefix(addr netip.Addr) netip.Prefix {
+	addr = addr.Unmap()
+	bits := 32
+	if !addr.Is4() {
+		bits = 64
+	}
+	prefix, _ := addr.Prefix(bits)
+	return prefix
+}
+
+// ParsePrefix parses an IP address or CIDR block. A bare address blocks only
+// that address.
+func ParsePrefix(value string) (netip.Prefix, error) {
+	value = strings.TrimSpace(value)
+	if strings.Contains(value, "/") {
+		prefix, err := netip.ParsePrefix(value)
+		if err != nil {
+			return netip.Prefix{}, fmt.Errorf("invalid blocklist entry %q: must be an IP address or CIDR block", value)
+		}
+		return prefix.Masked(), nil
+	}
+
+	addr, err := netip.ParseAddr(value)
+	if err != nil {
+		return netip.Prefix{}, fmt.Errorf("invalid blocklist entry %q: must be an IP address or CIDR block", value)
+	}
+	addr = addr.Unmap()
+	return netip.PrefixFrom(addr, addr.BitLen()), nil
+}
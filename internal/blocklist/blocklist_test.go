@@ -0,0 +1,174 @@
+package blocklist
+
+import (
+	"net/netip"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestListPersistsAcrossOpen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "portal", "blocklist.json")
+
+	list, err := Open(path)
+	if err != nil {
+		t.Fatalf("open missing blocklist: %v", err)
+	}
+	if _, added, err := list.Add(netip.MustParsePrefix("198.51.100.7/24"), " scraping ", SourceManual); err != nil || !added {
+		t.Fatalf("add: added=%v err=%v", added, err)
+	}
+	if _, added, err := list.Add(netip.MustParsePrefix("198.51.100.0/24"), "", SourceAuto); err != nil || added {
+		t.Fatalf("expected duplicate prefix to be ignored: added=%v err=%v", added, err)
+	}
+
+	reopened, err := Open(path)
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	entries := reopened.Entries()
+	if len(entries) != 1 {
+		t.Fatalf("expected 1 entry, got %+v", entries)
+	}
+	if entries[0].Prefix.String() != "198.51.100.0/24" || entries[0].Reason != "scraping" || entries[0].Source != SourceManual {
+		t.Fatalf("unexpected entry: %+v", entries[0])
+	}
+
+	if info, err := os.Stat(filepath.Dir(path)); err != nil || info.Mode().Perm() != 0o700 {
+		t.Fatalf("expected private blocklist directory, got %v %v", info, err)
+	}
+}
+
+func TestListMatchAndRemove(t *testing.T) {
+	list := NewMemory()
+	list.Add(netip.MustParsePrefix("2001:db8::/32"), "", SourceManual)
+	list.Add(netip.MustParsePrefix("203.0.113.9/32"), "", SourceAuto)
+
+	if entry, ok := list.Match(netip.MustParseAddr("::ffff:203.0.113.9")); !ok || entry.Source != SourceAuto {
+		t.Fatalf("expected IPv4-mapped address to match, got %+v %v", entry, ok)
+	}
+	if _, ok := list.Match(netip.MustParseAddr("2001:db8::1")); !ok {
+		t.Fatal("expected IPv6 address inside the CIDR to match")
+	}
+	if _, ok := list.Match(netip.MustParseAddr("203.0.113.10")); ok {
+		t.Fatal("expected neighbouring address not to match")
+	}
+
+	if removed, err := list.Remove(netip.MustParsePrefix("203.0.113.9/32")); err != nil || !removed {
+		t.Fatalf("remove: removed=%v err=%v", removed, err)
+	}
+	if removed, _ := list.Remove(netip.MustParsePrefix("203.0.113.9/32")); removed {
+		t.Fatal("expected second remove to report false")
+	}
+	if _, ok := list.Match(netip.MustParseAddr("203.0.113.9")); ok {
+		t.Fatal("expected removed address not to match")
+	}
+}
+
+func TestListEvictsOldestAutoEntries(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "blocklist.json")
+	list, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	list.maxAuto = 2
+
+	list.Add(netip.MustParsePrefix("192.0.2.0/24"), "", SourceManual)
+	for _, prefix := range []string{"203.0.113.1/32", "203.0.113.2/32", "203.0.113.3/32"} {
+		if _, added, err := list.Add(netip.MustParsePrefix(prefix), "", SourceAuto); err != nil || !added {
+			t.Fatalf("add %s: added=%v err=%v", prefix, added, err)
+		}
+	}
+
+	if _, ok := list.Match(netip.MustParseAddr("203.0.113.1")); ok {
+		t.Fatal("expected the oldest auto entry to be evicted")
+	}
+	reopened, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, entry := range reopened.Entries() {
+		got = append(got, entry.Prefix.String())
+	}
+	if strings.Join(got, ",") != "192.0.2.0/24,203.0.113.2/32,203.0.113.3/32" {
+		t.Fatalf("expected the manual entry and newest auto entries in order, got %v", got)
+	}
+}
+
+func TestAutoBanPrefix(t *testing.T) {
+	for input, want := range map[string]string{
+		"203.0.113.9":        "203.0.113.9/32",
+		"::ffff:203.0.113.9": "203.0.113.9/32",
+		"2001:db8:1:2::10":   "2001:db8:1:2::/64",
+	} {
+		if got := AutoBanPrefix(netip.MustParseAddr(input)).String(); got != want {
+			t.Fatalf("AutoBanPrefix(%q) = %s; want %s", input, got, want)
+		}
+	}
+}
+
+func TestOpenRejectsCorruptFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "blocklist.json")
+	if err := os.WriteFile(path, []byte("{"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Open(path); err == nil {
+		t.Fatal("expected corrupt blocklist to fail")
+	}
+}
+
+func TestParsePrefix(t *testing.T) {
+	for input, want := range map[string]string{
+		"203.0.113.9":        "203.0.113.9/32",
+		" 198.51.100.7/24 ":  "198.51.100.0/24",
+		"2001:db8::1":        "2001:db8::1/128",
+		"::ffff:203.0.113.9": "203.0.113.9/32",
+	} {
+		prefix, err := ParsePrefix(input)
+		if err != nil || prefix.String() != want {
+			t.Fatalf("ParsePrefix(%q) = %v, %v; want %s", input, prefix, err, want)
+		}
+	}
+	for _, input := range []string{"", "example.com", "203.0.113.0/40"} {
+		if _, err := ParsePrefix(input); err == nil {
+			t.Fatalf("expected ParsePrefix(%q) to fail", input)
+		}
+	}
+}
+
+func TestAutoBanScannerPath(t *testing.T) {
+	ban := NewAutoBan(AutoBanConfig{ScannerPaths: DefaultScannerPaths})
+	if path, ok := ban.ScannerPath("/static/.git/config"); !ok || path != "/.git/config" {
+		t.Fatalf("expected suffix match, got %q %v", path, ok)
+	}
+	if _, ok := ban.ScannerPath("/environment"); ok {
+		t.Fatal("expected unrelated path not to match")
+	}
+}
+
+func TestAutoBanRecordNotFoundWithinWindow(t *testing.T) {
+	now := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	ban := NewAutoBan(AutoBanConfig{NotFoundThreshold: 3, NotFoundWindow: time.Minute})
+	ban.now = func() time.Time { return now }
+	addr := netip.MustParseAddr("198.51.100.4")
+
+	ban.RecordNotFound(addr)
+	ban.RecordNotFound(addr)
+	now = now.Add(2 * time.Minute)
+	if ban.RecordNotFound(addr) {
+		t.Fatal("expected expired not-found responses not to count")
+	}
+	ban.RecordNotFound(addr)
+	if !ban.RecordNotFound(addr) {
+		t.Fatal("expected third not-found response within the window to ban")
+	}
+
+	disabled := NewAutoBan(AutoBanConfig{})
+	for i := 0; i < 100; i++ {
+		if disabled.RecordNotFound(addr) {
+			t.Fatal("expected a zero threshold to disable not-found bans")
+		}
+	}
+}
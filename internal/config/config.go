@@ -2,16 +2,23 @@ package config
 
 import (
 	"fmt"
-	"net/netip"
+	"net/http"
 	"os"
 	"path/filepath"
+	"slices"
+	"sort"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/spf13/cobra"
 	"github.com/spf13/pflag"
 	"github.com/spf13/viper"
 	"tailscale.com/tailcfg"
+
+	"github.com/jaxxstorm/portal/internal/access"
+	"github.com/jaxxstorm/portal/internal/blocklist"
+	"github.com/jaxxstorm/portal/internal/telemetry"
 )
 
 const (
@@ -24,6 +31,47 @@ const (
 	legacyListenModeKey    = "tsnet-listen-mode"
 	serviceNameKey         = "service-name"
 	legacyServiceNameKey   = "tsnet-service-name"
+	requestIDHeaderKey     = "request-id-header"
+	otelEndpointKey        = "otel-endpoint"
+	otelProtocolKey        = "otel-protocol"
+	routesKey              = "routes"
+	blocklistFileKey       = "blocklist-file"
+	autoBanKey             = "auto-ban"
+	autoBanPathsKey        = "auto-ban-paths"
+	autoBanThresholdKey    = "auto-ban-404-threshold"
+	autoBanWindowKey       = "auto-ban-404-window"
+	funnelDenylistKey      = "funnel-denylist"
+	funnelStrictKey        = "funnel-allowlist-strict"
+	funnelRulesKey         = "funnel-rules"
+	funnelRulesFileKey     = "funnel-rules-file"
+	captureLimitKey        = "capture-limit"
+	geoIPDBKey             = "geoip-db"
+	geoIPASNDBKey          = "geoip-asn-db"
+	profileKey             = "profile"
+	profilesKey            = "profiles"
+	nameKey                = "name"
+
+	// DefaultRequestIDHeader is the header used to propagate request IDs.
+	DefaultRequestIDHeader = "X-Request-ID"
+	// DefaultCaptureLimit is how many captured requests are kept.
+	DefaultCaptureLimit = 1000
+
+	// Subcommands that inspect configuration instead of exposing a service.
+	CommandConfigShow     = "config show"
+	CommandConfigInit     = "config init"
+	CommandConfigValidate = "config validate"
+	CommandConfigExplain  = "config explain"
+
+	// CommandStatus lists running portal sessions.
+	CommandStatus = "status"
+
+	// Subcommands that manage portals running in the background.
+	CommandStart = "start"
+	CommandStop  = "stop"
+	CommandLogs  = "logs"
+
+	// CommandCtl drives a running portal through its control API.
+	CommandCtl = "ctl"
 )
 
 // Config holds the parsed and validated configuration
@@ -31,7 +79,11 @@ type Config struct {
 	Port             int
 	TailscaleName    string
 	Funnel           bool
-	FunnelAllowlist  []netip.Prefix
+	FunnelAllowlist  []access.Entry
+	FunnelDenylist   []access.Entry
+	FunnelStrict     bool
+	FunnelRules      []access.Rule
+	FunnelRulesFile  string
 	Verbose          bool
 	JSON             bool
 	LogFile          string
@@ -48,6 +100,29 @@ type Config struct {
 	CleanupServe     bool
 	TSNetListenMode  string
 	TSNetServiceName string
+	RequestIDHeader  string
+	OTelEndpoint     string
+	OTelProtocol     string
+	Routes           []string
+	BlocklistFile    string
+	AutoBan          bool
+	AutoBanPaths     []string
+	AutoBanThreshold int
+	AutoBanWindow    time.Duration
+	GeoIPDB          string
+	GeoIPASNDB       string
+	CaptureLimit     int
+	ConfigFile       string            // config file portal reads, whether or not it exists
+	ProjectConfig    string            // project .portal.yml merged over ConfigFile, if found
+	Profile          string            // named profile applied from the config files
+	Name             string            // session name used by portal stop and portal logs
+	Command          string            // subcommand to run instead of exposing a service
+	CommandArg       string            // file argument of the subcommand, if any
+	CommandArgs      []string          // further arguments of the subcommand, if any
+	Force            bool              // config init overwrites an existing file
+	JSONOutput       bool              // print the subcommand's output as JSON
+	Follow           bool              // portal logs keeps printing new lines
+	Sources          map[string]string // where each setting's value came from, by key
 }
 
 // Parse parses command line arguments and returns a validated configuration
@@ -59,11 +134,10 @@ func Parse() (*Config, error) {
 // Exposed for tests.
 func ParseArgs(args []string) (*Config, error) {
 	v := viper.New()
-	if err := configureViper(v); err != nil {
-		return nil, err
-	}
-
 	state := &parseState{}
+	// config init and validate report broken config files themselves.
+	configErr := configureViper(v, state)
+
 	cmd, err := newRootCommand(v, state)
 	if err != nil {
 		return nil, err
@@ -73,14 +147,33 @@ func ParseArgs(args []string) (*Config, error) {
 	cmd.SilenceErrors = true
 	cmd.SilenceUsage = true
 
-	if err := cmd.Execute(); err != nil {
+	executed, err := cmd.ExecuteC()
+	if err != nil {
 		return nil, err
 	}
 
-	if helpRequested(cmd, args) {
+	if helpRequested(executed, args) {
 		return nil, pflag.ErrHelp
 	}
 
+	if state.command == CommandConfigInit || state.command == CommandConfigValidate {
+		return &Config{
+			ConfigFile:    state.configFile,
+			ProjectConfig: state.projectFile,
+			Command:       state.command,
+			CommandArg:    state.commandArg,
+			Force:         state.force,
+		}, nil
+	}
+	if configErr != nil {
+		return nil, configErr
+	}
+
+	profile, err := applyProfile(v, state)
+	if err != nil {
+		return nil, err
+	}
+
 	port := v.GetInt("port")
 	if state.portSet {
 		port = state.port
@@ -111,16 +204,76 @@ func ParseArgs(args []string) (*Config, error) {
 		serviceName = "svc:portal"
 	}
 
-	funnelAllowlist, err := parseFunnelAllowlist(normalizeList(v.Get("funnel-allowlist")))
+	funnelAllowlist, err := parseAccessEntries("funnel allowlist", normalizeList(v.Get("funnel-allowlist")))
+	if err != nil {
+		return nil, err
+	}
+	funnelDenylist, err := parseAccessEntries("funnel denylist", normalizeList(v.Get(funnelDenylistKey)))
+	if err != nil {
+		return nil, err
+	}
+	funnelRules, err := loadFunnelRules(v)
+	if err != nil {
+		return nil, err
+	}
+	geoIPDB := strings.TrimSpace(v.GetString(geoIPDBKey))
+	geoIPASNDB := strings.TrimSpace(v.GetString(geoIPASNDBKey))
+	if geoIPDB == "" && geoIPASNDB == "" && (access.NeedsGeo(funnelAllowlist) || access.NeedsGeo(funnelDenylist) || rulesNeedGeo(funnelRules)) {
+		return nil, fmt.Errorf("country and asn funnel allowlist, denylist or rule entries require %s or %s", geoIPDBKey, geoIPASNDBKey)
+	}
+
+	requestIDHeader := http.CanonicalHeaderKey(strings.TrimSpace(v.GetString(requestIDHeaderKey)))
+	if requestIDHeader == "" {
+		requestIDHeader = DefaultRequestIDHeader
+	}
+
+	routes, err := parseRoutes(normalizeList(v.Get(routesKey)))
 	if err != nil {
 		return nil, err
 	}
 
+	autoBanPaths, err := parseAutoBanPaths(normalizeList(v.Get(autoBanPathsKey)))
+	if err != nil {
+		return nil, err
+	}
+	autoBanThreshold := v.GetInt(autoBanThresholdKey)
+	if autoBanThreshold < 0 {
+		return nil, fmt.Errorf("invalid %s %d: must be zero (disabled) or a positive integer", autoBanThresholdKey, autoBanThreshold)
+	}
+	autoBanWindow := v.GetDuration(autoBanWindowKey)
+	if autoBanWindow <= 0 {
+		return nil, fmt.Errorf("invalid %s %q: must be a positive duration such as 1m", autoBanWindowKey, v.GetString(autoBanWindowKey))
+	}
+
+	captureLimit := v.GetInt(captureLimitKey)
+	if captureLimit <= 0 {
+		return nil, fmt.Errorf("invalid %s %d: must be a positive integer", captureLimitKey, captureLimit)
+	}
+
+	name := strings.TrimSpace(v.GetString(nameKey))
+	if name != "" {
+		if err := ValidateName(name); err != nil {
+			return nil, err
+		}
+	}
+
+	otelProtocol := strings.ToLower(strings.TrimSpace(v.GetString(otelProtocolKey)))
+	if otelProtocol == "" {
+		otelProtocol = telemetry.ProtocolGRPC
+	}
+	if otelProtocol != telemetry.ProtocolGRPC && otelProtocol != telemetry.ProtocolHTTP {
+		return nil, fmt.Errorf("invalid otel-protocol %q: must be %q or %q", otelProtocol, telemetry.ProtocolGRPC, telemetry.ProtocolHTTP)
+	}
+
 	cfg := &Config{
 		Port:             port,
 		TailscaleName:    deviceName,
 		Funnel:           v.GetBool("funnel"),
 		FunnelAllowlist:  funnelAllowlist,
+		FunnelDenylist:   funnelDenylist,
+		FunnelStrict:     v.GetBool(funnelStrictKey),
+		FunnelRules:      funnelRules,
+		FunnelRulesFile:  strings.TrimSpace(v.GetString(funnelRulesFileKey)),
 		Verbose:          v.GetBool("verbose"),
 		JSON:             v.GetBool("json"),
 		LogFile:          v.GetString("log-file"),
@@ -137,6 +290,29 @@ func ParseArgs(args []string) (*Config, error) {
 		CleanupServe:     v.GetBool("cleanup-serve"),
 		TSNetListenMode:  listenMode,
 		TSNetServiceName: serviceName,
+		RequestIDHeader:  requestIDHeader,
+		OTelEndpoint:     strings.TrimSpace(v.GetString(otelEndpointKey)),
+		OTelProtocol:     otelProtocol,
+		Routes:           routes,
+		BlocklistFile:    strings.TrimSpace(v.GetString(blocklistFileKey)),
+		AutoBan:          v.GetBool(autoBanKey),
+		AutoBanPaths:     autoBanPaths,
+		AutoBanThreshold: autoBanThreshold,
+		AutoBanWindow:    autoBanWindow,
+		GeoIPDB:          geoIPDB,
+		GeoIPASNDB:       geoIPASNDB,
+		CaptureLimit:     captureLimit,
+		ConfigFile:       v.ConfigFileUsed(),
+		ProjectConfig:    state.projectFile,
+		Profile:          profile,
+		Name:             name,
+		Command:          state.command,
+		CommandArg:       state.commandArg,
+		CommandArgs:      state.commandArgs,
+		Force:            state.force,
+		JSONOutput:       state.jsonOutput,
+		Follow:           state.follow,
+		Sources:          settingSources(cmd.PersistentFlags(), state, profile),
 	}
 
 	// Handle version flag
@@ -149,6 +325,18 @@ func ParseArgs(args []string) (*Config, error) {
 		return cfg, nil
 	}
 
+	// Subcommands that inspect configuration need no port; portal start
+	// runs a portal, so it is validated like one.
+	if cfg.Command != "" && cfg.Command != CommandStart {
+		cfg.applyAutoConfiguration()
+		if cfg.Command == CommandConfigExplain {
+			if err := cfg.validateTSNetServiceConfig(); err != nil {
+				return nil, err
+			}
+		}
+		return cfg, nil
+	}
+
 	// Validate arguments
 	if cfg.Mock && cfg.Port != 0 {
 		return nil, fmt.Errorf("cannot specify both port and --mock flag%s", usageSuffix)
@@ -208,11 +396,36 @@ func (c *Config) HasFunnelAllowlist() bool {
 	return c.Funnel && len(c.FunnelAllowlist) > 0
 }
 
+// HasFunnelDenylist reports whether Funnel denylist enforcement is active.
+func (c *Config) HasFunnelDenylist() bool {
+	return c.Funnel && len(c.FunnelDenylist) > 0
+}
+
+// HasFunnelRules reports whether path-scoped Funnel access rules are active.
+func (c *Config) HasFunnelRules() bool {
+	return c.Funnel && len(c.FunnelRules) > 0
+}
+
+// HasFunnelAccessControl reports whether any Funnel source IP enforcement
+// is active.
+func (c *Config) HasFunnelAccessControl() bool {
+	return c.HasFunnelAllowlist() || c.HasFunnelDenylist() || c.HasFunnelRules()
+}
+
 // UseFunnelProxyProtocol reports whether Funnel traffic should use PROXY v2.
 // We only enable this for root-path serving because TCP forwarding does not
 // support mount-point routing semantics from serve web handlers.
 func (c *Config) UseFunnelProxyProtocol() bool {
-	return c.HasFunnelAllowlist() && c.GetSetPath() == "/"
+	return c.HasFunnelAccessControl() && c.GetSetPath() == "/"
+}
+
+// ProxyBindHost returns the interface the local proxy listens on. Strict
+// Funnel access control keeps it on loopback so only Tailscale can reach it.
+func (c *Config) ProxyBindHost() string {
+	if c.FunnelStrict {
+		return "127.0.0.1"
+	}
+	return "0.0.0.0"
 }
 
 // EffectiveTSNetListenMode returns the runtime tsnet listen mode once
@@ -230,14 +443,82 @@ func (c *Config) IsServiceMode() bool {
 	return c.EffectiveTSNetListenMode() == TSNetListenModeService
 }
 
-const usageSuffix = "\nUsage: portal <port> [flags]     (proxy mode)\n       portal --mock [flags]     (mock/testing mode)\n       portal --version\n       portal --cleanup-serve"
+const usageSuffix = "\nUsage: portal <port> [flags]     (proxy mode)\n       portal --mock [flags]     (mock/testing mode)\n       portal up <profile> [port] (profile from config file)\n       portal status [--json]\n       portal start <port> [flags] (run in the background)\n       portal stop <name>\n       portal logs <name> [--follow]\n       portal ctl <name> <action> [value]\n       portal --version\n       portal --cleanup-serve"
 
 type parseState struct {
-	port    int
-	portSet bool
+	port        int
+	portSet     bool
+	profile     string // from portal up <profile>
+	command     string
+	commandArg  string
+	commandArgs []string
+	force       bool
+	jsonOutput  bool
+	follow      bool
+
+	// Settings from each config file layer, to report where values come from.
+	configFile      string
+	fileSettings    map[string]any
+	projectFile     string
+	projectSettings map[string]any
+	profileSettings map[string]any
+}
+
+// ProjectConfigName is the project-local config file found by walking up
+// from the working directory.
+const ProjectConfigName = ".portal.yml"
+
+// projectKeys are the settings a project config file may set: what a
+// repository can describe about its own traffic. Settings that expose the
+// service, hold credentials, or name files and endpoints on this machine
+// come only from the home config file, env and flags, so running portal in
+// a cloned repository cannot change them.
+var projectKeys = []string{
+	routesKey,
+	"funnel-allowlist",
+	funnelDenylistKey,
+	funnelStrictKey,
+	funnelRulesKey,
+	funnelRulesFileKey,
+	profilesKey,
+}
+
+// projectProfileKeys are the settings a project profile may set as well as
+// projectKeys. A profile applies only when chosen with portal up or
+// --profile, so it may also say how to expose the service.
+var projectProfileKeys = []string{"port", "mock", "funnel", "set-path", "serve-port", "use-https"}
+
+// checkProjectSettings reports settings in a project config file that a
+// project may not set, and project profiles that would change a profile of
+// the same name in homeSettings.
+func checkProjectSettings(settings, homeSettings map[string]any) []error {
+	var problems []error
+	for _, key := range sortedKeys(settings) {
+		if knownKey(key, true) && !slices.Contains(projectKeys, key) {
+			problems = append(problems, fmt.Errorf("a project config file cannot set %s; set it in the home config file, env or flags", key))
+		}
+	}
+
+	profiles, _ := settings[profilesKey].(map[string]any)
+	homeProfiles, _ := homeSettings[profilesKey].(map[string]any)
+	for _, name := range profileNames(profiles) {
+		if _, ok := homeProfiles[name]; ok {
+			problems = append(problems, fmt.Errorf("a project config file cannot change profile %s, which the home config file defines", name))
+			continue
+		}
+		values, _ := profiles[name].(map[string]any)
+		for _, key := range sortedKeys(values) {
+			if knownKey(key, false) && !slices.Contains(projectKeys, key) && !slices.Contains(projectProfileKeys, key) {
+				problems = append(problems, fmt.Errorf("a project config file cannot set %s in profile %s; set it in the home config file, env or flags", key, name))
+			}
+		}
+	}
+	return problems
 }
 
-func configureViper(v *viper.Viper) error {
+// configureViper sets defaults and environment binding, then layers the
+// project config file over the home config file.
+func configureViper(v *viper.Viper, state *parseState) error {
 	homeDir, err := os.UserHomeDir()
 	if err != nil {
 		return fmt.Errorf("failed to determine home directory: %w", err)
@@ -250,18 +531,207 @@ func configureViper(v *viper.Viper) error {
 	v.SetEnvKeyReplacer(strings.NewReplacer("-", "_", ".", "_"))
 	v.AutomaticEnv()
 	v.SetDefault("funnel-allowlist", []string{})
+	v.SetDefault(funnelDenylistKey, []string{})
+	v.SetDefault(routesKey, []string{})
+	v.SetDefault(blocklistFileKey, filepath.Join(homeDir, ".portal", "blocklist.json"))
+	v.SetDefault(autoBanPathsKey, blocklist.DefaultScannerPaths)
+	v.SetDefault(autoBanThresholdKey, blocklist.DefaultNotFoundThreshold)
+	v.SetDefault(autoBanWindowKey, blocklist.DefaultNotFoundWindow.String())
+	v.SetDefault(captureLimitKey, DefaultCaptureLimit)
+
+	workDir, err := os.Getwd()
+	if err != nil {
+		return fmt.Errorf("failed to determine working directory: %w", err)
+	}
+	state.configFile = configPath
+	state.projectFile = findProjectConfig(workDir)
+
+	state.fileSettings, err = readConfigFile(configPath)
+	if err != nil {
+		return err
+	}
+	if err := v.MergeConfigMap(state.fileSettings); err != nil {
+		return fmt.Errorf("failed to read config file %s: %w", configPath, err)
+	}
 
-	if err := v.ReadInConfig(); err != nil {
+	if state.projectFile == "" {
+		return nil
+	}
+	state.projectSettings, err = readConfigFile(state.projectFile)
+	if err != nil {
+		return err
+	}
+	if problems := checkProjectSettings(state.projectSettings, state.fileSettings); len(problems) > 0 {
+		return fmt.Errorf("%s: %w", state.projectFile, problems[0])
+	}
+	if err := v.MergeConfigMap(state.projectSettings); err != nil {
+		return fmt.Errorf("failed to read config file %s: %w", state.projectFile, err)
+	}
+
+	return nil
+}
+
+// readConfigFile reads a YAML config file. A missing file has no settings.
+// Relative paths in the file are resolved from its directory.
+func readConfigFile(path string) (map[string]any, error) {
+	source := viper.New()
+	source.SetConfigFile(path)
+	source.SetConfigType("yaml")
+	if err := source.ReadInConfig(); err != nil {
 		if _, ok := err.(viper.ConfigFileNotFoundError); ok {
-			return nil
+			return nil, nil
 		}
 		if os.IsNotExist(err) {
-			return nil
+			return nil, nil
 		}
-		return fmt.Errorf("failed to read config file %s: %w", configPath, err)
+		return nil, fmt.Errorf("failed to read config file %s: %w", path, err)
 	}
 
-	return nil
+	settings := source.AllSettings()
+	dir, err := filepath.Abs(filepath.Dir(path))
+	if err != nil {
+		return nil, err
+	}
+	resolvePaths(settings, dir)
+	if profiles, ok := settings[profilesKey].(map[string]any); ok {
+		for _, values := range profiles {
+			if values, ok := values.(map[string]any); ok {
+				resolvePaths(values, dir)
+			}
+		}
+	}
+	return settings, nil
+}
+
+// resolvePaths makes funnel-rules-file and file: access entries in one
+// layer of settings relative to dir, the directory of the file that set
+// them, instead of the working directory.
+func resolvePaths(settings map[string]any, dir string) {
+	if path, ok := settings[funnelRulesFileKey].(string); ok {
+		if path = strings.TrimSpace(path); path != "" && !filepath.IsAbs(path) {
+			settings[funnelRulesFileKey] = filepath.Join(dir, path)
+		}
+	}
+	for _, key := range []string{"funnel-allowlist", funnelDenylistKey} {
+		if value, ok := settings[key]; ok {
+			settings[key] = resolveFileEntries(value, dir)
+		}
+	}
+	if rules, ok := settings[funnelRulesKey].([]any); ok {
+		for _, rule := range rules {
+			rule, ok := rule.(map[string]any)
+			if !ok {
+				continue
+			}
+			for _, key := range []string{"allow", "deny"} {
+				if value, ok := rule[key]; ok {
+					rule[key] = resolveFileEntries(value, dir)
+				}
+			}
+		}
+	}
+}
+
+// resolveFileEntries resolves the file: entries of an access list, written
+// as a list or a comma-separated string, from dir.
+func resolveFileEntries(value any, dir string) any {
+	switch list := value.(type) {
+	case string:
+		entries := strings.Split(list, ",")
+		for i, entry := range entries {
+			entries[i] = resolveFileEntry(entry, dir)
+		}
+		return strings.Join(entries, ",")
+	case []any:
+		resolved := make([]any, len(list))
+		for i, entry := range list {
+			if entry, ok := entry.(string); ok {
+				resolved[i] = resolveFileEntry(entry, dir)
+			} else {
+				resolved[i] = entry
+			}
+		}
+		return resolved
+	default:
+		return value
+	}
+}
+
+// fileEntryPrefix starts access entries that load a file.
+const fileEntryPrefix = "file:"
+
+// resolveFileEntry makes a relative file:<path>[#key] entry relative to dir
+// and leaves other entries as they are.
+func resolveFileEntry(entry, dir string) string {
+	trimmed := strings.TrimSpace(entry)
+	if len(trimmed) <= len(fileEntryPrefix) || !strings.EqualFold(trimmed[:len(fileEntryPrefix)], fileEntryPrefix) {
+		return entry
+	}
+	path := trimmed[len(fileEntryPrefix):]
+	if filepath.IsAbs(path) {
+		return entry
+	}
+	return fileEntryPrefix + filepath.Join(dir, path)
+}
+
+// findProjectConfig returns the closest ProjectConfigName in dir or its
+// parents, or "" when there is none.
+func findProjectConfig(dir string) string {
+	for {
+		path := filepath.Join(dir, ProjectConfigName)
+		if info, err := os.Stat(path); err == nil && !info.IsDir() {
+			return path
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return ""
+		}
+		dir = parent
+	}
+}
+
+// boundKeys are the settings bound to flags and PORTAL_ environment variables.
+var boundKeys = []string{
+	"port",
+	deviceNameKey,
+	legacyTailscaleNameKey,
+	"funnel",
+	"funnel-allowlist",
+	funnelDenylistKey,
+	funnelStrictKey,
+	funnelRulesFileKey,
+	geoIPDBKey,
+	geoIPASNDBKey,
+	"verbose",
+	"json",
+	"log-file",
+	"auth-key",
+	"force-tsnet",
+	"set-path",
+	"serve-port",
+	"use-https",
+	"no-tui",
+	"no-ui",
+	"ui-port",
+	"version",
+	"mock",
+	"cleanup-serve",
+	profileKey,
+	nameKey,
+	listenModeKey,
+	serviceNameKey,
+	legacyListenModeKey,
+	legacyServiceNameKey,
+	requestIDHeaderKey,
+	otelEndpointKey,
+	otelProtocolKey,
+	routesKey,
+	captureLimitKey,
+	blocklistFileKey,
+	autoBanKey,
+	autoBanPathsKey,
+	autoBanThresholdKey,
+	autoBanWindowKey,
 }
 
 func newRootCommand(v *viper.Viper, state *parseState) (*cobra.Command, error) {
@@ -273,19 +743,140 @@ func newRootCommand(v *viper.Viper, state *parseState) (*cobra.Command, error) {
 			if len(args) == 0 {
 				return nil
 			}
+			return state.setPort(args[0])
+		},
+	}
+	cmd.CompletionOptions.DisableDefaultCmd = true
 
-			port, err := strconv.Atoi(args[0])
-			if err != nil || port <= 0 {
-				return fmt.Errorf("invalid port %q: must be a positive integer", args[0])
+	configCmd := &cobra.Command{
+		Use:   "config",
+		Short: "Inspect portal configuration",
+	}
+	configCmd.AddCommand(&cobra.Command{
+		Use:   "show",
+		Short: "Print the effective configuration and where each value came from",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			state.command = CommandConfigShow
+			return nil
+		},
+	})
+	initCmd := &cobra.Command{
+		Use:   "init [file]",
+		Short: "Write a commented starter config file (default: ~/.portal/config.yml)",
+		Args:  cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			state.command = CommandConfigInit
+			if len(args) == 1 {
+				state.commandArg = args[0]
 			}
+			return nil
+		},
+	}
+	initCmd.Flags().BoolVar(&state.force, "force", false, "Overwrite the file if it exists")
+	configCmd.AddCommand(initCmd)
+	configCmd.AddCommand(&cobra.Command{
+		Use:   "validate [file]",
+		Short: "Check a config file, or the config files portal would read",
+		Args:  cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			state.command = CommandConfigValidate
+			if len(args) == 1 {
+				state.commandArg = args[0]
+			}
+			return nil
+		},
+	})
+	configCmd.AddCommand(&cobra.Command{
+		Use:   "explain [port]",
+		Short: "Describe the operating mode portal would run in",
+		Args:  cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			state.command = CommandConfigExplain
+			if len(args) == 0 {
+				return nil
+			}
+			return state.setPort(args[0])
+		},
+	})
+	cmd.AddCommand(configCmd)
 
-			state.port = port
-			state.portSet = true
+	statusCmd := &cobra.Command{
+		Use:   "status",
+		Short: "List running portal sessions and what they expose",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			state.command = CommandStatus
 			return nil
 		},
 	}
+	// Shadows the persistent --json log flag for this command.
+	statusCmd.Flags().BoolVarP(&state.jsonOutput, "json", "j", false, "Print sessions as JSON")
+	cmd.AddCommand(statusCmd)
 
-	flags := cmd.Flags()
+	cmd.AddCommand(&cobra.Command{
+		Use:   "start [port]",
+		Short: "Run portal in the background and return once its URL is ready",
+		Args:  cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			state.command = CommandStart
+			if len(args) == 0 {
+				return nil
+			}
+			return state.setPort(args[0])
+		},
+	})
+	cmd.AddCommand(&cobra.Command{
+		Use:   "stop <name>",
+		Short: "Stop a named portal and remove its serve entries",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			state.command = CommandStop
+			state.commandArg = args[0]
+			return nil
+		},
+	})
+	logsCmd := &cobra.Command{
+		Use:   "logs <name>",
+		Short: "Print the log file of a named portal",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			state.command = CommandLogs
+			state.commandArg = args[0]
+			return nil
+		},
+	}
+	// No -f shorthand: it is taken by the persistent --funnel flag.
+	logsCmd.Flags().BoolVar(&state.follow, "follow", false, "Keep printing lines as they are written")
+	cmd.AddCommand(logsCmd)
+
+	cmd.AddCommand(&cobra.Command{
+		Use:   "ctl <name> <action> [value]",
+		Short: "Drive a running portal: state, captures, clear-captures, target <port>, mock on|off, funnel on|off, shutdown",
+		Args:  cobra.RangeArgs(2, 3),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			state.command = CommandCtl
+			state.commandArg = args[0]
+			state.commandArgs = args[1:]
+			return nil
+		},
+	})
+
+	cmd.AddCommand(&cobra.Command{
+		Use:   "up <profile> [port]",
+		Short: "Expose a service using a named profile from the config file",
+		Args:  cobra.RangeArgs(1, 2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			state.profile = strings.TrimSpace(args[0])
+			if len(args) == 1 {
+				return nil
+			}
+			return state.setPort(args[1])
+		},
+	})
+
+	// Flags are persistent so that subcommands such as up accept them too.
+	flags := cmd.PersistentFlags()
 	flags.StringP(deviceNameKey, "n", "", "Tailscale device name (only used with tsnet mode) (default: portal)")
 	flags.String(legacyTailscaleNameKey, "", "Deprecated alias for --device-name")
 	flags.BoolP("funnel", "f", false, "Enable Tailscale funnel (public internet access)")
@@ -302,9 +893,23 @@ func newRootCommand(v *viper.Viper, state *parseState) (*cobra.Command, error) {
 	flags.Int("ui-port", 0, "Custom port for web UI (default: 4040 or next available)")
 	flags.Bool("version", false, "Show version information")
 	flags.BoolP("mock", "m", false, "Enable mock/testing mode (no backing server required)")
-	flags.Bool("cleanup-serve", false, "Clear all Tailscale serve configurations and exit")
+	flags.Bool("cleanup-serve", false, "Remove the Tailscale serve entries portal added and exit")
+	flags.String(profileKey, "", "Named profile from the profiles section of the config file")
+	flags.String(nameKey, "", "Session name for portal stop and portal logs (default with portal start: the port, profile or mock)")
 	flags.String(listenModeKey, "", "Listen mode: listener or service (default: listener; service mode requires tag-based identity)")
 	flags.String(serviceNameKey, "", "Service name used when listen-mode=service (default: svc:portal; requires tagged host identity)")
+	flags.String(requestIDHeaderKey, "", "Header used to propagate request IDs to the backend (default: X-Request-ID)")
+	flags.String(otelEndpointKey, "", "OTLP endpoint for request traces, as host:port or URL (default: tracing disabled)")
+	flags.String(otelProtocolKey, "", "OTLP protocol: grpc or http (default: grpc)")
+	flags.Int(captureLimitKey, 0, "Number of captured requests kept for the TUI and web UI (default: 1000)")
+	flags.String(blocklistFileKey, "", "File the runtime IP blocklist is saved to (default: ~/.portal/blocklist.json)")
+	flags.Bool(autoBanKey, false, "Automatically block Funnel clients that probe scanner paths or hit repeated 404s")
+	flags.Int(autoBanThresholdKey, 0, "404 responses within --auto-ban-404-window that ban a Funnel client; 0 disables (default: 20)")
+	flags.Duration(autoBanWindowKey, 0, "Window for counting 404 responses toward --auto-ban-404-threshold (default: 1m)")
+	flags.String(funnelRulesFileKey, "", "YAML or JSON file of path-scoped Funnel access rules, instead of funnel-rules in config")
+	flags.Bool(funnelStrictKey, false, "Refuse to start unless the Funnel allowlist and denylist can use a source IP clients cannot forge")
+	flags.String(geoIPDBKey, "", "MaxMind-format .mmdb database used to add country and ASN to Funnel requests")
+	flags.String(geoIPASNDBKey, "", "Separate MaxMind-format .mmdb ASN database, when --geoip-db has no ASN data")
 	flags.String(legacyListenModeKey, "", "Deprecated alias for --listen-mode")
 	flags.String(legacyServiceNameKey, "", "Deprecated alias for --service-name")
 	_ = flags.MarkDeprecated(legacyTailscaleNameKey, "use --device-name instead")
@@ -314,33 +919,7 @@ func newRootCommand(v *viper.Viper, state *parseState) (*cobra.Command, error) {
 	_ = flags.MarkHidden(legacyListenModeKey)
 	_ = flags.MarkHidden(legacyServiceNameKey)
 
-	keys := []string{
-		"port",
-		deviceNameKey,
-		legacyTailscaleNameKey,
-		"funnel",
-		"funnel-allowlist",
-		"verbose",
-		"json",
-		"log-file",
-		"auth-key",
-		"force-tsnet",
-		"set-path",
-		"serve-port",
-		"use-https",
-		"no-tui",
-		"no-ui",
-		"ui-port",
-		"version",
-		"mock",
-		"cleanup-serve",
-		listenModeKey,
-		serviceNameKey,
-		legacyListenModeKey,
-		legacyServiceNameKey,
-	}
-
-	for _, key := range keys {
+	for _, key := range boundKeys {
 		if flag := flags.Lookup(key); flag != nil {
 			if err := v.BindPFlag(key, flag); err != nil {
 				return nil, fmt.Errorf("failed to bind flag %s: %w", key, err)
@@ -354,7 +933,93 @@ func newRootCommand(v *viper.Viper, state *parseState) (*cobra.Command, error) {
 	return cmd, nil
 }
 
+func (s *parseState) setPort(arg string) error {
+	port, err := strconv.Atoi(arg)
+	if err != nil || port <= 0 {
+		return fmt.Errorf("invalid port %q: must be a positive integer", arg)
+	}
+
+	s.port = port
+	s.portSet = true
+	return nil
+}
+
+// ValidateName checks a session name. Names become log file names, so they
+// are limited to letters, digits, '.', '_' and '-', and cannot start with
+// punctuation.
+func ValidateName(name string) error {
+	for i, r := range name {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+		case i > 0 && (r == '.' || r == '_' || r == '-'):
+		default:
+			return fmt.Errorf("invalid name %q: use letters, digits, '.', '_' and '-', starting with a letter or digit", name)
+		}
+	}
+	if name == "" {
+		return fmt.Errorf("name must not be empty")
+	}
+	return nil
+}
+
+// applyProfile layers the selected profile over the config file, so that its
+// settings win over top-level config file settings but not over environment
+// variables or flags. It returns the profile name, or "" when none is used.
+func applyProfile(v *viper.Viper, state *parseState) (string, error) {
+	name := strings.TrimSpace(v.GetString(profileKey))
+	if state.profile != "" {
+		if name != "" && !strings.EqualFold(name, state.profile) {
+			return "", fmt.Errorf("conflicting profiles: up %s and --%s %s", state.profile, profileKey, name)
+		}
+		name = state.profile
+	}
+	if name == "" {
+		return "", nil
+	}
+
+	profiles := v.GetStringMap(profilesKey)
+	settings, ok := profiles[strings.ToLower(name)]
+	if !ok {
+		if len(profiles) == 0 {
+			return "", fmt.Errorf("unknown profile %q: %s has no %s section", name, v.ConfigFileUsed(), profilesKey)
+		}
+		return "", fmt.Errorf("unknown profile %q (available: %s)", name, strings.Join(profileNames(profiles), ", "))
+	}
+
+	values, ok := settings.(map[string]any)
+	if !ok {
+		if settings != nil {
+			return "", fmt.Errorf("invalid profile %q: must be a map of settings", name)
+		}
+		values = map[string]any{}
+	}
+	for key := range values {
+		if key == profileKey || key == profilesKey {
+			return "", fmt.Errorf("invalid profile %q: cannot set %s", name, key)
+		}
+	}
+	if err := v.MergeConfigMap(values); err != nil {
+		return "", fmt.Errorf("invalid profile %q: %w", name, err)
+	}
+	state.profileSettings = values
+	return strings.ToLower(name), nil
+}
+
+func profileNames(profiles map[string]any) []string {
+	names := make([]string, 0, len(profiles))
+	for name := range profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
 func helpRequested(cmd *cobra.Command, args []string) bool {
+	// Commands that only group subcommands print their help when run.
+	if !cmd.Runnable() {
+		return true
+	}
+
 	help, err := cmd.Flags().GetBool("help")
 	if err == nil && help {
 		return true
@@ -393,37 +1058,165 @@ func normalizeList(value any) []string {
 	return normalized
 }
 
-func parseFunnelAllowlist(entries []string) ([]netip.Prefix, error) {
-	parsed := make([]netip.Prefix, 0, len(entries))
+// parseAccessEntries parses funnel allowlist or denylist entries; list names
+// the setting in errors.
+func parseAccessEntries(list string, entries []string) ([]access.Entry, error) {
+	parsed := make([]access.Entry, 0, len(entries))
 	for _, entry := range entries {
-		prefix, err := parseAllowlistEntry(entry)
+		accessEntry, err := access.ParseEntry(entry)
 		if err != nil {
-			return nil, fmt.Errorf("invalid funnel allowlist entry %q: must be an IP address or CIDR block", entry)
+			lower := strings.ToLower(strings.TrimSpace(entry))
+			if strings.HasPrefix(lower, "file:") || strings.HasPrefix(lower, "preset:") {
+				return nil, fmt.Errorf("invalid %s entry %q: %w", list, entry, err)
+			}
+			return nil, fmt.Errorf("invalid %s entry %q: must be an IP address, CIDR block, country:<code>, asn:<number>, file:<path> or preset:<name>", list, entry)
 		}
-		parsed = append(parsed, prefix)
+		parsed = append(parsed, accessEntry)
 	}
 	return parsed, nil
 }
 
-func parseAllowlistEntry(entry string) (netip.Prefix, error) {
-	if strings.Contains(entry, "/") {
-		prefix, err := netip.ParsePrefix(entry)
+// ruleSpec is a Funnel access rule as written in config or a rules file.
+type ruleSpec struct {
+	Name    string   `mapstructure:"name"`
+	Path    string   `mapstructure:"path"`
+	Methods []string `mapstructure:"methods"`
+	Allow   []string `mapstructure:"allow"`
+	Deny    []string `mapstructure:"deny"`
+	Default string   `mapstructure:"default"`
+}
+
+// loadFunnelRules reads Funnel access rules from the funnel-rules config key
+// or the rules: list of a separate YAML or JSON rules file.
+func loadFunnelRules(v *viper.Viper) ([]access.Rule, error) {
+	path := strings.TrimSpace(v.GetString(funnelRulesFileKey))
+	if path != "" && v.IsSet(funnelRulesKey) {
+		return nil, fmt.Errorf("set %s or %s, not both", funnelRulesKey, funnelRulesFileKey)
+	}
+
+	source, key := v, funnelRulesKey
+	if path != "" {
+		source, key = viper.New(), "rules"
+		source.SetConfigFile(path)
+		if err := source.ReadInConfig(); err != nil {
+			return nil, fmt.Errorf("failed to read %s %s: %w", funnelRulesFileKey, path, err)
+		}
+	}
+
+	var specs []ruleSpec
+	if err := source.UnmarshalKey(key, &specs); err != nil {
+		return nil, fmt.Errorf("invalid %s: %w", funnelRulesKey, err)
+	}
+	if path != "" {
+		// file: entries in a rules file are relative to the rules file.
+		dir, err := filepath.Abs(filepath.Dir(path))
 		if err != nil {
-			return netip.Prefix{}, err
+			return nil, err
+		}
+		for i := range specs {
+			for j, entry := range specs[i].Allow {
+				specs[i].Allow[j] = resolveFileEntry(entry, dir)
+			}
+			for j, entry := range specs[i].Deny {
+				specs[i].Deny[j] = resolveFileEntry(entry, dir)
+			}
 		}
-		return prefix.Masked(), nil
 	}
+	return parseFunnelRules(specs)
+}
 
-	addr, err := netip.ParseAddr(entry)
-	if err != nil {
-		return netip.Prefix{}, err
+func parseFunnelRules(specs []ruleSpec) ([]access.Rule, error) {
+	rules := make([]access.Rule, 0, len(specs))
+	for i, spec := range specs {
+		name := strings.TrimSpace(spec.Name)
+		if name == "" {
+			name = fmt.Sprintf("rule %d", i+1)
+		}
+		path := strings.TrimSpace(spec.Path)
+		if !strings.HasPrefix(path, "/") {
+			return nil, fmt.Errorf("invalid funnel rule %q: path %q must start with /", name, spec.Path)
+		}
+
+		methods := make([]string, 0, len(spec.Methods))
+		for _, method := range spec.Methods {
+			method = strings.ToUpper(strings.TrimSpace(method))
+			if method == "" {
+				continue
+			}
+			methods = append(methods, method)
+		}
+
+		allow, err := parseAccessEntries(fmt.Sprintf("funnel rule %q allow", name), spec.Allow)
+		if err != nil {
+			return nil, err
+		}
+		deny, err := parseAccessEntries(fmt.Sprintf("funnel rule %q deny", name), spec.Deny)
+		if err != nil {
+			return nil, err
+		}
+
+		action := strings.ToLower(strings.TrimSpace(spec.Default))
+		switch action {
+		case "":
+			// Listing allowed sources implies everyone else is refused.
+			action = access.ActionAllow
+			if len(allow) > 0 {
+				action = access.ActionDeny
+			}
+		case access.ActionAllow, access.ActionDeny:
+		default:
+			return nil, fmt.Errorf("invalid funnel rule %q: default %q must be %q or %q", name, spec.Default, access.ActionAllow, access.ActionDeny)
+		}
+
+		rules = append(rules, access.Rule{
+			Name:       name,
+			PathPrefix: path,
+			Methods:    methods,
+			Allow:      allow,
+			Deny:       deny,
+			Default:    action,
+		})
+	}
+	return rules, nil
+}
+
+// FunnelFileSets returns the file-backed entry sets used by the Funnel
+// allowlist, denylist and rules, so they can be watched for changes.
+func (c *Config) FunnelFileSets() []*access.Set {
+	sets := access.FileSets(c.FunnelAllowlist)
+	sets = append(sets, access.FileSets(c.FunnelDenylist)...)
+	for _, rule := range c.FunnelRules {
+		sets = append(sets, access.FileSets(rule.Allow)...)
+		sets = append(sets, access.FileSets(rule.Deny)...)
+	}
+	return sets
+}
+
+func rulesNeedGeo(rules []access.Rule) bool {
+	for _, rule := range rules {
+		if rule.NeedsGeo() {
+			return true
+		}
 	}
-	addr = addr.Unmap()
+	return false
+}
+
+func parseRoutes(entries []string) ([]string, error) {
+	for _, entry := range entries {
+		if !strings.HasPrefix(entry, "/") {
+			return nil, fmt.Errorf("invalid route %q: must start with /", entry)
+		}
+	}
+	return entries, nil
+}
 
-	if addr.Is4() {
-		return netip.PrefixFrom(addr, 32), nil
+func parseAutoBanPaths(entries []string) ([]string, error) {
+	for _, entry := range entries {
+		if !strings.HasPrefix(entry, "/") {
+			return nil, fmt.Errorf("invalid auto-ban path %q: must start with /", entry)
+		}
 	}
-	return netip.PrefixFrom(addr, 128), nil
+	return entries, nil
 }
 
 func resolveAliasedSetting(v *viper.Viper, canonicalKey, legacyKey string, normalize func(string) string) (string, error) {
@@ -2,14 +2,16 @@ package config
 
 import (
 	"errors"
-	"net/netip"
 	"os"
 	"path/filepath"
 	"slices"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/spf13/pflag"
+
+	"github.com/jaxxstorm/portal/internal/access"
 )
 
 func TestMain(m *testing.M) {
@@ -636,6 +638,81 @@ func TestParseArgsHelpReturnsHelpError(t *testing.T) {
 	if !errors.Is(err, pflag.ErrHelp) {
 		t.Fatalf("expected pflag.ErrHelp, got %v", err)
 	}
+
+	_, err = ParseArgs([]string{"up", "--help"})
+	if !errors.Is(err, pflag.ErrHelp) {
+		t.Fatalf("expected pflag.ErrHelp for up, got %v", err)
+	}
+}
+
+func TestParseArgsProfiles(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	writeConfigFile(t, home, `verbose: true
+serve-port: 8080
+set-path: /
+profiles:
+  api:
+    port: 8080
+    funnel: true
+    set-path: /hooks
+  web:
+    port: 3000
+`)
+
+	tests := []struct {
+		name      string
+		args      []string
+		env       map[string]string
+		wantPort  int
+		wantPath  string
+		wantServe int
+		wantErr   string
+	}{
+		{name: "profile flag", args: []string{"--profile", "api"}, wantPort: 8080, wantPath: "/hooks", wantServe: 8080},
+		{name: "up command", args: []string{"up", "api"}, wantPort: 8080, wantPath: "/hooks", wantServe: 8080},
+		{name: "up with port", args: []string{"up", "web", "4000"}, wantPort: 4000, wantPath: "/", wantServe: 8080},
+		{name: "flag beats profile", args: []string{"up", "api", "--set-path", "/other"}, wantPort: 8080, wantPath: "/other", wantServe: 8080},
+		{name: "env beats profile", args: []string{"up", "api"}, env: map[string]string{"PORTAL_SET_PATH": "/env"}, wantPort: 8080, wantPath: "/env", wantServe: 8080},
+		{name: "env selects profile", args: []string{}, env: map[string]string{"PORTAL_PROFILE": "web"}, wantPort: 3000, wantPath: "/", wantServe: 8080},
+		{name: "unknown profile", args: []string{"up", "db"}, wantErr: `unknown profile "db" (available: api, web)`},
+		{name: "conflicting profiles", args: []string{"up", "api", "--profile", "web"}, wantErr: "conflicting profiles"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			for key, value := range tc.env {
+				t.Setenv(key, value)
+			}
+
+			cfg, err := ParseArgs(tc.args)
+			if tc.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+					t.Fatalf("expected error containing %q, got %v", tc.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+			if cfg.Port != tc.wantPort || cfg.GetSetPath() != tc.wantPath || cfg.ServePort != tc.wantServe {
+				t.Fatalf("expected port=%d set-path=%s serve-port=%d, got port=%d set-path=%s serve-port=%d",
+					tc.wantPort, tc.wantPath, tc.wantServe, cfg.Port, cfg.GetSetPath(), cfg.ServePort)
+			}
+			if !cfg.Verbose {
+				t.Fatal("expected top-level config file settings to still apply")
+			}
+		})
+	}
+}
+
+func TestParseArgsProfileWithoutProfilesSection(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+
+	_, err := ParseArgs([]string{"up", "api"})
+	if err == nil || !strings.Contains(err.Error(), "has no profiles section") {
+		t.Fatalf("expected missing profiles error, got %v", err)
+	}
 }
 
 func writeConfigFile(t *testing.T, homeDir, content string) {
@@ -652,10 +729,375 @@ func writeConfigFile(t *testing.T, homeDir, content string) {
 	}
 }
 
-func funnelAllowlistStrings(prefixes []netip.Prefix) []string {
-	result := make([]string, 0, len(prefixes))
-	for _, prefix := range prefixes {
-		result = append(result, prefix.String())
+func funnelAllowlistStrings(entries []access.Entry) []string {
+	result := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		result = append(result, entry.String())
 	}
 	return result
 }
+
+func TestParseArgsRequestIDHeaderDefaultsAndOverrides(t *testing.T) {
+	cfg, err := ParseArgs([]string{"8080"})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if cfg.RequestIDHeader != DefaultRequestIDHeader {
+		t.Fatalf("expected default request ID header %q, got %q", DefaultRequestIDHeader, cfg.RequestIDHeader)
+	}
+
+	t.Setenv("PORTAL_REQUEST_ID_HEADER", "x-correlation-id")
+	cfg, err = ParseArgs([]string{"8080"})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if cfg.RequestIDHeader != "X-Correlation-Id" {
+		t.Fatalf("expected canonical env request ID header, got %q", cfg.RequestIDHeader)
+	}
+
+	cfg, err = ParseArgs([]string{"8080", "--request-id-header", "X-Trace-Request"})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if cfg.RequestIDHeader != "X-Trace-Request" {
+		t.Fatalf("expected CLI request ID header to win, got %q", cfg.RequestIDHeader)
+	}
+}
+
+func TestParseArgsOTelSettings(t *testing.T) {
+	cfg, err := ParseArgs([]string{"8080"})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if cfg.OTelEndpoint != "" || cfg.OTelProtocol != "grpc" {
+		t.Fatalf("expected tracing disabled over grpc by default, got %q/%q", cfg.OTelEndpoint, cfg.OTelProtocol)
+	}
+
+	t.Setenv("PORTAL_OTEL_ENDPOINT", "localhost:4317")
+	cfg, err = ParseArgs([]string{"8080", "--otel-protocol", "HTTP"})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if cfg.OTelEndpoint != "localhost:4317" || cfg.OTelProtocol != "http" {
+		t.Fatalf("unexpected otel settings %q/%q", cfg.OTelEndpoint, cfg.OTelProtocol)
+	}
+
+	_, err = ParseArgs([]string{"8080", "--otel-protocol", "thrift"})
+	if err == nil || !strings.Contains(err.Error(), "invalid otel-protocol") {
+		t.Fatalf("expected invalid protocol error, got %v", err)
+	}
+}
+
+func TestParseArgsRoutesFromConfigAndEnv(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	writeConfigFile(t, home, "routes:\n  - /users/{id}\n  - /static/*\n")
+
+	cfg, err := ParseArgs([]string{"8080"})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if strings.Join(cfg.Routes, ",") != "/users/{id},/static/*" {
+		t.Fatalf("unexpected routes from config: %q", cfg.Routes)
+	}
+
+	t.Setenv("PORTAL_ROUTES", "/orders/{id}, /health")
+	cfg, err = ParseArgs([]string{"8080"})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if strings.Join(cfg.Routes, ",") != "/orders/{id},/health" {
+		t.Fatalf("expected env routes to win, got %q", cfg.Routes)
+	}
+
+	t.Setenv("PORTAL_ROUTES", "orders")
+	if _, err := ParseArgs([]string{"8080"}); err == nil || !strings.Contains(err.Error(), "must start with /") {
+		t.Fatalf("expected invalid route error, got %v", err)
+	}
+}
+
+func TestParseArgsBlocklistAndAutoBanSettings(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+
+	cfg, err := ParseArgs([]string{"8080"})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if cfg.BlocklistFile != filepath.Join(home, ".portal", "blocklist.json") {
+		t.Fatalf("unexpected default blocklist file %q", cfg.BlocklistFile)
+	}
+	if cfg.AutoBan || cfg.AutoBanThreshold != 20 || cfg.AutoBanWindow != time.Minute || len(cfg.AutoBanPaths) == 0 {
+		t.Fatalf("unexpected auto-ban defaults: %+v", cfg)
+	}
+
+	writeConfigFile(t, home, "auto-ban: true\nauto-ban-paths:\n  - /.env\n  - /admin.php\n")
+	cfg, err = ParseArgs([]string{"8080", "--auto-ban-404-threshold", "5", "--auto-ban-404-window", "30s"})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if !cfg.AutoBan || strings.Join(cfg.AutoBanPaths, ",") != "/.env,/admin.php" {
+		t.Fatalf("unexpected auto-ban settings from config: %+v", cfg)
+	}
+	if cfg.AutoBanThreshold != 5 || cfg.AutoBanWindow != 30*time.Second {
+		t.Fatalf("expected CLI thresholds to win, got %d/%s", cfg.AutoBanThreshold, cfg.AutoBanWindow)
+	}
+
+	t.Setenv("PORTAL_AUTO_BAN_PATHS", "admin.php")
+	if _, err := ParseArgs([]string{"8080"}); err == nil || !strings.Contains(err.Error(), "invalid auto-ban path") {
+		t.Fatalf("expected invalid auto-ban path error, got %v", err)
+	}
+	t.Setenv("PORTAL_AUTO_BAN_PATHS", "")
+
+	if _, err := ParseArgs([]string{"8080", "--auto-ban-404-window", "0s"}); err == nil {
+		t.Fatal("expected zero auto-ban window to be rejected")
+	}
+}
+
+func TestParseArgsFunnelDenylistAndGeoIPEntries(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	writeConfigFile(t, home, "funnel: true\nfunnel-allowlist:\n  - 203.0.113.10\n  - country:nl\nfunnel-denylist:\n  - asn:AS64500\n  - 198.51.100.0/24\ngeoip-db: /var/lib/geoip/GeoLite2-Country.mmdb\n")
+
+	cfg, err := ParseArgs([]string{"8080", "--geoip-asn-db", "/var/lib/geoip/GeoLite2-ASN.mmdb"})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if got, want := funnelAllowlistStrings(cfg.FunnelAllowlist), []string{"203.0.113.10/32", "country:NL"}; !slices.Equal(got, want) {
+		t.Fatalf("unexpected allowlist %v", got)
+	}
+	if got, want := funnelAllowlistStrings(cfg.FunnelDenylist), []string{"asn:64500", "198.51.100.0/24"}; !slices.Equal(got, want) {
+		t.Fatalf("unexpected denylist %v", got)
+	}
+	if cfg.GeoIPDB != "/var/lib/geoip/GeoLite2-Country.mmdb" || cfg.GeoIPASNDB != "/var/lib/geoip/GeoLite2-ASN.mmdb" {
+		t.Fatalf("unexpected GeoIP databases %q/%q", cfg.GeoIPDB, cfg.GeoIPASNDB)
+	}
+	if !cfg.HasFunnelDenylist() || !cfg.UseFunnelProxyProtocol() {
+		t.Fatal("expected denylist enforcement over PROXY protocol")
+	}
+
+	t.Setenv("PORTAL_FUNNEL_DENYLIST", "country:usa")
+	if _, err := ParseArgs([]string{"8080"}); err == nil || !strings.Contains(err.Error(), `invalid funnel denylist entry "country:usa"`) {
+		t.Fatalf("expected invalid denylist entry error, got %v", err)
+	}
+}
+
+func TestParseArgsGeoIPEntriesRequireDatabase(t *testing.T) {
+	t.Setenv("PORTAL_FUNNEL", "true")
+	t.Setenv("PORTAL_FUNNEL_ALLOWLIST", "country:US")
+
+	if _, err := ParseArgs([]string{"8080"}); err == nil || !strings.Contains(err.Error(), "require geoip-db") {
+		t.Fatalf("expected missing GeoIP database error, got %v", err)
+	}
+}
+
+func TestParseArgsFunnelStrictBindsProxyToLoopback(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+
+	cfg, err := ParseArgs([]string{"8080"})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if cfg.FunnelStrict || cfg.ProxyBindHost() != "0.0.0.0" {
+		t.Fatalf("expected non-strict default on all interfaces, got %v %q", cfg.FunnelStrict, cfg.ProxyBindHost())
+	}
+
+	writeConfigFile(t, home, "funnel-allowlist-strict: true\n")
+	cfg, err = ParseArgs([]string{"8080", "--funnel", "--set-path", "/hooks"})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if !cfg.FunnelStrict || cfg.ProxyBindHost() != "127.0.0.1" {
+		t.Fatalf("expected strict mode on loopback, got %v %q", cfg.FunnelStrict, cfg.ProxyBindHost())
+	}
+}
+
+func TestParseArgsFunnelRulesFromConfigAndFile(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+
+	writeConfigFile(t, home, `funnel: true
+funnel-rules:
+  - name: github
+    path: /hooks/github
+    methods: [post]
+    allow: [192.30.252.0/22]
+  - path: /admin
+    default: deny
+  - path: /public
+    deny: [192.0.2.0/24]
+`)
+	cfg, err := ParseArgs([]string{"8080"})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if !cfg.HasFunnelRules() || !cfg.HasFunnelAccessControl() || len(cfg.FunnelRules) != 3 {
+		t.Fatalf("expected three funnel rules, got %+v", cfg.FunnelRules)
+	}
+	github, admin, public := cfg.FunnelRules[0], cfg.FunnelRules[1], cfg.FunnelRules[2]
+	if github.Name != "github" || strings.Join(github.Methods, ",") != "POST" || funnelAllowlistStrings(github.Allow)[0] != "192.30.252.0/22" || github.Default != access.ActionDeny {
+		t.Fatalf("unexpected github rule %+v", github)
+	}
+	if admin.Name != "rule 2" || admin.Default != access.ActionDeny {
+		t.Fatalf("unexpected admin rule %+v", admin)
+	}
+	if public.Default != access.ActionAllow {
+		t.Fatalf("expected deny-only rule to default to allow, got %+v", public)
+	}
+
+	rulesPath := filepath.Join(home, "rules.json")
+	if err := os.WriteFile(rulesPath, []byte(`{"rules":[{"name":"stripe","path":"/hooks/stripe","allow":["3.18.12.63"]}]}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ParseArgs([]string{"8080", "--funnel-rules-file", rulesPath}); err == nil || !strings.Contains(err.Error(), "not both") {
+		t.Fatalf("expected funnel-rules and funnel-rules-file conflict, got %v", err)
+	}
+
+	writeConfigFile(t, home, "funnel: true\n")
+	cfg, err = ParseArgs([]string{"8080", "--funnel-rules-file", rulesPath})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if len(cfg.FunnelRules) != 1 || cfg.FunnelRules[0].Name != "stripe" || cfg.FunnelRules[0].PathPrefix != "/hooks/stripe" {
+		t.Fatalf("unexpected rules from file %+v", cfg.FunnelRules)
+	}
+}
+
+func TestParseArgsRejectsInvalidFunnelRules(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+
+	for content, want := range map[string]string{
+		"funnel-rules:\n  - path: hooks\n":                          "must start with /",
+		"funnel-rules:\n  - path: /hooks\n    allow: [nope]\n":      `invalid funnel rule "rule 1" allow entry "nope"`,
+		"funnel-rules:\n  - path: /hooks\n    default: maybe\n":     `default "maybe" must be`,
+		"funnel-rules:\n  - path: /hooks\n    deny: [country:NL]\n": "require geoip-db",
+	} {
+		writeConfigFile(t, home, content)
+		if _, err := ParseArgs([]string{"8080"}); err == nil || !strings.Contains(err.Error(), want) {
+			t.Fatalf("expected error containing %q for %q, got %v", want, content, err)
+		}
+	}
+
+	writeConfigFile(t, home, "funnel: true\n")
+	if _, err := ParseArgs([]string{"8080", "--funnel-rules-file", filepath.Join(home, "missing.yml")}); err == nil || !strings.Contains(err.Error(), "failed to read funnel-rules-file") {
+		t.Fatalf("expected missing rules file to fail, got %v", err)
+	}
+}
+
+func TestParseArgsFunnelAllowlistFileAndPresetEntries(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+
+	rangesPath := filepath.Join(home, "ranges.txt")
+	if err := os.WriteFile(rangesPath, []byte("203.0.113.0/24\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	writeConfigFile(t, home, "funnel: true\nfunnel-rules:\n  - path: /hooks/github\n    allow: [preset:github-hooks]\n")
+	t.Setenv("PORTAL_FUNNEL_ALLOWLIST", "file:"+rangesPath)
+
+	cfg, err := ParseArgs([]string{"8080"})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if got := funnelAllowlistStrings(cfg.FunnelAllowlist); len(got) != 1 || got[0] != "file:"+rangesPath {
+		t.Fatalf("unexpected allowlist %v", got)
+	}
+	if got := funnelAllowlistStrings(cfg.FunnelRules[0].Allow); got[0] != "preset:github-hooks" {
+		t.Fatalf("unexpected rule allow entries %v", got)
+	}
+	if sets := cfg.FunnelFileSets(); len(sets) != 1 || sets[0].Path() != rangesPath {
+		t.Fatalf("expected only the file set to be watched, got %v", sets)
+	}
+
+	t.Setenv("PORTAL_FUNNEL_ALLOWLIST", "preset:slack")
+	if _, err := ParseArgs([]string{"8080"}); err == nil || !strings.Contains(err.Error(), `unknown preset "slack"`) {
+		t.Fatalf("expected unknown preset error, got %v", err)
+	}
+}
+
+func TestParseArgsStatusJSONIsSeparateFromJSONLogs(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	t.Chdir(t.TempDir())
+
+	cfg, err := ParseArgs([]string{"status", "--json"})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if cfg.Command != CommandStatus || !cfg.JSONOutput {
+		t.Fatalf("expected status with JSON output, got command %q json_output=%t", cfg.Command, cfg.JSONOutput)
+	}
+	if cfg.JSON {
+		t.Fatal("expected status --json not to switch logs to JSON")
+	}
+
+	t.Setenv("PORTAL_JSON", "true")
+	cfg, err = ParseArgs([]string{"status"})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if cfg.JSONOutput {
+		t.Fatal("expected JSON logs not to switch status output to JSON")
+	}
+}
+
+func TestParseArgsBackgroundCommands(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	t.Chdir(t.TempDir())
+
+	cfg, err := ParseArgs([]string{"start", "8080", "--funnel", "--name", "api"})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if cfg.Command != CommandStart || cfg.Port != 8080 || !cfg.Funnel || cfg.Name != "api" {
+		t.Fatalf("unexpected start config: command %q port %d funnel %t name %q", cfg.Command, cfg.Port, cfg.Funnel, cfg.Name)
+	}
+
+	if _, err := ParseArgs([]string{"start"}); err == nil || !strings.Contains(err.Error(), "port argument is required") {
+		t.Fatalf("expected start to require a port like a normal run, got %v", err)
+	}
+	if _, err := ParseArgs([]string{"start", "8080", "--name", "../api"}); err == nil || !strings.Contains(err.Error(), "invalid name") {
+		t.Fatalf("expected an invalid name to be rejected, got %v", err)
+	}
+
+	cfg, err = ParseArgs([]string{"stop", "api"})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if cfg.Command != CommandStop || cfg.CommandArg != "api" {
+		t.Fatalf("unexpected stop config: command %q arg %q", cfg.Command, cfg.CommandArg)
+	}
+
+	cfg, err = ParseArgs([]string{"logs", "api", "--follow"})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if cfg.Command != CommandLogs || cfg.CommandArg != "api" || !cfg.Follow {
+		t.Fatalf("unexpected logs config: command %q arg %q follow %t", cfg.Command, cfg.CommandArg, cfg.Follow)
+	}
+
+	cfg, err = ParseArgs([]string{"ctl", "api", "target", "3000"})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if cfg.Command != CommandCtl || cfg.CommandArg != "api" || strings.Join(cfg.CommandArgs, " ") != "target 3000" {
+		t.Fatalf("unexpected ctl config: command %q arg %q args %q", cfg.Command, cfg.CommandArg, cfg.CommandArgs)
+	}
+	if _, err := ParseArgs([]string{"ctl", "api"}); err == nil {
+		t.Fatal("expected ctl without an action to be rejected")
+	}
+}
+
+func TestValidateName(t *testing.T) {
+	for _, name := range []string{"api", "8080", "web-ui", "svc.v2", "my_app"} {
+		if err := ValidateName(name); err != nil {
+			t.Fatalf("expected %q to be valid, got %v", name, err)
+		}
+	}
+	for _, name := range []string{"", "-api", ".hidden", "a/b", "a b", "../api"} {
+		if err := ValidateName(name); err == nil {
+			t.Fatalf("expected %q to be rejected", name)
+		}
+	}
+}
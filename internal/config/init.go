@@ -0,0 +1,96 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// starterConfig is written by portal config init. Every setting is commented
+// out at its default, so the file changes nothing until edited.
+const starterConfig = `# portal configuration
+#
+# Settings here apply to every run. Flags and PORTAL_* environment variables
+# take precedence, and a .portal.yml in a project directory is merged over
+# this file. Run "portal config validate" after editing, and
+# "portal config show" to see where each value comes from.
+
+# Local port to expose (or pass it as an argument: portal 8080)
+# port: 8080
+
+# Public internet access through Tailscale Funnel (implies use-https)
+# funnel: false
+
+# Serve settings
+# set-path: /
+# serve-port: 80            # 443 with use-https or funnel
+# use-https: false
+
+# tsnet backend, used when no local tailscaled is running
+# device-name: portal
+# listen-mode: listener     # or service (needs a tagged node, no funnel)
+# service-name: svc:portal
+# auth-key: tskey-...
+# force-tsnet: false
+
+# Funnel access control: IPs, CIDR blocks, country:<code>, asn:<number>,
+# file:<path> or preset:<name>
+# funnel-allowlist:
+#   - preset:github-hooks
+# funnel-denylist: []
+# funnel-allowlist-strict: false
+# geoip-db: /var/lib/GeoIP/GeoLite2-Country.mmdb
+
+# Path-scoped Funnel access rules
+# funnel-rules:
+#   - name: github
+#     path: /hooks/github
+#     methods: [POST]
+#     allow: [preset:github-hooks]
+
+# Output
+# verbose: false
+# json: false
+# log-file: ""
+# no-tui: false
+# no-ui: false
+# ui-port: 4040
+# capture-limit: 1000
+# name: api  # used by portal stop and portal logs
+
+# Request IDs, tracing and per-route statistics
+# request-id-header: X-Request-ID
+# otel-endpoint: localhost:4317
+# otel-protocol: grpc
+# routes:
+#   - /users/{id}
+
+# Blocklist and scanner auto-ban
+# blocklist-file: /path/to/blocklist.json  # default: ~/.portal/blocklist.json
+# auto-ban: false
+# auto-ban-404-threshold: 20
+# auto-ban-404-window: 1m
+
+# Named profiles, run with "portal up <name>"
+# profiles:
+#   api:
+#     port: 8080
+#     funnel: true
+#     set-path: /hooks
+`
+
+// WriteStarterConfig writes a commented starter config file to path. It
+// refuses to replace an existing file unless force is set.
+func WriteStarterConfig(path string, force bool) error {
+	if _, err := os.Stat(path); err == nil && !force {
+		return fmt.Errorf("%s already exists; use --force to overwrite it", path)
+	} else if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	return os.WriteFile(path, []byte(starterConfig), 0o644)
+}
@@ -0,0 +1,119 @@
+package config
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/jaxxstorm/portal/internal/access"
+)
+
+// Change is a setting whose value differs between two configurations.
+type Change struct {
+	Key        string
+	Old        string
+	New        string
+	Reloadable bool // applied while portal runs; otherwise needs a restart
+}
+
+// setting describes how one setting is compared between configurations.
+type setting struct {
+	key        string
+	reloadable bool
+	secret     bool // compared but never shown
+	value      func(*Config) string
+}
+
+// settings lists every setting compared on reload, in display order.
+var settings = []setting{
+	{"port", false, false, func(c *Config) string { return strconv.Itoa(c.Port) }},
+	{deviceNameKey, false, false, func(c *Config) string { return c.TailscaleName }},
+	{"funnel", false, false, func(c *Config) string { return strconv.FormatBool(c.Funnel) }},
+	{"funnel-allowlist", true, false, func(c *Config) string { return formatEntries(c.FunnelAllowlist) }},
+	{funnelDenylistKey, true, false, func(c *Config) string { return formatEntries(c.FunnelDenylist) }},
+	{funnelRulesKey, true, false, func(c *Config) string { return formatRules(c.FunnelRules) }},
+	{funnelRulesFileKey, true, false, func(c *Config) string { return c.FunnelRulesFile }},
+	{funnelStrictKey, false, false, func(c *Config) string { return strconv.FormatBool(c.FunnelStrict) }},
+	{geoIPDBKey, false, false, func(c *Config) string { return c.GeoIPDB }},
+	{geoIPASNDBKey, false, false, func(c *Config) string { return c.GeoIPASNDB }},
+	{"verbose", true, false, func(c *Config) string { return strconv.FormatBool(c.Verbose) }},
+	{"json", false, false, func(c *Config) string { return strconv.FormatBool(c.JSON) }},
+	{"log-file", false, false, func(c *Config) string { return c.LogFile }},
+	{"auth-key", false, true, func(c *Config) string { return c.AuthKey }},
+	{"force-tsnet", false, false, func(c *Config) string { return strconv.FormatBool(c.ForceTsnet) }},
+	{"set-path", false, false, func(c *Config) string { return c.SetPath }},
+	{"serve-port", false, false, func(c *Config) string { return strconv.Itoa(c.ServePort) }},
+	{"use-https", false, false, func(c *Config) string { return strconv.FormatBool(c.UseHTTPS) }},
+	{"no-tui", false, false, func(c *Config) string { return strconv.FormatBool(c.NoTUI) }},
+	{"no-ui", false, false, func(c *Config) string { return strconv.FormatBool(c.NoUI) }},
+	{"ui-port", false, false, func(c *Config) string { return strconv.Itoa(c.UIPort) }},
+	{"mock", false, false, func(c *Config) string { return strconv.FormatBool(c.Mock) }},
+	{nameKey, false, false, func(c *Config) string { return c.Name }},
+	{listenModeKey, false, false, func(c *Config) string { return c.TSNetListenMode }},
+	{serviceNameKey, false, false, func(c *Config) string { return c.TSNetServiceName }},
+	{requestIDHeaderKey, false, false, func(c *Config) string { return c.RequestIDHeader }},
+	{otelEndpointKey, false, false, func(c *Config) string { return c.OTelEndpoint }},
+	{otelProtocolKey, false, false, func(c *Config) string { return c.OTelProtocol }},
+	{routesKey, true, false, func(c *Config) string { return strings.Join(c.Routes, ",") }},
+	{captureLimitKey, true, false, func(c *Config) string { return strconv.Itoa(c.CaptureLimit) }},
+	{blocklistFileKey, false, false, func(c *Config) string { return c.BlocklistFile }},
+	{autoBanKey, false, false, func(c *Config) string { return strconv.FormatBool(c.AutoBan) }},
+	{autoBanPathsKey, false, false, func(c *Config) string { return strings.Join(c.AutoBanPaths, ",") }},
+	{autoBanThresholdKey, false, false, func(c *Config) string { return strconv.Itoa(c.AutoBanThreshold) }},
+	{autoBanWindowKey, false, false, func(c *Config) string { return c.AutoBanWindow.String() }},
+}
+
+// Diff lists the settings whose values differ from old to new.
+func Diff(old, new *Config) []Change {
+	var changes []Change
+	for _, s := range settings {
+		oldValue, newValue := s.value(old), s.value(new)
+		if oldValue != newValue {
+			if s.secret {
+				oldValue, newValue = redact(oldValue), redact(newValue)
+			}
+			changes = append(changes, Change{Key: s.key, Old: oldValue, New: newValue, Reloadable: s.reloadable})
+		}
+	}
+	return changes
+}
+
+// ApplyReloadable returns a copy of c with the reloadable settings taken
+// from next, which is the configuration a running portal ends up with after
+// a reload.
+func (c *Config) ApplyReloadable(next *Config) *Config {
+	applied := *c
+	applied.FunnelAllowlist = next.FunnelAllowlist
+	applied.FunnelDenylist = next.FunnelDenylist
+	applied.FunnelRules = next.FunnelRules
+	applied.FunnelRulesFile = next.FunnelRulesFile
+	applied.Verbose = next.Verbose
+	applied.Routes = next.Routes
+	applied.CaptureLimit = next.CaptureLimit
+	return &applied
+}
+
+func formatEntries(entries []access.Entry) string {
+	values := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		values = append(values, entry.String())
+	}
+	return strings.Join(values, ",")
+}
+
+func formatRules(rules []access.Rule) string {
+	values := make([]string, 0, len(rules))
+	for _, rule := range rules {
+		values = append(values, fmt.Sprintf("%s(%s %s allow=%s deny=%s default=%s)",
+			rule.Name, strings.Join(rule.Methods, "|"), rule.PathPrefix,
+			formatEntries(rule.Allow), formatEntries(rule.Deny), rule.Default))
+	}
+	return strings.Join(values, ";")
+}
+
+func redact(value string) string {
+	if value == "" {
+		return ""
+	}
+	return "(set)"
+}
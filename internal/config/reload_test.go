@@ -0,0 +1,54 @@
+package config
+
+import (
+	"testing"
+)
+
+func TestDiffClassifiesReloadableSettings(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+
+	writeConfigFile(t, home, "funnel: true\nfunnel-allowlist: [203.0.113.0/24]\nauth-key: tskey-one\n")
+	old, err := ParseArgs([]string{"8080"})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	writeConfigFile(t, home, "funnel: true\nfunnel-allowlist: [198.51.100.0/24]\nauth-key: tskey-two\nverbose: true\ncapture-limit: 50\nserve-port: 8443\n")
+	next, err := ParseArgs([]string{"8080"})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	got := map[string]Change{}
+	for _, change := range Diff(old, next) {
+		got[change.Key] = change
+	}
+	if len(got) != 5 {
+		t.Fatalf("expected 5 changes, got %+v", got)
+	}
+	if c := got["funnel-allowlist"]; !c.Reloadable || c.Old != "203.0.113.0/24" || c.New != "198.51.100.0/24" {
+		t.Fatalf("unexpected allowlist change %+v", c)
+	}
+	if c := got["capture-limit"]; !c.Reloadable || c.Old != "1000" || c.New != "50" {
+		t.Fatalf("unexpected capture-limit change %+v", c)
+	}
+	if c := got["serve-port"]; c.Reloadable {
+		t.Fatalf("expected serve-port to need a restart, got %+v", c)
+	}
+	if c := got["auth-key"]; c.Reloadable || c.Old != "(set)" || c.New != "(set)" {
+		t.Fatalf("expected auth key to be redacted, got %+v", c)
+	}
+
+	applied := old.ApplyReloadable(next)
+	if !applied.Verbose || applied.CaptureLimit != 50 || applied.ServePort != old.ServePort {
+		t.Fatalf("expected only reloadable settings to be applied, got %+v", applied)
+	}
+	remaining := Diff(applied, next)
+	if len(remaining) != 2 || remaining[0].Reloadable || remaining[1].Reloadable {
+		t.Fatalf("expected only restart-required changes to remain, got %+v", remaining)
+	}
+	if len(Diff(next, next)) != 0 {
+		t.Fatal("expected no changes between identical configurations")
+	}
+}
@@ -0,0 +1,117 @@
+package config
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/spf13/pflag"
+)
+
+// Setting sources reported by portal config show, besides files.
+const (
+	SourceDefault  = "default"
+	SourceArgument = "argument"
+)
+
+// legacyKeys maps canonical settings to the deprecated keys they replace.
+var legacyKeys = map[string]string{
+	deviceNameKey:  legacyTailscaleNameKey,
+	listenModeKey:  legacyListenModeKey,
+	serviceNameKey: legacyServiceNameKey,
+}
+
+// settingSources records the highest-precedence layer that set each setting:
+// the port argument, a flag, an environment variable, the profile, the
+// project config file, the home config file, or the default.
+func settingSources(flags *pflag.FlagSet, state *parseState, profile string) map[string]string {
+	sources := make(map[string]string, len(settings))
+	for _, s := range settings {
+		keys := []string{s.key}
+		if legacy, ok := legacyKeys[s.key]; ok {
+			keys = append(keys, legacy)
+		}
+		sources[s.key] = settingSource(keys, flags, state, profile)
+	}
+	return sources
+}
+
+func settingSource(keys []string, flags *pflag.FlagSet, state *parseState, profile string) string {
+	if keys[0] == "port" && state.portSet {
+		return SourceArgument
+	}
+	for _, key := range keys {
+		if flag := flags.Lookup(key); flag != nil && flag.Changed {
+			return "flag --" + key
+		}
+	}
+	for _, key := range keys {
+		name := envName(key)
+		if os.Getenv(name) != "" {
+			return "env " + name
+		}
+	}
+	layers := []struct {
+		settings map[string]any
+		source   string
+	}{
+		{state.profileSettings, "profile " + profile},
+		{state.projectSettings, state.projectFile},
+		{state.fileSettings, state.configFile},
+	}
+	for _, layer := range layers {
+		for _, key := range keys {
+			if _, ok := layer.settings[key]; ok {
+				return layer.source
+			}
+		}
+	}
+	return SourceDefault
+}
+
+func envName(key string) string {
+	return "PORTAL_" + strings.ToUpper(strings.NewReplacer("-", "_", ".", "_").Replace(key))
+}
+
+// Show writes the effective configuration, with the source of each value,
+// for portal config show.
+func Show(w io.Writer, cfg *Config) error {
+	files := []string{describeFile(cfg.ConfigFile)}
+	if cfg.ProjectConfig != "" {
+		files = append(files, cfg.ProjectConfig)
+	}
+	profile := cfg.Profile
+	if profile == "" {
+		profile = "(none)"
+	}
+	if _, err := fmt.Fprintf(w, "Config files: %s\nProfile: %s\n\n", strings.Join(files, ", "), profile); err != nil {
+		return err
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "SETTING\tSOURCE\tVALUE")
+	for _, s := range settings {
+		value := s.value(cfg)
+		if s.secret {
+			value = redact(value)
+		}
+		if value == "" {
+			value = "-"
+		}
+		source := cfg.Sources[s.key]
+		if s.key == "use-https" && source == SourceDefault && cfg.Funnel {
+			source = "implied by funnel"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", s.key, source, value)
+	}
+	return tw.Flush()
+}
+
+func describeFile(path string) string {
+	if _, err := os.Stat(path); err != nil {
+		return path + " (not found)"
+	}
+	return path
+}
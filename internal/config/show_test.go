@@ -0,0 +1,161 @@
+package config
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParseArgsMergesProjectConfigOverHomeConfig(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	writeConfigFile(t, home, "verbose: true\nfunnel-allowlist-strict: false\nroutes: [/home]\n")
+
+	project := t.TempDir()
+	projectFile := filepath.Join(project, ProjectConfigName)
+	if err := os.WriteFile(projectFile, []byte("funnel-allowlist-strict: true\nroutes: [\"/users/{id}\"]\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	workDir := filepath.Join(project, "cmd", "api")
+	if err := os.MkdirAll(workDir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	t.Chdir(workDir)
+	t.Setenv("PORTAL_CAPTURE_LIMIT", "50")
+
+	cfg, err := ParseArgs([]string{"8080", "--ui-port", "4041"})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if cfg.ProjectConfig != projectFile {
+		t.Fatalf("expected project config %s, got %q", projectFile, cfg.ProjectConfig)
+	}
+	if !cfg.Verbose || !cfg.FunnelStrict || strings.Join(cfg.Routes, ",") != "/users/{id}" {
+		t.Fatalf("expected project settings over home settings, got verbose=%t funnel-allowlist-strict=%t routes=%v", cfg.Verbose, cfg.FunnelStrict, cfg.Routes)
+	}
+
+	homeFile := filepath.Join(home, ".portal", "config.yml")
+	for key, want := range map[string]string{
+		"port":                    SourceArgument,
+		"ui-port":                 "flag --ui-port",
+		"capture-limit":           "env PORTAL_CAPTURE_LIMIT",
+		"funnel-allowlist-strict": projectFile,
+		"verbose":                 homeFile,
+		"json":                    SourceDefault,
+	} {
+		if got := cfg.Sources[key]; got != want {
+			t.Fatalf("expected %s source %q, got %q", key, want, got)
+		}
+	}
+
+	var out bytes.Buffer
+	if err := Show(&out, cfg); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if !strings.Contains(out.String(), "Config files: "+homeFile+", "+projectFile) {
+		t.Fatalf("expected config files in output, got %q", out.String())
+	}
+	var strictLine string
+	for _, line := range strings.Split(out.String(), "\n") {
+		if strings.HasPrefix(line, "funnel-allowlist-strict ") {
+			strictLine = line
+		}
+	}
+	if fields := strings.Fields(strictLine); len(fields) != 3 || fields[1] != projectFile || fields[2] != "true" {
+		t.Fatalf("expected funnel-allowlist-strict row with its source and value, got %q", strictLine)
+	}
+}
+
+func TestParseArgsResolvesProjectPathsFromProjectDir(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	project := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(project, "lists"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(project, "lists", "gh.txt"), []byte("192.0.2.0/24\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	rules := "rules:\n  - path: /hooks\n    allow: [\"file:gh.txt\"]\n"
+	if err := os.WriteFile(filepath.Join(project, "lists", "rules.yml"), []byte(rules), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	settings := "funnel-allowlist: [\"file:lists/gh.txt\"]\nfunnel-rules-file: lists/rules.yml\n"
+	if err := os.WriteFile(filepath.Join(project, ProjectConfigName), []byte(settings), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	workDir := filepath.Join(project, "a", "b")
+	if err := os.MkdirAll(workDir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	t.Chdir(workDir)
+
+	cfg, err := ParseArgs([]string{"8080"})
+	if err != nil {
+		t.Fatalf("expected project files to resolve from the project dir, got %v", err)
+	}
+	if got := cfg.FunnelAllowlist[0].String(); got != "file:"+filepath.Join(project, "lists", "gh.txt") {
+		t.Fatalf("unexpected allowlist entry %q", got)
+	}
+	if cfg.FunnelRulesFile != filepath.Join(project, "lists", "rules.yml") || len(cfg.FunnelRules) != 1 {
+		t.Fatalf("unexpected rules file %q with rules %+v", cfg.FunnelRulesFile, cfg.FunnelRules)
+	}
+}
+
+func TestParseArgsLimitsProjectConfigKeys(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	writeConfigFile(t, home, "profiles:\n  dev:\n    port: 3000\n")
+	project := t.TempDir()
+	t.Chdir(project)
+
+	for _, tt := range []struct {
+		settings string
+		want     string
+	}{
+		{"funnel: true\n", "cannot set funnel"},
+		{"otel-endpoint: collector.example.com:4317\n", "cannot set otel-endpoint"},
+		{"profiles:\n  hooks:\n    auth-key: tskey-123\n", "cannot set auth-key in profile hooks"},
+		{"profiles:\n  dev:\n    funnel: true\n", "cannot change profile dev"},
+	} {
+		if err := os.WriteFile(filepath.Join(project, ProjectConfigName), []byte(tt.settings), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := ParseArgs([]string{"8080"}); err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Fatalf("expected %q to fail with %q, got %v", tt.settings, tt.want, err)
+		}
+	}
+
+	if err := os.WriteFile(filepath.Join(project, ProjectConfigName), []byte("profiles:\n  hooks:\n    funnel: true\n    set-path: /hooks\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := ParseArgs([]string{"up", "hooks", "8080"})
+	if err != nil || !cfg.Funnel || cfg.SetPath != "/hooks" {
+		t.Fatalf("expected a chosen project profile to set funnel, got %+v (err %v)", cfg, err)
+	}
+}
+func TestParseArgsConfigShowNeedsNoPort(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	t.Chdir(t.TempDir())
+	t.Setenv("PORTAL_AUTH_KEY", "tskey-secret")
+
+	cfg, err := ParseArgs([]string{"config", "show"})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if cfg.Command != CommandConfigShow {
+		t.Fatalf("expected config show command, got %q", cfg.Command)
+	}
+
+	var out bytes.Buffer
+	if err := Show(&out, cfg); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if strings.Contains(out.String(), "tskey-secret") {
+		t.Fatalf("expected auth key to be redacted, got %q", out.String())
+	}
+	if !strings.Contains(out.String(), "(not found)") {
+		t.Fatalf("expected missing home config to be reported, got %q", out.String())
+	}
+}
@@ -0,0 +1,248 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/viper"
+	"tailscale.com/tailcfg"
+
+	"github.com/jaxxstorm/portal/internal/access"
+	"github.com/jaxxstorm/portal/internal/telemetry"
+)
+
+// boolKeys are the settings that must be true or false.
+var boolKeys = []string{
+	"funnel",
+	funnelStrictKey,
+	"verbose",
+	"json",
+	"force-tsnet",
+	"use-https",
+	"no-tui",
+	"no-ui",
+	"mock",
+	autoBanKey,
+}
+
+// ValidateFile checks a config file and each of its profiles, and returns
+// every problem found rather than stopping at the first. Unlike startup, it
+// ignores flags and PORTAL_ environment variables.
+func ValidateFile(path string) []error {
+	if _, err := os.Stat(path); err != nil {
+		return []error{err}
+	}
+	settings, err := readConfigFile(path)
+	if err != nil {
+		return []error{err}
+	}
+
+	problems := validateSettings(settings, true)
+	if filepath.Base(path) == ProjectConfigName {
+		problems = append(problems, checkProjectSettings(settings, nil)...)
+	}
+	reported := make(map[string]bool, len(problems))
+	for _, problem := range problems {
+		reported[problem.Error()] = true
+	}
+
+	profiles, ok := settings[profilesKey]
+	if !ok {
+		return problems
+	}
+	profileMap, ok := profiles.(map[string]any)
+	if !ok {
+		return append(problems, fmt.Errorf("%s must be a map of profile names to settings", profilesKey))
+	}
+	for _, name := range profileNames(profileMap) {
+		values, ok := profileMap[name].(map[string]any)
+		if !ok && profileMap[name] != nil {
+			problems = append(problems, fmt.Errorf("profile %s: must be a map of settings", name))
+			continue
+		}
+		var profileProblems []error
+		for _, key := range sortedKeys(values) {
+			if !knownKey(key, false) {
+				profileProblems = append(profileProblems, fmt.Errorf("unknown setting %q", key))
+			}
+		}
+
+		// Check the profile as applied over the file, reporting only
+		// problems the top level does not already have.
+		merged := make(map[string]any, len(settings)+len(values))
+		for key, value := range settings {
+			merged[key] = value
+		}
+		for key, value := range values {
+			merged[key] = value
+		}
+		profileProblems = append(profileProblems, validateSettings(merged, true)...)
+		for _, err := range profileProblems {
+			if !reported[err.Error()] {
+				problems = append(problems, fmt.Errorf("profile %s: %w", name, err))
+			}
+		}
+	}
+	return problems
+}
+
+// validateSettings checks one layer of settings as portal would read them.
+func validateSettings(settings map[string]any, topLevel bool) []error {
+	v := viper.New()
+	var problems []error
+	if err := v.MergeConfigMap(settings); err != nil {
+		return []error{err}
+	}
+	check := func(err error) {
+		if err != nil {
+			problems = append(problems, err)
+		}
+	}
+
+	for _, key := range sortedKeys(settings) {
+		if !knownKey(key, topLevel) {
+			check(fmt.Errorf("unknown setting %q", key))
+		}
+	}
+
+	for _, key := range boolKeys {
+		if v.IsSet(key) {
+			if _, err := strconv.ParseBool(settingString(v, key)); err != nil {
+				check(fmt.Errorf("invalid %s %q: must be true or false", key, settingString(v, key)))
+			}
+		}
+	}
+	check(validatePort(v, "port", 1))
+	check(validatePort(v, "serve-port", 0))
+	check(validatePort(v, "ui-port", 0))
+
+	var entries []access.Entry
+	for _, list := range []struct{ key, name string }{
+		{"funnel-allowlist", "funnel allowlist"},
+		{funnelDenylistKey, "funnel denylist"},
+	} {
+		for _, entry := range normalizeList(v.Get(list.key)) {
+			parsed, err := parseAccessEntries(list.name, []string{entry})
+			check(err)
+			entries = append(entries, parsed...)
+		}
+	}
+	rules, err := loadFunnelRules(v)
+	check(err)
+	if settingString(v, geoIPDBKey) == "" && settingString(v, geoIPASNDBKey) == "" &&
+		(access.NeedsGeo(entries) || rulesNeedGeo(rules)) {
+		check(fmt.Errorf("country and asn funnel allowlist, denylist or rule entries require %s or %s", geoIPDBKey, geoIPASNDBKey))
+	}
+
+	_, err = parseRoutes(normalizeList(v.Get(routesKey)))
+	check(err)
+	_, err = parseAutoBanPaths(normalizeList(v.Get(autoBanPathsKey)))
+	check(err)
+
+	problems = append(problems, validateOperatingMode(v)...)
+
+	if v.IsSet(otelProtocolKey) {
+		protocol := strings.ToLower(settingString(v, otelProtocolKey))
+		if protocol != telemetry.ProtocolGRPC && protocol != telemetry.ProtocolHTTP {
+			check(fmt.Errorf("invalid otel-protocol %q: must be %q or %q", protocol, telemetry.ProtocolGRPC, telemetry.ProtocolHTTP))
+		}
+	}
+	if v.IsSet(captureLimitKey) {
+		if limit, err := strconv.Atoi(settingString(v, captureLimitKey)); err != nil || limit <= 0 {
+			check(fmt.Errorf("invalid %s %q: must be a positive integer", captureLimitKey, settingString(v, captureLimitKey)))
+		}
+	}
+	if v.IsSet(nameKey) {
+		check(ValidateName(settingString(v, nameKey)))
+	}
+	if v.IsSet(autoBanThresholdKey) {
+		if threshold, err := strconv.Atoi(settingString(v, autoBanThresholdKey)); err != nil || threshold < 0 {
+			check(fmt.Errorf("invalid %s %q: must be zero (disabled) or a positive integer", autoBanThresholdKey, settingString(v, autoBanThresholdKey)))
+		}
+	}
+	if v.IsSet(autoBanWindowKey) {
+		if window, err := time.ParseDuration(settingString(v, autoBanWindowKey)); err != nil || window <= 0 {
+			check(fmt.Errorf("invalid %s %q: must be a positive duration such as 1m", autoBanWindowKey, settingString(v, autoBanWindowKey)))
+		}
+	}
+	return problems
+}
+
+// validateOperatingMode checks listen-mode and service-name, and that service
+// mode is not combined with Funnel.
+func validateOperatingMode(v *viper.Viper) []error {
+	var problems []error
+	listenMode, err := resolveAliasedSetting(v, listenModeKey, legacyListenModeKey, func(raw string) string {
+		return strings.ToLower(strings.TrimSpace(raw))
+	})
+	if err != nil {
+		problems = append(problems, err)
+	}
+	serviceName, err := resolveAliasedSetting(v, serviceNameKey, legacyServiceNameKey, strings.TrimSpace)
+	if err != nil {
+		problems = append(problems, err)
+	}
+	if serviceName != "" {
+		if err := tailcfg.ServiceName(serviceName).Validate(); err != nil {
+			problems = append(problems, fmt.Errorf("invalid service-name %q: %w", serviceName, err))
+		}
+	}
+
+	// Service names were checked above; check the mode with a valid one.
+	cfg := &Config{
+		Funnel:           v.GetBool("funnel"),
+		TSNetListenMode:  listenMode,
+		TSNetServiceName: "svc:portal",
+	}
+	if err := cfg.validateTSNetServiceConfig(); err != nil {
+		problems = append(problems, err)
+	}
+	return problems
+}
+
+func validatePort(v *viper.Viper, key string, min int) error {
+	if !v.IsSet(key) {
+		return nil
+	}
+	port, err := strconv.Atoi(settingString(v, key))
+	if err != nil || port < min || port > 65535 {
+		return fmt.Errorf("invalid %s %q: must be a port number between %d and 65535", key, settingString(v, key), min)
+	}
+	return nil
+}
+
+// knownKey reports whether key can be set in a config file. Profiles cannot
+// select or define other profiles.
+func knownKey(key string, topLevel bool) bool {
+	switch key {
+	case "version", "cleanup-serve":
+		return false
+	case profileKey, profilesKey:
+		return topLevel
+	case funnelRulesKey:
+		return true
+	}
+	return slices.Contains(boundKeys, key)
+}
+
+func settingString(v *viper.Viper, key string) string {
+	value := v.Get(key)
+	if value == nil {
+		return ""
+	}
+	return strings.TrimSpace(fmt.Sprint(value))
+}
+
+func sortedKeys(settings map[string]any) []string {
+	keys := make([]string, 0, len(settings))
+	for key := range settings {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+	return keys
+}
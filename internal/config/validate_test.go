@@ -0,0 +1,114 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestValidateFileReportsEveryProblem(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yml")
+	content := `funnel: true
+listen-mode: service
+service-name: web
+funnel-allowlist: [203.0.113.10, not-an-ip, "country:US"]
+verbos: true
+serve-port: 99999
+profiles:
+  api:
+    port: 0
+    profile: other
+  web:
+    port: 3000
+`
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	var got []string
+	for _, problem := range ValidateFile(path) {
+		got = append(got, problem.Error())
+	}
+	want := []string{
+		`unknown setting "verbos"`,
+		`invalid serve-port "99999"`,
+		`invalid funnel allowlist entry "not-an-ip"`,
+		`require geoip-db or geoip-asn-db`,
+		`invalid service-name "web"`,
+		`listen-mode=service cannot be combined with funnel=true`,
+		`profile api: unknown setting "profile"`,
+		`profile api: invalid port "0"`,
+	}
+	if len(got) != len(want) {
+		t.Fatalf("expected %d problems, got %d: %q", len(want), len(got), got)
+	}
+	for i := range want {
+		if !strings.Contains(got[i], want[i]) {
+			t.Fatalf("expected problem %d to contain %q, got %q", i, want[i], got[i])
+		}
+	}
+}
+
+func TestValidateFileChecksProjectKeys(t *testing.T) {
+	path := filepath.Join(t.TempDir(), ProjectConfigName)
+	if err := os.WriteFile(path, []byte("routes: [/health]\nlog-file: portal.log\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	problems := ValidateFile(path)
+	if len(problems) != 1 || !strings.Contains(problems[0].Error(), "cannot set log-file") {
+		t.Fatalf("expected only the log-file problem, got %v", problems)
+	}
+}
+
+func TestValidateFileMissingFile(t *testing.T) {
+	problems := ValidateFile(filepath.Join(t.TempDir(), "missing.yml"))
+	if len(problems) != 1 || !os.IsNotExist(problems[0]) {
+		t.Fatalf("expected a not-exist error, got %v", problems)
+	}
+}
+
+func TestWriteStarterConfigIsValidAndNotOverwritten(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "nested", "config.yml")
+	if err := WriteStarterConfig(path, false); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if problems := ValidateFile(path); len(problems) != 0 {
+		t.Fatalf("expected starter config to be valid, got %v", problems)
+	}
+
+	if err := os.WriteFile(path, []byte("verbose: true\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := WriteStarterConfig(path, false); err == nil || !strings.Contains(err.Error(), "--force") {
+		t.Fatalf("expected existing file to be kept, got %v", err)
+	}
+	if err := WriteStarterConfig(path, true); err != nil {
+		t.Fatalf("expected --force to overwrite, got %v", err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != starterConfig {
+		t.Fatal("expected the starter config to replace the file")
+	}
+}
+
+func TestParseArgsConfigValidateIgnoresBrokenConfig(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Chdir(t.TempDir())
+	writeConfigFile(t, home, "funnel-allowlist: [not-an-ip]\n")
+
+	if _, err := ParseArgs([]string{"8080"}); err == nil {
+		t.Fatal("expected a normal run to reject the config")
+	}
+	cfg, err := ParseArgs([]string{"config", "validate"})
+	if err != nil {
+		t.Fatalf("expected config validate to parse, got %v", err)
+	}
+	if cfg.Command != CommandConfigValidate || cfg.ConfigFile != filepath.Join(home, ".portal", "config.yml") {
+		t.Fatalf("unexpected config %+v", cfg)
+	}
+}
@@ -0,0 +1,114 @@
+package control
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+
+	"github.com/jaxxstorm/portal/internal/model"
+)
+
+// Client calls the control API of one portal.
+type Client struct {
+	http *http.Client
+}
+
+// NewClient returns a client for the control socket at path.
+func NewClient(path string) *Client {
+	return &Client{http: &http.Client{
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var dialer net.Dialer
+				return dialer.DialContext(ctx, "unix", path)
+			},
+		},
+	}}
+}
+
+// State returns the portal's endpoint state, upstream and Funnel setting.
+func (c *Client) State(ctx context.Context) (State, error) {
+	var state State
+	return state, c.do(ctx, http.MethodGet, "/v1/state", nil, &state)
+}
+
+// Captures returns the captured requests, oldest first.
+func (c *Client) Captures(ctx context.Context) ([]model.RequestLog, error) {
+	var captures []model.RequestLog
+	return captures, c.do(ctx, http.MethodGet, "/v1/captures", nil, &captures)
+}
+
+// ClearCaptures drops the captured requests.
+func (c *Client) ClearCaptures(ctx context.Context) error {
+	return c.do(ctx, http.MethodDelete, "/v1/captures", nil, nil)
+}
+
+// SetTargetPort points the portal at a new upstream port.
+func (c *Client) SetTargetPort(ctx context.Context, port int) (State, error) {
+	var state State
+	return state, c.do(ctx, http.MethodPut, "/v1/target", Retarget{Port: port}, &state)
+}
+
+// SetMock turns mock mode on or off.
+func (c *Client) SetMock(ctx context.Context, enabled bool) (State, error) {
+	var state State
+	return state, c.do(ctx, http.MethodPut, "/v1/mock", Toggle{Enabled: enabled}, &state)
+}
+
+// SetFunnel turns Funnel on or off.
+func (c *Client) SetFunnel(ctx context.Context, enabled bool) (State, error) {
+	var state State
+	return state, c.do(ctx, http.MethodPut, "/v1/funnel", Toggle{Enabled: enabled}, &state)
+}
+
+// Shutdown asks the portal to shut down gracefully. It returns once the
+// request is accepted, not once the portal has exited.
+func (c *Client) Shutdown(ctx context.Context) error {
+	return c.do(ctx, http.MethodPost, "/v1/shutdown", nil, nil)
+}
+
+func (c *Client) do(ctx context.Context, method, path string, body, out any) error {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(data)
+	}
+	// The host is ignored; requests always go to the socket.
+	req, err := http.NewRequestWithContext(ctx, method, "http://portal"+path, reader)
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to reach portal: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= http.StatusBadRequest {
+		var apiErr struct {
+			Error string `json:"error"`
+		}
+		if json.NewDecoder(resp.Body).Decode(&apiErr) == nil && apiErr.Error != "" {
+			return errors.New(apiErr.Error)
+		}
+		return fmt.Errorf("portal returned %s", resp.Status)
+	}
+	if out == nil {
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("failed to decode response: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,216 @@
+// Package control serves the local control API of a running portal on a
+// Unix socket, and the client portal ctl uses to call it.
+package control
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+
+	"go.uber.org/zap"
+
+	"github.com/jaxxstorm/portal/internal/logging"
+	"github.com/jaxxstorm/portal/internal/model"
+)
+
+// Portal is the running portal the control API drives.
+type Portal interface {
+	GetEndpointState() model.EndpointState
+	Target() (model.ServerMode, int)
+	FunnelEnabled() bool
+	GetRequestLogs() []model.RequestLog
+	ClearRequestLogs()
+	SetTargetPort(port int) error
+	SetMock(enabled bool) error
+	SetFunnel(ctx context.Context, enabled bool) error
+	Shutdown()
+}
+
+// State is what the control API reports about a portal.
+type State struct {
+	Endpoint   model.EndpointState `json:"endpoint"`
+	Mock       bool                `json:"mock"`
+	TargetPort int                 `json:"target_port,omitempty"`
+	Funnel     bool                `json:"funnel"`
+}
+
+// Toggle is the body of requests that turn a feature on or off.
+type Toggle struct {
+	Enabled bool `json:"enabled"`
+}
+
+// Retarget is the body of a request to change the upstream port.
+type Retarget struct {
+	Port int `json:"port"`
+}
+
+// Server serves the control API.
+type Server struct {
+	portal Portal
+	logger *zap.Logger
+	path   string
+	server *http.Server
+}
+
+// NewServer returns a control API server for portal. Call Listen to serve it
+// on a socket.
+func NewServer(portal Portal, logger *zap.Logger) *Server {
+	s := &Server{portal: portal, logger: logger}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /v1/state", s.handleState)
+	mux.HandleFunc("GET /v1/captures", s.handleCaptures)
+	mux.HandleFunc("DELETE /v1/captures", s.handleClearCaptures)
+	mux.HandleFunc("PUT /v1/target", s.handleTarget)
+	mux.HandleFunc("PUT /v1/mock", s.handleMock)
+	mux.HandleFunc("PUT /v1/funnel", s.handleFunnel)
+	mux.HandleFunc("POST /v1/shutdown", s.handleShutdown)
+	s.server = &http.Server{Handler: mux}
+	return s
+}
+
+// Handler returns the API's HTTP handler.
+func (s *Server) Handler() http.Handler {
+	return s.server.Handler
+}
+
+// Listen serves the API on a Unix socket at path until Close. The socket is
+// only accessible to the current user.
+func (s *Server) Listen(path string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return fmt.Errorf("failed to create control socket directory: %w", err)
+	}
+	// A socket left by an earlier process with the same PID.
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to remove stale control socket %s: %w", path, err)
+	}
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return fmt.Errorf("failed to listen on control socket %s: %w", path, err)
+	}
+	if err := os.Chmod(path, 0o600); err != nil {
+		listener.Close()
+		return fmt.Errorf("failed to restrict control socket %s: %w", path, err)
+	}
+	s.path = path
+
+	go func() {
+		if err := s.server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			s.logger.Warn("Control API stopped",
+				logging.Component("control_api"),
+				logging.Error(err),
+			)
+		}
+	}()
+	return nil
+}
+
+// Close stops serving and removes the socket.
+func (s *Server) Close(ctx context.Context) error {
+	err := s.server.Shutdown(ctx)
+	if s.path != "" {
+		if removeErr := os.Remove(s.path); removeErr != nil && !errors.Is(removeErr, os.ErrNotExist) && err == nil {
+			err = removeErr
+		}
+	}
+	return err
+}
+
+func (s *Server) state() State {
+	mode, port := s.portal.Target()
+	return State{
+		Endpoint:   s.portal.GetEndpointState(),
+		Mock:       mode == model.ModeMock,
+		TargetPort: port,
+		Funnel:     s.portal.FunnelEnabled(),
+	}
+}
+
+func (s *Server) handleState(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, s.state())
+}
+
+func (s *Server) handleCaptures(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, s.portal.GetRequestLogs())
+}
+
+func (s *Server) handleClearCaptures(w http.ResponseWriter, r *http.Request) {
+	s.portal.ClearRequestLogs()
+	s.logAction("clear_captures")
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) handleTarget(w http.ResponseWriter, r *http.Request) {
+	var body Retarget
+	if !decodeBody(w, r, &body) {
+		return
+	}
+	if err := s.portal.SetTargetPort(body.Port); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	s.logAction("target", logging.TargetPort(body.Port))
+	writeJSON(w, http.StatusOK, s.state())
+}
+
+func (s *Server) handleMock(w http.ResponseWriter, r *http.Request) {
+	var body Toggle
+	if !decodeBody(w, r, &body) {
+		return
+	}
+	if err := s.portal.SetMock(body.Enabled); err != nil {
+		writeError(w, http.StatusConflict, err)
+		return
+	}
+	s.logAction("mock", logging.MockMode(body.Enabled))
+	writeJSON(w, http.StatusOK, s.state())
+}
+
+func (s *Server) handleFunnel(w http.ResponseWriter, r *http.Request) {
+	var body Toggle
+	if !decodeBody(w, r, &body) {
+		return
+	}
+	if err := s.portal.SetFunnel(r.Context(), body.Enabled); err != nil {
+		writeError(w, http.StatusConflict, err)
+		return
+	}
+	s.logAction("funnel", logging.FunnelEnabled(body.Enabled))
+	writeJSON(w, http.StatusOK, s.state())
+}
+
+func (s *Server) handleShutdown(w http.ResponseWriter, r *http.Request) {
+	s.logAction("shutdown")
+	writeJSON(w, http.StatusAccepted, map[string]string{"status": "shutting_down"})
+	s.portal.Shutdown()
+}
+
+func (s *Server) logAction(action string, fields ...zap.Field) {
+	s.logger.Info("Control API request",
+		append([]zap.Field{logging.Component("control_api"), logging.Operation(action)}, fields...)...,
+	)
+}
+
+func decodeBody(w http.ResponseWriter, r *http.Request, v any) bool {
+	if err := json.NewDecoder(io.LimitReader(r.Body, 1<<16)).Decode(v); err != nil {
+		writeError(w, http.StatusBadRequest, errors.New("invalid request body"))
+		return false
+	}
+	return true
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
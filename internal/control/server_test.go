@@ -0,0 +1,119 @@
+package control
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"go.uber.org/zap"
+
+	"github.com/jaxxstorm/portal/internal/model"
+)
+
+type fakePortal struct {
+	mode     model.ServerMode
+	port     int
+	funnel   bool
+	captures []model.RequestLog
+	shutdown bool
+}
+
+func (p *fakePortal) GetEndpointState() model.EndpointState {
+	return model.EndpointState{Readiness: model.EndpointReadinessReady, ServiceURL: "https://node.example.ts.net/"}
+}
+func (p *fakePortal) Target() (model.ServerMode, int)    { return p.mode, p.port }
+func (p *fakePortal) FunnelEnabled() bool                { return p.funnel }
+func (p *fakePortal) GetRequestLogs() []model.RequestLog { return p.captures }
+func (p *fakePortal) ClearRequestLogs()                  { p.captures = nil }
+func (p *fakePortal) Shutdown()                          { p.shutdown = true }
+
+func (p *fakePortal) SetTargetPort(port int) error {
+	p.mode, p.port = model.ModeProxy, port
+	return nil
+}
+
+func (p *fakePortal) SetMock(enabled bool) error {
+	if enabled {
+		p.mode = model.ModeMock
+	} else {
+		p.mode = model.ModeProxy
+	}
+	return nil
+}
+
+func (p *fakePortal) SetFunnel(ctx context.Context, enabled bool) error {
+	return errors.New("funnel requires HTTPS on port 443")
+}
+
+func TestControlAPIOverSocket(t *testing.T) {
+	portal := &fakePortal{mode: model.ModeProxy, port: 8080, captures: []model.RequestLog{{ID: "req_1"}}}
+	server := NewServer(portal, zap.NewNop())
+	path := filepath.Join(t.TempDir(), "portal.sock")
+	if err := server.Listen(path); err != nil {
+		t.Fatalf("Listen failed: %v", err)
+	}
+	defer server.Close(context.Background())
+
+	info, err := os.Stat(path)
+	if err != nil || info.Mode().Perm() != 0o600 {
+		t.Fatalf("expected a socket only the user can use, got %v (err %v)", info, err)
+	}
+
+	ctx := t.Context()
+	client := NewClient(path)
+
+	state, err := client.State(ctx)
+	if err != nil {
+		t.Fatalf("State failed: %v", err)
+	}
+	if state.Endpoint.Readiness != model.EndpointReadinessReady || state.TargetPort != 8080 || state.Mock {
+		t.Fatalf("unexpected state %+v", state)
+	}
+
+	captures, err := client.Captures(ctx)
+	if err != nil || len(captures) != 1 || captures[0].ID != "req_1" {
+		t.Fatalf("unexpected captures %+v (err %v)", captures, err)
+	}
+	if err := client.ClearCaptures(ctx); err != nil {
+		t.Fatalf("ClearCaptures failed: %v", err)
+	}
+	if len(portal.captures) != 0 {
+		t.Fatal("expected captures to be cleared")
+	}
+
+	if state, err = client.SetTargetPort(ctx, 3000); err != nil || state.TargetPort != 3000 {
+		t.Fatalf("unexpected retarget result %+v (err %v)", state, err)
+	}
+	if state, err = client.SetMock(ctx, true); err != nil || !state.Mock {
+		t.Fatalf("unexpected mock result %+v (err %v)", state, err)
+	}
+	if _, err := client.SetFunnel(ctx, true); err == nil || err.Error() != "funnel requires HTTPS on port 443" {
+		t.Fatalf("expected the portal's funnel error, got %v", err)
+	}
+
+	if err := client.Shutdown(ctx); err != nil {
+		t.Fatalf("Shutdown failed: %v", err)
+	}
+	if !portal.shutdown {
+		t.Fatal("expected shutdown to be triggered")
+	}
+}
+
+func TestCloseRemovesSocket(t *testing.T) {
+	server := NewServer(&fakePortal{}, zap.NewNop())
+	path := filepath.Join(t.TempDir(), "portal.sock")
+	if err := server.Listen(path); err != nil {
+		t.Fatalf("Listen failed: %v", err)
+	}
+	if err := server.Close(context.Background()); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Fatalf("expected the socket to be removed, got %v", err)
+	}
+	if _, err := NewClient(path).State(t.Context()); err == nil {
+		t.Fatal("expected the client to fail once the socket is gone")
+	}
+}
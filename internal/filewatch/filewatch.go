@@ -0,0 +1,90 @@
+// Package filewatch calls back when files change on disk.
+package filewatch
+
+import (
+	"context"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// settleDelay lets editors and tools finish writing before onChange runs.
+const settleDelay = 200 * time.Millisecond
+
+// Watch calls onChange with the path of each file that is written or
+// created, until ctx is done. Directories are watched rather than the files
+// themselves so that files replaced by rename, as editors and atomic writers
+// do, keep being watched. Bursts of events for a file are coalesced into one
+// call.
+func Watch(ctx context.Context, paths []string, onChange func(path string)) error {
+	if len(paths) == 0 {
+		return nil
+	}
+
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+
+	watched := make(map[string]bool, len(paths))
+	for _, path := range paths {
+		absPath, err := filepath.Abs(path)
+		if err != nil {
+			watcher.Close()
+			return err
+		}
+		watched[absPath] = true
+		if err := watcher.Add(filepath.Dir(absPath)); err != nil {
+			watcher.Close()
+			return err
+		}
+	}
+
+	go func() {
+		defer watcher.Close()
+
+		var mu sync.Mutex
+		timers := make(map[string]*time.Timer)
+		defer func() {
+			mu.Lock()
+			defer mu.Unlock()
+			for _, timer := range timers {
+				timer.Stop()
+			}
+		}()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case event, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				path := filepath.Clean(event.Name)
+				if !watched[path] || !event.Has(fsnotify.Write|fsnotify.Create) {
+					continue
+				}
+
+				mu.Lock()
+				if timer, ok := timers[path]; ok {
+					timer.Reset(settleDelay)
+				} else {
+					timers[path] = time.AfterFunc(settleDelay, func() {
+						if ctx.Err() == nil {
+							onChange(path)
+						}
+					})
+				}
+				mu.Unlock()
+			case _, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+			}
+		}
+	}()
+	return nil
+}
@@ -0,0 +1,49 @@
+package filewatch
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestWatchFollowsFilesReplacedByRename(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config.yml")
+	if err := os.WriteFile(path, []byte("a: 1\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	changed := make(chan string, 4)
+	if err := Watch(ctx, []string{path}, func(path string) { changed <- path }); err != nil {
+		t.Fatalf("watch: %v", err)
+	}
+
+	for i := 0; i < 2; i++ {
+		tmp := filepath.Join(dir, "config.yml.tmp")
+		if err := os.WriteFile(tmp, []byte("a: 2\n"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Rename(tmp, path); err != nil {
+			t.Fatal(err)
+		}
+
+		select {
+		case got := <-changed:
+			if got != path {
+				t.Fatalf("expected change for %s, got %s", path, got)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out waiting for change %d", i+1)
+		}
+	}
+
+	select {
+	case got := <-changed:
+		t.Fatalf("expected the temporary file to be ignored and events coalesced, got %s", got)
+	case <-time.After(2 * settleDelay):
+	}
+}
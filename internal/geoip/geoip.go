@@ -0,0 +1,92 @@
+// Package geoip looks up the country and autonomous system of source IPs in
+// local MaxMind-format (.mmdb) databases.
+package geoip
+
+import (
+	"errors"
+	"fmt"
+	"net/netip"
+
+	"github.com/oschwald/maxminddb-golang/v2"
+
+	"github.com/jaxxstorm/portal/internal/model"
+)
+
+// record holds the fields portal reads. It covers the GeoIP2/GeoLite2
+// Country, City and ASN layouts, so one type decodes every database.
+type record struct {
+	Country struct {
+		ISOCode string `maxminddb:"iso_code"`
+	} `maxminddb:"country"`
+	RegisteredCountry struct {
+		ISOCode string `maxminddb:"iso_code"`
+	} `maxminddb:"registered_country"`
+	ASN   uint   `maxminddb:"autonomous_system_number"`
+	ASOrg string `maxminddb:"autonomous_system_organization"`
+}
+
+// DB looks up source IPs in one or more databases, such as a country
+// database and an ASN database. A nil DB finds nothing.
+type DB struct {
+	readers []*maxminddb.Reader
+}
+
+// Open opens the databases at paths, skipping empty paths. It returns nil
+// when no path is given.
+func Open(paths ...string) (*DB, error) {
+	db := &DB{}
+	for _, path := range paths {
+		if path == "" {
+			continue
+		}
+		reader, err := maxminddb.Open(path)
+		if err != nil {
+			db.Close()
+			return nil, fmt.Errorf("failed to open GeoIP database %s: %w", path, err)
+		}
+		db.readers = append(db.readers, reader)
+	}
+	if len(db.readers) == 0 {
+		return nil, nil
+	}
+	return db, nil
+}
+
+// Lookup returns what the databases know about addr. Each field is taken
+// from the first database that has it.
+func (d *DB) Lookup(addr netip.Addr) (model.GeoInfo, bool) {
+	var info model.GeoInfo
+	if d == nil || !addr.IsValid() {
+		return info, false
+	}
+
+	for _, reader := range d.readers {
+		var rec record
+		if err := reader.Lookup(addr.Unmap()).Decode(&rec); err != nil {
+			continue
+		}
+		if info.Country == "" {
+			info.Country = rec.Country.ISOCode
+			if info.Country == "" {
+				info.Country = rec.RegisteredCountry.ISOCode
+			}
+		}
+		if info.ASN == 0 {
+			info.ASN, info.ASOrg = rec.ASN, rec.ASOrg
+		}
+	}
+	return info, info != model.GeoInfo{}
+}
+
+// Close releases the databases.
+func (d *DB) Close() error {
+	if d == nil {
+		return nil
+	}
+	var errs []error
+	for _, reader := range d.readers {
+		errs = append(errs, reader.Close())
+	}
+	d.readers = nil
+	return errors.Join(errs...)
+}
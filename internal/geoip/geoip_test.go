@@ -0,0 +1,67 @@
+package geoip
+
+import (
+	"net/netip"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/jaxxstorm/portal/internal/geoip/geoiptest"
+	"github.com/jaxxstorm/portal/internal/model"
+)
+
+func TestLookupMergesCountryAndASNDatabases(t *testing.T) {
+	dir := t.TempDir()
+	countryDB := geoiptest.WriteDB(t, filepath.Join(dir, "country.mmdb"), map[string]map[string]any{
+		"203.0.113.0/24": {"country": map[string]any{"iso_code": "NL"}},
+		"198.51.100.0/24": {
+			"country":            map[string]any{},
+			"registered_country": map[string]any{"iso_code": "US"},
+		},
+	})
+	asnDB := geoiptest.WriteDB(t, filepath.Join(dir, "asn.mmdb"), map[string]map[string]any{
+		"203.0.113.0/25": {"autonomous_system_number": uint32(64500), "autonomous_system_organization": "Example Hosting"},
+	})
+
+	db, err := Open(countryDB, "", asnDB)
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	defer db.Close()
+
+	for addr, want := range map[string]model.GeoInfo{
+		"203.0.113.10":        {Country: "NL", ASN: 64500, ASOrg: "Example Hosting"},
+		"::ffff:203.0.113.10": {Country: "NL", ASN: 64500, ASOrg: "Example Hosting"},
+		"203.0.113.200":       {Country: "NL"},
+		"198.51.100.4":        {Country: "US"},
+	} {
+		got, ok := db.Lookup(netip.MustParseAddr(addr))
+		if !ok || got != want {
+			t.Fatalf("Lookup(%s) = %+v, %v; want %+v", addr, got, ok, want)
+		}
+	}
+
+	if got, ok := db.Lookup(netip.MustParseAddr("192.0.2.1")); ok {
+		t.Fatalf("expected no data for an unknown address, got %+v", got)
+	}
+}
+
+func TestOpenWithoutPathsReturnsNil(t *testing.T) {
+	db, err := Open("", "")
+	if err != nil || db != nil {
+		t.Fatalf("expected nil DB without paths, got %v, %v", db, err)
+	}
+	if _, ok := db.Lookup(netip.MustParseAddr("203.0.113.10")); ok {
+		t.Fatal("expected nil DB to find nothing")
+	}
+}
+
+func TestOpenRejectsInvalidDatabase(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "broken.mmdb")
+	if err := os.WriteFile(path, []byte("not a database"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Open(path); err == nil {
+		t.Fatal("expected invalid database to fail")
+	}
+}
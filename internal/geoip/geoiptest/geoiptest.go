@@ -0,0 +1,150 @@
+// Package geoiptest writes small MaxMind DB files for tests.
+package geoiptest
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"net/netip"
+	"os"
+	"sort"
+	"testing"
+)
+
+// WriteDB writes an IPv4 MaxMind DB with 24-bit records mapping each prefix
+// to its record, and returns path. Records hold strings, uint16, uint32,
+// uint64, []any and map[string]any values.
+func WriteDB(t *testing.T, path string, records map[string]map[string]any) string {
+	t.Helper()
+
+	type node struct {
+		children [2]*node
+		leaf     bool
+		data     int
+	}
+	root := &node{}
+	var data bytes.Buffer
+	prefixes := make([]string, 0, len(records))
+	for prefix := range records {
+		prefixes = append(prefixes, prefix)
+	}
+	sort.Strings(prefixes)
+	for _, value := range prefixes {
+		prefix := netip.MustParsePrefix(value)
+		addr := prefix.Addr().As4()
+		current := root
+		for bit := 0; bit < prefix.Bits(); bit++ {
+			side := (addr[bit/8] >> (7 - bit%8)) & 1
+			if current.children[side] == nil {
+				current.children[side] = &node{}
+			}
+			current = current.children[side]
+		}
+		current.leaf, current.data = true, data.Len()
+		encodeValue(&data, records[value])
+	}
+
+	var nodes []*node
+	index := map[*node]int{}
+	var walk func(n *node)
+	walk = func(n *node) {
+		index[n] = len(nodes)
+		nodes = append(nodes, n)
+		for _, child := range n.children {
+			if child != nil && !child.leaf {
+				walk(child)
+			}
+		}
+	}
+	walk(root)
+
+	var out bytes.Buffer
+	nodeCount := len(nodes)
+	for _, n := range nodes {
+		for _, child := range n.children {
+			record := nodeCount
+			switch {
+			case child == nil:
+			case child.leaf:
+				record = nodeCount + 16 + child.data
+			default:
+				record = index[child]
+			}
+			out.Write([]byte{byte(record >> 16), byte(record >> 8), byte(record)})
+		}
+	}
+	out.Write(make([]byte, 16))
+	out.Write(data.Bytes())
+	out.WriteString("\xab\xcd\xefMaxMind.com")
+	encodeValue(&out, map[string]any{
+		"node_count":                  uint32(nodeCount),
+		"record_size":                 uint16(24),
+		"ip_version":                  uint16(4),
+		"database_type":               "portal-test",
+		"languages":                   []any{},
+		"description":                 map[string]any{},
+		"binary_format_major_version": uint16(2),
+		"binary_format_minor_version": uint16(0),
+		"build_epoch":                 uint64(1700000000),
+	})
+
+	if err := os.WriteFile(path, out.Bytes(), 0o600); err != nil {
+		t.Fatalf("write test database: %v", err)
+	}
+	return path
+}
+
+// encodeValue writes value in the MaxMind DB data section format.
+func encodeValue(buf *bytes.Buffer, value any) {
+	control := func(kind, size int) {
+		sizeBits, extra := size, -1
+		if size >= 29 {
+			sizeBits, extra = 29, size-29 // sizes up to 284 only
+		}
+		if kind <= 7 {
+			buf.WriteByte(byte(kind<<5 | sizeBits))
+		} else {
+			buf.WriteByte(byte(sizeBits))
+			buf.WriteByte(byte(kind - 7))
+		}
+		if extra >= 0 {
+			buf.WriteByte(byte(extra))
+		}
+	}
+	unsigned := func(kind int, v uint64, width int) {
+		b := make([]byte, 8)
+		binary.BigEndian.PutUint64(b, v)
+		control(kind, width)
+		buf.Write(b[8-width:])
+	}
+
+	switch v := value.(type) {
+	case string:
+		control(2, len(v))
+		buf.WriteString(v)
+	case uint16:
+		unsigned(5, uint64(v), 2)
+	case uint32:
+		unsigned(6, uint64(v), 4)
+	case uint64:
+		unsigned(9, v, 8)
+	case []any:
+		control(11, len(v))
+		for _, item := range v {
+			encodeValue(buf, item)
+		}
+	case map[string]any:
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		control(7, len(keys))
+		for _, key := range keys {
+			encodeValue(buf, key)
+			encodeValue(buf, v[key])
+		}
+	default:
+		panic(fmt.Sprintf("geoiptest: unsupported value %T", value))
+	}
+}
@@ -8,6 +8,8 @@ import (
 )
 
 // NewHTTPListener creates a TCP listener and optionally requires PROXY headers.
+// Only loopback peers, where tailscaled forwards from, may send PROXY headers;
+// other peers are served with their own address and cannot forge one.
 func NewHTTPListener(addr string, requireProxyProtocol bool) (net.Listener, error) {
 	baseListener, err := net.Listen("tcp", addr)
 	if err != nil {
@@ -20,8 +22,11 @@ func NewHTTPListener(addr string, requireProxyProtocol bool) (net.Listener, erro
 
 	return &proxyproto.Listener{
 		Listener: baseListener,
-		Policy: func(net.Addr) (proxyproto.Policy, error) {
-			return proxyproto.REQUIRE, nil
+		Policy: func(upstream net.Addr) (proxyproto.Policy, error) {
+			if tcpAddr, ok := upstream.(*net.TCPAddr); ok && tcpAddr.IP.IsLoopback() {
+				return proxyproto.REQUIRE, nil
+			}
+			return proxyproto.REJECT, nil
 		},
 		ReadHeaderTimeout: 5 * time.Second,
 	}, nil
@@ -16,7 +16,16 @@ type Config struct {
 	Verbose   bool
 	JSON      bool
 	LogFile   string
-	TUIWriter io.Writer // Optional TUI writer for log redirection
+	TUIWriter io.Writer        // Optional TUI writer for log redirection
+	Level     *zap.AtomicLevel // Optional shared level, so it can change at runtime
+}
+
+// LevelFor returns the log level for the verbose setting
+func LevelFor(verbose bool) zapcore.Level {
+	if verbose {
+		return zap.DebugLevel
+	}
+	return zap.InfoLevel
 }
 
 // SetupLogger creates and configures a zap logger based on the provided configuration
@@ -33,10 +42,10 @@ func SetupLogger(config Config) (*zap.Logger, error) {
 	}
 
 	// Set log level
-	if config.Verbose {
-		zapConfig.Level = zap.NewAtomicLevelAt(zap.DebugLevel)
+	if config.Level != nil {
+		zapConfig.Level = *config.Level
 	} else {
-		zapConfig.Level = zap.NewAtomicLevelAt(zap.InfoLevel)
+		zapConfig.Level = zap.NewAtomicLevelAt(LevelFor(config.Verbose))
 	}
 
 	// Configure output paths
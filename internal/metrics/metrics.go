@@ -0,0 +1,290 @@
+// Package metrics exposes portal traffic in Prometheus text format.
+package metrics
+
+import (
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+
+	"github.com/jaxxstorm/portal/internal/model"
+	"github.com/jaxxstorm/portal/internal/stats"
+)
+
+const namespace = "portal"
+
+const (
+	// maxRoutes bounds the route label values, as per-route statistics do,
+	// so scanners probing random paths cannot grow the registry; further
+	// routes are recorded as stats.OverflowRoute.
+	maxRoutes = 500
+
+	// otherMethod labels requests with a method outside the HTTP standard.
+	otherMethod = "_OTHER"
+)
+
+var standardMethods = map[string]bool{
+	http.MethodGet:     true,
+	http.MethodHead:    true,
+	http.MethodPost:    true,
+	http.MethodPut:     true,
+	http.MethodPatch:   true,
+	http.MethodDelete:  true,
+	http.MethodConnect: true,
+	http.MethodOptions: true,
+	http.MethodTrace:   true,
+}
+
+// Allowlist decisions recorded by ObserveAllowlistDecision.
+const (
+	DecisionAllow = "allow"
+	DecisionDeny  = "deny"
+)
+
+var endpointReadinessStates = []string{
+	model.EndpointReadinessStarting,
+	model.EndpointReadinessReady,
+	model.EndpointReadinessFailed,
+}
+
+// Sources supplies point-in-time values read on every scrape.
+type Sources struct {
+	InFlightRequests func() int
+	Connections      func() model.ConnectionStats
+	EndpointState    func() model.EndpointState
+}
+
+// Recorder collects portal traffic metrics into its own registry.
+type Recorder struct {
+	registry      *prometheus.Registry
+	requests      *prometheus.CounterVec
+	duration      *prometheus.HistogramVec
+	requestBytes  *prometheus.CounterVec
+	responseBytes *prometheus.CounterVec
+	allowlist     *prometheus.CounterVec
+	blocked       *prometheus.CounterVec
+	autoBans      *prometheus.CounterVec
+
+	routesMu sync.Mutex
+	routes   map[string]struct{}
+}
+
+// NewRecorder creates a recorder and registers portal, Go runtime and process
+// collectors. Nil sources are skipped.
+func NewRecorder(sources Sources) *Recorder {
+	r := &Recorder{
+		registry: prometheus.NewRegistry(),
+		routes:   make(map[string]struct{}),
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "requests_total",
+			Help:      "Requests handled by portal.",
+		}, []string{"method", "status_class", "route"}),
+		duration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "request_duration_seconds",
+			Help:      "Time taken to serve requests, including the backend.",
+			Buckets:   []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30},
+		}, []string{"method", "route"}),
+		requestBytes: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "request_bytes_total",
+			Help:      "Request body bytes received.",
+		}, []string{"method", "route"}),
+		responseBytes: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "response_bytes_total",
+			Help:      "Response body bytes sent.",
+		}, []string{"method", "route"}),
+		allowlist: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "funnel_allowlist_decisions_total",
+			Help:      "Funnel allowlist decisions by outcome and reason.",
+		}, []string{"decision", "reason"}),
+		blocked: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "blocked_requests_total",
+			Help:      "Requests refused by the runtime blocklist, by how the matching entry was added.",
+		}, []string{"source"}),
+		autoBans: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "auto_bans_total",
+			Help:      "Sources added to the blocklist by auto-ban heuristics.",
+		}, []string{"reason"}),
+	}
+
+	r.registry.MustRegister(
+		r.requests,
+		r.duration,
+		r.requestBytes,
+		r.responseBytes,
+		r.allowlist,
+		r.blocked,
+		r.autoBans,
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+
+	if sources.InFlightRequests != nil {
+		r.registry.MustRegister(prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "requests_in_flight",
+			Help:      "Requests currently being served.",
+		}, func() float64 {
+			return float64(sources.InFlightRequests())
+		}))
+	}
+	if sources.Connections != nil {
+		r.registry.MustRegister(&connectionCollector{
+			stats: sources.Connections,
+			open: prometheus.NewDesc(
+				prometheus.BuildFQName(namespace, "", "open_connections"),
+				"Open connections to the proxy listener by HTTP connection state.",
+				[]string{"state"}, nil,
+			),
+			accepted: prometheus.NewDesc(
+				prometheus.BuildFQName(namespace, "connections", "accepted_total"),
+				"Connections accepted by the proxy listener.",
+				nil, nil,
+			),
+			hijacked: prometheus.NewDesc(
+				prometheus.BuildFQName(namespace, "connections", "hijacked_total"),
+				"Connections taken over by a handler, such as WebSocket upgrades.",
+				nil, nil,
+			),
+		})
+	}
+	if sources.EndpointState != nil {
+		r.registry.MustRegister(&endpointCollector{
+			state: sources.EndpointState,
+			desc: prometheus.NewDesc(
+				prometheus.BuildFQName(namespace, "endpoint", "readiness"),
+				"Endpoint readiness; the series for the current state is 1.",
+				[]string{"state", "mode", "exposure"}, nil,
+			),
+		})
+	}
+
+	return r
+}
+
+// ObserveRequest records a completed request. Non-standard methods are
+// recorded as otherMethod, and routes beyond the first 500 as
+// stats.OverflowRoute.
+func (r *Recorder) ObserveRequest(method string, statusCode int, route string, duration time.Duration, requestBytes, responseBytes int64) {
+	method = methodLabel(method)
+	route = r.routeLabel(route)
+	r.requests.WithLabelValues(method, StatusClass(statusCode), route).Inc()
+	r.duration.WithLabelValues(method, route).Observe(duration.Seconds())
+	if requestBytes > 0 {
+		r.requestBytes.WithLabelValues(method, route).Add(float64(requestBytes))
+	}
+	if responseBytes > 0 {
+		r.responseBytes.WithLabelValues(method, route).Add(float64(responseBytes))
+	}
+}
+
+// routeLabel returns route, or stats.OverflowRoute once maxRoutes other
+// routes have been recorded.
+func (r *Recorder) routeLabel(route string) string {
+	r.routesMu.Lock()
+	defer r.routesMu.Unlock()
+
+	if _, ok := r.routes[route]; ok {
+		return route
+	}
+	if len(r.routes) >= maxRoutes {
+		return stats.OverflowRoute
+	}
+	r.routes[route] = struct{}{}
+	return route
+}
+
+// methodLabel returns method when it is a standard HTTP method, and
+// otherMethod otherwise, since clients can send any token as a method.
+func methodLabel(method string) string {
+	if standardMethods[method] {
+		return method
+	}
+	return otherMethod
+}
+
+// ObserveAllowlistDecision records a Funnel allowlist allow or deny.
+func (r *Recorder) ObserveAllowlistDecision(decision, reason string) {
+	r.allowlist.WithLabelValues(decision, reason).Inc()
+}
+
+// ObserveBlocked records a request refused by the blocklist. source is how
+// the matching entry was added (manual or auto).
+func (r *Recorder) ObserveBlocked(source string) {
+	r.blocked.WithLabelValues(source).Inc()
+}
+
+// ObserveAutoBan records a source banned automatically.
+func (r *Recorder) ObserveAutoBan(reason string) {
+	r.autoBans.WithLabelValues(reason).Inc()
+}
+
+// Handler serves the registry in Prometheus exposition format.
+func (r *Recorder) Handler() http.Handler {
+	return promhttp.HandlerFor(r.registry, promhttp.HandlerOpts{})
+}
+
+// StatusClass groups a status code into 1xx..5xx, or "unknown" when no
+// status was written.
+func StatusClass(statusCode int) string {
+	if statusCode < 100 || statusCode > 599 {
+		return "unknown"
+	}
+	return strconv.Itoa(statusCode/100) + "xx"
+}
+
+// connectionCollector reports connection counts at scrape time from the same
+// snapshot the TUI and web UI read.
+type connectionCollector struct {
+	stats    func() model.ConnectionStats
+	open     *prometheus.Desc
+	accepted *prometheus.Desc
+	hijacked *prometheus.Desc
+}
+
+func (c *connectionCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.open
+	ch <- c.accepted
+	ch <- c.hijacked
+}
+
+func (c *connectionCollector) Collect(ch chan<- prometheus.Metric) {
+	stats := c.stats()
+	ch <- prometheus.MustNewConstMetric(c.open, prometheus.GaugeValue, float64(stats.New), "new")
+	ch <- prometheus.MustNewConstMetric(c.open, prometheus.GaugeValue, float64(stats.Active), "active")
+	ch <- prometheus.MustNewConstMetric(c.open, prometheus.GaugeValue, float64(stats.Idle), "idle")
+	ch <- prometheus.MustNewConstMetric(c.accepted, prometheus.CounterValue, float64(stats.Accepted))
+	ch <- prometheus.MustNewConstMetric(c.hijacked, prometheus.CounterValue, float64(stats.Hijacked))
+}
+
+// endpointCollector reports readiness at scrape time so the metric always
+// matches the state shown in the TUI and web UI.
+type endpointCollector struct {
+	state func() model.EndpointState
+	desc  *prometheus.Desc
+}
+
+func (c *endpointCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.desc
+}
+
+func (c *endpointCollector) Collect(ch chan<- prometheus.Metric) {
+	state := c.state()
+	for _, readiness := range endpointReadinessStates {
+		value := 0.0
+		if state.Readiness == readiness {
+			value = 1
+		}
+		ch <- prometheus.MustNewConstMetric(c.desc, prometheus.GaugeValue, value, readiness, state.Mode, state.Exposure)
+	}
+}
@@ -0,0 +1,96 @@
+package metrics
+
+import (
+	"io"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jaxxstorm/portal/internal/model"
+)
+
+func scrape(t *testing.T, recorder *Recorder) string {
+	t.Helper()
+
+	rr := httptest.NewRecorder()
+	recorder.Handler().ServeHTTP(rr, httptest.NewRequest("GET", "/metrics", nil))
+	body, err := io.ReadAll(rr.Result().Body)
+	if err != nil {
+		t.Fatalf("read metrics: %v", err)
+	}
+	return string(body)
+}
+
+func TestRecorderExposesTrafficMetrics(t *testing.T) {
+	recorder := NewRecorder(Sources{
+		InFlightRequests: func() int { return 3 },
+		Connections: func() model.ConnectionStats {
+			return model.ConnectionStats{Open: 4, Active: 1, Idle: 3, Accepted: 9, Hijacked: 1}
+		},
+		EndpointState: func() model.EndpointState {
+			return model.EndpointState{Readiness: model.EndpointReadinessReady, Mode: "local_daemon", Exposure: "funnel"}
+		},
+	})
+
+	recorder.ObserveRequest("GET", 200, "/users/{id}", 120*time.Millisecond, 0, 512)
+	recorder.ObserveRequest("POST", 503, "/orders", 2*time.Second, 64, 10)
+	recorder.ObserveAllowlistDecision(DecisionDeny, "source_ip_not_allowlisted")
+	recorder.ObserveBlocked("auto")
+	recorder.ObserveAutoBan("scanner_path")
+
+	body := scrape(t, recorder)
+	for _, want := range []string{
+		`portal_requests_total{method="GET",route="/users/{id}",status_class="2xx"} 1`,
+		`portal_requests_total{method="POST",route="/orders",status_class="5xx"} 1`,
+		`portal_request_duration_seconds_bucket{method="GET",route="/users/{id}",le="0.25"} 1`,
+		`portal_request_bytes_total{method="POST",route="/orders"} 64`,
+		`portal_response_bytes_total{method="GET",route="/users/{id}"} 512`,
+		`portal_funnel_allowlist_decisions_total{decision="deny",reason="source_ip_not_allowlisted"} 1`,
+		`portal_blocked_requests_total{source="auto"} 1`,
+		`portal_auto_bans_total{reason="scanner_path"} 1`,
+		`portal_requests_in_flight 3`,
+		`portal_open_connections{state="active"} 1`,
+		`portal_open_connections{state="idle"} 3`,
+		`portal_connections_accepted_total 9`,
+		`portal_connections_hijacked_total 1`,
+		`portal_endpoint_readiness{exposure="funnel",mode="local_daemon",state="ready"} 1`,
+		`portal_endpoint_readiness{exposure="funnel",mode="local_daemon",state="starting"} 0`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Fatalf("expected metrics to contain %q, got:\n%s", want, body)
+		}
+	}
+}
+
+func TestRecorderBoundsMethodAndRouteLabels(t *testing.T) {
+	recorder := NewRecorder(Sources{})
+	for i := 0; i < maxRoutes+5; i++ {
+		recorder.ObserveRequest("GET", 404, "/probe-"+strconv.Itoa(i), time.Millisecond, 0, 0)
+	}
+	recorder.ObserveRequest("GET", 200, "/probe-0", time.Millisecond, 0, 0)
+	recorder.ObserveRequest("BREW", 405, "/probe-0", time.Millisecond, 0, 0)
+
+	body := scrape(t, recorder)
+	for _, want := range []string{
+		`portal_requests_total{method="GET",route="{other}",status_class="4xx"} 5`,
+		`portal_requests_total{method="GET",route="/probe-0",status_class="2xx"} 1`,
+		`portal_requests_total{method="_OTHER",route="/probe-0",status_class="4xx"} 1`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Fatalf("expected metrics to contain %q", want)
+		}
+	}
+	if strings.Contains(body, `route="/probe-`+strconv.Itoa(maxRoutes)+`"`) {
+		t.Fatal("expected routes past the limit to be folded")
+	}
+}
+
+func TestStatusClass(t *testing.T) {
+	for code, want := range map[int]string{0: "unknown", 101: "1xx", 204: "2xx", 302: "3xx", 404: "4xx", 503: "5xx"} {
+		if got := StatusClass(code); got != want {
+			t.Fatalf("expected %d to be %q, got %q", code, want, got)
+		}
+	}
+}
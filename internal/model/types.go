@@ -1,6 +1,7 @@
 package model
 
 import (
+	"encoding/json"
 	"net/http"
 	"time"
 )
@@ -8,12 +9,19 @@ import (
 // RequestLog represents a logged HTTP request
 type RequestLog struct {
 	ID          string            `json:"id"`
+	RequestID   string            `json:"request_id,omitempty"`
+	TraceID     string            `json:"trace_id,omitempty"`
 	Timestamp   time.Time         `json:"timestamp"`
 	Method      string            `json:"method"`
 	URL         string            `json:"url"`
 	RemoteAddr  string            `json:"remote_addr"`
+	SourceIP    string            `json:"source_ip,omitempty"`
+	Geo         *GeoInfo          `json:"geo,omitempty"`
+	AccessRule  string            `json:"access_rule,omitempty"` // Funnel access rule that decided the request
 	Headers     map[string]string `json:"headers"`
 	Body        string            `json:"body,omitempty"`
+	BodyView    *BodyView         `json:"body_view,omitempty"`
+	GraphQL     *GraphQLOperation `json:"graphql,omitempty"`
 	Response    ResponseLog       `json:"response"`
 	Duration    time.Duration     `json:"duration"`
 	UserAgent   string            `json:"user_agent"`
@@ -22,6 +30,161 @@ type RequestLog struct {
 	StatusCode  int               `json:"status_code"` // Convenience field for UI
 }
 
+// GeoInfo is the country and network a source IP belongs to, as found in a
+// GeoIP database. Empty fields were not in the database.
+type GeoInfo struct {
+	Country string `json:"country,omitempty"` // ISO 3166-1 alpha-2 code
+	ASN     uint   `json:"asn,omitempty"`
+	ASOrg   string `json:"as_org,omitempty"`
+}
+
+// BodyView is a structured rendering of a captured body for known content types.
+type BodyView struct {
+	Kind   string      `json:"kind"`
+	Pretty string      `json:"pretty,omitempty"`
+	Fields []BodyField `json:"fields,omitempty"`
+	Parts  []BodyPart  `json:"parts,omitempty"`
+	Error  string      `json:"error,omitempty"`
+}
+
+const (
+	BodyKindJSON      = "json"
+	BodyKindXML       = "xml"
+	BodyKindForm      = "form"
+	BodyKindMultipart = "multipart"
+)
+
+// BodyField is a single decoded form field.
+type BodyField struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+// BodyPart describes one part of a multipart body.
+type BodyPart struct {
+	Name        string            `json:"name,omitempty"`
+	Filename    string            `json:"filename,omitempty"`
+	ContentType string            `json:"content_type,omitempty"`
+	Headers     map[string]string `json:"headers,omitempty"`
+	Size        int64             `json:"size"`
+	Preview     string            `json:"preview,omitempty"`
+}
+
+// GraphQLOperation describes a detected GraphQL request and any errors
+// returned in its response.
+type GraphQLOperation struct {
+	OperationType string          `json:"operation_type"`
+	OperationName string          `json:"operation_name,omitempty"`
+	Variables     json.RawMessage `json:"variables,omitempty"`
+	Errors        []string        `json:"errors,omitempty"`
+}
+
+// Label returns a short display label such as "query GetUser".
+func (g GraphQLOperation) Label() string {
+	if g.OperationName == "" {
+		return g.OperationType
+	}
+	return g.OperationType + " " + g.OperationName
+}
+
+// LatencyWindow summarises request latency over a trailing time window.
+// Durations are in milliseconds.
+type LatencyWindow struct {
+	Window string  `json:"window"`
+	Count  int     `json:"count"`
+	Avg    float64 `json:"avg_ms"`
+	P50    float64 `json:"p50_ms"`
+	P90    float64 `json:"p90_ms"`
+	P95    float64 `json:"p95_ms"`
+	P99    float64 `json:"p99_ms"`
+	Max    float64 `json:"max_ms"`
+}
+
+// RouteStats aggregates traffic for one method and route template since the
+// last reset. Durations are in milliseconds and ErrorRate is a percentage of
+// 4xx and 5xx responses.
+type RouteStats struct {
+	Method        string  `json:"method"`
+	Route         string  `json:"route"`
+	Count         int     `json:"count"`
+	ClientErrors  int     `json:"client_errors"`
+	ServerErrors  int     `json:"server_errors"`
+	ErrorRate     float64 `json:"error_rate"`
+	Avg           float64 `json:"avg_ms"`
+	P50           float64 `json:"p50_ms"`
+	P90           float64 `json:"p90_ms"`
+	P95           float64 `json:"p95_ms"`
+	P99           float64 `json:"p99_ms"`
+	Max           float64 `json:"max_ms"`
+	RequestBytes  int64   `json:"request_bytes"`
+	ResponseBytes int64   `json:"response_bytes"`
+}
+
+// TimeSeriesPoint aggregates the requests completed during one step of a
+// time series, starting at Timestamp. Latencies are in milliseconds.
+type TimeSeriesPoint struct {
+	Timestamp    time.Time `json:"timestamp"`
+	Requests     int       `json:"requests"`
+	ClientErrors int       `json:"client_errors"`
+	ServerErrors int       `json:"server_errors"`
+	Bytes        int64     `json:"bytes"`
+	P50          float64   `json:"p50_ms"`
+	P95          float64   `json:"p95_ms"`
+}
+
+// Talker aggregates the requests from one source IP since the last reset.
+// Allowed and Denied count Funnel allowlist decisions, and ErrorRate is a
+// percentage of 4xx and 5xx responses.
+type Talker struct {
+	IP           string        `json:"ip"`
+	SourceSignal string        `json:"source_signal"`
+	Requests     int           `json:"requests"`
+	ClientErrors int           `json:"client_errors"`
+	ServerErrors int           `json:"server_errors"`
+	ErrorRate    float64       `json:"error_rate"`
+	Allowed      int           `json:"allowlist_allowed"`
+	Denied       int           `json:"allowlist_denied"`
+	FirstSeen    time.Time     `json:"first_seen"`
+	LastSeen     time.Time     `json:"last_seen"`
+	Paths        []TalkerCount `json:"paths"`
+	UserAgents   []TalkerCount `json:"user_agents"`
+}
+
+// TalkerCount is a value seen from a source and how often it was seen.
+type TalkerCount struct {
+	Value string `json:"value"`
+	Count int    `json:"count"`
+}
+
+// ConnectionStats describes the TCP connections held open to portal's
+// listeners, separately from the requests sent over them. Ages are in
+// seconds; totals count since startup.
+type ConnectionStats struct {
+	Open                  int              `json:"open"`
+	New                   int              `json:"new"`
+	Active                int              `json:"active"`
+	Idle                  int              `json:"idle"`
+	Accepted              int              `json:"accepted_total"`
+	Closed                int              `json:"closed_total"`
+	Hijacked              int              `json:"hijacked_total"`
+	Requests              int              `json:"requests_total"`
+	RequestsPerConnection float64          `json:"requests_per_connection"`
+	AvgAge                float64          `json:"avg_age_seconds"`
+	MaxAge                float64          `json:"max_age_seconds"`
+	Peers                 []ConnectionPeer `json:"peers"`
+}
+
+// ConnectionPeer summarises the open connections held by one client address.
+type ConnectionPeer struct {
+	Address   string  `json:"address"`
+	Open      int     `json:"open"`
+	New       int     `json:"new"`
+	Active    int     `json:"active"`
+	Idle      int     `json:"idle"`
+	Requests  int     `json:"requests"`
+	OldestAge float64 `json:"oldest_age_seconds"`
+}
+
 // EndpointState represents startup/endpoint reachability details for TUI.
 type EndpointState struct {
 	Readiness string `json:"readiness"`
@@ -48,6 +211,13 @@ type ResponseLog struct {
 	Body          string            `json:"body,omitempty"`
 	BodyTruncated bool              `json:"body_truncated,omitempty"`
 	Size          int64             `json:"size"`
+
+	// ContentEncoding, EncodedSize and DecodedSize describe how the body was
+	// compressed on the wire. DecodedSize only covers the captured preview
+	// when BodyTruncated is set.
+	ContentEncoding string `json:"content_encoding,omitempty"`
+	EncodedSize     int64  `json:"encoded_size"`
+	DecodedSize     int64  `json:"decoded_size"`
 }
 
 // Config holds the main application configuration
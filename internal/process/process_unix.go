@@ -0,0 +1,30 @@
+//go:build !windows
+
+// Package process reports on and controls other portal processes.
+package process
+
+import (
+	"errors"
+	"os/exec"
+	"syscall"
+)
+
+// Alive reports whether a process with the given PID exists.
+func Alive(pid int) bool {
+	if pid <= 0 {
+		return false
+	}
+	err := syscall.Kill(pid, 0)
+	return err == nil || errors.Is(err, syscall.EPERM)
+}
+
+// Terminate asks a process to shut down, letting it clean up first.
+func Terminate(pid int) error {
+	return syscall.Kill(pid, syscall.SIGTERM)
+}
+
+// Detach starts cmd in its own session, so it keeps running after the
+// terminal that started it closes.
+func Detach(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+}
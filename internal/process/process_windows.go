@@ -0,0 +1,44 @@
+//go:build windows
+
+// Package process reports on and controls other portal processes.
+package process
+
+import (
+	"os"
+	"os/exec"
+	"syscall"
+)
+
+// detachedProcess is DETACHED_PROCESS, which the syscall package lacks.
+const detachedProcess = 0x00000008
+
+// Alive reports whether a process with the given PID exists. On Windows,
+// finding a process opens it, which fails once it has exited.
+func Alive(pid int) bool {
+	if pid <= 0 {
+		return false
+	}
+	process, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+	process.Release()
+	return true
+}
+
+// Terminate stops a process. Windows has no signal a console process can
+// handle here, so the process is killed without cleaning up.
+func Terminate(pid int) error {
+	process, err := os.FindProcess(pid)
+	if err != nil {
+		return err
+	}
+	defer process.Release()
+	return process.Kill()
+}
+
+// Detach starts cmd without a console and in its own process group, so it
+// keeps running after the console that started it closes.
+func Detach(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{CreationFlags: detachedProcess | syscall.CREATE_NEW_PROCESS_GROUP}
+}
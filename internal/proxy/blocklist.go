@@ -0,0 +1,179 @@
+package proxy
+
+import (
+	"fmt"
+	"net/http"
+	"net/netip"
+
+	"go.uber.org/zap"
+
+	"github.com/jaxxstorm/portal/internal/access"
+	"github.com/jaxxstorm/portal/internal/blocklist"
+	"github.com/jaxxstorm/portal/internal/logging"
+	"github.com/jaxxstorm/portal/internal/stats"
+)
+
+// accessResult is the outcome of access control for a request.
+type accessResult struct {
+	allowed  bool
+	decision string // for per-source statistics; empty when no access control applied
+	rule     string // Funnel access rule that decided the request, if any
+}
+
+// enforceAccess refuses blocked and denylisted sources, then applies the
+// first Funnel access rule covering the request or, when none does, the
+// Funnel allowlist.
+func (s *Server) enforceAccess(w http.ResponseWriter, r *http.Request, source requestSource) accessResult {
+	if !s.enforceBlocklist(w, r, source) || !s.enforceFunnelDenylist(w, r, source) {
+		return accessResult{decision: stats.DecisionDeny}
+	}
+
+	if rule, ok := s.funnelRule(r); ok {
+		allowed := s.enforceFunnelRule(w, r, source, rule)
+		decision := stats.DecisionDeny
+		if allowed {
+			decision = stats.DecisionAllow
+		}
+		return accessResult{allowed: allowed, decision: decision, rule: rule.Name}
+	}
+
+	allowed := s.enforceFunnelAllowlist(w, r, source)
+	switch {
+	case !s.allowlistActive() && !s.denylistActive():
+		return accessResult{allowed: allowed}
+	case allowed:
+		return accessResult{allowed: true, decision: stats.DecisionAllow}
+	default:
+		return accessResult{decision: stats.DecisionDeny}
+	}
+}
+
+// enforceBlocklist refuses requests from blocked sources, banning sources
+// that request a scanner path first when auto-ban is enabled. Requests whose
+// source cannot be resolved are left to the allowlist.
+func (s *Server) enforceBlocklist(w http.ResponseWriter, r *http.Request, source requestSource) bool {
+	if !source.resolved {
+		return true
+	}
+	sourceIP := source.ip
+
+	entry, blocked := s.blocklist.Match(sourceIP)
+	if !blocked && s.autoBanEligible(r, sourceIP) {
+		if scannerPath, ok := s.autoBan.ScannerPath(r.URL.Path); ok {
+			entry, blocked = s.autoBanSource(r, sourceIP, blocklist.ReasonScannerPath, "requested scanner path "+scannerPath)
+		}
+	}
+	if !blocked {
+		return true
+	}
+
+	logging.WithFields(r.Context()).Warn("Request blocked",
+		logging.Component("blocklist"),
+		zap.String("source_signal", source.signal),
+		zap.String("source_ip", sourceIP.String()),
+		zap.String("blocked_entry", entry.Prefix.String()),
+		zap.String("block_source", entry.Source),
+		zap.String("method", r.Method),
+		zap.String("path", r.URL.Path),
+	)
+	s.metrics.ObserveBlocked(entry.Source)
+	http.Error(w, "Forbidden", http.StatusForbidden)
+	return false
+}
+
+// recordNotFound feeds not-found responses to the auto-ban heuristics and
+// bans the source once it crosses the threshold.
+func (s *Server) recordNotFound(r *http.Request, sourceIP netip.Addr, statusCode int) {
+	if statusCode != http.StatusNotFound || !s.autoBanEligible(r, sourceIP) {
+		return
+	}
+	if s.autoBan.RecordNotFound(sourceIP) {
+		s.autoBanSource(r, sourceIP, blocklist.ReasonNotFound,
+			fmt.Sprintf("%d not-found responses within %s", s.autoBan.Threshold(), s.autoBan.Window()))
+	}
+}
+
+// autoBanEligible reports whether sourceIP may be banned automatically. Only
+// Funnel traffic from a source mode clients cannot spoof is considered, and
+// loopback sources and sources on the allowlist by IP are never banned.
+func (s *Server) autoBanEligible(r *http.Request, sourceIP netip.Addr) bool {
+	if s.autoBan == nil || !s.sourceMode.Trusted() || !sourceIP.IsValid() || sourceIP.IsLoopback() {
+		return false
+	}
+	if s.requestExposure(r) != exposureFunnel {
+		return false
+	}
+	if s.allowlistActive() {
+		if _, allowlisted := access.Match(s.funnelAccess.Load().allowlist, sourceIP, nil); allowlisted {
+			return false
+		}
+	}
+	return true
+}
+
+// autoBanSource adds sourceIP, or its /64 for IPv6, to the blocklist. It
+// reports false when the ban could not be saved, in which case the request
+// is not blocked.
+func (s *Server) autoBanSource(r *http.Request, sourceIP netip.Addr, reason, detail string) (blocklist.Entry, bool) {
+	logger := logging.WithFields(r.Context())
+
+	entry, added, err := s.blocklist.Add(blocklist.AutoBanPrefix(sourceIP), "auto-ban: "+detail, blocklist.SourceAuto)
+	if err != nil {
+		logger.Error(logging.MsgRuntimeError,
+			logging.Component("blocklist"),
+			logging.Operation("auto_ban"),
+			zap.String("source_ip", sourceIP.String()),
+			logging.Error(err),
+		)
+		return blocklist.Entry{}, false
+	}
+	if added {
+		logger.Warn("Source auto-banned",
+			logging.Component("blocklist"),
+			zap.String("source_ip", sourceIP.String()),
+			zap.String("blocked_entry", entry.Prefix.String()),
+			zap.String("ban_reason", reason),
+			zap.String("detail", detail),
+		)
+		s.metrics.ObserveAutoBan(reason)
+	}
+	return entry, true
+}
+
+// BlockSource adds prefix to the runtime blocklist. It reports false when
+// the prefix was already blocked.
+func (s *Server) BlockSource(prefix netip.Prefix, reason string) (blocklist.Entry, bool, error) {
+	entry, added, err := s.blocklist.Add(prefix, reason, blocklist.SourceManual)
+	if err != nil {
+		return blocklist.Entry{}, false, err
+	}
+	if added {
+		s.logger.Info("Source blocked",
+			logging.Component("blocklist"),
+			zap.String("blocked_entry", entry.Prefix.String()),
+			zap.String("reason", entry.Reason),
+		)
+	}
+	return entry, added, nil
+}
+
+// UnblockSource removes prefix from the runtime blocklist. It reports false
+// when the prefix was not blocked.
+func (s *Server) UnblockSource(prefix netip.Prefix) (bool, error) {
+	removed, err := s.blocklist.Remove(prefix)
+	if err != nil {
+		return false, err
+	}
+	if removed {
+		s.logger.Info("Source unblocked",
+			logging.Component("blocklist"),
+			zap.String("blocked_entry", prefix.Masked().String()),
+		)
+	}
+	return removed, nil
+}
+
+// GetBlocklist returns the blocked prefixes in the order they were added.
+func (s *Server) GetBlocklist() []blocklist.Entry {
+	return s.blocklist.Entries()
+}
@@ -0,0 +1,169 @@
+package proxy
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/url"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/jaxxstorm/portal/internal/model"
+)
+
+const maxBodyPartPreviewBytes = 1024
+
+// buildBodyView parses a captured body into a structured view based on its
+// content type. It returns nil for empty bodies and unrecognised types.
+func buildBodyView(contentType string, body []byte) *model.BodyView {
+	if len(body) == 0 {
+		return nil
+	}
+
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		mediaType = strings.ToLower(strings.TrimSpace(strings.Split(contentType, ";")[0]))
+	}
+
+	switch {
+	case mediaType == "application/json" || strings.HasSuffix(mediaType, "+json"):
+		return jsonBodyView(body)
+	case mediaType == "application/xml" || mediaType == "text/xml" || strings.HasSuffix(mediaType, "+xml"):
+		return xmlBodyView(body)
+	case mediaType == "application/x-www-form-urlencoded":
+		return formBodyView(body)
+	case strings.HasPrefix(mediaType, "multipart/"):
+		return multipartBodyView(body, params["boundary"])
+	default:
+		return nil
+	}
+}
+
+func jsonBodyView(body []byte) *model.BodyView {
+	view := &model.BodyView{Kind: model.BodyKindJSON}
+
+	var pretty bytes.Buffer
+	if err := json.Indent(&pretty, body, "", "  "); err != nil {
+		view.Error = err.Error()
+		return view
+	}
+	view.Pretty = pretty.String()
+	return view
+}
+
+func xmlBodyView(body []byte) *model.BodyView {
+	view := &model.BodyView{Kind: model.BodyKindXML}
+
+	decoder := xml.NewDecoder(bytes.NewReader(body))
+	var pretty bytes.Buffer
+	encoder := xml.NewEncoder(&pretty)
+	encoder.Indent("", "  ")
+
+	for {
+		token, err := decoder.Token()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			view.Error = err.Error()
+			return view
+		}
+
+		if data, ok := token.(xml.CharData); ok {
+			trimmed := bytes.TrimSpace(data)
+			if len(trimmed) == 0 {
+				continue
+			}
+			token = xml.CharData(trimmed)
+		}
+
+		if err := encoder.EncodeToken(xml.CopyToken(token)); err != nil {
+			view.Error = err.Error()
+			return view
+		}
+	}
+
+	if err := encoder.Flush(); err != nil {
+		view.Error = err.Error()
+		return view
+	}
+	view.Pretty = pretty.String()
+	return view
+}
+
+func formBodyView(body []byte) *model.BodyView {
+	view := &model.BodyView{Kind: model.BodyKindForm}
+
+	values, err := url.ParseQuery(string(body))
+	if err != nil {
+		view.Error = err.Error()
+	}
+
+	names := make([]string, 0, len(values))
+	for name := range values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		for _, value := range values[name] {
+			view.Fields = append(view.Fields, model.BodyField{Name: name, Value: value})
+		}
+	}
+	return view
+}
+
+func multipartBodyView(body []byte, boundary string) *model.BodyView {
+	view := &model.BodyView{Kind: model.BodyKindMultipart}
+	if boundary == "" {
+		view.Error = "missing multipart boundary"
+		return view
+	}
+
+	reader := multipart.NewReader(bytes.NewReader(body), boundary)
+	for {
+		part, err := reader.NextPart()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			view.Error = err.Error()
+			break
+		}
+
+		var preview bytes.Buffer
+		size, copyErr := io.Copy(&preview, part)
+		headers := make(map[string]string, len(part.Header))
+		for key, values := range part.Header {
+			headers[key] = strings.Join(values, ", ")
+		}
+
+		entry := model.BodyPart{
+			Name:        part.FormName(),
+			Filename:    part.FileName(),
+			ContentType: part.Header.Get("Content-Type"),
+			Headers:     headers,
+			Size:        size,
+		}
+		if entry.Filename == "" && utf8.Valid(preview.Bytes()) {
+			data := preview.Bytes()
+			if len(data) > maxBodyPartPreviewBytes {
+				data = data[:maxBodyPartPreviewBytes]
+			}
+			entry.Preview = string(data)
+		}
+		view.Parts = append(view.Parts, entry)
+		part.Close()
+
+		if copyErr != nil {
+			view.Error = copyErr.Error()
+			break
+		}
+	}
+	return view
+}
@@ -0,0 +1,116 @@
+package proxy
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+)
+
+// maxDecodedBodyBytes bounds how much decoded data is produced for previews so
+// small compressed payloads cannot expand without limit.
+const maxDecodedBodyBytes = 10 * 1024 * 1024
+
+// decodedBody is the result of decoding a captured body for inspection.
+type decodedBody struct {
+	data      []byte
+	size      int64
+	truncated bool
+}
+
+// decodeContentEncoding reverses the codings listed in a Content-Encoding
+// header. Codings are applied in listed order, so they are undone in reverse.
+// At most limit decoded bytes are read from each coding, so callers that only
+// keep a preview do not inflate more than that. A partial result is returned
+// alongside an error when the input ends early, which is expected when the
+// captured preview was truncated.
+func decodeContentEncoding(contentEncoding string, data []byte, limit int) (decodedBody, error) {
+	codings := parseContentEncoding(contentEncoding)
+	result := decodedBody{data: data, size: int64(len(data))}
+	if len(codings) == 0 {
+		return result, nil
+	}
+
+	for i := len(codings) - 1; i >= 0; i-- {
+		decoded, truncated, err := decodeWithCoding(codings[i], result.data, limit)
+		result.data = decoded
+		result.size = int64(len(decoded))
+		result.truncated = result.truncated || truncated
+		if err != nil {
+			return result, err
+		}
+	}
+
+	return result, nil
+}
+
+func parseContentEncoding(header string) []string {
+	var codings []string
+	for _, entry := range strings.Split(header, ",") {
+		coding := strings.ToLower(strings.TrimSpace(entry))
+		if coding == "" || coding == "identity" {
+			continue
+		}
+		codings = append(codings, coding)
+	}
+	return codings
+}
+
+func decodeWithCoding(coding string, data []byte, limit int) ([]byte, bool, error) {
+	var reader io.Reader
+	switch coding {
+	case "gzip", "x-gzip":
+		gz, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return data, false, fmt.Errorf("gzip: %w", err)
+		}
+		defer gz.Close()
+		reader = gz
+	case "deflate":
+		// RFC 9110 deflate is zlib-wrapped, but raw deflate streams are common
+		// enough in the wild that we fall back to them.
+		if zr, err := zlib.NewReader(bytes.NewReader(data)); err == nil {
+			defer zr.Close()
+			reader = zr
+		} else {
+			fr := flate.NewReader(bytes.NewReader(data))
+			defer fr.Close()
+			reader = fr
+		}
+	case "br":
+		reader = brotli.NewReader(bytes.NewReader(data))
+	case "zstd":
+		zr, err := zstd.NewReader(bytes.NewReader(data), zstd.WithDecoderMaxMemory(maxDecodedBodyBytes))
+		if err != nil {
+			return data, false, fmt.Errorf("zstd: %w", err)
+		}
+		defer zr.Close()
+		reader = zr
+	default:
+		return data, false, fmt.Errorf("unsupported content encoding %q", coding)
+	}
+
+	decoded, err := io.ReadAll(io.LimitReader(reader, int64(limit)+1))
+	truncated := len(decoded) > limit
+	if truncated {
+		decoded = decoded[:limit]
+	}
+	if err != nil {
+		if errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, io.EOF) {
+			return decoded, true, nil
+		}
+		if len(decoded) > 0 {
+			return decoded, true, nil
+		}
+		return data, false, fmt.Errorf("%s: %w", coding, err)
+	}
+
+	return decoded, truncated, nil
+}
@@ -0,0 +1,220 @@
+package proxy
+
+import (
+	"bytes"
+	"encoding/json"
+	"mime"
+	"net/http"
+	"strings"
+
+	"github.com/jaxxstorm/portal/internal/model"
+)
+
+const (
+	graphQLOperationQuery        = "query"
+	graphQLOperationMutation     = "mutation"
+	graphQLOperationSubscription = "subscription"
+
+	graphQLFragment = "fragment"
+)
+
+type graphQLRequest struct {
+	Query         string          `json:"query"`
+	OperationName string          `json:"operationName"`
+	Variables     json.RawMessage `json:"variables"`
+}
+
+type graphQLDefinition struct {
+	operationType string
+	name          string
+}
+
+// detectGraphQL recognises GraphQL over HTTP requests, either as a GET with a
+// query parameter or a POST with a JSON or application/graphql body. It
+// returns nil when the request does not carry a parseable GraphQL document.
+func detectGraphQL(r *http.Request, body []byte) *model.GraphQLOperation {
+	var req graphQLRequest
+
+	switch r.Method {
+	case http.MethodGet:
+		query := r.URL.Query()
+		req.Query = query.Get("query")
+		req.OperationName = query.Get("operationName")
+		if variables := query.Get("variables"); variables != "" && json.Valid([]byte(variables)) {
+			req.Variables = json.RawMessage(variables)
+		}
+	case http.MethodPost:
+		mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+		switch mediaType {
+		case "application/graphql":
+			req.Query = string(body)
+			req.OperationName = r.URL.Query().Get("operationName")
+		case "application/json", "application/graphql+json", "application/graphql-response+json":
+			if err := json.Unmarshal(body, &req); err != nil {
+				return nil
+			}
+		default:
+			return nil
+		}
+	default:
+		return nil
+	}
+
+	if strings.TrimSpace(req.Query) == "" {
+		return nil
+	}
+
+	definitions := parseGraphQLDefinitions(req.Query)
+	if len(definitions) == 0 {
+		return nil
+	}
+
+	selected := definitions[0]
+	if req.OperationName != "" {
+		for _, definition := range definitions {
+			if definition.name == req.OperationName {
+				selected = definition
+				break
+			}
+		}
+	}
+
+	operation := &model.GraphQLOperation{
+		OperationType: selected.operationType,
+		OperationName: selected.name,
+	}
+	if req.OperationName != "" {
+		operation.OperationName = req.OperationName
+	}
+	if trimmed := bytes.TrimSpace(req.Variables); len(trimmed) > 0 && !bytes.Equal(trimmed, []byte("null")) {
+		operation.Variables = json.RawMessage(trimmed)
+	}
+	return operation
+}
+
+// graphQLResponseErrors extracts error messages from a GraphQL response body.
+// Bodies that are not valid JSON, including truncated previews, yield nil.
+func graphQLResponseErrors(body string) []string {
+	var response struct {
+		Errors []struct {
+			Message string `json:"message"`
+		} `json:"errors"`
+	}
+	if err := json.Unmarshal([]byte(body), &response); err != nil {
+		return nil
+	}
+
+	messages := make([]string, 0, len(response.Errors))
+	for _, entry := range response.Errors {
+		messages = append(messages, entry.Message)
+	}
+	if len(messages) == 0 {
+		return nil
+	}
+	return messages
+}
+
+// parseGraphQLDefinitions scans a GraphQL document for top-level operation
+// definitions. It is intentionally shallow: it only tracks enough structure
+// to find operation keywords and names, skipping comments, strings and
+// selection sets.
+func parseGraphQLDefinitions(document string) []graphQLDefinition {
+	var (
+		definitions []graphQLDefinition
+		current     *graphQLDefinition
+		expectName  bool
+		braceDepth  int
+		parenDepth  int
+	)
+
+	for i := 0; i < len(document); {
+		c := document[i]
+		switch {
+		case c == '#':
+			for i < len(document) && document[i] != '\n' {
+				i++
+			}
+			continue
+		case c == '"':
+			i = skipGraphQLString(document, i)
+			continue
+		case c == '(':
+			parenDepth++
+			expectName = false
+		case c == ')':
+			if parenDepth > 0 {
+				parenDepth--
+			}
+		case c == '@':
+			expectName = false
+		case c == '{':
+			if braceDepth == 0 && parenDepth == 0 {
+				if current == nil {
+					definitions = append(definitions, graphQLDefinition{operationType: graphQLOperationQuery})
+				} else if current.operationType != graphQLFragment {
+					definitions = append(definitions, *current)
+				}
+				current = nil
+				expectName = false
+			}
+			if parenDepth == 0 {
+				braceDepth++
+			}
+		case c == '}':
+			if parenDepth == 0 && braceDepth > 0 {
+				braceDepth--
+			}
+		case isGraphQLNameStart(c):
+			start := i
+			for i < len(document) && isGraphQLNameContinue(document[i]) {
+				i++
+			}
+			if braceDepth == 0 && parenDepth == 0 {
+				name := document[start:i]
+				switch {
+				case current == nil:
+					switch name {
+					case graphQLOperationQuery, graphQLOperationMutation, graphQLOperationSubscription, graphQLFragment:
+						current = &graphQLDefinition{operationType: name}
+						expectName = true
+					}
+				case expectName:
+					current.name = name
+					expectName = false
+				}
+			}
+			continue
+		}
+		i++
+	}
+
+	return definitions
+}
+
+func skipGraphQLString(document string, start int) int {
+	if strings.HasPrefix(document[start:], `"""`) {
+		end := strings.Index(document[start+3:], `"""`)
+		if end < 0 {
+			return len(document)
+		}
+		return start + 3 + end + 3
+	}
+
+	for i := start + 1; i < len(document); i++ {
+		switch document[i] {
+		case '\\':
+			i++
+		case '"', '\n':
+			return i + 1
+		}
+	}
+	return len(document)
+}
+
+func isGraphQLNameStart(c byte) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+func isGraphQLNameContinue(c byte) bool {
+	return isGraphQLNameStart(c) || (c >= '0' && c <= '9')
+}
@@ -6,10 +6,9 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
+	"net"
 	"net/http"
-	"net/http/httputil"
-	"net/netip"
-	"net/url"
+	"path"
 	"strings"
 	"sync"
 	"sync/atomic"
@@ -17,9 +16,14 @@ import (
 	"unicode/utf8"
 
 	tea "github.com/charmbracelet/bubbletea"
+	"go.opentelemetry.io/otel/trace"
 	"go.uber.org/zap"
 
+	"github.com/jaxxstorm/portal/internal/access"
+	"github.com/jaxxstorm/portal/internal/blocklist"
+	"github.com/jaxxstorm/portal/internal/geoip"
 	"github.com/jaxxstorm/portal/internal/logging"
+	"github.com/jaxxstorm/portal/internal/metrics"
 	"github.com/jaxxstorm/portal/internal/model"
 	"github.com/jaxxstorm/portal/internal/stats"
 )
@@ -32,12 +36,17 @@ type LoggingResponseWriter struct {
 	headers       map[string]string
 	bodyPreview   []byte
 	bodyTruncated bool
+	echoHeader    string
+	echoValue     string
 }
 
 const maxResponseBodyPreviewBytes = 256 * 1024
 
-// WriteHeader captures the status code
+// WriteHeader captures the status code and echoes the request ID header
 func (lrw *LoggingResponseWriter) WriteHeader(code int) {
+	if lrw.statusCode == 0 && lrw.echoHeader != "" {
+		lrw.ResponseWriter.Header().Set(lrw.echoHeader, lrw.echoValue)
+	}
 	lrw.statusCode = code
 	lrw.ResponseWriter.WriteHeader(code)
 }
@@ -45,7 +54,7 @@ func (lrw *LoggingResponseWriter) WriteHeader(code int) {
 // Write captures the response size
 func (lrw *LoggingResponseWriter) Write(b []byte) (int, error) {
 	if lrw.statusCode == 0 {
-		lrw.statusCode = 200
+		lrw.WriteHeader(http.StatusOK)
 	}
 
 	remaining := maxResponseBodyPreviewBytes - len(lrw.bodyPreview)
@@ -82,22 +91,31 @@ func (lrw *LoggingResponseWriter) captureHeaders() {
 type Server struct {
 	logger          *zap.Logger
 	sugarLogger     *zap.SugaredLogger
-	proxy           *httputil.ReverseProxy
-	targetURL       *url.URL
+	upstream        atomic.Pointer[upstream]
 	requestLog      []model.RequestLog
 	logMutex        sync.RWMutex
 	program         *tea.Program
 	useTUI          bool
-	mode            model.ServerMode
 	stats           *stats.Tracker
 	requestID       int64
 	endpoint        model.EndpointState
 	endpointMu      sync.RWMutex
 	maxLogsCap      int                      // Maximum number of logs to keep
 	listeners       []func(model.RequestLog) // Event listeners for new requests
-	funnelEnabled   bool
-	funnelAllowlist []netip.Prefix
-	preferRemoteIP  bool
+	endpointHooks   []func(model.EndpointState)
+	funnelEnabled   atomic.Bool
+	funnelAccess    atomic.Pointer[funnelAccess]
+	geoIP           *geoip.DB
+	sourceMode      SourceMode
+	mountPath       string
+	requestIDHeader string
+	tracer          trace.Tracer
+	metrics         *metrics.Recorder
+	routes          atomic.Pointer[stats.RouteTable]
+	series          *stats.TimeSeries
+	conns           *stats.ConnectionTracker
+	blocklist       *blocklist.List
+	autoBan         *blocklist.AutoBan
 }
 
 // Config holds configuration for the proxy server
@@ -108,55 +126,73 @@ type Config struct {
 	Logger          *zap.Logger
 	MaxLogs         int // Maximum number of logs to keep (default: 1000)
 	FunnelEnabled   bool
-	FunnelAllowlist []netip.Prefix
-	PreferRemoteIP  bool
+	FunnelAllowlist []access.Entry
+	FunnelDenylist  []access.Entry
+	FunnelRules     []access.Rule // Path-scoped Funnel rules, tried in order before the allowlist
+	SourceMode      SourceMode    // Which request signals identify the source IP (default: SourceModeHeaders)
+	MountPath       string        // Public path tailscaled strips before proxying (--set-path, default: /)
 	InitialEndpoint model.EndpointState
+	RequestIDHeader string             // Header used to propagate request IDs (default: X-Request-ID)
+	Tracer          trace.Tracer       // Optional tracer for per-request spans
+	Routes          []string           // Route templates for per-route stats, tried before automatic templating
+	Blocklist       *blocklist.List    // Runtime blocklist (default: empty, in memory)
+	AutoBan         *blocklist.AutoBan // Optional heuristics that add Funnel scanners to the blocklist
+	GeoIP           *geoip.DB          // Optional country and ASN lookups for Funnel sources
 }
 
 // NewServer creates a new proxy server
 func NewServer(config Config) *Server {
-	var targetURL *url.URL
-	var proxy *httputil.ReverseProxy
-
 	maxLogs := config.MaxLogs
 	if maxLogs <= 0 {
 		maxLogs = 1000 // Default
 	}
 
-	if config.Mode == model.ModeProxy {
-		targetURL = &url.URL{
-			Scheme: "http",
-			Host:   fmt.Sprintf("localhost:%d", config.TargetPort),
-		}
-
-		proxy = httputil.NewSingleHostReverseProxy(targetURL)
+	requestIDHeader := http.CanonicalHeaderKey(strings.TrimSpace(config.RequestIDHeader))
+	if requestIDHeader == "" {
+		requestIDHeader = "X-Request-ID"
+	}
 
-		// Customize the director to preserve original headers
-		originalDirector := proxy.Director
-		proxy.Director = func(req *http.Request) {
-			originalDirector(req)
-			req.Header.Set("X-Forwarded-Proto", "https")
-			req.Header.Set("X-Forwarded-Host", req.Host)
-		}
+	sourceMode := config.SourceMode
+	if sourceMode == "" {
+		sourceMode = SourceModeHeaders
 	}
 
-	return &Server{
+	server := &Server{
 		logger:          config.Logger,
 		sugarLogger:     config.Logger.Sugar(),
-		proxy:           proxy,
-		targetURL:       targetURL,
 		requestLog:      make([]model.RequestLog, 0),
 		useTUI:          config.UseTUI,
-		mode:            config.Mode,
 		stats:           stats.NewTracker(),
 		requestID:       0,
 		endpoint:        config.InitialEndpoint,
 		maxLogsCap:      maxLogs,
 		listeners:       make([]func(model.RequestLog), 0),
-		funnelEnabled:   config.FunnelEnabled,
-		funnelAllowlist: config.FunnelAllowlist,
-		preferRemoteIP:  config.PreferRemoteIP,
+		geoIP:           config.GeoIP,
+		sourceMode:      sourceMode,
+		mountPath:       mountPath(config.MountPath),
+		requestIDHeader: requestIDHeader,
+		tracer:          config.Tracer,
+		series:          stats.NewTimeSeries(),
+		conns:           stats.NewConnectionTracker(),
+		blocklist:       config.Blocklist,
+		autoBan:         config.AutoBan,
+	}
+	server.upstream.Store(newUpstream(config.Mode, config.TargetPort))
+	server.funnelEnabled.Store(config.FunnelEnabled)
+	server.SetFunnelAccess(config.FunnelAllowlist, config.FunnelDenylist, config.FunnelRules)
+	server.SetRoutes(config.Routes)
+	if server.blocklist == nil {
+		server.blocklist = blocklist.NewMemory()
 	}
+	server.metrics = metrics.NewRecorder(metrics.Sources{
+		InFlightRequests: func() int {
+			_, open := server.stats.GetConnectionCount()
+			return open
+		},
+		Connections:   server.GetConnectionStats,
+		EndpointState: server.GetEndpointState,
+	})
+	return server
 }
 
 // SetProgram sets the TUI program for sending messages
@@ -190,8 +226,13 @@ func (s *Server) GetWebUIURL() string {
 
 func (s *Server) SetEndpointState(state model.EndpointState) {
 	s.endpointMu.Lock()
-	defer s.endpointMu.Unlock()
 	s.endpoint = state
+	hooks := s.endpointHooks
+	s.endpointMu.Unlock()
+
+	for _, hook := range hooks {
+		hook(state)
+	}
 }
 
 func (s *Server) GetEndpointState() model.EndpointState {
@@ -202,8 +243,6 @@ func (s *Server) GetEndpointState() model.EndpointState {
 
 func (s *Server) MarkEndpointFailure(reason string) {
 	s.endpointMu.Lock()
-	defer s.endpointMu.Unlock()
-
 	s.endpoint.Readiness = model.EndpointReadinessFailed
 	s.endpoint.ServiceURL = ""
 	if strings.TrimSpace(reason) != "" {
@@ -212,6 +251,23 @@ func (s *Server) MarkEndpointFailure(reason string) {
 	if s.endpoint.WebUIStatus == "" || s.endpoint.WebUIStatus == "enabled" {
 		s.endpoint.WebUIStatus = "unavailable"
 	}
+	state, hooks := s.endpoint, s.endpointHooks
+	s.endpointMu.Unlock()
+
+	for _, hook := range hooks {
+		hook(state)
+	}
+}
+
+// AddEndpointListener adds a function called with the endpoint state each
+// time it is set or marked failed.
+func (s *Server) AddEndpointListener(listener func(model.EndpointState)) {
+	if listener == nil {
+		return
+	}
+	s.endpointMu.Lock()
+	defer s.endpointMu.Unlock()
+	s.endpointHooks = append(s.endpointHooks, listener)
 }
 
 // AddListener adds a listener function that will be called for each new request
@@ -236,7 +292,27 @@ func (s *Server) nextRequestID() string {
 // ServeHTTP implements the http.Handler interface
 func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	start := time.Now()
-	requestID := s.nextRequestID()
+	captureID := s.nextRequestID()
+
+	// Continue the caller's request ID and trace when present so the capture
+	// can be correlated with backend logs.
+	requestID := captureID
+	if incoming, ok := sanitizeRequestID(r.Header.Get(s.requestIDHeader)); ok {
+		requestID = incoming
+	}
+	traceCtx := continueTraceContext(r.Header.Get(traceparentHeader))
+
+	ctx, span := s.startRequestSpan(r.Context(), r)
+	defer span.End()
+	if sc := span.SpanContext(); sc.IsValid() {
+		traceCtx = traceContextFromSpan(sc)
+	}
+
+	ctx = logging.WithLogger(ctx, s.logger)
+	ctx = logging.WithRequestID(ctx, requestID)
+	ctx = logging.WithTraceID(ctx, traceCtx.traceID)
+	r = r.WithContext(ctx)
+	logger := logging.WithFields(ctx)
 
 	// Track connection stats
 	s.stats.IncrementOpen()
@@ -250,6 +326,8 @@ func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		headers:        make(map[string]string),
 		bodyPreview:    make([]byte, 0),
 		bodyTruncated:  false,
+		echoHeader:     s.requestIDHeader,
+		echoValue:      requestID,
 	}
 
 	// Read request body for logging (if not too large)
@@ -260,6 +338,15 @@ func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		bodyString = string(bodyBytes)
 		r.Body = io.NopCloser(strings.NewReader(bodyString))
 	}
+	// The upstream receives the original bytes; only the capture is decoded.
+	// The body view and GraphQL detection see the decoded bytes, so binary
+	// parts keep their content; only the text preview is made valid UTF-8.
+	captureBody, decoded := decodeRequestBody(logger, r.Header.Get("Content-Encoding"), bodyBytes)
+	bodyPreview := bodyString
+	if decoded {
+		bodyPreview = string(bytes.ToValidUTF8(captureBody, []byte("\uFFFD")))
+	}
+	graphQL := detectGraphQL(r, captureBody)
 
 	// Capture request headers
 	reqHeaders := make(map[string]string)
@@ -267,21 +354,28 @@ func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		reqHeaders[k] = strings.Join(v, ", ")
 	}
 
+	// Propagate the request ID and trace context to the backend.
+	r.Header.Set(s.requestIDHeader, requestID)
+	r.Header.Set(traceparentHeader, traceCtx.Traceparent())
+
 	// Log application-level events using the same pattern as other components
-	s.logger.Info("Request received",
+	logger.Info("Request received",
 		logging.Component("proxy_server"),
 		zap.String("method", r.Method),
 		zap.String("path", r.URL.Path),
 		zap.String("remote_addr", r.RemoteAddr),
 	)
 
-	if s.enforceFunnelAllowlist(lrw, r) {
+	source := s.resolveSource(r)
+	outcome := s.enforceAccess(lrw, r, source)
+	if outcome.allowed {
 		// Handle request based on mode
-		switch s.mode {
+		// Loaded once, so a retarget mid-request does not split it.
+		switch upstream := s.upstream.Load(); upstream.mode {
 		case model.ModeMock:
 			s.handleMockRequest(lrw, r, bodyString)
 		case model.ModeProxy:
-			s.proxy.ServeHTTP(lrw, r)
+			s.serveUpstream(lrw, r, upstream)
 		}
 	}
 	// Capture response headers after serving
@@ -291,35 +385,71 @@ func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 
 	// Add to stats
 	s.stats.AddRequest(duration)
+	requestSize := r.ContentLength
+	if requestSize <= 0 {
+		requestSize = int64(len(bodyBytes))
+	}
+	route := s.routes.Load().Route(r.URL.Path)
+	s.metrics.ObserveRequest(r.Method, lrw.statusCode, route, duration, requestSize, lrw.size)
+	sample := stats.RouteSample{
+		Method:        r.Method,
+		Route:         route,
+		StatusCode:    lrw.statusCode,
+		Duration:      duration,
+		RequestBytes:  requestSize,
+		ResponseBytes: lrw.size,
+	}
+	s.stats.AddRouteRequest(sample)
+	s.series.Record(sample)
+	s.recordSource(r, source, lrw.statusCode, outcome.decision)
+	s.recordNotFound(r, source.ip, lrw.statusCode)
+
+	responsePreview := decodeResponseBodyPreview(logger, lrw)
+	responseBody := formatResponseBodyPreview(lrw.headers, responsePreview.data)
+	if graphQL != nil {
+		graphQL.Errors = graphQLResponseErrors(responseBody)
+	}
 
 	// Create request log entry
 	logEntry := model.RequestLog{
-		ID:          requestID,
+		ID:          captureID,
+		RequestID:   requestID,
+		TraceID:     traceCtx.traceID,
 		Timestamp:   start,
 		Method:      r.Method,
 		URL:         r.URL.String(),
 		RemoteAddr:  r.RemoteAddr,
+		SourceIP:    sourceIPString(source.ip),
+		Geo:         source.geo,
+		AccessRule:  outcome.rule,
 		Headers:     reqHeaders,
-		Body:        bodyString,
+		Body:        bodyPreview,
+		BodyView:    buildBodyView(r.Header.Get("Content-Type"), captureBody),
+		GraphQL:     graphQL,
 		UserAgent:   r.UserAgent(),
 		ContentType: r.Header.Get("Content-Type"),
 		Size:        r.ContentLength,
 		StatusCode:  lrw.statusCode, // Convenience field for UI
 		Response: model.ResponseLog{
-			StatusCode:    lrw.statusCode,
-			Headers:       lrw.headers,
-			Body:          formatResponseBodyPreview(lrw.headers, lrw.bodyPreview),
-			BodyTruncated: lrw.bodyTruncated,
-			Size:          lrw.size,
+			StatusCode:      lrw.statusCode,
+			Headers:         lrw.headers,
+			Body:            responseBody,
+			BodyTruncated:   lrw.bodyTruncated || responsePreview.truncated,
+			Size:            lrw.size,
+			ContentEncoding: lrw.headers["Content-Encoding"],
+			EncodedSize:     lrw.size,
+			DecodedSize:     responsePreview.size,
 		},
 		Duration: duration,
 	}
 
+	s.finishRequestSpan(span, r, logEntry)
+
 	// Store log entry and notify listeners
 	s.captureRequest(logEntry)
 
 	// Log application-level response events with proper structured format
-	s.logger.Info("Request completed",
+	logger.Info("Request completed",
 		zap.Int("status_code", lrw.statusCode),
 		zap.Duration("duration", duration),
 		zap.Int64("response_size", lrw.size),
@@ -327,6 +457,48 @@ func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 
 }
 
+// decodeRequestBody returns the request body for captures, reversing any
+// Content-Encoding, and reports whether it was decoded. The raw body is kept
+// when it is not encoded or decoding fails.
+func decodeRequestBody(logger *zap.Logger, contentEncoding string, body []byte) ([]byte, bool) {
+	if len(body) == 0 || len(parseContentEncoding(contentEncoding)) == 0 {
+		return body, false
+	}
+
+	decoded, err := decodeContentEncoding(contentEncoding, body, maxDecodedBodyBytes)
+	if err != nil {
+		logger.Debug("Request body decode failed",
+			logging.Component("proxy_server"),
+			zap.String("content_encoding", contentEncoding),
+			logging.Error(err),
+		)
+		return body, false
+	}
+	return decoded.data, true
+}
+
+// decodeResponseBodyPreview reverses Content-Encoding on the captured response
+// preview. The bytes written to the client are never modified.
+func decodeResponseBodyPreview(logger *zap.Logger, lrw *LoggingResponseWriter) decodedBody {
+	preview := decodedBody{data: lrw.bodyPreview, size: int64(len(lrw.bodyPreview))}
+	contentEncoding := lrw.headers["Content-Encoding"]
+	if len(lrw.bodyPreview) == 0 || len(parseContentEncoding(contentEncoding)) == 0 {
+		preview.size = lrw.size
+		return preview
+	}
+
+	decoded, err := decodeContentEncoding(contentEncoding, lrw.bodyPreview, maxResponseBodyPreviewBytes)
+	if err != nil {
+		logger.Debug("Response body decode failed",
+			logging.Component("proxy_server"),
+			zap.String("content_encoding", contentEncoding),
+			logging.Error(err),
+		)
+		return preview
+	}
+	return decoded
+}
+
 func formatResponseBodyPreview(headers map[string]string, preview []byte) string {
 	if len(preview) == 0 {
 		return ""
@@ -348,53 +520,200 @@ func formatResponseBodyPreview(headers map[string]string, preview []byte) string
 	return "[binary response body omitted]"
 }
 
-func (s *Server) enforceFunnelAllowlist(w http.ResponseWriter, r *http.Request) bool {
-	if !s.funnelEnabled || len(s.funnelAllowlist) == 0 {
+// funnelAccess holds the Funnel access lists, replaced as a whole on reload.
+type funnelAccess struct {
+	allowlist []access.Entry
+	denylist  []access.Entry
+	rules     []access.Rule
+}
+
+// SetFunnelAccess replaces the Funnel allowlist, denylist and rules, for
+// configuration reloads. Requests already being checked finish against the
+// lists they started with.
+func (s *Server) SetFunnelAccess(allowlist, denylist []access.Entry, rules []access.Rule) {
+	s.funnelAccess.Store(&funnelAccess{allowlist: allowlist, denylist: denylist, rules: rules})
+}
+
+// SetRoutes replaces the route templates used for per-route statistics.
+// Stats already recorded keep their routes.
+func (s *Server) SetRoutes(routes []string) {
+	s.routes.Store(stats.NewRouteTable(routes))
+}
+
+// SetMaxLogs changes how many captured requests are kept, dropping the
+// oldest captures when the limit shrinks. Values below 1 are ignored.
+func (s *Server) SetMaxLogs(maxLogs int) {
+	if maxLogs <= 0 {
+		return
+	}
+	s.logMutex.Lock()
+	defer s.logMutex.Unlock()
+	s.maxLogsCap = maxLogs
+	if len(s.requestLog) > maxLogs {
+		s.requestLog = s.requestLog[len(s.requestLog)-maxLogs:]
+	}
+}
+
+// allowlistActive reports whether Funnel allowlist enforcement applies.
+func (s *Server) allowlistActive() bool {
+	return s.funnelEnabled.Load() && len(s.funnelAccess.Load().allowlist) > 0
+}
+
+// denylistActive reports whether Funnel denylist enforcement applies.
+func (s *Server) denylistActive() bool {
+	return s.funnelEnabled.Load() && len(s.funnelAccess.Load().denylist) > 0
+}
+
+// funnelRule returns the Funnel access rule covering a Funnel request.
+func (s *Server) funnelRule(r *http.Request) (access.Rule, bool) {
+	rules := s.funnelAccess.Load().rules
+	if !s.funnelEnabled.Load() || len(rules) == 0 || s.requestExposure(r) != exposureFunnel {
+		return access.Rule{}, false
+	}
+	return access.MatchRule(rules, r.Method, s.publicPath(r))
+}
+
+// publicPath returns the path the Funnel client requested. tailscaled strips
+// the --set-path mount before proxying, so rules written against the public
+// URL are matched with the mount put back.
+func (s *Server) publicPath(r *http.Request) string {
+	if s.mountPath == "/" {
+		return r.URL.Path
+	}
+	return path.Join(s.mountPath, r.URL.Path)
+}
+
+// mountPath normalizes a --set-path value the way serve config does.
+func mountPath(p string) string {
+	return path.Clean("/" + p)
+}
+
+// enforceFunnelRule applies a Funnel access rule. Sources that cannot be
+// resolved match no entry and get the rule's default.
+func (s *Server) enforceFunnelRule(w http.ResponseWriter, r *http.Request, source requestSource, rule access.Rule) bool {
+	action, entry, matched := rule.Decide(source.ip, source.geo)
+	reason, entryField := "rule_default", zap.Skip()
+	if matched {
+		reason, entryField = "rule_entry", zap.String("matched_rule_entry", entry.String())
+	}
+	fields := []zap.Field{
+		logging.Component("funnel_rules"),
+		logging.FunnelEnabled(true),
+		zap.String("access_rule", rule.Name),
+		zap.String("source_signal", source.signal),
+		zap.String("source_ip", sourceIPString(source.ip)),
+		geoField(source.geo),
+		entryField,
+		zap.String("method", r.Method),
+		zap.String("path", r.URL.Path),
+	}
+
+	logger := logging.WithFields(r.Context())
+	if action == access.ActionAllow {
+		logger.Info("Funnel request allowed", fields...)
+		s.metrics.ObserveAllowlistDecision(metrics.DecisionAllow, reason)
+		return true
+	}
+
+	logger.Warn("Funnel request denied", append(fields, zap.String("deny_reason", reason))...)
+	s.metrics.ObserveAllowlistDecision(metrics.DecisionDeny, reason)
+	http.Error(w, "Forbidden", http.StatusForbidden)
+	return false
+}
+
+// enforceFunnelDenylist refuses sources matching the Funnel denylist. Sources
+// that cannot be resolved are left to the allowlist.
+func (s *Server) enforceFunnelDenylist(w http.ResponseWriter, r *http.Request, source requestSource) bool {
+	if !s.denylistActive() || !source.resolved {
 		return true
 	}
 
-	sourceIP, sourceSignal, resolved := resolveSourceIP(r, s.preferRemoteIP)
-	if !resolved {
-		s.logger.Warn("Funnel request denied",
+	matchedEntry, denied := access.Match(s.funnelAccess.Load().denylist, source.ip, source.geo)
+	if !denied {
+		return true
+	}
+
+	logging.WithFields(r.Context()).Warn("Funnel request denied",
+		logging.Component("funnel_denylist"),
+		logging.FunnelEnabled(true),
+		zap.String("source_signal", source.signal),
+		zap.String("source_ip", source.ip.String()),
+		geoField(source.geo),
+		zap.String("deny_reason", "source_denylisted"),
+		zap.String("matched_denylist_entry", matchedEntry.String()),
+		zap.String("method", r.Method),
+		zap.String("path", r.URL.Path),
+	)
+	s.metrics.ObserveAllowlistDecision(metrics.DecisionDeny, "source_denylisted")
+	http.Error(w, "Forbidden", http.StatusForbidden)
+	return false
+}
+
+func (s *Server) enforceFunnelAllowlist(w http.ResponseWriter, r *http.Request, source requestSource) bool {
+	if !s.allowlistActive() {
+		return true
+	}
+
+	logger := logging.WithFields(r.Context())
+
+	if !source.resolved {
+		logger.Warn("Funnel request denied",
 			logging.Component("funnel_allowlist"),
 			logging.FunnelEnabled(true),
-			zap.String("source_signal", sourceSignal),
+			zap.String("source_signal", source.signal),
 			zap.String("deny_reason", "source_ip_unresolved"),
 			zap.String("method", r.Method),
 			zap.String("path", r.URL.Path),
 		)
+		s.metrics.ObserveAllowlistDecision(metrics.DecisionDeny, "source_ip_unresolved")
 		http.Error(w, "Forbidden", http.StatusForbidden)
 		return false
 	}
 
-	matchedEntry, allowed := allowlistedEntry(sourceIP, s.funnelAllowlist)
+	matchedEntry, allowed := access.Match(s.funnelAccess.Load().allowlist, source.ip, source.geo)
 	if !allowed {
-		s.logger.Warn("Funnel request denied",
+		logger.Warn("Funnel request denied",
 			logging.Component("funnel_allowlist"),
 			logging.FunnelEnabled(true),
-			zap.String("source_signal", sourceSignal),
-			zap.String("source_ip", sourceIP.String()),
+			zap.String("source_signal", source.signal),
+			zap.String("source_ip", source.ip.String()),
+			geoField(source.geo),
 			zap.String("deny_reason", "source_ip_not_allowlisted"),
 			zap.String("method", r.Method),
 			zap.String("path", r.URL.Path),
 		)
+		s.metrics.ObserveAllowlistDecision(metrics.DecisionDeny, "source_ip_not_allowlisted")
 		http.Error(w, "Forbidden", http.StatusForbidden)
 		return false
 	}
 
-	s.logger.Info("Funnel request allowed",
+	logger.Info("Funnel request allowed",
 		logging.Component("funnel_allowlist"),
 		logging.FunnelEnabled(true),
-		zap.String("source_signal", sourceSignal),
-		zap.String("source_ip", sourceIP.String()),
+		zap.String("source_signal", source.signal),
+		zap.String("source_ip", source.ip.String()),
+		geoField(source.geo),
 		zap.String("matched_allowlist_entry", matchedEntry.String()),
 		zap.String("method", r.Method),
 		zap.String("path", r.URL.Path),
 	)
+	s.metrics.ObserveAllowlistDecision(metrics.DecisionAllow, "source_ip_allowlisted")
 
 	return true
 }
 
+// recordSource adds a completed request to the per-source statistics.
+func (s *Server) recordSource(r *http.Request, source requestSource, statusCode int, decision string) {
+	s.stats.AddSourceRequest(stats.SourceSample{
+		IP:           sourceIPString(source.ip),
+		SourceSignal: source.signal,
+		Path:         r.URL.Path,
+		UserAgent:    r.UserAgent(),
+		StatusCode:   statusCode,
+		Decision:     decision,
+	})
+}
+
 // captureRequest stores the log entry and notifies listeners
 func (s *Server) captureRequest(logEntry model.RequestLog) {
 	// Store log entry
@@ -455,11 +774,37 @@ func (s *Server) GetRequestLogs() []model.RequestLog {
 	return logs
 }
 
+// MetricsHandler serves portal's metrics in Prometheus text format.
+func (s *Server) MetricsHandler() http.Handler {
+	return s.metrics.Handler()
+}
+
 // GetStats returns current statistics (implements model.StatsProvider)
 func (s *Server) GetStats() (ttl, opn int, rt1, rt5, p50, p90 float64) {
 	return s.stats.GetStats()
 }
 
+// GetLatencyWindows returns latency summaries for the 1m, 5m and 15m windows.
+func (s *Server) GetLatencyWindows() []model.LatencyWindow {
+	return s.stats.GetLatencyWindows()
+}
+
+// GetRouteStats returns per-route statistics, busiest first.
+func (s *Server) GetRouteStats() []model.RouteStats {
+	return s.stats.GetRouteStats()
+}
+
+// GetTopTalkers returns per-source statistics, busiest first.
+func (s *Server) GetTopTalkers() []model.Talker {
+	return s.stats.GetTopTalkers()
+}
+
+// GetTimeSeries returns request rate, errors, bytes and latency for the last
+// window, bucketed by step.
+func (s *Server) GetTimeSeries(window, step time.Duration) ([]model.TimeSeriesPoint, error) {
+	return s.series.Query(window, step)
+}
+
 // ClearRequestLogs clears captured request history and resets runtime stats.
 func (s *Server) ClearRequestLogs() {
 	s.logMutex.Lock()
@@ -469,6 +814,24 @@ func (s *Server) ClearRequestLogs() {
 	s.requestLog = nil
 	s.logMutex.Unlock()
 	s.stats.Reset()
+	s.series.Reset()
+}
+
+// ConnState tracks connections on a listener serving this proxy. Assign it to
+// http.Server.ConnState.
+func (s *Server) ConnState(conn net.Conn, state http.ConnState) {
+	s.conns.ConnState(conn, state)
+}
+
+// ObserveConn tracks a connection whose client address is known separately
+// from its remote address, such as a Funnel connection relayed by tsnet.
+func (s *Server) ObserveConn(conn net.Conn, peer string, state http.ConnState) {
+	s.conns.Observe(conn, peer, state)
+}
+
+// GetConnectionStats returns open connection counts, ages and peers.
+func (s *Server) GetConnectionStats() model.ConnectionStats {
+	return s.conns.Snapshot()
 }
 
 // SendTUIMessage sends a message to the TUI if available (implements model.TUIMessageSender)
@@ -2,15 +2,34 @@ package proxy
 
 import (
 	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"errors"
 	"io"
+	"mime/multipart"
 	"net/http"
 	"net/http/httptest"
 	"net/netip"
+	"net/url"
 	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
 	"testing"
 
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
 	"go.uber.org/zap"
 
+	"github.com/jaxxstorm/portal/internal/access"
+	"github.com/jaxxstorm/portal/internal/blocklist"
+	"github.com/jaxxstorm/portal/internal/geoip"
+	"github.com/jaxxstorm/portal/internal/geoip/geoiptest"
 	"github.com/jaxxstorm/portal/internal/model"
 )
 
@@ -20,7 +39,7 @@ func TestServeHTTPTailnetModeIgnoresFunnelAllowlist(t *testing.T) {
 		UseTUI:          true,
 		Logger:          zap.NewNop(),
 		FunnelEnabled:   false,
-		FunnelAllowlist: mustPrefixes(t, "203.0.113.0/24"),
+		FunnelAllowlist: mustEntries(t, "203.0.113.0/24"),
 	})
 
 	req := httptest.NewRequest(http.MethodGet, "/", nil)
@@ -40,7 +59,7 @@ func TestServeHTTPFunnelModeAllowsAllowlistedForwardedSource(t *testing.T) {
 		UseTUI:          true,
 		Logger:          zap.NewNop(),
 		FunnelEnabled:   true,
-		FunnelAllowlist: mustPrefixes(t, "203.0.113.0/24"),
+		FunnelAllowlist: mustEntries(t, "203.0.113.0/24"),
 	})
 
 	req := httptest.NewRequest(http.MethodGet, "/", nil)
@@ -61,7 +80,7 @@ func TestServeHTTPFunnelModeAllowsAllowlistedRemoteAddrFallback(t *testing.T) {
 		UseTUI:          true,
 		Logger:          zap.NewNop(),
 		FunnelEnabled:   true,
-		FunnelAllowlist: mustPrefixes(t, "198.51.100.12/32"),
+		FunnelAllowlist: mustEntries(t, "198.51.100.12/32"),
 	})
 
 	req := httptest.NewRequest(http.MethodGet, "/", nil)
@@ -81,7 +100,7 @@ func TestServeHTTPFunnelModeDeniesNonAllowlistedSource(t *testing.T) {
 		UseTUI:          true,
 		Logger:          zap.NewNop(),
 		FunnelEnabled:   true,
-		FunnelAllowlist: mustPrefixes(t, "203.0.113.0/24"),
+		FunnelAllowlist: mustEntries(t, "203.0.113.0/24"),
 	})
 
 	req := httptest.NewRequest(http.MethodGet, "/", nil)
@@ -102,7 +121,7 @@ func TestServeHTTPFunnelModeDeniesUnresolvedSource(t *testing.T) {
 		UseTUI:          true,
 		Logger:          zap.NewNop(),
 		FunnelEnabled:   true,
-		FunnelAllowlist: mustPrefixes(t, "203.0.113.0/24"),
+		FunnelAllowlist: mustEntries(t, "203.0.113.0/24"),
 	})
 
 	req := httptest.NewRequest(http.MethodGet, "/", nil)
@@ -122,7 +141,7 @@ func TestResolveSourceIPPrioritizesTailscaleClientIP(t *testing.T) {
 	req.Header.Set("X-Forwarded-For", "203.0.113.25")
 	req.Header.Set("Tailscale-Client-IP", "192.0.2.4")
 
-	addr, signal, ok := resolveSourceIP(req, false)
+	addr, signal, ok := resolveSourceIP(req, SourceModeHeaders)
 
 	if !ok {
 		t.Fatalf("expected source IP resolution to succeed")
@@ -135,13 +154,13 @@ func TestResolveSourceIPPrioritizesTailscaleClientIP(t *testing.T) {
 	}
 }
 
-func TestResolveSourceIPPrefersRemoteAddrWhenConfigured(t *testing.T) {
+func TestResolveSourceIPUsesRemoteAddrWithProxyProtocol(t *testing.T) {
 	req := httptest.NewRequest(http.MethodGet, "/", nil)
 	req.RemoteAddr = "198.51.100.10:12345"
 	req.Header.Set("X-Forwarded-For", "203.0.113.25")
 	req.Header.Set("Tailscale-Client-IP", "192.0.2.4")
 
-	addr, signal, ok := resolveSourceIP(req, true)
+	addr, signal, ok := resolveSourceIP(req, SourceModeProxyProtocol)
 
 	if !ok {
 		t.Fatalf("expected source IP resolution to succeed")
@@ -154,14 +173,14 @@ func TestResolveSourceIPPrefersRemoteAddrWhenConfigured(t *testing.T) {
 	}
 }
 
-func TestServeHTTPFunnelModePrefersRemoteAddrWhenConfigured(t *testing.T) {
+func TestServeHTTPFunnelModeUsesRemoteAddrWithProxyProtocol(t *testing.T) {
 	server := NewServer(Config{
 		Mode:            model.ModeMock,
 		UseTUI:          true,
 		Logger:          zap.NewNop(),
 		FunnelEnabled:   true,
-		FunnelAllowlist: mustPrefixes(t, "198.51.100.0/24"),
-		PreferRemoteIP:  true,
+		FunnelAllowlist: mustEntries(t, "198.51.100.0/24"),
+		SourceMode:      SourceModeProxyProtocol,
 	})
 
 	req := httptest.NewRequest(http.MethodGet, "/", nil)
@@ -176,6 +195,101 @@ func TestServeHTTPFunnelModePrefersRemoteAddrWhenConfigured(t *testing.T) {
 	}
 }
 
+func TestResolveSourceIPTrustsOnlyTailscaleServeForwardedFor(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "/hooks", nil)
+	req.RemoteAddr = "127.0.0.1:41000"
+	req.Header.Set("Tailscale-Client-IP", "192.0.2.4")
+	req.Header.Set("X-Real-IP", "192.0.2.5")
+	req.Header.Add("X-Forwarded-For", "192.0.2.6, 203.0.113.25")
+
+	addr, signal, ok := resolveSourceIP(req, SourceModeServe)
+	if !ok || signal != sourceSignalTailscaleServe || addr.String() != "203.0.113.25" {
+		t.Fatalf("expected tailscaled forwarded address, got %s %q %v", addr, signal, ok)
+	}
+
+	req.Header.Del("X-Forwarded-For")
+	if _, signal, ok := resolveSourceIP(req, SourceModeServe); ok || signal != sourceSignalUnresolved {
+		t.Fatalf("expected loopback request without X-Forwarded-For to be unresolved, got %q %v", signal, ok)
+	}
+
+	req.RemoteAddr = "198.51.100.10:5000"
+	req.Header.Set("X-Forwarded-For", "203.0.113.25")
+	addr, signal, ok = resolveSourceIP(req, SourceModeServe)
+	if !ok || signal != sourceSignalRemoteAddr || addr.String() != "198.51.100.10" {
+		t.Fatalf("expected direct connection to resolve to its own address, got %s %q %v", addr, signal, ok)
+	}
+}
+
+func TestResolveSourceIPTSNetIgnoresForwardingHeaders(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.RemoteAddr = "100.64.0.7:5000"
+	req.Header.Set("X-Forwarded-For", "203.0.113.25")
+
+	addr, signal, ok := resolveSourceIP(req, SourceModeTSNet)
+	if !ok || signal != sourceSignalRemoteAddr || addr.String() != "100.64.0.7" {
+		t.Fatalf("expected connection address, got %s %q %v", addr, signal, ok)
+	}
+
+	req.Header.Set("Tailscale-Client-IP", "198.51.100.4")
+	addr, signal, ok = resolveSourceIP(req, SourceModeTSNet)
+	if !ok || signal != sourceSignalTailscaleClientIP || addr.String() != "198.51.100.4" {
+		t.Fatalf("expected tsnet Funnel source, got %s %q %v", addr, signal, ok)
+	}
+}
+
+func TestServeHTTPMountedPathAllowlistRejectsSpoofedHeaders(t *testing.T) {
+	server := NewServer(Config{
+		Mode:            model.ModeMock,
+		UseTUI:          true,
+		Logger:          zap.NewNop(),
+		FunnelEnabled:   true,
+		FunnelAllowlist: mustEntries(t, "198.51.100.0/24"),
+		SourceMode:      SourceModeServe,
+	})
+
+	spoofed := httptest.NewRequest(http.MethodPost, "/hooks", nil)
+	spoofed.RemoteAddr = "127.0.0.1:41000"
+	spoofed.Header.Set("Tailscale-Client-IP", "198.51.100.10")
+	spoofed.Header.Set("X-Forwarded-For", "203.0.113.9")
+	spoofed.Header.Set(tailscaleFunnelRequestHeader, "?1")
+	rr := httptest.NewRecorder()
+	server.ServeHTTP(rr, spoofed)
+	if rr.Code != http.StatusForbidden {
+		t.Fatalf("expected spoofed Tailscale-Client-IP to be ignored, got status %d", rr.Code)
+	}
+
+	allowed := httptest.NewRequest(http.MethodPost, "/hooks", nil)
+	allowed.RemoteAddr = "127.0.0.1:41000"
+	allowed.Header.Set("X-Forwarded-For", "198.51.100.10")
+	allowed.Header.Set(tailscaleFunnelRequestHeader, "?1")
+	rr = httptest.NewRecorder()
+	server.ServeHTTP(rr, allowed)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected allowlisted serve source, got status %d", rr.Code)
+	}
+}
+
+func TestServeHTTPProxyProtocolIgnoresClientIdentityHeaders(t *testing.T) {
+	server := NewServer(Config{
+		Mode:            model.ModeMock,
+		UseTUI:          true,
+		Logger:          zap.NewNop(),
+		FunnelEnabled:   true,
+		FunnelAllowlist: mustEntries(t, "198.51.100.0/24"),
+		SourceMode:      SourceModeProxyProtocol,
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.RemoteAddr = "203.0.113.9:12345"
+	req.Header.Set(tailscaleUserLoginHeader, "alice@example.com")
+	rr := httptest.NewRecorder()
+	server.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusForbidden {
+		t.Fatalf("expected forged tailnet identity to be ignored, got status %d", rr.Code)
+	}
+}
+
 func TestServeHTTPNonTUIModeDoesNotWriteLegacyConsoleOutput(t *testing.T) {
 	server := NewServer(Config{
 		Mode:          model.ModeMock,
@@ -261,16 +375,1060 @@ func TestEndpointStateDefaultsAndFailureTransition(t *testing.T) {
 	}
 }
 
-func mustPrefixes(t *testing.T, values ...string) []netip.Prefix {
+func TestEndpointListenerSeesTransitions(t *testing.T) {
+	server := NewServer(Config{Mode: model.ModeMock, UseTUI: true, Logger: zap.NewNop()})
+
+	var seen []string
+	server.AddEndpointListener(func(state model.EndpointState) {
+		seen = append(seen, state.Readiness)
+	})
+
+	server.SetEndpointState(model.EndpointState{Readiness: model.EndpointReadinessReady, ServiceURL: "https://node.example.ts.net/"})
+	server.MarkEndpointFailure("serve setup failed")
+
+	want := []string{model.EndpointReadinessReady, model.EndpointReadinessFailed}
+	if !slices.Equal(seen, want) {
+		t.Fatalf("expected listener to see %v, got %v", want, seen)
+	}
+}
+
+func mustEntries(t *testing.T, values ...string) []access.Entry {
 	t.Helper()
 
-	prefixes := make([]netip.Prefix, 0, len(values))
+	entries := make([]access.Entry, 0, len(values))
 	for _, value := range values {
-		prefix, err := netip.ParsePrefix(value)
+		entry, err := access.ParseEntry(value)
+		if err != nil {
+			t.Fatalf("failed to parse access entry %q: %v", value, err)
+		}
+		entries = append(entries, entry)
+	}
+	return entries
+}
+
+func TestServeHTTPDecodesCompressedResponseForCaptureOnly(t *testing.T) {
+	var compressed bytes.Buffer
+	gz := gzip.NewWriter(&compressed)
+	if _, err := gz.Write([]byte(`{"hello":"world"}`)); err != nil {
+		t.Fatalf("gzip write: %v", err)
+	}
+	if err := gz.Close(); err != nil {
+		t.Fatalf("gzip close: %v", err)
+	}
+
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Content-Encoding", "gzip")
+		w.Write(compressed.Bytes())
+	}))
+	defer upstream.Close()
+
+	server := NewServer(Config{
+		TargetPort: mustPort(t, upstream.URL),
+		Mode:       model.ModeProxy,
+		UseTUI:     true,
+		Logger:     zap.NewNop(),
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	rr := httptest.NewRecorder()
+
+	server.ServeHTTP(rr, req)
+
+	if !bytes.Equal(rr.Body.Bytes(), compressed.Bytes()) {
+		t.Fatalf("expected compressed bytes to reach the client unchanged")
+	}
+
+	logs := server.GetRequestLogs()
+	if len(logs) != 1 {
+		t.Fatalf("expected one captured request, got %d", len(logs))
+	}
+	resp := logs[0].Response
+	if resp.Body != `{"hello":"world"}` {
+		t.Fatalf("expected decoded response preview, got %q", resp.Body)
+	}
+	if resp.ContentEncoding != "gzip" {
+		t.Fatalf("unexpected content encoding: %q", resp.ContentEncoding)
+	}
+	if got, want := resp.EncodedSize, int64(compressed.Len()); got != want {
+		t.Fatalf("unexpected encoded size: got %d want %d", got, want)
+	}
+	if got, want := resp.DecodedSize, int64(len(`{"hello":"world"}`)); got != want {
+		t.Fatalf("unexpected decoded size: got %d want %d", got, want)
+	}
+}
+
+func TestServeHTTPBuildsBodyViewFromDecodedRequestBytes(t *testing.T) {
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	file, err := writer.CreateFormFile("payload", "payload.bin")
+	if err != nil {
+		t.Fatalf("create form file: %v", err)
+	}
+	file.Write([]byte{0xff, 0xfe, 0x00, 0x80})
+	writer.Close()
+
+	var compressed bytes.Buffer
+	gz := gzip.NewWriter(&compressed)
+	gz.Write(body.Bytes())
+	gz.Close()
+
+	server := NewServer(Config{Mode: model.ModeMock, UseTUI: true, Logger: zap.NewNop()})
+	req := httptest.NewRequest(http.MethodPost, "/upload", bytes.NewReader(compressed.Bytes()))
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	req.Header.Set("Content-Encoding", "gzip")
+	server.ServeHTTP(httptest.NewRecorder(), req)
+
+	view := server.GetRequestLogs()[0].BodyView
+	if view == nil || len(view.Parts) != 1 || view.Parts[0].Size != 4 {
+		t.Fatalf("expected the binary part's real size, got %+v", view)
+	}
+}
+
+func TestDecodeContentEncodingSupportedCodings(t *testing.T) {
+	payload := []byte("portal inspection payload")
+
+	encoders := map[string]func(io.Writer) io.WriteCloser{
+		"gzip": func(w io.Writer) io.WriteCloser { return gzip.NewWriter(w) },
+		"deflate": func(w io.Writer) io.WriteCloser {
+			return zlib.NewWriter(w)
+		},
+		"br": func(w io.Writer) io.WriteCloser { return brotli.NewWriter(w) },
+		"zstd": func(w io.Writer) io.WriteCloser {
+			enc, err := zstd.NewWriter(w)
+			if err != nil {
+				t.Fatalf("zstd writer: %v", err)
+			}
+			return enc
+		},
+	}
+
+	for coding, newWriter := range encoders {
+		t.Run(coding, func(t *testing.T) {
+			var buf bytes.Buffer
+			w := newWriter(&buf)
+			if _, err := w.Write(payload); err != nil {
+				t.Fatalf("write: %v", err)
+			}
+			if err := w.Close(); err != nil {
+				t.Fatalf("close: %v", err)
+			}
+
+			decoded, err := decodeContentEncoding(coding, buf.Bytes(), maxDecodedBodyBytes)
+			if err != nil {
+				t.Fatalf("decode: %v", err)
+			}
+			if !bytes.Equal(decoded.data, payload) {
+				t.Fatalf("unexpected decoded payload: %q", decoded.data)
+			}
+			if decoded.truncated {
+				t.Fatalf("expected complete decode")
+			}
+		})
+	}
+}
+
+func TestDecodeContentEncodingRejectsUnknownCoding(t *testing.T) {
+	if _, err := decodeContentEncoding("compress", []byte("data"), maxDecodedBodyBytes); err == nil {
+		t.Fatalf("expected unsupported coding error")
+	}
+}
+
+func TestDecodeContentEncodingStopsAtLimit(t *testing.T) {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	if _, err := gz.Write(bytes.Repeat([]byte("a"), 4*maxResponseBodyPreviewBytes)); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	if err := gz.Close(); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+
+	decoded, err := decodeContentEncoding("gzip", buf.Bytes(), maxResponseBodyPreviewBytes)
+	if err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if len(decoded.data) != maxResponseBodyPreviewBytes || !decoded.truncated {
+		t.Fatalf("expected %d truncated bytes, got %d (truncated=%v)", maxResponseBodyPreviewBytes, len(decoded.data), decoded.truncated)
+	}
+}
+
+func mustPort(t *testing.T, rawURL string) int {
+	t.Helper()
+
+	parsed, err := url.Parse(rawURL)
+	if err != nil {
+		t.Fatalf("failed to parse URL %q: %v", rawURL, err)
+	}
+	port, err := strconv.Atoi(parsed.Port())
+	if err != nil {
+		t.Fatalf("failed to parse port from %q: %v", rawURL, err)
+	}
+	return port
+}
+
+func TestBuildBodyViewJSONAndXML(t *testing.T) {
+	view := buildBodyView("application/json; charset=utf-8", []byte(`{"a":1,"b":[true]}`))
+	if view == nil || view.Kind != model.BodyKindJSON {
+		t.Fatalf("expected json body view, got %+v", view)
+	}
+	if want := "{\n  \"a\": 1,\n  \"b\": [\n    true\n  ]\n}"; view.Pretty != want {
+		t.Fatalf("unexpected pretty json: %q", view.Pretty)
+	}
+
+	view = buildBodyView("application/xml", []byte(`<root><item id="1">x</item></root>`))
+	if view == nil || view.Kind != model.BodyKindXML {
+		t.Fatalf("expected xml body view, got %+v", view)
+	}
+	if want := "<root>\n  <item id=\"1\">x</item>\n</root>"; view.Pretty != want {
+		t.Fatalf("unexpected pretty xml: %q", view.Pretty)
+	}
+
+	view = buildBodyView("application/json", []byte(`{"broken"`))
+	if view == nil || view.Error == "" {
+		t.Fatalf("expected parse error for invalid json, got %+v", view)
+	}
+
+	if view := buildBodyView("application/octet-stream", []byte("raw")); view != nil {
+		t.Fatalf("expected no view for unknown content type, got %+v", view)
+	}
+}
+
+func TestBuildBodyViewFormAndMultipart(t *testing.T) {
+	view := buildBodyView("application/x-www-form-urlencoded", []byte("b=2&a=hello+world&a=again"))
+	if view == nil || view.Kind != model.BodyKindForm {
+		t.Fatalf("expected form body view, got %+v", view)
+	}
+	wantFields := []model.BodyField{
+		{Name: "a", Value: "hello world"},
+		{Name: "a", Value: "again"},
+		{Name: "b", Value: "2"},
+	}
+	if len(view.Fields) != len(wantFields) {
+		t.Fatalf("unexpected form fields: %+v", view.Fields)
+	}
+	for i, field := range wantFields {
+		if view.Fields[i] != field {
+			t.Fatalf("unexpected form field %d: got %+v want %+v", i, view.Fields[i], field)
+		}
+	}
+
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	if err := writer.WriteField("event", "push"); err != nil {
+		t.Fatalf("write field: %v", err)
+	}
+	file, err := writer.CreateFormFile("payload", "payload.bin")
+	if err != nil {
+		t.Fatalf("create form file: %v", err)
+	}
+	file.Write([]byte{0x00, 0x01, 0x02})
+	writer.Close()
+
+	view = buildBodyView(writer.FormDataContentType(), body.Bytes())
+	if view == nil || view.Kind != model.BodyKindMultipart {
+		t.Fatalf("expected multipart body view, got %+v", view)
+	}
+	if len(view.Parts) != 2 {
+		t.Fatalf("expected two parts, got %+v", view.Parts)
+	}
+	if got := view.Parts[0]; got.Name != "event" || got.Preview != "push" || got.Size != 4 {
+		t.Fatalf("unexpected field part: %+v", got)
+	}
+	if got := view.Parts[1]; got.Name != "payload" || got.Filename != "payload.bin" || got.Size != 3 || got.Preview != "" {
+		t.Fatalf("unexpected file part: %+v", got)
+	}
+	if got := view.Parts[1].Headers["Content-Type"]; got != "application/octet-stream" {
+		t.Fatalf("unexpected file part content type header: %q", got)
+	}
+}
+
+func TestDetectGraphQLPostJSON(t *testing.T) {
+	body := []byte(`{
+		"query": "# fetch a user\nquery GetUser($id: ID!) { user(id: $id) { name } }\nmutation Rename { rename { ok } }",
+		"operationName": "GetUser",
+		"variables": {"id": "42"}
+	}`)
+	req := httptest.NewRequest(http.MethodPost, "/graphql", bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+
+	op := detectGraphQL(req, body)
+	if op == nil {
+		t.Fatalf("expected GraphQL operation to be detected")
+	}
+	if got, want := op.Label(), "query GetUser"; got != want {
+		t.Fatalf("unexpected label: got %q want %q", got, want)
+	}
+	if got, want := string(op.Variables), `{"id": "42"}`; got != want {
+		t.Fatalf("unexpected variables: got %q want %q", got, want)
+	}
+}
+
+func TestDetectGraphQLGetAndShorthand(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "/graphql?query="+url.QueryEscape(`mutation { like(id: "1") { ok } }`), nil)
+	op := detectGraphQL(req, nil)
+	if op == nil || op.OperationType != "mutation" || op.OperationName != "" {
+		t.Fatalf("unexpected GET operation: %+v", op)
+	}
+
+	body := []byte(`{"query": "{ viewer { login } }"}`)
+	req = httptest.NewRequest(http.MethodPost, "/graphql", bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	op = detectGraphQL(req, body)
+	if op == nil || op.Label() != "query" {
+		t.Fatalf("expected anonymous shorthand query, got %+v", op)
+	}
+
+	body = []byte(`{"query": "search term"}`)
+	req = httptest.NewRequest(http.MethodPost, "/search", bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	if op := detectGraphQL(req, body); op != nil {
+		t.Fatalf("expected non-GraphQL JSON to be ignored, got %+v", op)
+	}
+}
+
+func TestServeHTTPCapturesGraphQLErrors(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"data":null,"errors":[{"message":"user not found"}]}`))
+	}))
+	defer upstream.Close()
+
+	server := NewServer(Config{
+		TargetPort: mustPort(t, upstream.URL),
+		Mode:       model.ModeProxy,
+		UseTUI:     true,
+		Logger:     zap.NewNop(),
+	})
+
+	body := `{"query":"query GetUser { user { id } }"}`
+	req := httptest.NewRequest(http.MethodPost, "/graphql", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	server.ServeHTTP(httptest.NewRecorder(), req)
+
+	logs := server.GetRequestLogs()
+	if len(logs) != 1 || logs[0].GraphQL == nil {
+		t.Fatalf("expected GraphQL capture, got %+v", logs)
+	}
+	if got := logs[0].GraphQL.Errors; len(got) != 1 || got[0] != "user not found" {
+		t.Fatalf("unexpected GraphQL errors: %q", got)
+	}
+}
+
+func TestServeHTTPPropagatesRequestIDAndTraceContext(t *testing.T) {
+	var upstreamRequestID, upstreamTraceparent string
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upstreamRequestID = r.Header.Get("X-Correlation-ID")
+		upstreamTraceparent = r.Header.Get("traceparent")
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer upstream.Close()
+
+	server := NewServer(Config{
+		TargetPort:      mustPort(t, upstream.URL),
+		Mode:            model.ModeProxy,
+		UseTUI:          true,
+		Logger:          zap.NewNop(),
+		RequestIDHeader: "x-correlation-id",
+	})
+
+	t.Run("generates new identifiers", func(t *testing.T) {
+		rr := httptest.NewRecorder()
+		server.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/", nil))
+
+		logs := server.GetRequestLogs()
+		capture := logs[len(logs)-1]
+		if upstreamRequestID == "" || upstreamRequestID != capture.RequestID {
+			t.Fatalf("expected generated request ID %q upstream, got %q", capture.RequestID, upstreamRequestID)
+		}
+		if got := rr.Header().Get("X-Correlation-ID"); got != capture.RequestID {
+			t.Fatalf("expected request ID to be echoed, got %q", got)
+		}
+		traceID, _, ok := parseTraceparent(upstreamTraceparent)
+		if !ok {
+			t.Fatalf("expected valid traceparent upstream, got %q", upstreamTraceparent)
+		}
+		if traceID != capture.TraceID {
+			t.Fatalf("expected captured trace ID %q, got %q", traceID, capture.TraceID)
+		}
+	})
+
+	t.Run("continues incoming identifiers", func(t *testing.T) {
+		const incomingTrace = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set("X-Correlation-ID", "abc-123")
+		req.Header.Set("traceparent", incomingTrace)
+		rr := httptest.NewRecorder()
+		server.ServeHTTP(rr, req)
+
+		if upstreamRequestID != "abc-123" {
+			t.Fatalf("expected incoming request ID upstream, got %q", upstreamRequestID)
+		}
+		if got := rr.Header().Get("X-Correlation-ID"); got != "abc-123" {
+			t.Fatalf("expected incoming request ID to be echoed, got %q", got)
+		}
+		if !strings.HasPrefix(upstreamTraceparent, "00-4bf92f3577b34da6a3ce929d0e0e4736-") || upstreamTraceparent == incomingTrace {
+			t.Fatalf("expected trace to continue with a new parent ID, got %q", upstreamTraceparent)
+		}
+	})
+}
+
+func TestParseTraceparentRejectsInvalidValues(t *testing.T) {
+	for _, value := range []string{
+		"",
+		"00-00000000000000000000000000000000-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01",
+		"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		"00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra",
+	} {
+		if _, _, ok := parseTraceparent(value); ok {
+			t.Fatalf("expected traceparent %q to be rejected", value)
+		}
+	}
+}
+
+func TestServeHTTPEmitsRequestAndUpstreamSpans(t *testing.T) {
+	var upstreamTraceparent string
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upstreamTraceparent = r.Header.Get("traceparent")
+		_, _ = w.Write([]byte("hello"))
+	}))
+	defer upstream.Close()
+
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	server := NewServer(Config{
+		TargetPort:    mustPort(t, upstream.URL),
+		Mode:          model.ModeProxy,
+		UseTUI:        true,
+		Logger:        zap.NewNop(),
+		FunnelEnabled: true,
+		Tracer:        provider.Tracer("test"),
+	})
+
+	const incomingTrace = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+	req := httptest.NewRequest(http.MethodPost, "/orders/42", strings.NewReader("{}"))
+	req.Header.Set("traceparent", incomingTrace)
+	req.Header.Set("X-Forwarded-For", "203.0.113.7")
+	server.ServeHTTP(httptest.NewRecorder(), req)
+
+	spans := recorder.Ended()
+	if len(spans) != 2 {
+		t.Fatalf("expected request and upstream spans, got %d", len(spans))
+	}
+	upstreamSpan, requestSpan := spans[0], spans[1]
+
+	if requestSpan.Name() != "POST /orders/{id}" || requestSpan.SpanKind() != trace.SpanKindServer {
+		t.Fatalf("unexpected request span %q (%s)", requestSpan.Name(), requestSpan.SpanKind())
+	}
+	if got := requestSpan.Parent().SpanID().String(); got != "00f067aa0ba902b7" {
+		t.Fatalf("expected request span to continue incoming parent, got %q", got)
+	}
+	attrs := make(map[attribute.Key]attribute.Value)
+	for _, kv := range requestSpan.Attributes() {
+		attrs[kv.Key] = kv.Value
+	}
+	for key, want := range map[attribute.Key]string{
+		"http.request.method":       "POST",
+		"http.route":                "/orders/{id}",
+		"url.path":                  "/orders/42",
+		"client.address":            "203.0.113.7",
+		"portal.exposure":           "funnel",
+		"http.response.status_code": "200",
+		"http.request.body.size":    "2",
+		"http.response.body.size":   "5",
+	} {
+		if got := attrs[key].Emit(); got != want {
+			t.Fatalf("expected %s=%q, got %q", key, want, got)
+		}
+	}
+
+	if upstreamSpan.Parent().SpanID() != requestSpan.SpanContext().SpanID() || upstreamSpan.SpanKind() != trace.SpanKindClient {
+		t.Fatalf("expected client upstream span under request span, got %+v", upstreamSpan.Parent())
+	}
+	want := "00-4bf92f3577b34da6a3ce929d0e0e4736-" + upstreamSpan.SpanContext().SpanID().String() + "-01"
+	if upstreamTraceparent != want {
+		t.Fatalf("expected backend traceparent %q, got %q", want, upstreamTraceparent)
+	}
+
+	logs := server.GetRequestLogs()
+	if logs[0].TraceID != "4bf92f3577b34da6a3ce929d0e0e4736" {
+		t.Fatalf("expected capture to record trace ID, got %q", logs[0].TraceID)
+	}
+}
+
+func TestRequestExposurePrefersTailscaleMarkers(t *testing.T) {
+	funnel := NewServer(Config{Mode: model.ModeMock, Logger: zap.NewNop(), FunnelEnabled: true})
+	tailnet := NewServer(Config{Mode: model.ModeMock, Logger: zap.NewNop()})
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	if got := funnel.requestExposure(req); got != exposureFunnel {
+		t.Fatalf("expected funnel exposure by default, got %q", got)
+	}
+	if got := tailnet.requestExposure(req); got != exposureTailnet {
+		t.Fatalf("expected tailnet exposure by default, got %q", got)
+	}
+
+	req.Header.Set("Tailscale-User-Login", "alice@example.com")
+	if got := funnel.requestExposure(req); got != exposureTailnet {
+		t.Fatalf("expected identified caller to be tailnet, got %q", got)
+	}
+
+	req.Header.Set("Tailscale-Funnel-Request", "?1")
+	if got := tailnet.requestExposure(req); got != exposureFunnel {
+		t.Fatalf("expected funnel marker to win, got %q", got)
+	}
+}
+
+func TestMetricsHandlerReportsProxiedTraffic(t *testing.T) {
+	server := NewServer(Config{
+		Mode:            model.ModeMock,
+		UseTUI:          true,
+		Logger:          zap.NewNop(),
+		FunnelEnabled:   true,
+		FunnelAllowlist: mustEntries(t, "203.0.113.0/24"),
+		InitialEndpoint: model.EndpointState{Readiness: model.EndpointReadinessReady},
+	})
+
+	for _, ip := range []string{"203.0.113.7", "198.51.100.1"} {
+		req := httptest.NewRequest(http.MethodGet, "/users/42", nil)
+		req.Header.Set("X-Forwarded-For", ip)
+		server.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	rr := httptest.NewRecorder()
+	server.MetricsHandler().ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	body := rr.Body.String()
+	for _, want := range []string{
+		`portal_requests_total{method="GET",route="/users/{id}",status_class="2xx"} 1`,
+		`portal_requests_total{method="GET",route="/users/{id}",status_class="4xx"} 1`,
+		`portal_funnel_allowlist_decisions_total{decision="allow",reason="source_ip_allowlisted"} 1`,
+		`portal_funnel_allowlist_decisions_total{decision="deny",reason="source_ip_not_allowlisted"} 1`,
+		`portal_requests_in_flight 0`,
+		`portal_endpoint_readiness{exposure="",mode="",state="ready"} 1`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Fatalf("expected metrics to contain %q, got:\n%s", want, body)
+		}
+	}
+}
+
+func TestServeHTTPRecordsRouteStats(t *testing.T) {
+	server := NewServer(Config{
+		Mode:   model.ModeMock,
+		UseTUI: true,
+		Logger: zap.NewNop(),
+		Routes: []string{"/accounts/{account}"},
+	})
+
+	for _, path := range []string{"/users/1", "/users/2", "/accounts/acme"} {
+		server.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, path, nil))
+	}
+
+	routes := server.GetRouteStats()
+	if len(routes) != 2 {
+		t.Fatalf("expected 2 routes, got %+v", routes)
+	}
+	if routes[0].Route != "/users/{id}" || routes[0].Count != 2 {
+		t.Fatalf("expected templated users route first, got %+v", routes[0])
+	}
+	if routes[1].Route != "/accounts/{account}" || routes[1].Count != 1 {
+		t.Fatalf("expected configured accounts route, got %+v", routes[1])
+	}
+}
+
+func TestConnStateCountsKeepAliveConnectionsSeparatelyFromRequests(t *testing.T) {
+	server := NewServer(Config{
+		Mode:   model.ModeMock,
+		UseTUI: true,
+		Logger: zap.NewNop(),
+	})
+
+	backend := httptest.NewUnstartedServer(server)
+	backend.Config.ConnState = server.ConnState
+	backend.Start()
+	defer backend.Close()
+
+	client := backend.Client()
+	for i := 0; i < 3; i++ {
+		resp, err := client.Get(backend.URL + "/ping")
 		if err != nil {
-			t.Fatalf("failed to parse prefix %q: %v", value, err)
+			t.Fatalf("request %d: %v", i, err)
+		}
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+	}
+
+	conns := server.GetConnectionStats()
+	if conns.Accepted != 1 || conns.Requests != 3 || conns.Open != 1 {
+		t.Fatalf("expected one reused connection carrying 3 requests, got %+v", conns)
+	}
+	if len(conns.Peers) != 1 || conns.Peers[0].Address != "127.0.0.1" {
+		t.Fatalf("expected loopback peer, got %+v", conns.Peers)
+	}
+	if ttl, _, _, _, _, _ := server.GetStats(); ttl != 3 {
+		t.Fatalf("expected 3 requests in request stats, got %d", ttl)
+	}
+}
+
+func TestServeHTTPRecordsTopTalkersWithAllowlistDecisions(t *testing.T) {
+	server := NewServer(Config{
+		Mode:            model.ModeMock,
+		UseTUI:          true,
+		Logger:          zap.NewNop(),
+		FunnelEnabled:   true,
+		FunnelAllowlist: mustEntries(t, "203.0.113.0/24"),
+	})
+
+	for _, request := range []struct{ ip, path string }{
+		{"198.51.100.4", "/.env"},
+		{"198.51.100.4", "/.git/config"},
+		{"203.0.113.7", "/"},
+	} {
+		req := httptest.NewRequest(http.MethodGet, request.path, nil)
+		req.Header.Set("Tailscale-Client-IP", request.ip)
+		req.Header.Set("User-Agent", "scanner/1.0")
+		server.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	talkers := server.GetTopTalkers()
+	if len(talkers) != 2 {
+		t.Fatalf("expected 2 talkers, got %+v", talkers)
+	}
+	denied := talkers[0]
+	if denied.IP != "198.51.100.4" || denied.Requests != 2 || denied.Denied != 2 || denied.ErrorRate != 100 {
+		t.Fatalf("unexpected denied talker: %+v", denied)
+	}
+	if denied.SourceSignal != sourceSignalTailscaleClientIP || denied.UserAgents[0].Value != "scanner/1.0" {
+		t.Fatalf("expected source signal and user agent, got %+v", denied)
+	}
+	if talkers[1].IP != "203.0.113.7" || talkers[1].Allowed != 1 || talkers[1].Denied != 0 {
+		t.Fatalf("unexpected allowed talker: %+v", talkers[1])
+	}
+}
+
+func TestServeHTTPRefusesBlockedSource(t *testing.T) {
+	server := NewServer(Config{
+		Mode:   model.ModeMock,
+		UseTUI: true,
+		Logger: zap.NewNop(),
+	})
+	if _, added, err := server.BlockSource(netip.MustParsePrefix("198.51.100.0/24"), "scraping"); err != nil || !added {
+		t.Fatalf("block source: added=%v err=%v", added, err)
+	}
+
+	for _, request := range []struct {
+		ip   string
+		want int
+	}{
+		{"198.51.100.4", http.StatusForbidden},
+		{"203.0.113.7", http.StatusOK},
+	} {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.RemoteAddr = request.ip + ":40000"
+		rr := httptest.NewRecorder()
+		server.ServeHTTP(rr, req)
+		if rr.Code != request.want {
+			t.Fatalf("expected status %d for %s, got %d", request.want, request.ip, rr.Code)
+		}
+	}
+
+	if removed, err := server.UnblockSource(netip.MustParsePrefix("198.51.100.0/24")); err != nil || !removed {
+		t.Fatalf("unblock source: removed=%v err=%v", removed, err)
+	}
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.RemoteAddr = "198.51.100.4:40000"
+	rr := httptest.NewRecorder()
+	server.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected unblocked source to be served, got status %d", rr.Code)
+	}
+}
+
+func TestServeHTTPAutoBansScannerPathOverFunnel(t *testing.T) {
+	server := NewServer(Config{
+		Mode:          model.ModeMock,
+		UseTUI:        true,
+		Logger:        zap.NewNop(),
+		FunnelEnabled: true,
+		SourceMode:    SourceModeTSNet,
+		AutoBan:       blocklist.NewAutoBan(blocklist.AutoBanConfig{ScannerPaths: blocklist.DefaultScannerPaths}),
+	})
+
+	for _, path := range []string{"/app/.env", "/"} {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.Header.Set("Tailscale-Client-IP", "198.51.100.4")
+		rr := httptest.NewRecorder()
+		server.ServeHTTP(rr, req)
+		if rr.Code != http.StatusForbidden {
+			t.Fatalf("expected %s to be refused after the scanner probe, got status %d", path, rr.Code)
+		}
+	}
+
+	entries := server.GetBlocklist()
+	if len(entries) != 1 || entries[0].Prefix.String() != "198.51.100.4/32" || entries[0].Source != blocklist.SourceAuto {
+		t.Fatalf("expected one auto-ban entry, got %+v", entries)
+	}
+	if !strings.Contains(entries[0].Reason, "/.env") {
+		t.Fatalf("expected reason to name the scanner path, got %q", entries[0].Reason)
+	}
+}
+
+func TestServeHTTPAutoBansRepeatedNotFound(t *testing.T) {
+	upstream := httptest.NewServer(http.NotFoundHandler())
+	defer upstream.Close()
+
+	server := NewServer(Config{
+		TargetPort:    mustPort(t, upstream.URL),
+		Mode:          model.ModeProxy,
+		UseTUI:        true,
+		Logger:        zap.NewNop(),
+		FunnelEnabled: true,
+		SourceMode:    SourceModeTSNet,
+		AutoBan:       blocklist.NewAutoBan(blocklist.AutoBanConfig{NotFoundThreshold: 3}),
+	})
+
+	codes := make([]int, 0, 4)
+	for i := 0; i < 4; i++ {
+		req := httptest.NewRequest(http.MethodGet, "/missing-"+strconv.Itoa(i), nil)
+		req.Header.Set("Tailscale-Client-IP", "198.51.100.4")
+		rr := httptest.NewRecorder()
+		server.ServeHTTP(rr, req)
+		codes = append(codes, rr.Code)
+	}
+
+	want := []int{http.StatusNotFound, http.StatusNotFound, http.StatusNotFound, http.StatusForbidden}
+	for i := range want {
+		if codes[i] != want[i] {
+			t.Fatalf("expected statuses %v, got %v", want, codes)
+		}
+	}
+}
+
+func TestServeHTTPAutoBanIgnoresTailnetAndAllowlistedSources(t *testing.T) {
+	server := NewServer(Config{
+		Mode:            model.ModeMock,
+		UseTUI:          true,
+		Logger:          zap.NewNop(),
+		FunnelEnabled:   true,
+		FunnelAllowlist: mustEntries(t, "203.0.113.0/24"),
+		SourceMode:      SourceModeTSNet,
+		AutoBan:         blocklist.NewAutoBan(blocklist.AutoBanConfig{ScannerPaths: blocklist.DefaultScannerPaths}),
+	})
+
+	tailnet := httptest.NewRequest(http.MethodGet, "/.env", nil)
+	tailnet.RemoteAddr = "100.64.0.7:40000"
+	tailnet.Header.Set(tailscaleUserLoginHeader, "alice@example.com")
+	server.ServeHTTP(httptest.NewRecorder(), tailnet)
+
+	allowlisted := httptest.NewRequest(http.MethodGet, "/.env", nil)
+	allowlisted.Header.Set("Tailscale-Client-IP", "203.0.113.7")
+	server.ServeHTTP(httptest.NewRecorder(), allowlisted)
+
+	if entries := server.GetBlocklist(); len(entries) != 0 {
+		t.Fatalf("expected tailnet and allowlisted sources to be exempt, got %+v", entries)
+	}
+}
+
+func TestServeHTTPAutoBanNeedsTrustedSourceAndBansIPv6Prefix(t *testing.T) {
+	config := Config{
+		Mode:          model.ModeMock,
+		UseTUI:        true,
+		Logger:        zap.NewNop(),
+		FunnelEnabled: true,
+		AutoBan:       blocklist.NewAutoBan(blocklist.AutoBanConfig{ScannerPaths: blocklist.DefaultScannerPaths}),
+	}
+
+	spoofable := NewServer(config)
+	forged := httptest.NewRequest(http.MethodGet, "/.env", nil)
+	forged.Header.Set("Tailscale-Client-IP", "198.51.100.4")
+	forged.Header.Set("X-Forwarded-For", "100.64.0.7")
+	spoofable.ServeHTTP(httptest.NewRecorder(), forged)
+	if entries := spoofable.GetBlocklist(); len(entries) != 0 {
+		t.Fatalf("expected no auto-ban from spoofable headers, got %+v", entries)
+	}
+
+	config.SourceMode = SourceModeTSNet
+	trusted := NewServer(config)
+	probe := httptest.NewRequest(http.MethodGet, "/.env", nil)
+	probe.Header.Set("Tailscale-Client-IP", "2001:db8:1:2::10")
+	trusted.ServeHTTP(httptest.NewRecorder(), probe)
+
+	rotated := httptest.NewRequest(http.MethodGet, "/", nil)
+	rotated.Header.Set("Tailscale-Client-IP", "2001:db8:1:2::99")
+	rr := httptest.NewRecorder()
+	trusted.ServeHTTP(rr, rotated)
+	if rr.Code != http.StatusForbidden {
+		t.Fatalf("expected another address in the /64 to be refused, got status %d", rr.Code)
+	}
+	if entries := trusted.GetBlocklist(); len(entries) != 1 || entries[0].Prefix.String() != "2001:db8:1:2::/64" {
+		t.Fatalf("expected the /64 to be banned, got %+v", entries)
+	}
+}
+
+func testGeoIP(t *testing.T) *geoip.DB {
+	t.Helper()
+
+	path := geoiptest.WriteDB(t, filepath.Join(t.TempDir(), "geo.mmdb"), map[string]map[string]any{
+		"203.0.113.0/24":  {"country": map[string]any{"iso_code": "NL"}},
+		"198.51.100.0/24": {"country": map[string]any{"iso_code": "US"}, "autonomous_system_number": uint32(64500)},
+	})
+	db, err := geoip.Open(path)
+	if err != nil {
+		t.Fatalf("open GeoIP database: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+func TestServeHTTPEnrichesFunnelCapturesWithGeoIP(t *testing.T) {
+	server := NewServer(Config{
+		Mode:          model.ModeMock,
+		UseTUI:        true,
+		Logger:        zap.NewNop(),
+		FunnelEnabled: true,
+		GeoIP:         testGeoIP(t),
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("Tailscale-Client-IP", "198.51.100.4")
+	server.ServeHTTP(httptest.NewRecorder(), req)
+
+	logs := server.GetRequestLogs()
+	if len(logs) != 1 || logs[0].Geo == nil {
+		t.Fatalf("expected a capture with GeoIP data, got %+v", logs)
+	}
+	if got := *logs[0].Geo; got.Country != "US" || got.ASN != 64500 {
+		t.Fatalf("unexpected GeoIP data %+v", got)
+	}
+
+	tailnet := httptest.NewRequest(http.MethodGet, "/", nil)
+	tailnet.Header.Set("Tailscale-Client-IP", "198.51.100.4")
+	tailnet.Header.Set(tailscaleUserLoginHeader, "alice@example.com")
+	server.ServeHTTP(httptest.NewRecorder(), tailnet)
+	if logs := server.GetRequestLogs(); logs[len(logs)-1].Geo != nil {
+		t.Fatalf("expected tailnet capture without GeoIP data, got %+v", logs[len(logs)-1].Geo)
+	}
+}
+
+func TestServeHTTPFunnelCountryAllowlistAndASNDenylist(t *testing.T) {
+	server := NewServer(Config{
+		Mode:            model.ModeMock,
+		UseTUI:          true,
+		Logger:          zap.NewNop(),
+		FunnelEnabled:   true,
+		FunnelAllowlist: mustEntries(t, "country:NL", "country:US"),
+		FunnelDenylist:  mustEntries(t, "asn:64500"),
+		GeoIP:           testGeoIP(t),
+	})
+
+	for _, request := range []struct {
+		ip   string
+		want int
+	}{
+		{"203.0.113.7", http.StatusOK},         // allowlisted country
+		{"198.51.100.4", http.StatusForbidden}, // allowlisted country, denylisted ASN
+		{"192.0.2.9", http.StatusForbidden},    // not in the database
+	} {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set("Tailscale-Client-IP", request.ip)
+		rr := httptest.NewRecorder()
+		server.ServeHTTP(rr, req)
+		if rr.Code != request.want {
+			t.Fatalf("expected status %d for %s, got %d", request.want, request.ip, rr.Code)
+		}
+	}
+
+	for _, talker := range server.GetTopTalkers() {
+		if talker.IP == "198.51.100.4" {
+			if talker.Denied != 1 {
+				t.Fatalf("expected denylisted source to count as denied, got %+v", talker)
+			}
+			return
+		}
+	}
+	t.Fatal("expected a talker entry for the denylisted source")
+}
+
+func TestServeHTTPFunnelRulesScopeAccessByPath(t *testing.T) {
+	server := NewServer(Config{
+		Mode:            model.ModeMock,
+		UseTUI:          true,
+		Logger:          zap.NewNop(),
+		FunnelEnabled:   true,
+		FunnelAllowlist: mustEntries(t, "203.0.113.0/24"),
+		FunnelRules: []access.Rule{
+			{Name: "github", PathPrefix: "/hooks/github", Methods: []string{http.MethodPost}, Allow: mustEntries(t, "192.30.252.0/22"), Default: access.ActionDeny},
+			{Name: "admin", PathPrefix: "/admin", Default: access.ActionDeny},
+		},
+	})
+
+	for _, tc := range []struct {
+		method, path, ip string
+		wantStatus       int
+		wantRule         string
+	}{
+		{http.MethodPost, "/hooks/github", "192.30.252.10", http.StatusOK, "github"},
+		{http.MethodPost, "/hooks/github", "203.0.113.7", http.StatusForbidden, "github"},
+		{http.MethodGet, "/admin/users", "203.0.113.7", http.StatusForbidden, "admin"},
+		{http.MethodGet, "/hooks/github", "203.0.113.7", http.StatusOK, ""},
+		{http.MethodGet, "/", "192.30.252.10", http.StatusForbidden, ""},
+	} {
+		req := httptest.NewRequest(tc.method, tc.path, nil)
+		req.Header.Set("Tailscale-Client-IP", tc.ip)
+		rr := httptest.NewRecorder()
+		server.ServeHTTP(rr, req)
+
+		if rr.Code != tc.wantStatus {
+			t.Fatalf("%s %s from %s: expected status %d, got %d", tc.method, tc.path, tc.ip, tc.wantStatus, rr.Code)
+		}
+		logs := server.GetRequestLogs()
+		if got := logs[len(logs)-1].AccessRule; got != tc.wantRule {
+			t.Fatalf("%s %s from %s: expected access rule %q, got %q", tc.method, tc.path, tc.ip, tc.wantRule, got)
+		}
+	}
+
+	tailnet := httptest.NewRequest(http.MethodGet, "/admin", nil)
+	tailnet.Header.Set(tailscaleUserLoginHeader, "alice@example.com")
+	tailnet.Header.Set("Tailscale-Client-IP", "203.0.113.7")
+	rr := httptest.NewRecorder()
+	server.ServeHTTP(rr, tailnet)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected tailnet request to bypass Funnel rules, got status %d", rr.Code)
+	}
+}
+
+func TestSetFunnelAccessAndMaxLogsApplyToLaterRequests(t *testing.T) {
+	server := NewServer(Config{
+		Mode:            model.ModeMock,
+		UseTUI:          true,
+		Logger:          zap.NewNop(),
+		FunnelEnabled:   true,
+		FunnelAllowlist: mustEntries(t, "203.0.113.0/24"),
+	})
+
+	serve := func(ip string) int {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set("Tailscale-Client-IP", ip)
+		rr := httptest.NewRecorder()
+		server.ServeHTTP(rr, req)
+		return rr.Code
+	}
+
+	if code := serve("198.51.100.4"); code != http.StatusForbidden {
+		t.Fatalf("expected source outside the allowlist to be denied, got %d", code)
+	}
+	server.SetFunnelAccess(mustEntries(t, "198.51.100.0/24"), nil, nil)
+	if code := serve("198.51.100.4"); code != http.StatusOK {
+		t.Fatalf("expected source in the replaced allowlist to be allowed, got %d", code)
+	}
+	if code := serve("203.0.113.7"); code != http.StatusForbidden {
+		t.Fatalf("expected source removed from the allowlist to be denied, got %d", code)
+	}
+
+	server.SetMaxLogs(2)
+	if logs := server.GetRequestLogs(); len(logs) != 2 {
+		t.Fatalf("expected captures trimmed to 2, got %d", len(logs))
+	}
+	serve("198.51.100.4")
+	if logs := server.GetRequestLogs(); len(logs) != 2 {
+		t.Fatalf("expected captures capped at 2, got %d", len(logs))
+	}
+}
+
+func TestSetTargetPortAndMockSwitchUpstream(t *testing.T) {
+	newUpstreamServer := func(name string) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(name))
+		}))
+	}
+	first, second := newUpstreamServer("first"), newUpstreamServer("second")
+	defer first.Close()
+	defer second.Close()
+
+	server := NewServer(Config{
+		TargetPort: mustPort(t, first.URL),
+		Mode:       model.ModeProxy,
+		UseTUI:     true,
+		Logger:     zap.NewNop(),
+	})
+	get := func() *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		server.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/", nil))
+		return rr
+	}
+
+	if body := get().Body.String(); body != "first" {
+		t.Fatalf("expected the first upstream, got %q", body)
+	}
+	if err := server.SetTargetPort(mustPort(t, second.URL)); err != nil {
+		t.Fatalf("SetTargetPort failed: %v", err)
+	}
+	if body := get().Body.String(); body != "second" {
+		t.Fatalf("expected the new upstream, got %q", body)
+	}
+
+	if err := server.SetMock(true); err != nil {
+		t.Fatalf("SetMock failed: %v", err)
+	}
+	if rr := get(); rr.Header().Get("X-portal-mode") != "mock" {
+		t.Fatalf("expected a mock response, got %q", rr.Body.String())
+	}
+	if err := server.SetMock(false); err != nil {
+		t.Fatalf("SetMock failed: %v", err)
+	}
+	if body := get().Body.String(); body != "second" {
+		t.Fatalf("expected leaving mock mode to return to the upstream, got %q", body)
+	}
+
+	mock := NewServer(Config{Mode: model.ModeMock, UseTUI: true, Logger: zap.NewNop()})
+	if err := mock.SetMock(false); !errors.Is(err, ErrNoTargetPort) {
+		t.Fatalf("expected ErrNoTargetPort without a port, got %v", err)
+	}
+	if err := mock.SetTargetPort(0); err == nil {
+		t.Fatal("expected an invalid port to be rejected")
+	}
+}
+
+func TestServeHTTPFunnelRulesMatchPublicPathUnderSetPath(t *testing.T) {
+	server := NewServer(Config{
+		Mode:          model.ModeMock,
+		UseTUI:        true,
+		Logger:        zap.NewNop(),
+		FunnelEnabled: true,
+		MountPath:     "/hooks",
+		FunnelRules: []access.Rule{
+			{Name: "github", PathPrefix: "/hooks/github", Allow: mustEntries(t, "192.30.252.0/22"), Default: access.ActionDeny},
+			{Name: "mount", PathPrefix: "/hooks", Default: access.ActionDeny},
+		},
+	})
+
+	// tailscaled strips /hooks before proxying, so portal sees /github for
+	// https://host/hooks/github.
+	for _, tc := range []struct {
+		path, ip   string
+		wantStatus int
+		wantRule   string
+	}{
+		{"/github", "192.30.252.10", http.StatusOK, "github"},
+		{"/github/push", "203.0.113.7", http.StatusForbidden, "github"},
+		{"/", "192.30.252.10", http.StatusForbidden, "mount"},
+		{"/other", "192.30.252.10", http.StatusForbidden, "mount"},
+	} {
+		req := httptest.NewRequest(http.MethodPost, tc.path, nil)
+		req.Header.Set("Tailscale-Client-IP", tc.ip)
+		rr := httptest.NewRecorder()
+		server.ServeHTTP(rr, req)
+
+		if rr.Code != tc.wantStatus {
+			t.Fatalf("%s from %s: expected status %d, got %d", tc.path, tc.ip, tc.wantStatus, rr.Code)
+		}
+		logs := server.GetRequestLogs()
+		if got := logs[len(logs)-1].AccessRule; got != tc.wantRule {
+			t.Fatalf("%s from %s: expected access rule %q, got %q", tc.path, tc.ip, tc.wantRule, got)
 		}
-		prefixes = append(prefixes, prefix)
 	}
-	return prefixes
 }
@@ -4,11 +4,17 @@ import (
 	"net"
 	"net/http"
 	"net/netip"
+	"strconv"
 	"strings"
+
+	"go.uber.org/zap"
+
+	"github.com/jaxxstorm/portal/internal/model"
 )
 
 const (
 	sourceSignalTailscaleClientIP = "tailscale_client_ip"
+	sourceSignalTailscaleServe    = "tailscale_serve"
 	sourceSignalForwarded         = "forwarded"
 	sourceSignalXForwardedFor     = "x_forwarded_for"
 	sourceSignalXRealIP           = "x_real_ip"
@@ -16,12 +22,77 @@ const (
 	sourceSignalUnresolved        = "unresolved"
 )
 
-func resolveSourceIP(r *http.Request, preferRemoteIP bool) (netip.Addr, string, bool) {
-	if preferRemoteIP {
-		if addr, ok := parseIPValue(strings.TrimSpace(r.RemoteAddr)); ok {
-			return addr, sourceSignalRemoteAddr, true
+// SourceMode selects which request signals identify the source IP.
+type SourceMode string
+
+const (
+	// SourceModeHeaders trusts the first forwarding header present, falling
+	// back to the connection address. Clients can set these headers, so
+	// enforcement in this mode is best effort. It is the default.
+	SourceModeHeaders SourceMode = "headers"
+	// SourceModeProxyProtocol trusts the connection address, which the PROXY
+	// v2 listener takes from tailscaled's TCP forwarding header.
+	SourceModeProxyProtocol SourceMode = "proxy_protocol"
+	// SourceModeServe trusts the X-Forwarded-For value tailscaled's serve
+	// web handler sets when it proxies a request over loopback, as it does
+	// for mounted paths. Direct connections resolve to their own address.
+	SourceModeServe SourceMode = "tailscale_serve"
+	// SourceModeTSNet trusts the Tailscale-Client-IP header portal sets from
+	// tsnet Funnel connections, falling back to the connection address.
+	SourceModeTSNet SourceMode = "tsnet"
+)
+
+// Trusted reports whether clients cannot choose the source IP portal sees.
+func (m SourceMode) Trusted() bool {
+	switch m {
+	case SourceModeProxyProtocol, SourceModeServe, SourceModeTSNet:
+		return true
+	default:
+		return false
+	}
+}
+
+// requestSource is the resolved origin of a request.
+type requestSource struct {
+	ip       netip.Addr
+	signal   string
+	resolved bool
+	geo      *model.GeoInfo // nil when GeoIP data is unavailable
+}
+
+// resolveSource resolves the source IP of r and, for Funnel requests, looks
+// it up in the GeoIP database.
+func (s *Server) resolveSource(r *http.Request) requestSource {
+	ip, signal, resolved := resolveSourceIP(r, s.sourceMode)
+	source := requestSource{ip: ip, signal: signal, resolved: resolved}
+	if resolved && s.requestExposure(r) == exposureFunnel {
+		if geo, ok := s.geoIP.Lookup(ip); ok {
+			source.geo = &geo
+		}
+	}
+	return source
+}
+
+func resolveSourceIP(r *http.Request, mode SourceMode) (netip.Addr, string, bool) {
+	switch mode {
+	case SourceModeProxyProtocol:
+		return remoteAddrSource(r)
+	case SourceModeServe:
+		remote, _, ok := remoteAddrSource(r)
+		if !ok || !remote.IsLoopback() {
+			return remoteAddrSource(r)
+		}
+		// tailscaled replaces X-Forwarded-For with the peer address; older
+		// releases append it instead, so the last value is always its own.
+		if addr, ok := parseIPValue(lastCSVValue(r.Header.Values("X-Forwarded-For"))); ok {
+			return addr, sourceSignalTailscaleServe, true
 		}
 		return netip.Addr{}, sourceSignalUnresolved, false
+	case SourceModeTSNet:
+		if addr, ok := parseIPValue(r.Header.Get("Tailscale-Client-IP")); ok {
+			return addr, sourceSignalTailscaleClientIP, true
+		}
+		return remoteAddrSource(r)
 	}
 
 	if addr, ok := parseIPValue(r.Header.Get("Tailscale-Client-IP")); ok {
@@ -40,13 +111,31 @@ func resolveSourceIP(r *http.Request, preferRemoteIP bool) (netip.Addr, string,
 		return addr, sourceSignalXRealIP, true
 	}
 
+	return remoteAddrSource(r)
+}
+
+func remoteAddrSource(r *http.Request) (netip.Addr, string, bool) {
 	if addr, ok := parseIPValue(strings.TrimSpace(r.RemoteAddr)); ok {
 		return addr, sourceSignalRemoteAddr, true
 	}
-
 	return netip.Addr{}, sourceSignalUnresolved, false
 }
 
+// trustsIdentityHeaders reports whether the Tailscale identity headers on r,
+// which decide tailnet or Funnel exposure, were set by Tailscale rather
+// than the client. TCP forwarding passes client headers through untouched.
+func (s *Server) trustsIdentityHeaders(r *http.Request) bool {
+	switch s.sourceMode {
+	case SourceModeProxyProtocol:
+		return false
+	case SourceModeServe:
+		remote, _, ok := remoteAddrSource(r)
+		return ok && remote.IsLoopback()
+	default:
+		return true
+	}
+}
+
 func parseForwardedHeader(header string) (netip.Addr, bool) {
 	if strings.TrimSpace(header) == "" {
 		return netip.Addr{}, false
@@ -82,6 +171,20 @@ func firstCSVValue(value string) string {
 	return ""
 }
 
+// lastCSVValue returns the last non-empty value across comma-separated
+// header values.
+func lastCSVValue(values []string) string {
+	for i := len(values) - 1; i >= 0; i-- {
+		entries := strings.Split(values[i], ",")
+		for j := len(entries) - 1; j >= 0; j-- {
+			if trimmed := strings.TrimSpace(entries[j]); trimmed != "" {
+				return trimmed
+			}
+		}
+	}
+	return ""
+}
+
 func parseIPValue(value string) (netip.Addr, bool) {
 	candidate := strings.TrimSpace(value)
 	if candidate == "" {
@@ -113,11 +216,29 @@ func parseIPValue(value string) (netip.Addr, bool) {
 	return netip.Addr{}, false
 }
 
-func allowlistedEntry(addr netip.Addr, allowlist []netip.Prefix) (netip.Prefix, bool) {
-	for _, entry := range allowlist {
-		if entry.Contains(addr) {
-			return entry, true
-		}
+// sourceIPString formats a resolved source IP, or "" when unresolved.
+func sourceIPString(addr netip.Addr) string {
+	if !addr.IsValid() {
+		return ""
+	}
+	return addr.String()
+}
+
+// geoField logs GeoIP data as "NL AS64500 (Example Hosting)", or nothing
+// when there is none.
+func geoField(geo *model.GeoInfo) zap.Field {
+	if geo == nil {
+		return zap.Skip()
+	}
+	parts := make([]string, 0, 3)
+	if geo.Country != "" {
+		parts = append(parts, geo.Country)
+	}
+	if geo.ASN != 0 {
+		parts = append(parts, "AS"+strconv.FormatUint(uint64(geo.ASN), 10))
+	}
+	if geo.ASOrg != "" {
+		parts = append(parts, "("+geo.ASOrg+")")
 	}
-	return netip.Prefix{}, false
+	return zap.String("source_geo", strings.Join(parts, " "))
 }
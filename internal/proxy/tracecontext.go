@@ -0,0 +1,103 @@
+package proxy
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+const (
+	traceparentHeader = "Traceparent"
+
+	traceparentVersion = "00"
+	traceFlagsSampled  = "01"
+
+	maxRequestIDLength = 128
+)
+
+// traceContext is the W3C trace context portal forwards upstream.
+type traceContext struct {
+	traceID  string
+	parentID string
+	flags    string
+}
+
+// Traceparent formats the context as a W3C traceparent header value.
+func (tc traceContext) Traceparent() string {
+	return fmt.Sprintf("%s-%s-%s-%s", traceparentVersion, tc.traceID, tc.parentID, tc.flags)
+}
+
+// continueTraceContext continues the trace in an incoming traceparent header,
+// giving portal's hop a fresh parent ID. A new sampled trace is started when
+// the header is missing or invalid.
+func continueTraceContext(header string) traceContext {
+	if traceID, flags, ok := parseTraceparent(header); ok {
+		return traceContext{traceID: traceID, parentID: randomHex(8), flags: flags}
+	}
+	return traceContext{traceID: randomHex(16), parentID: randomHex(8), flags: traceFlagsSampled}
+}
+
+func parseTraceparent(header string) (traceID, flags string, ok bool) {
+	parts := strings.Split(strings.TrimSpace(header), "-")
+	if len(parts) < 4 {
+		return "", "", false
+	}
+
+	version, traceID, parentID, flags := parts[0], parts[1], parts[2], parts[3]
+	if !isLowerHex(version, 2) || version == "ff" {
+		return "", "", false
+	}
+	// Version 00 has exactly four fields; later versions may append more.
+	if version == traceparentVersion && len(parts) != 4 {
+		return "", "", false
+	}
+	if !isLowerHex(traceID, 32) || isAllZeros(traceID) {
+		return "", "", false
+	}
+	if !isLowerHex(parentID, 16) || isAllZeros(parentID) {
+		return "", "", false
+	}
+	if !isLowerHex(flags, 2) {
+		return "", "", false
+	}
+	return traceID, flags, true
+}
+
+// sanitizeRequestID accepts an incoming request ID if it is short and made of
+// printable ASCII, so it is safe to echo in headers and logs.
+func sanitizeRequestID(value string) (string, bool) {
+	value = strings.TrimSpace(value)
+	if value == "" || len(value) > maxRequestIDLength {
+		return "", false
+	}
+	for i := 0; i < len(value); i++ {
+		if value[i] < 0x21 || value[i] > 0x7e {
+			return "", false
+		}
+	}
+	return value, true
+}
+
+func randomHex(n int) string {
+	buf := make([]byte, n)
+	rand.Read(buf)
+	return hex.EncodeToString(buf)
+}
+
+func isLowerHex(value string, length int) bool {
+	if len(value) != length {
+		return false
+	}
+	for i := 0; i < len(value); i++ {
+		c := value[i]
+		if !(c >= '0' && c <= '9') && !(c >= 'a' && c <= 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+func isAllZeros(value string) bool {
+	return strings.Trim(value, "0") == ""
+}
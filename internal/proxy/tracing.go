@@ -0,0 +1,142 @@
+package proxy
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	semconv "go.opentelemetry.io/otel/semconv/v1.37.0"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/jaxxstorm/portal/internal/model"
+)
+
+const (
+	exposureFunnel  = "funnel"
+	exposureTailnet = "tailnet"
+
+	// Tailscale serve marks requests that arrived over Funnel; tailnet
+	// requests carry the caller's identity instead.
+	tailscaleFunnelRequestHeader = "Tailscale-Funnel-Request"
+	tailscaleUserLoginHeader     = "Tailscale-User-Login"
+
+	attrExposure  = attribute.Key("portal.exposure")
+	attrRequestID = attribute.Key("portal.request_id")
+	attrCaptureID = attribute.Key("portal.capture_id")
+)
+
+var traceContextPropagator = propagation.TraceContext{}
+
+// startRequestSpan starts the server span for a request, continuing any trace
+// in the incoming headers. The span is named after the route template, so
+// that each URL does not get a span name of its own. Without a tracer it
+// returns a no-op span whose context is invalid.
+func (s *Server) startRequestSpan(ctx context.Context, r *http.Request) (context.Context, trace.Span) {
+	if s.tracer == nil {
+		return ctx, trace.SpanFromContext(context.Background())
+	}
+
+	route := s.routes.Load().Route(r.URL.Path)
+	ctx = traceContextPropagator.Extract(ctx, propagation.HeaderCarrier(r.Header))
+	return s.tracer.Start(ctx, r.Method+" "+route,
+		trace.WithSpanKind(trace.SpanKindServer),
+		trace.WithAttributes(
+			semconv.HTTPRequestMethodKey.String(r.Method),
+			semconv.HTTPRoute(route),
+			semconv.URLPath(r.URL.Path),
+			semconv.UserAgentOriginal(r.UserAgent()),
+			attrExposure.String(s.requestExposure(r)),
+		),
+	)
+}
+
+// finishRequestSpan records the outcome of a request on its server span.
+func (s *Server) finishRequestSpan(span trace.Span, r *http.Request, logEntry model.RequestLog) {
+	if !span.IsRecording() {
+		return
+	}
+
+	span.SetAttributes(
+		semconv.HTTPResponseStatusCode(logEntry.StatusCode),
+		semconv.HTTPResponseBodySize(int(logEntry.Response.Size)),
+		attrRequestID.String(logEntry.RequestID),
+		attrCaptureID.String(logEntry.ID),
+	)
+	if logEntry.Size >= 0 {
+		span.SetAttributes(semconv.HTTPRequestBodySize(int(logEntry.Size)))
+	}
+	if sourceIP, _, ok := resolveSourceIP(r, s.sourceMode); ok {
+		span.SetAttributes(semconv.ClientAddress(sourceIP.String()))
+	}
+	if logEntry.GraphQL != nil {
+		span.SetAttributes(semconv.GraphQLOperationTypeKey.String(logEntry.GraphQL.OperationType))
+		if logEntry.GraphQL.OperationName != "" {
+			span.SetAttributes(semconv.GraphQLOperationName(logEntry.GraphQL.OperationName))
+		}
+	}
+	if logEntry.StatusCode >= http.StatusInternalServerError {
+		span.SetStatus(codes.Error, http.StatusText(logEntry.StatusCode))
+	}
+}
+
+// serveUpstream forwards the request to the backend inside a client span, and
+// points the forwarded traceparent at that span so backend spans nest under it.
+func (s *Server) serveUpstream(lrw *LoggingResponseWriter, r *http.Request, upstream *upstream) {
+	if s.tracer == nil {
+		upstream.proxy.ServeHTTP(lrw, r)
+		return
+	}
+
+	ctx, span := s.tracer.Start(r.Context(), "upstream "+r.Method,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			semconv.HTTPRequestMethodKey.String(r.Method),
+			semconv.ServerAddress(upstream.target.Hostname()),
+			semconv.ServerPort(targetPort(upstream.target)),
+		),
+	)
+	defer span.End()
+
+	traceContextPropagator.Inject(ctx, propagation.HeaderCarrier(r.Header))
+	upstream.proxy.ServeHTTP(lrw, r.WithContext(ctx))
+
+	span.SetAttributes(semconv.HTTPResponseStatusCode(lrw.statusCode))
+	if lrw.statusCode >= http.StatusInternalServerError {
+		span.SetStatus(codes.Error, http.StatusText(lrw.statusCode))
+	}
+}
+
+func targetPort(target *url.URL) int {
+	port, _ := strconv.Atoi(target.Port())
+	return port
+}
+
+// requestExposure reports whether a request reached portal over Funnel or
+// from inside the tailnet, preferring the markers Tailscale serve adds.
+func (s *Server) requestExposure(r *http.Request) string {
+	trusted := s.trustsIdentityHeaders(r)
+	switch {
+	case trusted && r.Header.Get(tailscaleFunnelRequestHeader) != "":
+		return exposureFunnel
+	case trusted && r.Header.Get(tailscaleUserLoginHeader) != "":
+		return exposureTailnet
+	case s.funnelEnabled.Load():
+		return exposureFunnel
+	default:
+		return exposureTailnet
+	}
+}
+
+// traceContextFromSpan converts an OpenTelemetry span context into the trace
+// context portal records and forwards.
+func traceContextFromSpan(sc trace.SpanContext) traceContext {
+	flags := "00"
+	if sc.IsSampled() {
+		flags = traceFlagsSampled
+	}
+	return traceContext{traceID: sc.TraceID().String(), parentID: sc.SpanID().String(), flags: flags}
+}
@@ -0,0 +1,115 @@
+package proxy
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+
+	"github.com/jaxxstorm/portal/internal/logging"
+	"github.com/jaxxstorm/portal/internal/model"
+)
+
+// ErrNoTargetPort is returned when leaving mock mode with no upstream port to
+// proxy to.
+var ErrNoTargetPort = errors.New("no upstream port to proxy to; set one first")
+
+// upstream is where requests go: the mock handler or a local port. It is
+// replaced as a whole so that requests see a consistent mode and target.
+type upstream struct {
+	mode   model.ServerMode
+	port   int // kept in mock mode, to return to
+	target *url.URL
+	proxy  *httputil.ReverseProxy
+}
+
+func newUpstream(mode model.ServerMode, port int) *upstream {
+	u := &upstream{mode: mode, port: port}
+	if mode != model.ModeProxy {
+		return u
+	}
+
+	u.target = &url.URL{
+		Scheme: "http",
+		Host:   fmt.Sprintf("localhost:%d", port),
+	}
+	u.proxy = httputil.NewSingleHostReverseProxy(u.target)
+
+	// Customize the director to preserve original headers
+	originalDirector := u.proxy.Director
+	u.proxy.Director = func(req *http.Request) {
+		originalDirector(req)
+		req.Header.Set("X-Forwarded-Proto", "https")
+		req.Header.Set("X-Forwarded-Host", req.Host)
+	}
+	return u
+}
+
+// Target returns the server mode and the upstream port. The port is the one
+// last proxied to, or 0, in mock mode.
+func (s *Server) Target() (model.ServerMode, int) {
+	u := s.upstream.Load()
+	return u.mode, u.port
+}
+
+// SetTargetPort proxies new requests to port, leaving mock mode if needed.
+// Requests already in progress finish against the old target.
+func (s *Server) SetTargetPort(port int) error {
+	if port <= 0 || port > 65535 {
+		return fmt.Errorf("invalid port %d: must be between 1 and 65535", port)
+	}
+	s.upstream.Store(newUpstream(model.ModeProxy, port))
+	s.logger.Info("Upstream target changed",
+		logging.Component("proxy_server"),
+		logging.TargetPort(port),
+	)
+	return nil
+}
+
+// SetMock switches new requests between the mock handler and the upstream
+// port. Leaving mock mode returns ErrNoTargetPort if portal has never had a
+// port to proxy to.
+func (s *Server) SetMock(enabled bool) error {
+	current := s.upstream.Load()
+	switch {
+	case enabled && current.mode == model.ModeMock, !enabled && current.mode == model.ModeProxy:
+		return nil
+	case enabled:
+		s.upstream.Store(newUpstream(model.ModeMock, current.port))
+	case current.port == 0:
+		return ErrNoTargetPort
+	default:
+		s.upstream.Store(newUpstream(model.ModeProxy, current.port))
+	}
+	s.logger.Info("Mock mode changed",
+		logging.Component("proxy_server"),
+		logging.MockMode(enabled),
+	)
+	return nil
+}
+
+// FunnelEnabled reports whether portal treats unmarked requests as Funnel
+// traffic and enforces Funnel access control.
+func (s *Server) FunnelEnabled() bool {
+	return s.funnelEnabled.Load()
+}
+
+// FunnelAccessControlled reports whether a Funnel allowlist, denylist or
+// rule is set, whether or not Funnel is on.
+func (s *Server) FunnelAccessControlled() bool {
+	fa := s.funnelAccess.Load()
+	return len(fa.allowlist) > 0 || len(fa.denylist) > 0 || len(fa.rules) > 0
+}
+
+// SourceMode returns how the proxy identifies request sources. It is fixed
+// when the server is created.
+func (s *Server) SourceMode() SourceMode {
+	return s.sourceMode
+}
+
+// SetFunnelEnabled changes whether Funnel access control applies. It does not
+// change what Tailscale exposes; callers do that first.
+func (s *Server) SetFunnelEnabled(enabled bool) {
+	s.funnelEnabled.Store(enabled)
+}
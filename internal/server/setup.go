@@ -4,7 +4,9 @@ import (
 	"context"
 	"fmt"
 	"io/fs"
+	"net"
 	"net/http"
+	"strconv"
 	"time"
 
 	"github.com/jaxxstorm/portal/internal/config"
@@ -26,6 +28,14 @@ func SetupLocalTailscaleQuiet(ctx context.Context, tsClient *tailscale.Client, p
 		}
 	}
 
+	// Remove entries left behind by portal sessions that did not exit cleanly
+	reclaimed, err := tsClient.ReclaimStale(ctx)
+	if err != nil {
+		logger.Warnf("Failed to reclaim stale serve entries: %v", err)
+	} else if reclaimed > 0 {
+		logger.Infof("Reclaimed stale serve entries entries=%d", reclaimed)
+	}
+
 	// Find an available port for our local proxy server using random allocation
 	proxyPort, err := tailscale.FindAvailableLocalPort()
 	if err != nil {
@@ -39,8 +49,9 @@ func SetupLocalTailscaleQuiet(ctx context.Context, tsClient *tailscale.Client, p
 	// Start our proxy server
 	useFunnelProxyProtocol := cfg.UseFunnelProxyProtocol()
 	httpServer := &http.Server{
-		Addr:    fmt.Sprintf(":%d", proxyPort),
-		Handler: proxyServer,
+		Addr:      net.JoinHostPort(cfg.ProxyBindHost(), strconv.Itoa(proxyPort)),
+		Handler:   proxyServer,
+		ConnState: proxyServer.ConnState,
 	}
 
 	proxyListener, err := httputil.NewHTTPListener(httpServer.Addr, useFunnelProxyProtocol)
@@ -129,12 +140,9 @@ func SetupLocalTailscaleQuiet(ctx context.Context, tsClient *tailscale.Client, p
 		cleanupCtx, cleanupCancel := context.WithTimeout(context.Background(), 10*time.Second)
 		defer cleanupCancel()
 
-		// Comprehensive cleanup of all Tailscale serve configs
-		err := tsClient.CleanupAll(cleanupCtx)
-		if err != nil {
-			logger.Warnf("Failed to perform comprehensive cleanup, trying specific cleanup: %v", err)
-			// Fallback to specific config cleanup
-			tsClient.Cleanup(cleanupCtx, tsConfig)
+		// Remove only the serve entries this session added
+		if err := tsClient.Cleanup(cleanupCtx); err != nil {
+			logger.Warnf("Failed to remove serve entries added by portal, run portal --cleanup-serve: %v", err)
 		}
 		// Shutdown proxy server
 		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
@@ -0,0 +1,269 @@
+// Package session keeps a registry of running portal processes, so portal
+// status can list what each one exposes.
+package session
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jaxxstorm/portal/internal/fileutil"
+	"github.com/jaxxstorm/portal/internal/process"
+)
+
+// Record describes one running portal. It is saved at startup and
+// refreshed while portal runs.
+type Record struct {
+	PID           int       `json:"pid"`
+	Name          string    `json:"name,omitempty"`
+	StartedAt     time.Time `json:"started_at"`
+	UpdatedAt     time.Time `json:"updated_at"`
+	Readiness     string    `json:"readiness"`
+	Mode          string    `json:"mode"`
+	Exposure      string    `json:"exposure"`
+	URL           string    `json:"url,omitempty"`
+	MountPath     string    `json:"mount_path"`
+	Target        string    `json:"target"`
+	WebUIURL      string    `json:"web_ui_url,omitempty"`
+	Profile       string    `json:"profile,omitempty"`
+	Requests      int       `json:"requests"`
+	LogFile       string    `json:"log_file,omitempty"`
+	ControlSocket string    `json:"control_socket,omitempty"`
+	Error         string    `json:"error,omitempty"`
+}
+
+// Live reports whether the portal that saved r is still running. A record
+// whose PID is gone is stale, and so is one whose control socket no longer
+// accepts connections, as its PID may now belong to another process.
+func (r Record) Live() bool {
+	if !process.Alive(r.PID) {
+		return false
+	}
+	if r.ControlSocket == "" {
+		return true
+	}
+	conn, err := net.DialTimeout("unix", r.ControlSocket, 500*time.Millisecond)
+	if err != nil {
+		return false
+	}
+	conn.Close()
+	return true
+}
+
+// DefaultDir is where running portals keep their records.
+func DefaultDir() (string, error) {
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("failed to get home directory: %w", err)
+	}
+	return filepath.Join(homeDir, ".portal", "sessions"), nil
+}
+
+// DefaultLogDir is where portal start writes the logs of named portals.
+func DefaultLogDir() (string, error) {
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("failed to get home directory: %w", err)
+	}
+	return filepath.Join(homeDir, ".portal", "logs"), nil
+}
+
+// Registration is this process's entry in the registry.
+type Registration struct {
+	path string
+
+	mu     sync.Mutex
+	record Record
+	closed bool
+}
+
+// Register saves record for the current process in dir, first removing
+// records left by portals that have exited. A named record fails to register
+// if a running portal already uses the name.
+func Register(dir string, record Record) (*Registration, error) {
+	if err := prune(dir); err != nil {
+		return nil, err
+	}
+	if record.Name != "" {
+		existing, err := Find(dir, record.Name)
+		if err != nil {
+			return nil, err
+		}
+		if existing != nil && existing.PID != os.Getpid() {
+			return nil, fmt.Errorf("name %q is already used by a running portal (pid %d)", record.Name, existing.PID)
+		}
+	}
+
+	record.PID = os.Getpid()
+	if record.StartedAt.IsZero() {
+		record.StartedAt = time.Now().UTC()
+	}
+	r := &Registration{
+		path:   filepath.Join(dir, strconv.Itoa(record.PID)+".json"),
+		record: record,
+	}
+	if err := r.save(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Refresh updates the record every interval until ctx is done. update fills
+// in the current values, such as the request count.
+func (r *Registration) Refresh(ctx context.Context, interval time.Duration, update func(*Record)) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		r.mu.Lock()
+		if r.closed {
+			r.mu.Unlock()
+			return
+		}
+		update(&r.record)
+		r.save()
+		r.mu.Unlock()
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// ControlSocket is where this process serves its control API, next to its
+// record.
+func (r *Registration) ControlSocket() string {
+	return strings.TrimSuffix(r.path, ".json") + ".sock"
+}
+
+// Update changes the record and saves it straight away, for changes such
+// as readiness that should not wait for the next refresh.
+func (r *Registration) Update(update func(*Record)) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.closed {
+		return nil
+	}
+	update(&r.record)
+	return r.save()
+}
+
+// Close removes the record from the registry.
+func (r *Registration) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.closed = true
+	if err := os.Remove(r.path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to remove session record %s: %w", r.path, err)
+	}
+	return nil
+}
+
+// save writes the record. Callers hold r.mu, except while registering.
+func (r *Registration) save() error {
+	r.record.UpdatedAt = time.Now().UTC()
+	data, err := json.MarshalIndent(r.record, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode session record: %w", err)
+	}
+	if err := fileutil.WriteAtomic(r.path, append(data, '\n')); err != nil {
+		return fmt.Errorf("failed to save session record %s: %w", r.path, err)
+	}
+	return nil
+}
+
+// List returns the records of running portals in dir, oldest first.
+// Records that are not Live are skipped. A missing directory yields no
+// records.
+func List(dir string) ([]Record, error) {
+	records, _, err := load(dir)
+	if err != nil {
+		return nil, err
+	}
+	return slices.DeleteFunc(records, func(record Record) bool {
+		return !record.Live()
+	}), nil
+}
+
+// Find returns the record of the running portal called name, or nil if
+// there is none.
+func Find(dir, name string) (*Record, error) {
+	records, err := List(dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, record := range records {
+		if record.Name == name {
+			return &record, nil
+		}
+	}
+	return nil, nil
+}
+
+// prune removes the records and control sockets of portals that are not
+// Live.
+func prune(dir string) error {
+	records, paths, err := load(dir)
+	if err != nil {
+		return err
+	}
+	for i, record := range records {
+		if record.Live() {
+			continue
+		}
+		socket := strings.TrimSuffix(paths[i], ".json") + ".sock"
+		for _, path := range []string{paths[i], socket} {
+			if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+				return fmt.Errorf("failed to remove session file %s: %w", path, err)
+			}
+		}
+	}
+	return nil
+}
+
+func load(dir string) ([]Record, []string, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	type loaded struct {
+		record Record
+		path   string
+	}
+	var entries []loaded
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if errors.Is(err, os.ErrNotExist) {
+			continue // removed by its portal while listing
+		}
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to read session record %s: %w", path, err)
+		}
+		var record Record
+		if err := json.Unmarshal(data, &record); err != nil {
+			return nil, nil, fmt.Errorf("failed to parse session record %s: %w", path, err)
+		}
+		entries = append(entries, loaded{record, path})
+	}
+	slices.SortFunc(entries, func(a, b loaded) int {
+		return a.record.StartedAt.Compare(b.record.StartedAt)
+	})
+
+	records := make([]Record, len(entries))
+	paths = make([]string, len(entries))
+	for i, entry := range entries {
+		records[i], paths[i] = entry.record, entry.path
+	}
+	return records, paths, nil
+}
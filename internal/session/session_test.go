@@ -0,0 +1,181 @@
+package session
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestRegisterListAndClose(t *testing.T) {
+	dir := t.TempDir()
+
+	registration, err := Register(dir, Record{Target: "localhost:8080", MountPath: "/"})
+	if err != nil {
+		t.Fatalf("Register failed: %v", err)
+	}
+
+	records, err := List(dir)
+	if err != nil {
+		t.Fatalf("List failed: %v", err)
+	}
+	if len(records) != 1 || records[0].PID != os.Getpid() || records[0].Target != "localhost:8080" {
+		t.Fatalf("expected this process's record, got %+v", records)
+	}
+	if records[0].StartedAt.IsZero() {
+		t.Fatal("expected the start time to be set")
+	}
+
+	if err := registration.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+	records, err = List(dir)
+	if err != nil || len(records) != 0 {
+		t.Fatalf("expected no records after Close, got %+v (err %v)", records, err)
+	}
+}
+
+func TestRefreshUpdatesRecordUntilClosed(t *testing.T) {
+	dir := t.TempDir()
+	registration, err := Register(dir, Record{})
+	if err != nil {
+		t.Fatalf("Register failed: %v", err)
+	}
+
+	requests := 0
+	done := make(chan struct{})
+	go func() {
+		registration.Refresh(t.Context(), 10*time.Millisecond, func(record *Record) {
+			requests++
+			record.Requests = requests
+		})
+		close(done)
+	}()
+
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		records, err := List(dir)
+		if err != nil {
+			t.Fatalf("List failed: %v", err)
+		}
+		if len(records) == 1 && records[0].Requests >= 2 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected the record to be refreshed, got %+v", records)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if err := registration.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("expected Refresh to stop after Close")
+	}
+	if _, err := os.Stat(registration.path); !os.IsNotExist(err) {
+		t.Fatalf("expected the record to stay removed, got %v", err)
+	}
+}
+
+func TestRegisterPrunesExitedPortals(t *testing.T) {
+	dir := t.TempDir()
+
+	exited := exec.Command(os.Args[0], "-test.run=^$")
+	if err := exited.Run(); err != nil {
+		t.Fatalf("failed to run child process: %v", err)
+	}
+	stale := filepath.Join(dir, strconv.Itoa(exited.Process.Pid)+".json")
+	if err := os.WriteFile(stale, []byte(`{"pid": `+strconv.Itoa(exited.Process.Pid)+`}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	records, err := List(dir)
+	if err != nil || len(records) != 0 {
+		t.Fatalf("expected the exited portal to be skipped, got %+v (err %v)", records, err)
+	}
+
+	registration, err := Register(dir, Record{})
+	if err != nil {
+		t.Fatalf("Register failed: %v", err)
+	}
+	defer registration.Close()
+	if _, err := os.Stat(stale); !os.IsNotExist(err) {
+		t.Fatalf("expected the exited portal's record to be removed, got %v", err)
+	}
+}
+
+func TestRegisterPrunesPortalsWithDeadControlSocket(t *testing.T) {
+	dir := t.TempDir()
+
+	// The parent process stands in for an unrelated process that took over
+	// the PID of a portal killed without cleaning up.
+	pid := strconv.Itoa(os.Getppid())
+	socket := filepath.Join(dir, pid+".sock")
+	if err := os.WriteFile(socket, nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	stale := filepath.Join(dir, pid+".json")
+	record := `{"pid": ` + pid + `, "name": "api", "control_socket": "` + filepath.ToSlash(socket) + `"}`
+	if err := os.WriteFile(stale, []byte(record), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	if found, err := Find(dir, "api"); err != nil || found != nil {
+		t.Fatalf("expected the portal with a dead socket to be skipped, got %+v (err %v)", found, err)
+	}
+
+	registration, err := Register(dir, Record{})
+	if err != nil {
+		t.Fatalf("Register failed: %v", err)
+	}
+	defer registration.Close()
+	for _, path := range []string{stale, socket} {
+		if _, err := os.Stat(path); !os.IsNotExist(err) {
+			t.Fatalf("expected %s to be removed, got %v", path, err)
+		}
+	}
+}
+
+func TestListMissingDir(t *testing.T) {
+	records, err := List(filepath.Join(t.TempDir(), "missing"))
+	if err != nil || len(records) != 0 {
+		t.Fatalf("expected no records for a missing dir, got %+v (err %v)", records, err)
+	}
+}
+
+func TestRegisterRejectsNameInUse(t *testing.T) {
+	dir := t.TempDir()
+
+	// The test's parent process stands in for another running portal.
+	other := `{"pid": ` + strconv.Itoa(os.Getppid()) + `, "name": "api"}`
+	if err := os.WriteFile(filepath.Join(dir, strconv.Itoa(os.Getppid())+".json"), []byte(other), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	found, err := Find(dir, "api")
+	if err != nil || found == nil || found.PID != os.Getppid() {
+		t.Fatalf("expected to find the other portal, got %+v (err %v)", found, err)
+	}
+	if _, err := Register(dir, Record{Name: "api"}); err == nil {
+		t.Fatal("expected Register to reject a name in use")
+	}
+
+	registration, err := Register(dir, Record{Name: "web"})
+	if err != nil {
+		t.Fatalf("Register failed: %v", err)
+	}
+	defer registration.Close()
+
+	if err := registration.Update(func(record *Record) { record.URL = "https://node.example.ts.net/" }); err != nil {
+		t.Fatalf("Update failed: %v", err)
+	}
+	found, err = Find(dir, "web")
+	if err != nil || found == nil || found.URL != "https://node.example.ts.net/" {
+		t.Fatalf("expected the update to be saved, got %+v (err %v)", found, err)
+	}
+}
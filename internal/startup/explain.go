@@ -0,0 +1,115 @@
+package startup
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/jaxxstorm/portal/internal/config"
+)
+
+// Reasons for the Tailscale backend choice, as reported by portal config
+// explain.
+const (
+	BackendReasonDaemonRunning = "local tailscaled is running"
+	BackendReasonDaemonMissing = "no local tailscaled is reachable"
+	BackendReasonForceTSNet    = "force-tsnet is set"
+	BackendReasonAuthKey       = "auth-key is set"
+)
+
+// Explain writes a description of the operating mode cfg resolves to,
+// before anything is started, for portal config explain. It uses the same
+// resolution as the startup-ready summary.
+func Explain(w io.Writer, cfg *config.Config, useLocalDaemon bool, backendReason string) error {
+	summary := BuildReadySummary(cfg, useLocalDaemon, "", "", "", TSNetDetails{})
+
+	backend := "local tailscaled, through tailscale serve"
+	if summary.Mode == ModeTSNet {
+		backend = fmt.Sprintf("tsnet, as device %q", cfg.TailscaleName)
+	}
+
+	exposure := "tailnet only"
+	if summary.Exposure == ExposureFunnel {
+		exposure = "public internet through Funnel, and tailnet"
+	}
+
+	target := fmt.Sprintf("proxy to localhost:%d", cfg.Port)
+	if cfg.Port == 0 {
+		target = "proxy to the port given when portal runs"
+	}
+	if summary.BackendMode == BackendModeMock {
+		target = "mock backend (no local service)"
+	}
+
+	scheme := "http"
+	if cfg.UseHTTPS {
+		scheme = "https"
+	}
+
+	listenMode := cfg.EffectiveTSNetListenMode()
+	serve := fmt.Sprintf("%s on port %d, path %s", scheme, cfg.GetServePort(), cfg.GetSetPath())
+	if cfg.IsServiceMode() {
+		listenMode += fmt.Sprintf(" (%s)", cfg.TSNetServiceName)
+		serve = fmt.Sprintf("%s on port %d of service %s, path %s", scheme, cfg.GetServePort(), cfg.TSNetServiceName, cfg.GetSetPath())
+	}
+
+	rows := [][2]string{
+		{"Backend", fmt.Sprintf("%s (%s)", backend, backendReason)},
+		{"Exposure", exposure},
+		{"Target", target},
+		{"Listen mode", listenMode},
+		{"Serve", serve},
+		{"PROXY protocol", explainProxyProtocol(cfg, summary)},
+		{"Web UI", explainWebUI(cfg, summary)},
+	}
+	if cfg.Profile != "" {
+		rows = append(rows, [2]string{"Profile", cfg.Profile})
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	for _, row := range rows {
+		fmt.Fprintf(tw, "%s:\t%s\n", row[0], row[1])
+	}
+	return tw.Flush()
+}
+
+func explainProxyProtocol(cfg *config.Config, summary Summary) string {
+	switch {
+	case !cfg.HasFunnelAccessControl():
+		return "not used"
+	case summary.Mode == ModeTSNet:
+		return "not needed; tsnet sees Funnel client IPs directly"
+	case cfg.UseFunnelProxyProtocol():
+		return "used for Funnel traffic, so the allowlist sees real client IPs"
+	default:
+		return fmt.Sprintf("not used with set-path %s; client IPs come from serve headers", cfg.GetSetPath())
+	}
+}
+
+func explainWebUI(cfg *config.Config, summary Summary) string {
+	switch summary.WebUIReason {
+	case WebUIReasonDisabledByConfig:
+		return "disabled"
+	case WebUIReasonTSNetNotSupported:
+		return "not exposed with tsnet"
+	}
+	if cfg.UIPort != 0 {
+		return fmt.Sprintf("enabled on port %d", cfg.UIPort)
+	}
+	return "enabled on port 4040, or the next free port"
+}
+
+// BackendReason explains why portal uses a local daemon or tsnet.
+func BackendReason(cfg *config.Config, daemonAvailable bool) string {
+	switch {
+	case cfg.ForceTsnet:
+		return BackendReasonForceTSNet
+	case strings.TrimSpace(cfg.AuthKey) != "":
+		return BackendReasonAuthKey
+	case daemonAvailable:
+		return BackendReasonDaemonRunning
+	default:
+		return BackendReasonDaemonMissing
+	}
+}
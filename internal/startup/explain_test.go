@@ -0,0 +1,74 @@
+package startup
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/jaxxstorm/portal/internal/access"
+	"github.com/jaxxstorm/portal/internal/config"
+)
+
+func TestExplainDescribesOperatingMode(t *testing.T) {
+	entry, err := access.ParseEntry("203.0.113.0/24")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name           string
+		cfg            *config.Config
+		useLocalDaemon bool
+		want           []string
+	}{
+		{
+			name:           "local daemon funnel with allowlist",
+			cfg:            &config.Config{Port: 8080, Funnel: true, UseHTTPS: true, FunnelAllowlist: []access.Entry{entry}},
+			useLocalDaemon: true,
+			want: []string{
+				"Backend:         local tailscaled, through tailscale serve (local tailscaled is running)",
+				"Exposure:        public internet through Funnel, and tailnet",
+				"Listen mode:     listener",
+				"Serve:           https on port 443, path /",
+				"PROXY protocol:  used for Funnel traffic",
+				"Web UI:          enabled on port 4040",
+			},
+		},
+		{
+			name: "tsnet service mode",
+			cfg:  &config.Config{Port: 8080, ForceTsnet: true, TailscaleName: "portal", TSNetListenMode: config.TSNetListenModeService, TSNetServiceName: "svc:web"},
+			want: []string{
+				`Backend:         tsnet, as device "portal" (force-tsnet is set)`,
+				"Exposure:        tailnet only",
+				"Listen mode:     service (svc:web)",
+				"Serve:           http on port 8080 of service svc:web, path /",
+				"PROXY protocol:  not used",
+				"Web UI:          not exposed with tsnet",
+			},
+		},
+		{
+			name:           "local daemon service mode",
+			cfg:            &config.Config{Port: 8080, UseHTTPS: true, TSNetListenMode: config.TSNetListenModeService, TSNetServiceName: "svc:web"},
+			useLocalDaemon: true,
+			want: []string{
+				"Backend:         local tailscaled, through tailscale serve (local tailscaled is running)",
+				"Listen mode:     service (svc:web)",
+				"Serve:           https on port 443 of service svc:web, path /",
+			},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			var out bytes.Buffer
+			if err := Explain(&out, tc.cfg, tc.useLocalDaemon, BackendReason(tc.cfg, tc.useLocalDaemon)); err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+			for _, line := range tc.want {
+				if !strings.Contains(out.String(), line) {
+					t.Fatalf("expected %q in output:\n%s", line, out.String())
+				}
+			}
+		})
+	}
+}
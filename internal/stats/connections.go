@@ -0,0 +1,174 @@
+package stats
+
+import (
+	"net"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/jaxxstorm/portal/internal/model"
+)
+
+// maxReportedPeers caps the peers returned by ConnectionTracker.Snapshot.
+const maxReportedPeers = 20
+
+type trackedConn struct {
+	peer     string
+	state    http.ConnState
+	opened   time.Time
+	requests int
+}
+
+// ConnectionTracker follows TCP connections through http.Server.ConnState so
+// keep-alive reuse, idle connections and slow clients show up separately from
+// request counts.
+type ConnectionTracker struct {
+	conns    map[net.Conn]*trackedConn
+	accepted int
+	closed   int
+	hijacked int
+	requests int
+	now      func() time.Time
+	mu       sync.Mutex
+}
+
+// NewConnectionTracker creates an empty connection tracker.
+func NewConnectionTracker() *ConnectionTracker {
+	return &ConnectionTracker{
+		conns: make(map[net.Conn]*trackedConn),
+		now:   time.Now,
+	}
+}
+
+// ConnState records a connection state change. It has the signature of
+// http.Server.ConnState and attributes the connection to its remote address.
+func (c *ConnectionTracker) ConnState(conn net.Conn, state http.ConnState) {
+	c.Observe(conn, "", state)
+}
+
+// Observe records a connection state change. peer names the client holding
+// the connection and is only read for new connections; when empty the remote
+// address host is used.
+func (c *ConnectionTracker) Observe(conn net.Conn, peer string, state http.ConnState) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if state == http.StateNew {
+		if peer == "" {
+			peer = remoteHost(conn)
+		}
+		c.accepted++
+		c.conns[conn] = &trackedConn{peer: peer, state: state, opened: c.now()}
+		return
+	}
+
+	tracked, ok := c.conns[conn]
+	if !ok {
+		return
+	}
+
+	switch state {
+	case http.StateActive:
+		tracked.state = state
+		tracked.requests++
+		c.requests++
+	case http.StateIdle:
+		tracked.state = state
+	case http.StateHijacked:
+		// The server stops reporting hijacked connections, so their close
+		// is never seen; count them and stop tracking.
+		c.hijacked++
+		delete(c.conns, conn)
+	case http.StateClosed:
+		c.closed++
+		delete(c.conns, conn)
+	}
+}
+
+// Snapshot summarises open connections and totals since startup. Peers are
+// ordered by open connections, then by the age of their oldest connection.
+func (c *ConnectionTracker) Snapshot() model.ConnectionStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := c.now()
+	snapshot := model.ConnectionStats{
+		Open:     len(c.conns),
+		Accepted: c.accepted,
+		Closed:   c.closed,
+		Hijacked: c.hijacked,
+		Requests: c.requests,
+	}
+	if c.accepted > 0 {
+		snapshot.RequestsPerConnection = float64(c.requests) / float64(c.accepted)
+	}
+
+	peers := make(map[string]*model.ConnectionPeer)
+	var totalAge time.Duration
+	for _, tracked := range c.conns {
+		age := now.Sub(tracked.opened)
+		totalAge += age
+		if age.Seconds() > snapshot.MaxAge {
+			snapshot.MaxAge = age.Seconds()
+		}
+
+		peer := peers[tracked.peer]
+		if peer == nil {
+			peer = &model.ConnectionPeer{Address: tracked.peer}
+			peers[tracked.peer] = peer
+		}
+		peer.Open++
+		peer.Requests += tracked.requests
+		if age.Seconds() > peer.OldestAge {
+			peer.OldestAge = age.Seconds()
+		}
+
+		switch tracked.state {
+		case http.StateNew:
+			snapshot.New++
+			peer.New++
+		case http.StateActive:
+			snapshot.Active++
+			peer.Active++
+		case http.StateIdle:
+			snapshot.Idle++
+			peer.Idle++
+		}
+	}
+	if len(c.conns) > 0 {
+		snapshot.AvgAge = totalAge.Seconds() / float64(len(c.conns))
+	}
+
+	snapshot.Peers = make([]model.ConnectionPeer, 0, len(peers))
+	for _, peer := range peers {
+		snapshot.Peers = append(snapshot.Peers, *peer)
+	}
+	sort.Slice(snapshot.Peers, func(i, j int) bool {
+		a, b := snapshot.Peers[i], snapshot.Peers[j]
+		if a.Open != b.Open {
+			return a.Open > b.Open
+		}
+		if a.OldestAge != b.OldestAge {
+			return a.OldestAge > b.OldestAge
+		}
+		return a.Address < b.Address
+	})
+	if len(snapshot.Peers) > maxReportedPeers {
+		snapshot.Peers = snapshot.Peers[:maxReportedPeers]
+	}
+
+	return snapshot
+}
+
+func remoteHost(conn net.Conn) string {
+	addr := conn.RemoteAddr()
+	if addr == nil {
+		return "unknown"
+	}
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return addr.String()
+	}
+	return host
+}
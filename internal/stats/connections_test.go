@@ -0,0 +1,80 @@
+package stats
+
+import (
+	"net"
+	"net/http"
+	"testing"
+	"time"
+)
+
+type fakeConn struct {
+	net.Conn
+	remote string
+}
+
+func (c *fakeConn) RemoteAddr() net.Addr {
+	addr, _ := net.ResolveTCPAddr("tcp", c.remote)
+	return addr
+}
+
+func TestConnectionTrackerFollowsKeepAliveConnections(t *testing.T) {
+	clock := &fakeClock{now: time.Unix(1_700_000_000, 0)}
+	tracker := NewConnectionTracker()
+	tracker.now = clock.Now
+
+	reused := &fakeConn{remote: "100.64.0.7:50000"}
+	tracker.ConnState(reused, http.StateNew)
+	for i := 0; i < 3; i++ {
+		tracker.ConnState(reused, http.StateActive)
+		tracker.ConnState(reused, http.StateIdle)
+	}
+
+	clock.now = clock.now.Add(30 * time.Second)
+	slow := &fakeConn{remote: "100.64.0.7:50001"}
+	tracker.ConnState(slow, http.StateNew)
+	tracker.ConnState(slow, http.StateActive)
+
+	funnel := &fakeConn{remote: "100.100.1.1:443"}
+	tracker.Observe(funnel, "203.0.113.9", http.StateNew)
+	tracker.Observe(funnel, "", http.StateActive)
+	tracker.Observe(funnel, "", http.StateHijacked)
+
+	closed := &fakeConn{remote: "100.64.0.8:40000"}
+	tracker.ConnState(closed, http.StateNew)
+	tracker.ConnState(closed, http.StateActive)
+	tracker.ConnState(closed, http.StateClosed)
+
+	clock.now = clock.now.Add(10 * time.Second)
+	snapshot := tracker.Snapshot()
+
+	if snapshot.Open != 2 || snapshot.Active != 1 || snapshot.Idle != 1 {
+		t.Fatalf("expected 2 open (1 active, 1 idle), got %+v", snapshot)
+	}
+	if snapshot.Accepted != 4 || snapshot.Closed != 1 || snapshot.Hijacked != 1 || snapshot.Requests != 6 {
+		t.Fatalf("unexpected totals: %+v", snapshot)
+	}
+	if snapshot.RequestsPerConnection != 1.5 {
+		t.Fatalf("expected 1.5 requests per connection, got %.2f", snapshot.RequestsPerConnection)
+	}
+	if snapshot.MaxAge != 40 || snapshot.AvgAge != 25 {
+		t.Fatalf("expected max age 40s and average 25s, got max=%.1f avg=%.1f", snapshot.MaxAge, snapshot.AvgAge)
+	}
+
+	if len(snapshot.Peers) != 1 {
+		t.Fatalf("expected one peer holding connections, got %+v", snapshot.Peers)
+	}
+	peer := snapshot.Peers[0]
+	if peer.Address != "100.64.0.7" || peer.Open != 2 || peer.Requests != 4 || peer.OldestAge != 40 {
+		t.Fatalf("unexpected peer: %+v", peer)
+	}
+}
+
+func TestConnectionTrackerIgnoresUnknownConnections(t *testing.T) {
+	tracker := NewConnectionTracker()
+	tracker.ConnState(&fakeConn{remote: "127.0.0.1:1"}, http.StateClosed)
+
+	snapshot := tracker.Snapshot()
+	if snapshot.Open != 0 || snapshot.Closed != 0 {
+		t.Fatalf("expected untracked close to be ignored, got %+v", snapshot)
+	}
+}
@@ -0,0 +1,127 @@
+package stats
+
+import (
+	"math"
+	"time"
+)
+
+const (
+	// histogramGamma sets the growth factor between bucket bounds; quantile
+	// estimates are within about 1% of the true value.
+	histogramGamma = 1.02
+	// histogramBuckets covers 1µs to several hours; slower requests share the
+	// last bucket and are still reported exactly by Max.
+	histogramBuckets = 1200
+)
+
+var histogramLogGamma = math.Log(histogramGamma)
+
+// Histogram is a fixed-size latency histogram with logarithmic buckets.
+// Histograms merge by adding counts, so windows can be assembled from
+// per-interval histograms at a cost independent of request volume.
+type Histogram struct {
+	counts [histogramBuckets]uint32
+	count  uint64
+	sum    time.Duration
+	max    time.Duration
+}
+
+// Record adds a duration to the histogram.
+func (h *Histogram) Record(d time.Duration) {
+	if d < 0 {
+		d = 0
+	}
+	h.counts[histogramBucket(d)]++
+	h.count++
+	h.sum += d
+	if d > h.max {
+		h.max = d
+	}
+}
+
+// Merge adds all observations from other into h.
+func (h *Histogram) Merge(other *Histogram) {
+	if other == nil || other.count == 0 {
+		return
+	}
+	for i, c := range other.counts {
+		h.counts[i] += c
+	}
+	h.count += other.count
+	h.sum += other.sum
+	if other.max > h.max {
+		h.max = other.max
+	}
+}
+
+// Reset clears all observations.
+func (h *Histogram) Reset() {
+	*h = Histogram{}
+}
+
+// Count returns the number of recorded durations.
+func (h *Histogram) Count() uint64 {
+	return h.count
+}
+
+// Max returns the largest recorded duration.
+func (h *Histogram) Max() time.Duration {
+	return h.max
+}
+
+// Mean returns the average recorded duration.
+func (h *Histogram) Mean() time.Duration {
+	if h.count == 0 {
+		return 0
+	}
+	return h.sum / time.Duration(h.count)
+}
+
+// Quantile estimates the duration at quantile q (0 < q <= 1).
+func (h *Histogram) Quantile(q float64) time.Duration {
+	if h.count == 0 {
+		return 0
+	}
+	if q >= 1 {
+		return h.max
+	}
+
+	rank := uint64(math.Ceil(q * float64(h.count)))
+	if rank == 0 {
+		rank = 1
+	}
+
+	var seen uint64
+	for i, c := range h.counts {
+		seen += uint64(c)
+		if seen >= rank {
+			if value := histogramValue(i); value < h.max {
+				return value
+			}
+			return h.max
+		}
+	}
+	return h.max
+}
+
+func histogramBucket(d time.Duration) int {
+	micros := float64(d) / float64(time.Microsecond)
+	if micros <= 1 {
+		return 0
+	}
+	index := int(math.Ceil(math.Log(micros) / histogramLogGamma))
+	if index >= histogramBuckets {
+		return histogramBuckets - 1
+	}
+	return index
+}
+
+// histogramValue returns the representative duration for a bucket, chosen to
+// minimise relative error across the bucket's range.
+func histogramValue(index int) time.Duration {
+	if index == 0 {
+		return time.Microsecond
+	}
+	upper := math.Pow(histogramGamma, float64(index))
+	return time.Duration(2 * upper / (histogramGamma + 1) * float64(time.Microsecond))
+}
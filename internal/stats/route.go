@@ -0,0 +1,150 @@
+package stats
+
+import (
+	"strings"
+)
+
+// RoutePlaceholder replaces path segments that look like identifiers.
+const RoutePlaceholder = "{id}"
+
+// RouteTemplate collapses identifier-like path segments (numbers, UUIDs and
+// hex hashes) so requests for the same endpoint share a route, for example
+// /users/123 becomes /users/{id}. Query strings are not part of the route.
+func RouteTemplate(path string) string {
+	path, _, _ = strings.Cut(path, "?")
+	if path == "" || path == "/" {
+		return "/"
+	}
+
+	segments := strings.Split(path, "/")
+	for i, segment := range segments {
+		if isIdentifierSegment(segment) {
+			segments[i] = RoutePlaceholder
+		}
+	}
+	return strings.Join(segments, "/")
+}
+
+func isIdentifierSegment(segment string) bool {
+	switch {
+	case segment == "":
+		return false
+	case isDigits(segment):
+		return true
+	case isUUID(segment):
+		return true
+	case len(segment) >= 16 && isHex(segment):
+		return true
+	default:
+		return false
+	}
+}
+
+func isDigits(value string) bool {
+	for i := 0; i < len(value); i++ {
+		if value[i] < '0' || value[i] > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+func isHex(value string) bool {
+	for i := 0; i < len(value); i++ {
+		c := value[i]
+		if !(c >= '0' && c <= '9') && !(c >= 'a' && c <= 'f') && !(c >= 'A' && c <= 'F') {
+			return false
+		}
+	}
+	return true
+}
+
+func isUUID(value string) bool {
+	if len(value) != 36 {
+		return false
+	}
+	for i := 0; i < len(value); i++ {
+		switch i {
+		case 8, 13, 18, 23:
+			if value[i] != '-' {
+				return false
+			}
+		default:
+			if !isHex(value[i : i+1]) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// RouteTable maps request paths to route templates. Configured patterns are
+// tried in order; paths that match none fall back to RouteTemplate.
+//
+// Pattern segments are literals, a parameter such as {id} or :id matching any
+// single segment, or a trailing * matching the rest of the path.
+type RouteTable struct {
+	patterns []routePattern
+}
+
+type routePattern struct {
+	template string
+	segments []string
+}
+
+// NewRouteTable builds a route table from configured patterns. Blank
+// patterns are ignored.
+func NewRouteTable(patterns []string) *RouteTable {
+	table := &RouteTable{}
+	for _, pattern := range patterns {
+		pattern = strings.TrimSpace(pattern)
+		if pattern == "" {
+			continue
+		}
+		table.patterns = append(table.patterns, routePattern{
+			template: pattern,
+			segments: strings.Split(strings.Trim(pattern, "/"), "/"),
+		})
+	}
+	return table
+}
+
+// Route returns the route template for a request path.
+func (t *RouteTable) Route(path string) string {
+	if t != nil {
+		path, _, _ := strings.Cut(path, "?")
+		segments := strings.Split(strings.Trim(path, "/"), "/")
+		for _, pattern := range t.patterns {
+			if pattern.matches(segments) {
+				return pattern.template
+			}
+		}
+	}
+	return RouteTemplate(path)
+}
+
+func (p routePattern) matches(segments []string) bool {
+	for i, want := range p.segments {
+		if want == "*" && i == len(p.segments)-1 {
+			return true
+		}
+		if i >= len(segments) {
+			return false
+		}
+		if isRouteParameter(want) {
+			if segments[i] == "" {
+				return false
+			}
+			continue
+		}
+		if want != segments[i] {
+			return false
+		}
+	}
+	return len(segments) == len(p.segments)
+}
+
+func isRouteParameter(segment string) bool {
+	return (strings.HasPrefix(segment, "{") && strings.HasSuffix(segment, "}") && len(segment) > 2) ||
+		(strings.HasPrefix(segment, ":") && len(segment) > 1)
+}
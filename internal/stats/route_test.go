@@ -0,0 +1,102 @@
+package stats
+
+import (
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestRouteTemplateCollapsesIdentifiers(t *testing.T) {
+	for path, want := range map[string]string{
+		"":                 "/",
+		"/":                "/",
+		"/users":           "/users",
+		"/users/123":       "/users/{id}",
+		"/users/123/posts": "/users/{id}/posts",
+		"/orders/3f2504e0-4f89-11d3-9a0c-0305e82c3301": "/orders/{id}",
+		"/blobs/9b74c9897bac770ffc029102a200c5de":      "/blobs/{id}",
+		"/search?q=123": "/search",
+		"/v2/api":       "/v2/api",
+		"/files/cafe":   "/files/cafe",
+	} {
+		if got := RouteTemplate(path); got != want {
+			t.Fatalf("RouteTemplate(%q) = %q, want %q", path, got, want)
+		}
+	}
+}
+
+func TestRouteTablePrefersConfiguredRoutes(t *testing.T) {
+	table := NewRouteTable([]string{"/users/{user}/posts/:post", "/static/*", "/health"})
+
+	for path, want := range map[string]string{
+		"/users/alice/posts/hello": "/users/{user}/posts/:post",
+		"/static/css/site.css":     "/static/*",
+		"/static":                  "/static/*",
+		"/health":                  "/health",
+		"/health/live":             "/health/live",
+		"/users/42":                "/users/{id}",
+	} {
+		if got := table.Route(path); got != want {
+			t.Fatalf("Route(%q) = %q, want %q", path, got, want)
+		}
+	}
+
+	var empty *RouteTable
+	if got := empty.Route("/orders/7"); got != "/orders/{id}" {
+		t.Fatalf("expected nil table to template automatically, got %q", got)
+	}
+}
+
+func TestRouteStatsAggregateByMethodAndRoute(t *testing.T) {
+	tracker := NewTracker()
+	for _, sample := range []RouteSample{
+		{Method: "GET", Route: "/users/{id}", StatusCode: 200, Duration: 10 * time.Millisecond, ResponseBytes: 100},
+		{Method: "GET", Route: "/users/{id}", StatusCode: 404, Duration: 20 * time.Millisecond, ResponseBytes: 50},
+		{Method: "GET", Route: "/users/{id}", StatusCode: 200, Duration: 30 * time.Millisecond, ResponseBytes: 100},
+		{Method: "POST", Route: "/users", StatusCode: 503, Duration: 900 * time.Millisecond, RequestBytes: 2048},
+	} {
+		tracker.AddRouteRequest(sample)
+	}
+
+	routes := tracker.GetRouteStats()
+	if len(routes) != 2 {
+		t.Fatalf("expected 2 routes, got %+v", routes)
+	}
+	users := routes[0]
+	if users.Method != "GET" || users.Route != "/users/{id}" || users.Count != 3 || users.ClientErrors != 1 || users.ResponseBytes != 250 {
+		t.Fatalf("unexpected busiest route: %+v", users)
+	}
+	if users.ErrorRate < 33.3 || users.ErrorRate > 33.4 || users.Max != 30 {
+		t.Fatalf("unexpected error rate or max: %+v", users)
+	}
+
+	if err := SortRouteStats(routes, RouteSortP95); err != nil || routes[0].Route != "/users" {
+		t.Fatalf("expected slowest route first, got %+v (%v)", routes, err)
+	}
+	if err := SortRouteStats(routes, RouteSortRoute); err != nil || routes[0].Route != "/users" {
+		t.Fatalf("expected alphabetical order, got %+v (%v)", routes, err)
+	}
+	if err := SortRouteStats(routes, "latency"); err == nil {
+		t.Fatal("expected unknown sort key to fail")
+	}
+
+	tracker.Reset()
+	if routes := tracker.GetRouteStats(); len(routes) != 0 {
+		t.Fatalf("expected reset to clear routes, got %+v", routes)
+	}
+}
+
+func TestRouteStatsFoldOverflowRoutes(t *testing.T) {
+	tracker := NewTracker()
+	for i := 0; i < maxTrackedRoutes+5; i++ {
+		tracker.AddRouteRequest(RouteSample{Method: "GET", Route: "/r/" + strconv.Itoa(i) + "x", StatusCode: 200})
+	}
+
+	routes := tracker.GetRouteStats()
+	if len(routes) != maxTrackedRoutes+1 {
+		t.Fatalf("expected %d tracked routes, got %d", maxTrackedRoutes+1, len(routes))
+	}
+	if routes[0].Route != OverflowRoute || routes[0].Count != 5 {
+		t.Fatalf("expected overflow bucket to collect extra routes, got %+v", routes[0])
+	}
+}
@@ -0,0 +1,159 @@
+package stats
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/jaxxstorm/portal/internal/model"
+)
+
+const (
+	// maxTrackedRoutes bounds memory when paths defeat templating; further
+	// routes are folded into OverflowRoute.
+	maxTrackedRoutes = 500
+
+	// OverflowRoute collects requests once maxTrackedRoutes is reached.
+	OverflowRoute = "{other}"
+)
+
+// Route sort keys accepted by SortRouteStats.
+const (
+	RouteSortCount  = "count"
+	RouteSortErrors = "errors"
+	RouteSortP95    = "p95"
+	RouteSortBytes  = "bytes"
+	RouteSortRoute  = "route"
+)
+
+// RouteSortKeys lists the supported sort keys in display order.
+var RouteSortKeys = []string{RouteSortCount, RouteSortErrors, RouteSortP95, RouteSortBytes, RouteSortRoute}
+
+// RouteSample describes a completed request for per-route statistics.
+type RouteSample struct {
+	Method        string
+	Route         string
+	StatusCode    int
+	Duration      time.Duration
+	RequestBytes  int64
+	ResponseBytes int64
+}
+
+type routeKey struct {
+	method string
+	route  string
+}
+
+type routeEntry struct {
+	hist          Histogram
+	clientErrors  int
+	serverErrors  int
+	requestBytes  int64
+	responseBytes int64
+}
+
+// AddRouteRequest records a request against its method and route.
+func (t *Tracker) AddRouteRequest(sample RouteSample) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.routes == nil {
+		t.routes = make(map[routeKey]*routeEntry)
+	}
+
+	key := routeKey{method: sample.Method, route: sample.Route}
+	entry, ok := t.routes[key]
+	if !ok {
+		if len(t.routes) >= maxTrackedRoutes {
+			key.route = OverflowRoute
+			entry = t.routes[key]
+		}
+		if entry == nil {
+			entry = &routeEntry{}
+			t.routes[key] = entry
+		}
+	}
+
+	entry.hist.Record(sample.Duration)
+	switch {
+	case sample.StatusCode >= 500:
+		entry.serverErrors++
+	case sample.StatusCode >= 400:
+		entry.clientErrors++
+	}
+	if sample.RequestBytes > 0 {
+		entry.requestBytes += sample.RequestBytes
+	}
+	if sample.ResponseBytes > 0 {
+		entry.responseBytes += sample.ResponseBytes
+	}
+}
+
+// GetRouteStats returns statistics for every tracked route, busiest first.
+func (t *Tracker) GetRouteStats() []model.RouteStats {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	routes := make([]model.RouteStats, 0, len(t.routes))
+	for key, entry := range t.routes {
+		count := int(entry.hist.Count())
+		stats := model.RouteStats{
+			Method:        key.method,
+			Route:         key.route,
+			Count:         count,
+			ClientErrors:  entry.clientErrors,
+			ServerErrors:  entry.serverErrors,
+			Avg:           durationMs(entry.hist.Mean()),
+			P50:           durationMs(entry.hist.Quantile(0.50)),
+			P90:           durationMs(entry.hist.Quantile(0.90)),
+			P95:           durationMs(entry.hist.Quantile(0.95)),
+			P99:           durationMs(entry.hist.Quantile(0.99)),
+			Max:           durationMs(entry.hist.Max()),
+			RequestBytes:  entry.requestBytes,
+			ResponseBytes: entry.responseBytes,
+		}
+		if count > 0 {
+			stats.ErrorRate = float64(entry.clientErrors+entry.serverErrors) / float64(count) * 100
+		}
+		routes = append(routes, stats)
+	}
+
+	SortRouteStats(routes, RouteSortCount)
+	return routes
+}
+
+// SortRouteStats sorts routes in place by key, largest first for numeric
+// keys and alphabetically for RouteSortRoute. Ties fall back to route and
+// method so the order is stable between refreshes.
+func SortRouteStats(routes []model.RouteStats, key string) error {
+	var less func(a, b model.RouteStats) (bool, bool)
+	switch strings.ToLower(strings.TrimSpace(key)) {
+	case "", RouteSortCount:
+		less = func(a, b model.RouteStats) (bool, bool) { return a.Count > b.Count, a.Count != b.Count }
+	case RouteSortErrors:
+		less = func(a, b model.RouteStats) (bool, bool) { return a.ErrorRate > b.ErrorRate, a.ErrorRate != b.ErrorRate }
+	case RouteSortP95:
+		less = func(a, b model.RouteStats) (bool, bool) { return a.P95 > b.P95, a.P95 != b.P95 }
+	case RouteSortBytes:
+		less = func(a, b model.RouteStats) (bool, bool) {
+			aBytes, bBytes := a.RequestBytes+a.ResponseBytes, b.RequestBytes+b.ResponseBytes
+			return aBytes > bBytes, aBytes != bBytes
+		}
+	case RouteSortRoute:
+		less = func(a, b model.RouteStats) (bool, bool) { return false, false }
+	default:
+		return fmt.Errorf("invalid route sort %q: must be one of %s", key, strings.Join(RouteSortKeys, ", "))
+	}
+
+	sort.SliceStable(routes, func(i, j int) bool {
+		if result, decided := less(routes[i], routes[j]); decided {
+			return result
+		}
+		if routes[i].Route != routes[j].Route {
+			return routes[i].Route < routes[j].Route
+		}
+		return routes[i].Method < routes[j].Method
+	})
+	return nil
+}
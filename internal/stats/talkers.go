@@ -0,0 +1,214 @@
+package stats
+
+import (
+	"container/list"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/jaxxstorm/portal/internal/model"
+)
+
+const (
+	// maxTrackedTalkers bounds memory under scans from many addresses; the
+	// least recently seen source is dropped to make room.
+	maxTrackedTalkers = 1000
+
+	// maxTalkerPaths and maxTalkerUserAgents cap the distinct values kept per
+	// source; further values are counted under OverflowRoute.
+	maxTalkerPaths      = 50
+	maxTalkerUserAgents = 10
+
+	// UnresolvedSource groups requests whose source IP could not be resolved.
+	UnresolvedSource = "unresolved"
+)
+
+// Talker sort keys accepted by SortTalkers.
+const (
+	TalkerSortRequests = "requests"
+	TalkerSortErrors   = "errors"
+	TalkerSortDenied   = "denied"
+	TalkerSortRecent   = "recent"
+)
+
+// TalkerSortKeys lists the supported sort keys in display order.
+var TalkerSortKeys = []string{TalkerSortRequests, TalkerSortErrors, TalkerSortDenied, TalkerSortRecent}
+
+// Allowlist decisions carried by SourceSample.
+const (
+	DecisionAllow = "allow"
+	DecisionDeny  = "deny"
+)
+
+// SourceSample describes a completed request for per-source statistics.
+type SourceSample struct {
+	IP           string // empty when the source could not be resolved
+	SourceSignal string
+	Path         string
+	UserAgent    string
+	StatusCode   int
+	Decision     string // DecisionAllow, DecisionDeny, or empty when no allowlist applied
+}
+
+type talkerEntry struct {
+	ip           string
+	sourceSignal string
+	requests     int
+	clientErrors int
+	serverErrors int
+	allowed      int
+	denied       int
+	firstSeen    time.Time
+	lastSeen     time.Time
+	paths        map[string]int
+	userAgents   map[string]int
+}
+
+// AddSourceRequest records a request against its source IP.
+func (t *Tracker) AddSourceRequest(sample SourceSample) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.talkers == nil {
+		t.talkers = make(map[string]*list.Element)
+		t.talkerOrder = list.New()
+	}
+
+	ip := sample.IP
+	if ip == "" {
+		ip = UnresolvedSource
+	}
+	now := t.now()
+
+	var entry *talkerEntry
+	if elem, ok := t.talkers[ip]; ok {
+		entry = elem.Value.(*talkerEntry)
+		t.talkerOrder.MoveToFront(elem)
+	} else {
+		if len(t.talkers) >= maxTrackedTalkers {
+			t.evictOldestTalker()
+		}
+		entry = &talkerEntry{
+			ip:         ip,
+			firstSeen:  now,
+			paths:      make(map[string]int),
+			userAgents: make(map[string]int),
+		}
+		t.talkers[ip] = t.talkerOrder.PushFront(entry)
+	}
+
+	entry.sourceSignal = sample.SourceSignal
+	entry.requests++
+	entry.lastSeen = now
+	switch {
+	case sample.StatusCode >= 500:
+		entry.serverErrors++
+	case sample.StatusCode >= 400:
+		entry.clientErrors++
+	}
+	switch sample.Decision {
+	case DecisionAllow:
+		entry.allowed++
+	case DecisionDeny:
+		entry.denied++
+	}
+	countBounded(entry.paths, sample.Path, maxTalkerPaths)
+	countBounded(entry.userAgents, sample.UserAgent, maxTalkerUserAgents)
+}
+
+// GetTopTalkers returns statistics for every tracked source, busiest first.
+func (t *Tracker) GetTopTalkers() []model.Talker {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	talkers := make([]model.Talker, 0, len(t.talkers))
+	for _, elem := range t.talkers {
+		entry := elem.Value.(*talkerEntry)
+		talker := model.Talker{
+			IP:           entry.ip,
+			SourceSignal: entry.sourceSignal,
+			Requests:     entry.requests,
+			ClientErrors: entry.clientErrors,
+			ServerErrors: entry.serverErrors,
+			Allowed:      entry.allowed,
+			Denied:       entry.denied,
+			FirstSeen:    entry.firstSeen,
+			LastSeen:     entry.lastSeen,
+			Paths:        sortedCounts(entry.paths),
+			UserAgents:   sortedCounts(entry.userAgents),
+		}
+		if entry.requests > 0 {
+			talker.ErrorRate = float64(entry.clientErrors+entry.serverErrors) / float64(entry.requests) * 100
+		}
+		talkers = append(talkers, talker)
+	}
+
+	SortTalkers(talkers, TalkerSortRequests)
+	return talkers
+}
+
+// SortTalkers sorts talkers in place by key, largest or most recent first.
+// Ties fall back to IP so the order is stable between refreshes.
+func SortTalkers(talkers []model.Talker, key string) error {
+	var less func(a, b model.Talker) (bool, bool)
+	switch strings.ToLower(strings.TrimSpace(key)) {
+	case "", TalkerSortRequests:
+		less = func(a, b model.Talker) (bool, bool) { return a.Requests > b.Requests, a.Requests != b.Requests }
+	case TalkerSortErrors:
+		less = func(a, b model.Talker) (bool, bool) { return a.ErrorRate > b.ErrorRate, a.ErrorRate != b.ErrorRate }
+	case TalkerSortDenied:
+		less = func(a, b model.Talker) (bool, bool) { return a.Denied > b.Denied, a.Denied != b.Denied }
+	case TalkerSortRecent:
+		less = func(a, b model.Talker) (bool, bool) {
+			return a.LastSeen.After(b.LastSeen), !a.LastSeen.Equal(b.LastSeen)
+		}
+	default:
+		return fmt.Errorf("invalid talker sort %q: must be one of %s", key, strings.Join(TalkerSortKeys, ", "))
+	}
+
+	sort.SliceStable(talkers, func(i, j int) bool {
+		if result, decided := less(talkers[i], talkers[j]); decided {
+			return result
+		}
+		return talkers[i].IP < talkers[j].IP
+	})
+	return nil
+}
+
+// evictOldestTalker drops the least recently seen source, at the back of
+// talkerOrder. Callers hold t.mu.
+func (t *Tracker) evictOldestTalker() {
+	oldest := t.talkerOrder.Back()
+	if oldest == nil {
+		return
+	}
+	t.talkerOrder.Remove(oldest)
+	delete(t.talkers, oldest.Value.(*talkerEntry).ip)
+}
+
+// countBounded increments value in counts, folding new values into
+// OverflowRoute once limit distinct values are held.
+func countBounded(counts map[string]int, value string, limit int) {
+	if value == "" {
+		return
+	}
+	if _, ok := counts[value]; !ok && len(counts) >= limit {
+		value = OverflowRoute
+	}
+	counts[value]++
+}
+
+func sortedCounts(counts map[string]int) []model.TalkerCount {
+	sorted := make([]model.TalkerCount, 0, len(counts))
+	for value, count := range counts {
+		sorted = append(sorted, model.TalkerCount{Value: value, Count: count})
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		if sorted[i].Count != sorted[j].Count {
+			return sorted[i].Count > sorted[j].Count
+		}
+		return sorted[i].Value < sorted[j].Value
+	})
+	return sorted
+}
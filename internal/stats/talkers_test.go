@@ -0,0 +1,92 @@
+package stats
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestTrackerAggregatesSources(t *testing.T) {
+	tracker, clock := newTestTracker()
+	start := clock.now
+
+	tracker.AddSourceRequest(SourceSample{IP: "203.0.113.9", SourceSignal: "x_forwarded_for", Path: "/.env", UserAgent: "zgrab/0.x", StatusCode: 404, Decision: DecisionDeny})
+	clock.now = clock.now.Add(time.Second)
+	tracker.AddSourceRequest(SourceSample{IP: "203.0.113.9", SourceSignal: "tailscale_client_ip", Path: "/.env", UserAgent: "zgrab/0.x", StatusCode: 404, Decision: DecisionDeny})
+	tracker.AddSourceRequest(SourceSample{IP: "203.0.113.9", SourceSignal: "tailscale_client_ip", Path: "/wp-login.php", UserAgent: "curl/8.0", StatusCode: 200, Decision: DecisionAllow})
+	tracker.AddSourceRequest(SourceSample{SourceSignal: "unresolved", Path: "/", StatusCode: 403, Decision: DecisionDeny})
+
+	talkers := tracker.GetTopTalkers()
+	if len(talkers) != 2 {
+		t.Fatalf("expected 2 sources, got %+v", talkers)
+	}
+
+	scanner := talkers[0]
+	if scanner.IP != "203.0.113.9" || scanner.Requests != 3 || scanner.ClientErrors != 2 || scanner.Allowed != 1 || scanner.Denied != 2 {
+		t.Fatalf("unexpected scanner stats: %+v", scanner)
+	}
+	if scanner.SourceSignal != "tailscale_client_ip" {
+		t.Fatalf("expected latest source signal, got %q", scanner.SourceSignal)
+	}
+	assertWithin(t, "error rate", scanner.ErrorRate, 66.67, 0.01)
+	if !scanner.FirstSeen.Equal(start) || !scanner.LastSeen.Equal(start.Add(time.Second)) {
+		t.Fatalf("unexpected first/last seen: %s %s", scanner.FirstSeen, scanner.LastSeen)
+	}
+	if len(scanner.Paths) != 2 || scanner.Paths[0].Value != "/.env" || scanner.Paths[0].Count != 2 {
+		t.Fatalf("expected /.env as the top path, got %+v", scanner.Paths)
+	}
+	if len(scanner.UserAgents) != 2 || scanner.UserAgents[0].Value != "zgrab/0.x" {
+		t.Fatalf("expected zgrab as the top user agent, got %+v", scanner.UserAgents)
+	}
+
+	if talkers[1].IP != UnresolvedSource || talkers[1].Denied != 1 {
+		t.Fatalf("expected unresolved requests grouped together, got %+v", talkers[1])
+	}
+
+	tracker.Reset()
+	if talkers := tracker.GetTopTalkers(); len(talkers) != 0 {
+		t.Fatalf("expected reset to clear sources, got %+v", talkers)
+	}
+}
+
+func TestTrackerBoundsSourcesAndPaths(t *testing.T) {
+	tracker, clock := newTestTracker()
+
+	for i := 0; i < maxTrackedTalkers+1; i++ {
+		clock.now = clock.now.Add(time.Millisecond)
+		tracker.AddSourceRequest(SourceSample{IP: fmt.Sprintf("10.0.%d.%d", i/256, i%256), Path: "/"})
+	}
+	talkers := tracker.GetTopTalkers()
+	if len(talkers) != maxTrackedTalkers {
+		t.Fatalf("expected %d sources, got %d", maxTrackedTalkers, len(talkers))
+	}
+	for _, talker := range talkers {
+		if talker.IP == "10.0.0.0" {
+			t.Fatal("expected least recently seen source to be evicted")
+		}
+	}
+
+	// Seeing the oldest source again moves it to the front.
+	tracker.AddSourceRequest(SourceSample{IP: "10.0.0.1", Path: "/"})
+	tracker.AddSourceRequest(SourceSample{IP: "192.0.2.1", Path: "/"})
+	seen := map[string]bool{}
+	for _, talker := range tracker.GetTopTalkers() {
+		seen[talker.IP] = true
+	}
+	if !seen["10.0.0.1"] || seen["10.0.0.2"] || !seen["192.0.2.1"] {
+		t.Fatal("expected the least recently seen source to be evicted, not the oldest first seen")
+	}
+
+	for i := 0; i < maxTalkerPaths+5; i++ {
+		tracker.AddSourceRequest(SourceSample{IP: "198.51.100.1", Path: fmt.Sprintf("/probe/%d", i)})
+	}
+	talkers = tracker.GetTopTalkers()
+	paths := talkers[0].Paths
+	if talkers[0].IP != "198.51.100.1" || len(paths) != maxTalkerPaths+1 || paths[0].Value != OverflowRoute || paths[0].Count != 5 {
+		t.Fatalf("expected extra paths folded into %s, got %d paths led by %+v", OverflowRoute, len(paths), paths[0])
+	}
+
+	if err := SortTalkers(talkers, "loudest"); err == nil {
+		t.Fatal("expected invalid sort key to fail")
+	}
+}
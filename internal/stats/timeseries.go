@@ -0,0 +1,166 @@
+package stats
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/jaxxstorm/portal/internal/model"
+)
+
+const (
+	// TimeSeriesRetention is how far back the time series reaches.
+	TimeSeriesRetention = time.Hour
+
+	seriesResolution = time.Second
+	seriesSlotCount  = int(TimeSeriesRetention / seriesResolution)
+)
+
+// seriesSlot holds the requests completed during one second. Latencies are
+// kept as a sparse histogram because most seconds touch only a handful of
+// buckets, which keeps an hour of history small.
+type seriesSlot struct {
+	second       int64
+	requests     int
+	clientErrors int
+	serverErrors int
+	bytes        int64
+	latency      []sparseBucket
+	maxLatency   time.Duration
+}
+
+type sparseBucket struct {
+	index uint16
+	count uint32
+}
+
+// TimeSeries stores per-second request counts, errors, bytes and latency for
+// the last hour.
+type TimeSeries struct {
+	slots [seriesSlotCount]seriesSlot
+	now   func() time.Time
+	mu    sync.RWMutex
+}
+
+// NewTimeSeries creates an empty time series.
+func NewTimeSeries() *TimeSeries {
+	return &TimeSeries{now: time.Now}
+}
+
+// Record adds a completed request to the current second.
+func (ts *TimeSeries) Record(sample RouteSample) {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	second := ts.now().Unix()
+	slot := &ts.slots[second%int64(seriesSlotCount)]
+	if slot.second != second {
+		*slot = seriesSlot{second: second, latency: slot.latency[:0]}
+	}
+
+	slot.requests++
+	switch {
+	case sample.StatusCode >= 500:
+		slot.serverErrors++
+	case sample.StatusCode >= 400:
+		slot.clientErrors++
+	}
+	if sample.RequestBytes > 0 {
+		slot.bytes += sample.RequestBytes
+	}
+	if sample.ResponseBytes > 0 {
+		slot.bytes += sample.ResponseBytes
+	}
+	if sample.Duration > slot.maxLatency {
+		slot.maxLatency = sample.Duration
+	}
+
+	index := uint16(histogramBucket(sample.Duration))
+	for i := range slot.latency {
+		if slot.latency[i].index == index {
+			slot.latency[i].count++
+			return
+		}
+	}
+	slot.latency = append(slot.latency, sparseBucket{index: index, count: 1})
+}
+
+// Query aggregates the last window into points of width step, oldest first.
+// Steps are aligned to multiples of step since the Unix epoch so points stay
+// stable between polls; the newest point covers the step in progress.
+func (ts *TimeSeries) Query(window, step time.Duration) ([]model.TimeSeriesPoint, error) {
+	if err := validateSeriesRange(window, step); err != nil {
+		return nil, err
+	}
+
+	ts.mu.RLock()
+	defer ts.mu.RUnlock()
+
+	stepSeconds := int64(step / time.Second)
+	count := int((window + step - 1) / step)
+	now := ts.now().Unix()
+	newest := now - now%stepSeconds
+	oldestAllowed := now - int64(seriesSlotCount) + 1
+
+	points := make([]model.TimeSeriesPoint, count)
+	var merged Histogram
+	for i := range points {
+		start := newest - int64(count-1-i)*stepSeconds
+		point := model.TimeSeriesPoint{Timestamp: time.Unix(start, 0).UTC()}
+
+		merged.Reset()
+		for second := start; second < start+stepSeconds && second <= now; second++ {
+			if second < oldestAllowed {
+				continue
+			}
+			slot := &ts.slots[second%int64(seriesSlotCount)]
+			if slot.second != second || slot.requests == 0 {
+				continue
+			}
+			point.Requests += slot.requests
+			point.ClientErrors += slot.clientErrors
+			point.ServerErrors += slot.serverErrors
+			point.Bytes += slot.bytes
+			merged.mergeSparse(slot.latency, slot.maxLatency)
+		}
+		point.P50 = durationMs(merged.Quantile(0.50))
+		point.P95 = durationMs(merged.Quantile(0.95))
+		points[i] = point
+	}
+
+	return points, nil
+}
+
+// Reset clears all recorded data.
+func (ts *TimeSeries) Reset() {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	for i := range ts.slots {
+		ts.slots[i] = seriesSlot{}
+	}
+}
+
+func validateSeriesRange(window, step time.Duration) error {
+	switch {
+	case window < seriesResolution || window > TimeSeriesRetention:
+		return fmt.Errorf("invalid window %s: must be between %s and %s", window, seriesResolution, TimeSeriesRetention)
+	case step < seriesResolution || step > window:
+		return fmt.Errorf("invalid step %s: must be between %s and the window", step, seriesResolution)
+	case step%seriesResolution != 0:
+		return fmt.Errorf("invalid step %s: must be a whole number of seconds", step)
+	}
+	return nil
+}
+
+// mergeSparse adds sparse bucket counts into h. Only counts and max are
+// merged, which is all Quantile needs.
+func (h *Histogram) mergeSparse(buckets []sparseBucket, max time.Duration) {
+	for _, bucket := range buckets {
+		h.counts[bucket.index] += bucket.count
+		h.count += uint64(bucket.count)
+	}
+	if max > h.max {
+		h.max = max
+	}
+}
@@ -0,0 +1,74 @@
+package stats
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTimeSeriesBucketsBySecondAndStep(t *testing.T) {
+	clock := &fakeClock{now: time.Unix(1_700_000_000, 0)}
+	series := NewTimeSeries()
+	series.now = clock.Now
+
+	series.Record(RouteSample{StatusCode: 200, Duration: 10 * time.Millisecond, ResponseBytes: 100})
+	series.Record(RouteSample{StatusCode: 404, Duration: 20 * time.Millisecond, RequestBytes: 10})
+	clock.now = clock.now.Add(25 * time.Second)
+	series.Record(RouteSample{StatusCode: 503, Duration: 300 * time.Millisecond})
+
+	points, err := series.Query(time.Minute, 10*time.Second)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if len(points) != 6 {
+		t.Fatalf("expected 6 points, got %d", len(points))
+	}
+
+	last := points[len(points)-1]
+	if last.Timestamp.Unix()%10 != 0 || last.Requests != 1 || last.ServerErrors != 1 {
+		t.Fatalf("unexpected newest point: %+v", last)
+	}
+	assertWithin(t, "newest p95", last.P95, 300, 0.02)
+
+	// The clock starts on a step boundary, so the first requests landed two
+	// steps before the newest.
+	point := points[len(points)-3]
+	if point.Requests != 2 || point.ClientErrors != 1 || point.Bytes != 110 {
+		t.Fatalf("unexpected counts: %+v", point)
+	}
+	assertWithin(t, "p50", point.P50, 10, 0.02)
+	assertWithin(t, "p95", point.P95, 20, 0.02)
+}
+
+func TestTimeSeriesExpiresAfterRetention(t *testing.T) {
+	clock := &fakeClock{now: time.Unix(1_700_000_000, 0)}
+	series := NewTimeSeries()
+	series.now = clock.Now
+
+	series.Record(RouteSample{StatusCode: 200, Duration: time.Millisecond})
+	clock.now = clock.now.Add(TimeSeriesRetention)
+
+	points, err := series.Query(TimeSeriesRetention, time.Minute)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	for _, point := range points {
+		if point.Requests != 0 {
+			t.Fatalf("expected data older than the retention to expire, got %+v", point)
+		}
+	}
+}
+
+func TestTimeSeriesRejectsInvalidRanges(t *testing.T) {
+	series := NewTimeSeries()
+	for _, tc := range []struct{ window, step time.Duration }{
+		{2 * time.Hour, time.Minute},
+		{0, time.Second},
+		{time.Minute, 0},
+		{time.Minute, 2 * time.Minute},
+		{time.Minute, 1500 * time.Millisecond},
+	} {
+		if _, err := series.Query(tc.window, tc.step); err == nil {
+			t.Fatalf("expected window=%s step=%s to be rejected", tc.window, tc.step)
+		}
+	}
+}
@@ -2,18 +2,45 @@
 package stats
 
 import (
-	"sort"
+	"container/list"
 	"sync"
 	"time"
+
+	"github.com/jaxxstorm/portal/internal/model"
+)
+
+const (
+	// slotWidth is the resolution of the latency windows; each slot holds a
+	// histogram of the requests completed during that interval.
+	slotWidth = 5 * time.Second
+	slotCount = int(15 * time.Minute / slotWidth)
 )
 
+// latencyWindows are the trailing windows reported by GetLatencyWindows, in
+// increasing order of length.
+var latencyWindows = []struct {
+	name   string
+	length time.Duration
+}{
+	{"1m", time.Minute},
+	{"5m", 5 * time.Minute},
+	{"15m", 15 * time.Minute},
+}
+
+type latencySlot struct {
+	epoch int64
+	hist  *Histogram
+}
+
 // Tracker tracks connection statistics
 type Tracker struct {
 	TotalConnections int
 	OpenConnections  int
-	Durations        []time.Duration
-	ResponseTimes1m  []time.Duration
-	ResponseTimes5m  []time.Duration
+	slots            [slotCount]latencySlot
+	routes           map[routeKey]*routeEntry
+	talkers          map[string]*list.Element // values are *talkerEntry
+	talkerOrder      *list.List               // most recently seen first
+	now              func() time.Time
 	mu               sync.RWMutex
 }
 
@@ -22,11 +49,7 @@ type Stats = Tracker
 
 // NewTracker creates a new statistics tracker
 func NewTracker() *Tracker {
-	return &Tracker{
-		Durations:       make([]time.Duration, 0),
-		ResponseTimes1m: make([]time.Duration, 0),
-		ResponseTimes5m: make([]time.Duration, 0),
-	}
+	return &Tracker{now: time.Now}
 }
 
 // New is an alias for NewTracker to match the alternate interface
@@ -40,24 +63,18 @@ func (t *Tracker) AddRequest(duration time.Duration) {
 	defer t.mu.Unlock()
 
 	t.TotalConnections++
-	t.Durations = append(t.Durations, duration)
-
-	// Keep only last minute for rt1
-	t.ResponseTimes1m = append(t.ResponseTimes1m, duration)
-	if len(t.ResponseTimes1m) > 60 {
-		t.ResponseTimes1m = t.ResponseTimes1m[1:]
-	}
 
-	// Keep only last 5 minutes for rt5
-	t.ResponseTimes5m = append(t.ResponseTimes5m, duration)
-	if len(t.ResponseTimes5m) > 300 {
-		t.ResponseTimes5m = t.ResponseTimes5m[1:]
+	epoch := t.currentEpoch()
+	slot := &t.slots[epoch%int64(slotCount)]
+	if slot.hist == nil {
+		slot.hist = &Histogram{}
 	}
-
-	// Keep only last 1000 overall durations
-	if len(t.Durations) > 1000 {
-		t.Durations = t.Durations[1:]
+	if slot.epoch != epoch {
+		// The slot last held data from a full ring ago; reuse it.
+		slot.hist.Reset()
+		slot.epoch = epoch
 	}
+	slot.hist.Record(duration)
 }
 
 // Add is an alias for AddRequest to match the alternate interface
@@ -91,61 +108,80 @@ func (t *Tracker) DecOpen() {
 
 // GetStats returns current statistics
 // Returns: total connections, open connections, avg response time 1m, avg response time 5m, p50, p90 (all times in ms)
+// Percentiles cover the 15m window.
 func (t *Tracker) GetStats() (ttl, opn int, rt1, rt5, p50, p90 float64) {
-	t.mu.RLock()
-	defer t.mu.RUnlock()
+	windows := t.GetLatencyWindows()
 
+	t.mu.RLock()
 	ttl = t.TotalConnections
 	opn = t.OpenConnections
+	t.mu.RUnlock()
+
+	return ttl, opn, windows[0].Avg, windows[1].Avg, windows[2].P50, windows[2].P90
+}
+
+// GetLatencyWindows summarises latency over the trailing 1m, 5m and 15m
+// windows. Windows are aligned to 5 second slots, so each covers its length
+// to within one slot. The cost is fixed by the number of slots and histogram
+// buckets, not by traffic.
+func (t *Tracker) GetLatencyWindows() []model.LatencyWindow {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
 
-	// Calculate rt1 (average response time last minute)
-	if len(t.ResponseTimes1m) > 0 {
-		var sum time.Duration
-		for _, d := range t.ResponseTimes1m {
-			sum += d
+	current := t.currentEpoch()
+	summaries := make([]model.LatencyWindow, 0, len(latencyWindows))
+
+	// Windows nest, so a single pass from the newest slot backwards builds
+	// each window on top of the previous one.
+	var merged Histogram
+	next := 0
+	for age := 0; age < slotCount && next < len(latencyWindows); age++ {
+		slot := t.slots[(current-int64(age))%int64(slotCount)]
+		if slot.hist != nil && slot.epoch == current-int64(age) {
+			merged.Merge(slot.hist)
 		}
-		rt1 = float64(sum) / float64(len(t.ResponseTimes1m)) / float64(time.Millisecond)
-	}
 
-	// Calculate rt5 (average response time last 5 minutes)
-	if len(t.ResponseTimes5m) > 0 {
-		var sum time.Duration
-		for _, d := range t.ResponseTimes5m {
-			sum += d
+		window := latencyWindows[next]
+		if age+1 == int(window.length/slotWidth) {
+			summaries = append(summaries, summarizeWindow(window.name, &merged))
+			next++
 		}
-		rt5 = float64(sum) / float64(len(t.ResponseTimes5m)) / float64(time.Millisecond)
 	}
 
-	// Calculate percentiles from overall durations
-	if len(t.Durations) > 0 {
-		sorted := make([]time.Duration, len(t.Durations))
-		copy(sorted, t.Durations)
-		sort.Slice(sorted, func(i, j int) bool {
-			return sorted[i] < sorted[j]
-		})
-
-		p50Idx := len(sorted) * 50 / 100
-		p90Idx := len(sorted) * 90 / 100
+	return summaries
+}
 
-		if p50Idx < len(sorted) {
-			p50 = float64(sorted[p50Idx]) / float64(time.Millisecond)
-		}
-		if p90Idx < len(sorted) {
-			p90 = float64(sorted[p90Idx]) / float64(time.Millisecond)
-		}
+func summarizeWindow(name string, h *Histogram) model.LatencyWindow {
+	return model.LatencyWindow{
+		Window: name,
+		Count:  int(h.Count()),
+		Avg:    durationMs(h.Mean()),
+		P50:    durationMs(h.Quantile(0.50)),
+		P90:    durationMs(h.Quantile(0.90)),
+		P95:    durationMs(h.Quantile(0.95)),
+		P99:    durationMs(h.Quantile(0.99)),
+		Max:    durationMs(h.Max()),
 	}
+}
 
-	return
+func durationMs(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+// currentEpoch returns the index of the slot interval containing now.
+func (t *Tracker) currentEpoch() int64 {
+	return t.now().UnixNano() / int64(slotWidth)
 }
 
 // StatsSnapshot represents a snapshot of statistics
 type StatsSnapshot struct {
-	TotalConnections  int     `json:"total_connections"`
-	OpenConnections   int     `json:"open_connections"`
-	AvgResponseTime1m float64 `json:"avg_response_time_1m"`
-	AvgResponseTime5m float64 `json:"avg_response_time_5m"`
-	P50ResponseTime   float64 `json:"p50_response_time"`
-	P90ResponseTime   float64 `json:"p90_response_time"`
+	TotalConnections  int                   `json:"total_connections"`
+	OpenConnections   int                   `json:"open_connections"`
+	AvgResponseTime1m float64               `json:"avg_response_time_1m"`
+	AvgResponseTime5m float64               `json:"avg_response_time_5m"`
+	P50ResponseTime   float64               `json:"p50_response_time"`
+	P90ResponseTime   float64               `json:"p90_response_time"`
+	LatencyWindows    []model.LatencyWindow `json:"latency_windows"`
 }
 
 // Snapshot returns a snapshot of current statistics
@@ -159,6 +195,7 @@ func (t *Tracker) Snapshot() StatsSnapshot {
 		AvgResponseTime5m: rt5,
 		P50ResponseTime:   p50,
 		P90ResponseTime:   p90,
+		LatencyWindows:    t.GetLatencyWindows(),
 	}
 }
 
@@ -169,9 +206,12 @@ func (t *Tracker) Reset() {
 
 	t.TotalConnections = 0
 	t.OpenConnections = 0
-	t.Durations = t.Durations[:0]
-	t.ResponseTimes1m = t.ResponseTimes1m[:0]
-	t.ResponseTimes5m = t.ResponseTimes5m[:0]
+	for i := range t.slots {
+		t.slots[i] = latencySlot{}
+	}
+	t.routes = nil
+	t.talkers = nil
+	t.talkerOrder = nil
 }
 
 // GetConnectionCount returns the current connection counts
@@ -183,62 +223,12 @@ func (t *Tracker) GetConnectionCount() (total, open int) {
 
 // GetAverageResponseTimes returns average response times for different time windows
 func (t *Tracker) GetAverageResponseTimes() (rt1m, rt5m float64) {
-	t.mu.RLock()
-	defer t.mu.RUnlock()
-
-	// Calculate rt1 (average response time last minute)
-	if len(t.ResponseTimes1m) > 0 {
-		var sum time.Duration
-		for _, d := range t.ResponseTimes1m {
-			sum += d
-		}
-		rt1m = float64(sum) / float64(len(t.ResponseTimes1m)) / float64(time.Millisecond)
-	}
-
-	// Calculate rt5 (average response time last 5 minutes)
-	if len(t.ResponseTimes5m) > 0 {
-		var sum time.Duration
-		for _, d := range t.ResponseTimes5m {
-			sum += d
-		}
-		rt5m = float64(sum) / float64(len(t.ResponseTimes5m)) / float64(time.Millisecond)
-	}
-
-	return rt1m, rt5m
+	windows := t.GetLatencyWindows()
+	return windows[0].Avg, windows[1].Avg
 }
 
-// GetPercentiles returns response time percentiles
+// GetPercentiles returns response time percentiles over the 15m window
 func (t *Tracker) GetPercentiles() (p50, p90, p95, p99 float64) {
-	t.mu.RLock()
-	defer t.mu.RUnlock()
-
-	if len(t.Durations) == 0 {
-		return 0, 0, 0, 0
-	}
-
-	sorted := make([]time.Duration, len(t.Durations))
-	copy(sorted, t.Durations)
-	sort.Slice(sorted, func(i, j int) bool {
-		return sorted[i] < sorted[j]
-	})
-
-	p50Idx := len(sorted) * 50 / 100
-	p90Idx := len(sorted) * 90 / 100
-	p95Idx := len(sorted) * 95 / 100
-	p99Idx := len(sorted) * 99 / 100
-
-	if p50Idx < len(sorted) {
-		p50 = float64(sorted[p50Idx]) / float64(time.Millisecond)
-	}
-	if p90Idx < len(sorted) {
-		p90 = float64(sorted[p90Idx]) / float64(time.Millisecond)
-	}
-	if p95Idx < len(sorted) {
-		p95 = float64(sorted[p95Idx]) / float64(time.Millisecond)
-	}
-	if p99Idx < len(sorted) {
-		p99 = float64(sorted[p99Idx]) / float64(time.Millisecond)
-	}
-
-	return p50, p90, p95, p99
+	window := t.GetLatencyWindows()[2]
+	return window.P50, window.P90, window.P95, window.P99
 }
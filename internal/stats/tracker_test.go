@@ -0,0 +1,115 @@
+package stats
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+type fakeClock struct {
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	return c.now
+}
+
+func newTestTracker() (*Tracker, *fakeClock) {
+	clock := &fakeClock{now: time.Unix(1_700_000_000, 0)}
+	tracker := NewTracker()
+	tracker.now = clock.Now
+	return tracker, clock
+}
+
+func assertWithin(t *testing.T, name string, got, want, tolerance float64) {
+	t.Helper()
+	if math.Abs(got-want) > want*tolerance {
+		t.Fatalf("expected %s ≈ %.2f, got %.2f", name, want, got)
+	}
+}
+
+func TestHistogramQuantilesWithinRelativeError(t *testing.T) {
+	var h Histogram
+	for i := 1; i <= 1000; i++ {
+		h.Record(time.Duration(i) * time.Millisecond)
+	}
+
+	assertWithin(t, "p50", durationMs(h.Quantile(0.50)), 500, 0.02)
+	assertWithin(t, "p90", durationMs(h.Quantile(0.90)), 900, 0.02)
+	assertWithin(t, "p99", durationMs(h.Quantile(0.99)), 990, 0.02)
+	if h.Max() != time.Second || h.Quantile(1) != time.Second {
+		t.Fatalf("expected exact max of 1s, got %s", h.Max())
+	}
+	if h.Mean() != 500500*time.Microsecond {
+		t.Fatalf("expected exact mean, got %s", h.Mean())
+	}
+}
+
+func TestHistogramMerge(t *testing.T) {
+	var a, b Histogram
+	a.Record(10 * time.Millisecond)
+	b.Record(30 * time.Millisecond)
+	b.Record(50 * time.Millisecond)
+
+	a.Merge(&b)
+	if a.Count() != 3 || a.Max() != 50*time.Millisecond {
+		t.Fatalf("unexpected merged histogram count=%d max=%s", a.Count(), a.Max())
+	}
+	assertWithin(t, "p50", durationMs(a.Quantile(0.5)), 30, 0.02)
+}
+
+func TestTrackerWindowsAreTimeBased(t *testing.T) {
+	tracker, clock := newTestTracker()
+
+	tracker.AddRequest(800 * time.Millisecond)
+	clock.now = clock.now.Add(3 * time.Minute)
+	tracker.AddRequest(100 * time.Millisecond)
+	clock.now = clock.now.Add(90 * time.Second)
+	tracker.AddRequest(20 * time.Millisecond)
+
+	windows := tracker.GetLatencyWindows()
+	if len(windows) != 3 {
+		t.Fatalf("expected 3 windows, got %d", len(windows))
+	}
+	for i, want := range []struct {
+		name  string
+		count int
+		max   float64
+	}{
+		{"1m", 1, 20},
+		{"5m", 3, 800},
+		{"15m", 3, 800},
+	} {
+		if windows[i].Window != want.name || windows[i].Count != want.count || windows[i].Max != want.max {
+			t.Fatalf("unexpected %s window: %+v", want.name, windows[i])
+		}
+	}
+
+	clock.now = clock.now.Add(11 * time.Minute)
+	windows = tracker.GetLatencyWindows()
+	if windows[1].Count != 0 || windows[2].Count != 2 {
+		t.Fatalf("expected old requests to age out, got %+v", windows)
+	}
+
+	clock.now = clock.now.Add(15 * time.Minute)
+	tracker.AddRequest(5 * time.Millisecond)
+	windows = tracker.GetLatencyWindows()
+	if windows[2].Count != 1 || windows[2].Max != 5 {
+		t.Fatalf("expected reused slots to drop stale data, got %+v", windows[2])
+	}
+
+	ttl, _, _, _, _, _ := tracker.GetStats()
+	if ttl != 4 {
+		t.Fatalf("expected total to count every request, got %d", ttl)
+	}
+}
+
+func TestTrackerResetClearsWindows(t *testing.T) {
+	tracker, _ := newTestTracker()
+	tracker.AddRequest(10 * time.Millisecond)
+	tracker.Reset()
+
+	if windows := tracker.GetLatencyWindows(); windows[2].Count != 0 {
+		t.Fatalf("expected empty windows after reset, got %+v", windows[2])
+	}
+}
@@ -6,8 +6,10 @@ import (
 	"fmt"
 	"math/rand/v2"
 	"net"
+	"os"
 	"slices"
 	"strings"
+	"sync"
 
 	"go.uber.org/zap"
 	"tailscale.com/client/local"
@@ -46,6 +48,25 @@ type ServiceInfo struct {
 type Client struct {
 	lc     *local.Client
 	logger *zap.Logger
+
+	// stateDir holds the serve state files; session records what this
+	// process added to the serve config.
+	stateDir string
+	session  *ServeSession
+
+	// served is the endpoint SetupServe set up, for SetFunnel. mu guards
+	// session and served once portal is running.
+	mu     sync.Mutex
+	served *servedEndpoint
+}
+
+// servedEndpoint is where SetupServe exposed the proxy.
+type servedEndpoint struct {
+	dnsName   string
+	servePort uint16
+	useTLS    bool
+	service   bool
+	proxyPort int
 }
 
 const (
@@ -58,9 +79,17 @@ const (
 
 // NewClient creates a new Tailscale client with structured logging
 func NewClient(logger *zap.Logger) *Client {
+	stateDir, err := DefaultServeStateDir()
+	if err != nil {
+		logger.Warn("Serve changes will not be recorded",
+			logging.Component("tailscale_serve"),
+			logging.Error(err),
+		)
+	}
 	return &Client{
-		lc:     &local.Client{},
-		logger: logger,
+		lc:       &local.Client{},
+		logger:   logger,
+		stateDir: stateDir,
 	}
 }
 
@@ -113,6 +142,23 @@ func (c *Client) GetDNSName(ctx context.Context) (string, error) {
 	return dnsName, nil
 }
 
+// ServeConfig returns the node's current serve config.
+func (c *Client) ServeConfig(ctx context.Context) (*ipn.ServeConfig, error) {
+	sc, err := c.lc.GetServeConfig(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get serve config: %w", err)
+	}
+	if sc == nil {
+		sc = new(ipn.ServeConfig)
+	}
+	return sc, nil
+}
+
+// StateDir is the directory holding the serve state files.
+func (c *Client) StateDir() string {
+	return c.stateDir
+}
+
 // ValidateServiceHostIdentity ensures the current node can act as a Tailscale
 // Service host before service-mode serve configuration is attempted.
 func (c *Client) ValidateServiceHostIdentity(ctx context.Context, serviceName string) error {
@@ -144,6 +190,7 @@ func (c *Client) SetupServe(ctx context.Context, config Config) (*ServiceInfo, e
 	if sc == nil {
 		sc = new(ipn.ServeConfig)
 	}
+	before := sc.Clone()
 
 	status, err := c.lc.Status(ctx)
 	if err != nil {
@@ -234,7 +281,10 @@ func (c *Client) SetupServe(ctx context.Context, config Config) (*ServiceInfo, e
 			zap.String("listen_mode", listenMode),
 			zap.String("service_name", serviceName),
 		)
-		return nil, fmt.Errorf("port %d is already in use by tailscale serve", srvPort)
+		if owner := c.serveOwner(srvPort); owner != nil {
+			return nil, fmt.Errorf("port %d is already in use by tailscale serve for a running portal (pid %d)", srvPort, owner.PID)
+		}
+		return nil, fmt.Errorf("port %d is already in use by tailscale serve; run tailscale serve status to see what is using it", srvPort)
 	}
 
 	useFunnelProxyProtocol := config.EnableFunnel && config.EnableProxyProtocol
@@ -311,8 +361,10 @@ func (c *Client) SetupServe(ctx context.Context, config Config) (*ServiceInfo, e
 		)
 	}
 
+	var advertised bool
 	if listenMode == TSNetListenModeService {
-		if err := c.ensureServiceAdvertised(ctx, serviceNameTag); err != nil {
+		advertised, err = c.ensureServiceAdvertised(ctx, serviceNameTag)
+		if err != nil {
 			c.logger.Error("Failed to advertise Tailscale service",
 				logging.Component("tailscale_serve"),
 				zap.String("service_name", serviceName),
@@ -331,6 +383,20 @@ func (c *Client) SetupServe(ctx context.Context, config Config) (*ServiceInfo, e
 		)
 		return nil, fmt.Errorf("failed to set serve config: %w", err)
 	}
+	advertisedService := ""
+	if advertised {
+		advertisedService = serviceNameTag.String()
+	}
+	c.recordServe(before, sc, config.ProxyPort, advertisedService)
+	c.mu.Lock()
+	c.served = &servedEndpoint{
+		dnsName:   dnsName,
+		servePort: srvPort,
+		useTLS:    useTLS,
+		service:   listenMode == TSNetListenModeService,
+		proxyPort: config.ProxyPort,
+	}
+	c.mu.Unlock()
 
 	// Display URL information
 	scheme := "http"
@@ -376,6 +442,55 @@ func (c *Client) SetupServe(ctx context.Context, config Config) (*ServiceInfo, e
 	return serviceInfo, nil
 }
 
+// SetFunnel turns Funnel on or off for the endpoint SetupServe set up. The
+// change is recorded like the rest of this session's serve entries, so that
+// Cleanup undoes it.
+func (c *Client) SetFunnel(ctx context.Context, enabled bool) error {
+	c.mu.Lock()
+	served := c.served
+	c.mu.Unlock()
+
+	switch {
+	case served == nil:
+		return fmt.Errorf("tailscale serve is not set up")
+	case served.service:
+		return fmt.Errorf("service mode is mutually exclusive with funnel")
+	case enabled && (!served.useTLS || served.servePort != 443):
+		return fmt.Errorf("funnel requires HTTPS on port 443; restart portal with --use-https")
+	}
+
+	// Check HTTPS and certificates as SetupServe does before opening Funnel.
+	if enabled {
+		if err := c.enableHTTPSFeature(ctx); err != nil {
+			return fmt.Errorf("HTTPS certificates not enabled: %w", err)
+		}
+		if err := c.checkTailscaleCertificates(ctx, served.dnsName); err != nil {
+			return fmt.Errorf("cannot enable funnel: %w", err)
+		}
+	}
+
+	sc, err := c.lc.GetServeConfig(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get serve config: %w", err)
+	}
+	if sc == nil {
+		sc = new(ipn.ServeConfig)
+	}
+	before := sc.Clone()
+	sc.SetFunnel(served.dnsName, served.servePort, enabled)
+	if err := c.lc.SetServeConfig(ctx, sc); err != nil {
+		return fmt.Errorf("failed to set serve config: %w", err)
+	}
+	c.recordServe(before, sc, served.proxyPort, "")
+
+	c.logger.Info("Funnel changed",
+		logging.Component("tailscale_serve"),
+		logging.FunnelEnabled(enabled),
+		logging.ServePort(int(served.servePort)),
+	)
+	return nil
+}
+
 func normalizeServeListenMode(mode string) string {
 	mode = strings.ToLower(strings.TrimSpace(mode))
 	if mode == "" {
@@ -411,16 +526,18 @@ func validateServiceHostIdentity(status *ipnstate.Status, serviceName string) er
 	)
 }
 
-func (c *Client) ensureServiceAdvertised(ctx context.Context, svcName tailcfg.ServiceName) error {
+// ensureServiceAdvertised advertises svcName from this node, reporting
+// whether portal added it.
+func (c *Client) ensureServiceAdvertised(ctx context.Context, svcName tailcfg.ServiceName) (bool, error) {
 	prefs, err := c.lc.GetPrefs(ctx)
 	if err != nil {
-		return fmt.Errorf("failed to get current tailscale prefs: %w", err)
+		return false, fmt.Errorf("failed to get current tailscale prefs: %w", err)
 	}
 
 	current := prefs.AdvertiseServices
 	svc := svcName.String()
 	if slices.Contains(current, svc) {
-		return nil
+		return false, nil
 	}
 
 	updated := append(append([]string{}, current...), svc)
@@ -431,14 +548,14 @@ func (c *Client) ensureServiceAdvertised(ctx context.Context, svcName tailcfg.Se
 		},
 	})
 	if err != nil {
-		return fmt.Errorf("failed to advertise service %q: %w", svc, err)
+		return false, fmt.Errorf("failed to advertise service %q: %w", svc, err)
 	}
 
 	c.logger.Info("Advertised Tailscale service",
 		logging.Component("tailscale_serve"),
 		zap.String("service_name", svc),
 	)
-	return nil
+	return true, nil
 }
 
 // SetupUIServe sets up Tailscale serve for the UI dashboard
@@ -460,6 +577,7 @@ func (c *Client) SetupUIServe(ctx context.Context, uiPort int) (uint16, string,
 	if sc == nil {
 		sc = new(ipn.ServeConfig)
 	}
+	before := sc.Clone()
 
 	// Get DNS name
 	dnsName, err := c.GetDNSName(ctx)
@@ -498,6 +616,7 @@ func (c *Client) SetupUIServe(ctx context.Context, uiPort int) (uint16, string,
 		)
 		return 0, "", fmt.Errorf("failed to set UI serve config: %w", err)
 	}
+	c.recordServe(before, sc, uiPort, "")
 
 	uiURL := fmt.Sprintf("http://%s:%d/ui/", dnsName, tailscalePort)
 
@@ -511,81 +630,245 @@ func (c *Client) SetupUIServe(ctx context.Context, uiPort int) (uint16, string,
 	return tailscalePort, uiURL, nil
 }
 
-// Cleanup removes Tailscale serve configuration
-func (c *Client) Cleanup(ctx context.Context, config Config) error {
-	c.logger.Info(logging.MsgCleanupStarting,
-		logging.Component("tailscale_serve"),
-		logging.ProxyPort(config.ProxyPort),
-	)
-
-	sc, err := c.lc.GetServeConfig(ctx)
-	if err != nil || sc == nil {
+// Cleanup removes the serve entries this client added, restoring any
+// configuration they displaced. Entries and services set up by hand or by
+// other portal sessions are left in place.
+func (c *Client) Cleanup(ctx context.Context) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.session == nil {
 		c.logger.Debug("No serve config to clean up",
 			logging.Component("tailscale_serve"),
-			logging.Error(err),
 		)
-		return nil // Nothing to clean up
+		return nil
 	}
 
-	dnsName, err := c.GetDNSName(ctx)
-	if err != nil {
-		c.logger.Warn("Failed to get DNS name during cleanup",
+	c.logger.Info(logging.MsgCleanupStarting,
+		logging.Component("tailscale_serve"),
+		zap.Int("entries", len(c.session.Entries)),
+	)
+
+	if _, err := c.cleanupSessions(ctx, []*ServeSession{c.session}); err != nil {
+		c.logger.Warn("Failed to clean up serve config",
 			logging.Component("tailscale_serve"),
 			logging.Error(err),
 		)
 		return err
 	}
+	c.session = nil
 
-	// Create a completely empty serve config to clear everything
-	emptyConfig := &ipn.ServeConfig{}
+	c.logger.Info(logging.MsgCleanupComplete,
+		logging.Component("tailscale_serve"),
+	)
+	return nil
+}
 
-	c.logger.Info("Clearing all Tailscale serve configurations",
+// CleanupAll removes the serve entries recorded by every portal session,
+// running or not, and returns how many it removed. Serve configuration
+// portal did not add is left in place.
+func (c *Client) CleanupAll(ctx context.Context) (int, error) {
+	c.logger.Info("Removing serve entries added by portal",
 		logging.Component("tailscale_serve"),
-		zap.String("dns_name", dnsName),
+		logging.Operation("cleanup_all"),
 	)
 
-	// Apply the empty config to clear all serve configurations
-	err = c.lc.SetServeConfig(ctx, emptyConfig)
+	if c.stateDir == "" {
+		return 0, fmt.Errorf("no serve state directory")
+	}
+	sessions, err := LoadServeSessions(c.stateDir)
 	if err != nil {
-		c.logger.Warn("Failed to clear serve config",
+		return 0, err
+	}
+
+	removed, err := c.cleanupSessions(ctx, sessions)
+	if err != nil {
+		c.logger.Error("Failed to remove serve entries added by portal",
 			logging.Component("tailscale_serve"),
 			logging.Error(err),
 		)
-		return err
-	} else {
-		c.logger.Info(logging.MsgCleanupComplete,
+		return removed, err
+	}
+	c.session = nil
+
+	c.logger.Info("Serve entries added by portal removed",
+		logging.Component("tailscale_serve"),
+		zap.Int("sessions", len(sessions)),
+		zap.Int("entries", removed),
+		logging.Status("portal_entries_cleared"),
+	)
+	return removed, nil
+}
+
+// ReclaimStale removes the serve entries of portal sessions that exited
+// without cleaning up, such as after SIGKILL, and returns how many it
+// removed. Sessions that are still running are left alone.
+func (c *Client) ReclaimStale(ctx context.Context) (int, error) {
+	if c.stateDir == "" {
+		return 0, nil
+	}
+	sessions, err := LoadServeSessions(c.stateDir)
+	if err != nil {
+		return 0, err
+	}
+
+	var stale []*ServeSession
+	for _, session := range sessions {
+		if session.PID == os.Getpid() || session.Live() {
+			continue
+		}
+		c.logger.Info("Reclaiming serve entries from exited portal session",
 			logging.Component("tailscale_serve"),
+			zap.Int("pid", session.PID),
+			zap.Time("started_at", session.StartedAt),
+			zap.Ints("local_ports", session.LocalPorts),
+			zap.Int("entries", len(session.Entries)),
 		)
+		stale = append(stale, session)
 	}
+	return c.cleanupSessions(ctx, stale)
+}
 
+// serveOwner returns the recorded portal session that added a TCP handler
+// on port, or nil if none did.
+func (c *Client) serveOwner(port uint16) *ServeSession {
+	if c.stateDir == "" {
+		return nil
+	}
+	sessions, err := LoadServeSessions(c.stateDir)
+	if err != nil {
+		return nil
+	}
+	for _, session := range sessions {
+		for _, entry := range session.Entries {
+			if entry.Kind == ServeEntryTCP && entry.Port == port && entry.Value != nil {
+				return session
+			}
+		}
+	}
 	return nil
 }
 
-// CleanupAll removes all Tailscale serve configurations (not just from this session)
-func (c *Client) CleanupAll(ctx context.Context) error {
-	c.logger.Info("Clearing ALL Tailscale serve configurations",
+// recordServe saves the entries that changed from before to after to this
+// client's session, so cleanup can undo exactly those. localPort is the
+// port the entries proxy to.
+func (c *Client) recordServe(before, after *ipn.ServeConfig, localPort int, advertisedService string) {
+	if c.stateDir == "" {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.session == nil {
+		c.session = newServeSession(c.stateDir)
+	}
+	if !slices.Contains(c.session.LocalPorts, localPort) {
+		c.session.LocalPorts = append(c.session.LocalPorts, localPort)
+	}
+	c.session.Entries = append(c.session.Entries, diffServeConfig(before, after)...)
+	if advertisedService != "" {
+		c.session.AdvertisedService = advertisedService
+	}
+
+	if err := c.session.save(); err != nil {
+		c.logger.Warn("Failed to record serve changes",
+			logging.Component("tailscale_serve"),
+			logging.Status("cleanup_will_leave_entries"),
+			logging.Error(err),
+		)
+		return
+	}
+	c.logger.Debug("Recorded serve changes",
 		logging.Component("tailscale_serve"),
-		logging.Operation("cleanup_all"),
+		zap.String("state_file", c.session.Path()),
+		zap.Int("entries", len(c.session.Entries)),
 	)
+}
 
-	// Create a completely empty serve config to clear everything
-	emptyConfig := &ipn.ServeConfig{}
+// cleanupSessions removes the entries of sessions from the serve config,
+// newest session first, stops advertising services that portal added and
+// nothing serves any more, and deletes the sessions' state files.
+func (c *Client) cleanupSessions(ctx context.Context, sessions []*ServeSession) (int, error) {
+	if len(sessions) == 0 {
+		return 0, nil
+	}
 
-	// Apply the empty config to clear all serve configurations
-	err := c.lc.SetServeConfig(ctx, emptyConfig)
+	sc, err := c.lc.GetServeConfig(ctx)
 	if err != nil {
-		c.logger.Error("Failed to clear all serve configurations",
-			logging.Component("tailscale_serve"),
-			logging.Error(err),
-		)
-		return err
+		return 0, fmt.Errorf("failed to get serve config: %w", err)
+	}
+	if sc == nil {
+		sc = new(ipn.ServeConfig)
 	}
 
-	c.logger.Info("All Tailscale serve configurations cleared",
+	removed := 0
+	var services []string
+	for i := len(sessions) - 1; i >= 0; i-- {
+		session := sessions[i]
+		skipped, err := removeServeEntries(sc, session.Entries)
+		if err != nil {
+			return 0, err
+		}
+		for _, entry := range skipped {
+			c.logger.Warn("Serve entry changed since portal set it, leaving it in place",
+				logging.Component("tailscale_serve"),
+				zap.String("entry", entry.String()),
+			)
+		}
+		removed += len(session.Entries) - len(skipped)
+		if session.AdvertisedService != "" {
+			services = append(services, session.AdvertisedService)
+		}
+	}
+
+	if err := c.lc.SetServeConfig(ctx, sc); err != nil {
+		return 0, fmt.Errorf("failed to set serve config: %w", err)
+	}
+
+	for _, svc := range services {
+		if _, ok := sc.Services[tailcfg.ServiceName(svc)]; ok {
+			continue
+		}
+		if err := c.stopAdvertisingService(ctx, svc); err != nil {
+			c.logger.Warn("Failed to stop advertising Tailscale service",
+				logging.Component("tailscale_serve"),
+				zap.String("service_name", svc),
+				logging.Error(err),
+			)
+		}
+	}
+
+	for _, session := range sessions {
+		if err := session.remove(); err != nil {
+			return removed, err
+		}
+	}
+	return removed, nil
+}
+
+// stopAdvertisingService removes svc from the services this node advertises.
+func (c *Client) stopAdvertisingService(ctx context.Context, svc string) error {
+	prefs, err := c.lc.GetPrefs(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get current tailscale prefs: %w", err)
+	}
+	if !slices.Contains(prefs.AdvertiseServices, svc) {
+		return nil
+	}
+
+	updated := slices.DeleteFunc(slices.Clone(prefs.AdvertiseServices), func(s string) bool { return s == svc })
+	_, err = c.lc.EditPrefs(ctx, &ipn.MaskedPrefs{
+		AdvertiseServicesSet: true,
+		Prefs: ipn.Prefs{
+			AdvertiseServices: updated,
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to stop advertising service %q: %w", svc, err)
+	}
+
+	c.logger.Info("Stopped advertising Tailscale service",
 		logging.Component("tailscale_serve"),
-		logging.Status("all_cleared"),
+		zap.String("service_name", svc),
 	)
-
 	return nil
 }
 
@@ -0,0 +1,430 @@
+package tailscale
+
+import (
+	"bytes"
+	"cmp"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"time"
+
+	"tailscale.com/ipn"
+	"tailscale.com/tailcfg"
+
+	"github.com/jaxxstorm/portal/internal/fileutil"
+	"github.com/jaxxstorm/portal/internal/process"
+)
+
+// Kinds of serve entry a session can own.
+const (
+	ServeEntryTCP    = "tcp"    // a TCP port handler
+	ServeEntryWeb    = "web"    // an HTTP handler at a host, port and mount path
+	ServeEntryFunnel = "funnel" // Funnel allowed on a host and port
+)
+
+// ServeEntry is one serve setting a portal session changed. Value is what
+// portal set; Previous is what it displaced. Either is empty when the entry
+// did not exist on that side.
+type ServeEntry struct {
+	Kind     string          `json:"kind"`
+	Service  string          `json:"service,omitempty"`
+	Port     uint16          `json:"port,omitempty"`
+	HostPort ipn.HostPort    `json:"host_port,omitempty"`
+	Path     string          `json:"path,omitempty"`
+	Value    json.RawMessage `json:"value,omitempty"`
+	Previous json.RawMessage `json:"previous,omitempty"`
+}
+
+// String names the entry the way tailscale serve status shows it.
+func (e ServeEntry) String() string {
+	var name string
+	switch e.Kind {
+	case ServeEntryWeb:
+		name = string(e.HostPort) + e.Path
+	case ServeEntryFunnel:
+		name = "funnel " + string(e.HostPort)
+	default:
+		name = "tcp :" + strconv.Itoa(int(e.Port))
+	}
+	if e.Service != "" {
+		name = e.Service + " " + name
+	}
+	return name
+}
+
+// ServeSession records the serve entries one portal process added, so
+// cleanup removes exactly those and restores anything they displaced.
+type ServeSession struct {
+	PID               int          `json:"pid"`
+	StartedAt         time.Time    `json:"started_at"`
+	LocalPorts        []int        `json:"local_ports,omitempty"` // ports the entries proxy to
+	Entries           []ServeEntry `json:"entries"`
+	AdvertisedService string       `json:"advertised_service,omitempty"`
+
+	path string
+}
+
+// DefaultServeStateDir is where portal keeps a state file for each session
+// that has changed the tailscale serve config.
+func DefaultServeStateDir() (string, error) {
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("failed to get home directory: %w", err)
+	}
+	return filepath.Join(homeDir, ".portal", "serve"), nil
+}
+
+func newServeSession(dir string) *ServeSession {
+	startedAt := time.Now().UTC()
+	pid := os.Getpid()
+	return &ServeSession{
+		PID:       pid,
+		StartedAt: startedAt,
+		path:      filepath.Join(dir, fmt.Sprintf("%d-%d.json", pid, startedAt.UnixNano())),
+	}
+}
+
+// Path is the state file the session is saved to.
+func (s *ServeSession) Path() string {
+	return s.path
+}
+
+// Live reports whether the portal process that recorded s is still running.
+// A session whose PID is gone is stale, and so is one none of whose local
+// ports is listening, as its PID now belongs to another process.
+func (s *ServeSession) Live() bool {
+	if !process.Alive(s.PID) {
+		return false
+	}
+	if len(s.LocalPorts) == 0 {
+		return true
+	}
+	return slices.ContainsFunc(s.LocalPorts, portListening)
+}
+
+// portListening reports whether something accepts connections on the local
+// port.
+func portListening(port int) bool {
+	conn, err := net.DialTimeout("tcp", net.JoinHostPort("127.0.0.1", strconv.Itoa(port)), 500*time.Millisecond)
+	if err != nil {
+		return false
+	}
+	conn.Close()
+	return true
+}
+
+// save writes the session atomically.
+func (s *ServeSession) save() error {
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode serve state: %w", err)
+	}
+	if err := fileutil.WriteAtomic(s.path, append(data, '\n')); err != nil {
+		return fmt.Errorf("failed to save serve state %s: %w", s.path, err)
+	}
+	return nil
+}
+
+// remove deletes the session's state file.
+func (s *ServeSession) remove() error {
+	if err := os.Remove(s.path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to remove serve state %s: %w", s.path, err)
+	}
+	return nil
+}
+
+// LoadServeSessions reads every session state file in dir, oldest first. A
+// missing directory yields no sessions.
+func LoadServeSessions(dir string) ([]*ServeSession, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+
+	var sessions []*ServeSession
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read serve state %s: %w", path, err)
+		}
+		session := &ServeSession{path: path}
+		if err := json.Unmarshal(data, session); err != nil {
+			return nil, fmt.Errorf("failed to parse serve state %s: %w", path, err)
+		}
+		sessions = append(sessions, session)
+	}
+	slices.SortFunc(sessions, func(a, b *ServeSession) int {
+		return a.StartedAt.Compare(b.StartedAt)
+	})
+	return sessions, nil
+}
+
+// UnownedServeEntries lists the entries in sc that none of sessions set,
+// such as entries set up by hand or left by a portal that has exited. Each
+// entry's Value is its current value.
+func UnownedServeEntries(sc *ipn.ServeConfig, sessions []*ServeSession) []ServeEntry {
+	var unowned []ServeEntry
+	for key, value := range serveEntries(sc) {
+		owned := slices.ContainsFunc(sessions, func(session *ServeSession) bool {
+			return slices.ContainsFunc(session.Entries, func(entry ServeEntry) bool {
+				return entry.key() == key && sameJSON(entry.Value, value)
+			})
+		})
+		if !owned {
+			unowned = append(unowned, newServeEntry(key, value, nil))
+		}
+	}
+	slices.SortFunc(unowned, func(a, b ServeEntry) int {
+		return compareServeKeys(a.key(), b.key())
+	})
+	return unowned
+}
+
+// serveKey identifies one entry in a serve config.
+type serveKey struct {
+	kind     string
+	service  string
+	port     uint16
+	hostPort ipn.HostPort
+	path     string
+}
+
+func (e ServeEntry) key() serveKey {
+	return serveKey{kind: e.Kind, service: e.Service, port: e.Port, hostPort: e.HostPort, path: e.Path}
+}
+
+// serveEntries flattens sc into its individual entries, encoded as JSON so
+// they can be compared and saved.
+func serveEntries(sc *ipn.ServeConfig) map[serveKey]json.RawMessage {
+	entries := make(map[serveKey]json.RawMessage)
+	if sc == nil {
+		return entries
+	}
+	add := func(key serveKey, value any) {
+		data, err := json.Marshal(value)
+		if err == nil {
+			entries[key] = data
+		}
+	}
+	addHandlers := func(service string, tcp map[uint16]*ipn.TCPPortHandler, web map[ipn.HostPort]*ipn.WebServerConfig) {
+		for port, handler := range tcp {
+			add(serveKey{kind: ServeEntryTCP, service: service, port: port}, handler)
+		}
+		for hostPort, webCfg := range web {
+			if webCfg == nil {
+				continue
+			}
+			for path, handler := range webCfg.Handlers {
+				add(serveKey{kind: ServeEntryWeb, service: service, hostPort: hostPort, path: path}, handler)
+			}
+		}
+	}
+
+	addHandlers("", sc.TCP, sc.Web)
+	for name, svc := range sc.Services {
+		if svc != nil {
+			addHandlers(name.String(), svc.TCP, svc.Web)
+		}
+	}
+	for hostPort, allowed := range sc.AllowFunnel {
+		add(serveKey{kind: ServeEntryFunnel, hostPort: hostPort}, allowed)
+	}
+	return entries
+}
+
+// diffServeConfig lists the entries that differ from before to after, in a
+// stable order.
+func diffServeConfig(before, after *ipn.ServeConfig) []ServeEntry {
+	previous, current := serveEntries(before), serveEntries(after)
+
+	var changes []ServeEntry
+	for key, value := range current {
+		if !bytes.Equal(previous[key], value) {
+			changes = append(changes, newServeEntry(key, value, previous[key]))
+		}
+	}
+	for key, value := range previous {
+		if _, ok := current[key]; !ok {
+			changes = append(changes, newServeEntry(key, nil, value))
+		}
+	}
+	slices.SortFunc(changes, func(a, b ServeEntry) int {
+		return compareServeKeys(a.key(), b.key())
+	})
+	return changes
+}
+
+func newServeEntry(key serveKey, value, previous json.RawMessage) ServeEntry {
+	return ServeEntry{
+		Kind:     key.kind,
+		Service:  key.service,
+		Port:     key.port,
+		HostPort: key.hostPort,
+		Path:     key.path,
+		Value:    value,
+		Previous: previous,
+	}
+}
+
+func compareServeKeys(a, b serveKey) int {
+	return cmp.Or(
+		cmp.Compare(a.service, b.service),
+		cmp.Compare(a.kind, b.kind),
+		cmp.Compare(a.port, b.port),
+		cmp.Compare(a.hostPort, b.hostPort),
+		cmp.Compare(a.path, b.path),
+	)
+}
+
+// removeServeEntries undoes entries in sc, newest first, putting back what
+// each displaced. Entries changed since portal set them are left alone and
+// returned.
+func removeServeEntries(sc *ipn.ServeConfig, entries []ServeEntry) (skipped []ServeEntry, err error) {
+	current := serveEntries(sc)
+	for i := len(entries) - 1; i >= 0; i-- {
+		entry := entries[i]
+		if !sameJSON(current[entry.key()], entry.Value) {
+			skipped = append(skipped, entry)
+			continue
+		}
+		if err := setServeEntry(sc, entry.key(), entry.Previous); err != nil {
+			return skipped, fmt.Errorf("failed to restore %s: %w", entry, err)
+		}
+		if entry.Previous == nil {
+			delete(current, entry.key())
+		} else {
+			current[entry.key()] = entry.Previous
+		}
+	}
+	pruneServeConfig(sc)
+	return skipped, nil
+}
+
+// sameJSON reports whether a and b encode the same value, ignoring the
+// indentation state files add.
+func sameJSON(a, b json.RawMessage) bool {
+	var compactA, compactB bytes.Buffer
+	if len(a) > 0 && json.Compact(&compactA, a) != nil {
+		return false
+	}
+	if len(b) > 0 && json.Compact(&compactB, b) != nil {
+		return false
+	}
+	return bytes.Equal(compactA.Bytes(), compactB.Bytes())
+}
+
+// setServeEntry sets the entry at key to the JSON value, or deletes it when
+// value is empty.
+func setServeEntry(sc *ipn.ServeConfig, key serveKey, value json.RawMessage) error {
+	tcp, web := &sc.TCP, &sc.Web
+	if key.service != "" {
+		name := tailcfg.ServiceName(key.service)
+		svc := sc.Services[name]
+		if svc == nil {
+			if value == nil {
+				return nil
+			}
+			svc = new(ipn.ServiceConfig)
+			if sc.Services == nil {
+				sc.Services = make(map[tailcfg.ServiceName]*ipn.ServiceConfig)
+			}
+			sc.Services[name] = svc
+		}
+		tcp, web = &svc.TCP, &svc.Web
+	}
+
+	switch key.kind {
+	case ServeEntryTCP:
+		if value == nil {
+			delete(*tcp, key.port)
+			return nil
+		}
+		var handler ipn.TCPPortHandler
+		if err := json.Unmarshal(value, &handler); err != nil {
+			return err
+		}
+		if *tcp == nil {
+			*tcp = make(map[uint16]*ipn.TCPPortHandler)
+		}
+		(*tcp)[key.port] = &handler
+	case ServeEntryWeb:
+		webCfg := (*web)[key.hostPort]
+		if value == nil {
+			if webCfg != nil {
+				delete(webCfg.Handlers, key.path)
+			}
+			return nil
+		}
+		var handler ipn.HTTPHandler
+		if err := json.Unmarshal(value, &handler); err != nil {
+			return err
+		}
+		if webCfg == nil {
+			webCfg = new(ipn.WebServerConfig)
+			if *web == nil {
+				*web = make(map[ipn.HostPort]*ipn.WebServerConfig)
+			}
+			(*web)[key.hostPort] = webCfg
+		}
+		if webCfg.Handlers == nil {
+			webCfg.Handlers = make(map[string]*ipn.HTTPHandler)
+		}
+		webCfg.Handlers[key.path] = &handler
+	case ServeEntryFunnel:
+		if value == nil {
+			delete(sc.AllowFunnel, key.hostPort)
+			return nil
+		}
+		var allowed bool
+		if err := json.Unmarshal(value, &allowed); err != nil {
+			return err
+		}
+		if sc.AllowFunnel == nil {
+			sc.AllowFunnel = make(map[ipn.HostPort]bool)
+		}
+		sc.AllowFunnel[key.hostPort] = allowed
+	default:
+		return fmt.Errorf("unknown serve entry kind %q", key.kind)
+	}
+	return nil
+}
+
+// pruneServeConfig drops the empty maps and services removing entries leaves
+// behind.
+func pruneServeConfig(sc *ipn.ServeConfig) {
+	pruneWeb := func(web map[ipn.HostPort]*ipn.WebServerConfig) {
+		for hostPort, webCfg := range web {
+			if webCfg == nil || len(webCfg.Handlers) == 0 {
+				delete(web, hostPort)
+			}
+		}
+	}
+	pruneWeb(sc.Web)
+	for name, svc := range sc.Services {
+		if svc == nil {
+			delete(sc.Services, name)
+			continue
+		}
+		pruneWeb(svc.Web)
+		if len(svc.TCP) == 0 && len(svc.Web) == 0 && !svc.Tun {
+			delete(sc.Services, name)
+		}
+	}
+	if len(sc.TCP) == 0 {
+		sc.TCP = nil
+	}
+	if len(sc.Web) == 0 {
+		sc.Web = nil
+	}
+	if len(sc.Services) == 0 {
+		sc.Services = nil
+	}
+	if len(sc.AllowFunnel) == 0 {
+		sc.AllowFunnel = nil
+	}
+}
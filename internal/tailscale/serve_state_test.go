@@ -0,0 +1,234 @@
+package tailscale
+
+import (
+	"net"
+	"os"
+	"os/exec"
+	"reflect"
+	"testing"
+
+	"tailscale.com/ipn"
+	"tailscale.com/tailcfg"
+)
+
+const testDNSName = "node.example.ts.net"
+
+func manualServeConfig() *ipn.ServeConfig {
+	sc := new(ipn.ServeConfig)
+	sc.SetWebHandler(&ipn.HTTPHandler{Proxy: "http://localhost:3000"}, testDNSName, 8443, "/", true, "")
+	sc.SetFunnel(testDNSName, 8443, true)
+	sc.SetTCPForwarding(2222, "127.0.0.1:22", false, 0, "")
+	return sc
+}
+
+func TestRemoveServeEntriesKeepsManualConfig(t *testing.T) {
+	before := manualServeConfig()
+
+	after := before.Clone()
+	after.SetWebHandler(&ipn.HTTPHandler{Proxy: "http://localhost:50000"}, testDNSName, 443, "/", true, "")
+	after.SetFunnel(testDNSName, 443, true)
+	after.SetWebHandler(&ipn.HTTPHandler{Proxy: "http://localhost:4040"}, testDNSName, 8080, "/ui/", false, "")
+
+	entries := diffServeConfig(before, after)
+	if len(entries) != 5 {
+		t.Fatalf("expected 5 entries (two tcp ports, two web handlers, one funnel), got %d: %v", len(entries), entries)
+	}
+	for _, entry := range entries {
+		if entry.Previous != nil {
+			t.Fatalf("expected %s not to displace anything, got previous %s", entry, entry.Previous)
+		}
+	}
+
+	skipped, err := removeServeEntries(after, entries)
+	if err != nil {
+		t.Fatalf("removeServeEntries failed: %v", err)
+	}
+	if len(skipped) != 0 {
+		t.Fatalf("expected no skipped entries, got %v", skipped)
+	}
+	if !reflect.DeepEqual(after, manualServeConfig()) {
+		t.Fatalf("expected only the manual config to remain, got %+v", after)
+	}
+}
+
+func TestRemoveServeEntriesRestoresDisplacedHandler(t *testing.T) {
+	before := new(ipn.ServeConfig)
+	before.SetWebHandler(&ipn.HTTPHandler{Proxy: "http://localhost:3000"}, testDNSName, 80, "/api", false, "")
+
+	// Mounting /api/ replaces the existing /api handler.
+	after := before.Clone()
+	after.SetWebHandler(&ipn.HTTPHandler{Proxy: "http://localhost:50000"}, testDNSName, 80, "/api/", false, "")
+
+	entries := diffServeConfig(before, after)
+	if _, ok := after.Web[ipn.HostPort(testDNSName+":80")].Handlers["/api"]; ok {
+		t.Fatal("expected /api to be displaced")
+	}
+
+	if _, err := removeServeEntries(after, entries); err != nil {
+		t.Fatalf("removeServeEntries failed: %v", err)
+	}
+	if !reflect.DeepEqual(after, before) {
+		t.Fatalf("expected the displaced /api handler to be restored, got %+v", after)
+	}
+}
+
+func TestRemoveServeEntriesLeavesChangedEntries(t *testing.T) {
+	before := new(ipn.ServeConfig)
+	after := before.Clone()
+	after.SetWebHandler(&ipn.HTTPHandler{Proxy: "http://localhost:50000"}, testDNSName, 443, "/", true, "")
+	entries := diffServeConfig(before, after)
+
+	// Someone points the handler elsewhere while portal runs.
+	after.SetWebHandler(&ipn.HTTPHandler{Proxy: "http://localhost:9999"}, testDNSName, 443, "/", true, "")
+
+	skipped, err := removeServeEntries(after, entries)
+	if err != nil {
+		t.Fatalf("removeServeEntries failed: %v", err)
+	}
+	if len(skipped) != 1 || skipped[0].Kind != ServeEntryWeb {
+		t.Fatalf("expected the changed web handler to be skipped, got %v", skipped)
+	}
+	handler := after.Web[ipn.HostPort(testDNSName+":443")].Handlers["/"]
+	if handler == nil || handler.Proxy != "http://localhost:9999" {
+		t.Fatalf("expected the changed handler to be kept, got %+v", handler)
+	}
+	if after.TCP[443] != nil {
+		t.Fatalf("expected the unchanged tcp entry to be removed, got %+v", after.TCP[443])
+	}
+}
+
+func TestRemoveServeEntriesPrunesService(t *testing.T) {
+	before := manualServeConfig()
+	after := before.Clone()
+	after.SetWebHandler(&ipn.HTTPHandler{Proxy: "http://localhost:50000"}, "svc:portal", 443, "/", true, "example.ts.net")
+
+	entries := diffServeConfig(before, after)
+	for _, entry := range entries {
+		if entry.Service != "svc:portal" {
+			t.Fatalf("expected only service entries, got %s", entry)
+		}
+	}
+
+	if _, err := removeServeEntries(after, entries); err != nil {
+		t.Fatalf("removeServeEntries failed: %v", err)
+	}
+	if _, ok := after.Services[tailcfg.ServiceName("svc:portal")]; ok {
+		t.Fatalf("expected the emptied service to be removed, got %+v", after.Services)
+	}
+	if !reflect.DeepEqual(after, manualServeConfig()) {
+		t.Fatalf("expected only the manual config to remain, got %+v", after)
+	}
+}
+
+func TestServeSessionSaveAndLoad(t *testing.T) {
+	dir := t.TempDir()
+
+	before := new(ipn.ServeConfig)
+	after := before.Clone()
+	after.SetTCPForwarding(443, "127.0.0.1:50000", true, 2, testDNSName)
+	after.SetFunnel(testDNSName, 443, true)
+
+	session := newServeSession(dir)
+	session.Entries = diffServeConfig(before, after)
+	session.AdvertisedService = "svc:portal"
+	if err := session.save(); err != nil {
+		t.Fatalf("save failed: %v", err)
+	}
+
+	sessions, err := LoadServeSessions(dir)
+	if err != nil {
+		t.Fatalf("LoadServeSessions failed: %v", err)
+	}
+	if len(sessions) != 1 {
+		t.Fatalf("expected 1 session, got %d", len(sessions))
+	}
+	loaded := sessions[0]
+	if loaded.PID != session.PID || loaded.AdvertisedService != "svc:portal" || loaded.Path() != session.Path() {
+		t.Fatalf("unexpected loaded session %+v", loaded)
+	}
+
+	if _, err := removeServeEntries(after, loaded.Entries); err != nil {
+		t.Fatalf("removeServeEntries failed: %v", err)
+	}
+	if !reflect.DeepEqual(after, before) {
+		t.Fatalf("expected loaded entries to undo the change, got %+v", after)
+	}
+
+	if err := loaded.remove(); err != nil {
+		t.Fatalf("remove failed: %v", err)
+	}
+	sessions, err = LoadServeSessions(dir)
+	if err != nil || len(sessions) != 0 {
+		t.Fatalf("expected no sessions after remove, got %v (err %v)", sessions, err)
+	}
+}
+
+func TestLoadServeSessionsMissingDir(t *testing.T) {
+	sessions, err := LoadServeSessions(t.TempDir() + "/missing")
+	if err != nil || len(sessions) != 0 {
+		t.Fatalf("expected no sessions for a missing dir, got %v (err %v)", sessions, err)
+	}
+}
+
+func TestServeSessionLive(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	defer listener.Close()
+	listening := listener.Addr().(*net.TCPAddr).Port
+
+	closed, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	notListening := closed.Addr().(*net.TCPAddr).Port
+	closed.Close()
+
+	// A PID that has exited and been reaped.
+	exited := exec.Command(os.Args[0], "-test.run=^$")
+	if err := exited.Run(); err != nil {
+		t.Fatalf("failed to run child process: %v", err)
+	}
+	exitedPID := exited.Process.Pid
+
+	tests := []struct {
+		name    string
+		session ServeSession
+		want    bool
+	}{
+		{"running with listening port", ServeSession{PID: os.Getpid(), LocalPorts: []int{notListening, listening}}, true},
+		{"running without recorded ports", ServeSession{PID: os.Getpid()}, true},
+		{"pid reused, ports closed", ServeSession{PID: os.Getpid(), LocalPorts: []int{notListening}}, false},
+		{"pid gone", ServeSession{PID: exitedPID, LocalPorts: []int{listening}}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.session.Live(); got != tt.want {
+				t.Fatalf("Live() = %t, want %t", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestUnownedServeEntries(t *testing.T) {
+	before := manualServeConfig()
+	after := before.Clone()
+	after.SetWebHandler(&ipn.HTTPHandler{Proxy: "http://localhost:50000"}, testDNSName, 443, "/", true, "")
+	session := &ServeSession{Entries: diffServeConfig(before, after)}
+
+	unowned := UnownedServeEntries(after, []*ServeSession{session})
+	if !reflect.DeepEqual(unowned, UnownedServeEntries(before, nil)) {
+		t.Fatalf("expected only the manual entries to be unowned, got %v", unowned)
+	}
+	if len(unowned) != 4 {
+		t.Fatalf("expected 4 manual entries, got %d: %v", len(unowned), unowned)
+	}
+
+	// An entry changed since portal set it is no longer portal's.
+	after.SetWebHandler(&ipn.HTTPHandler{Proxy: "http://localhost:9999"}, testDNSName, 443, "/", true, "")
+	unowned = UnownedServeEntries(after, []*ServeSession{session})
+	if len(unowned) != 5 {
+		t.Fatalf("expected the changed handler to be unowned too, got %v", unowned)
+	}
+}
@@ -158,6 +158,13 @@ func (ts *TSNetServer) Start(ctx context.Context) (string, error) {
 	return tailscaleURL, nil
 }
 
+// ConnObserver is implemented by handlers that track connections. Serve
+// reports each connection state change along with the Funnel client address,
+// or an empty peer when the connection did not arrive over Funnel.
+type ConnObserver interface {
+	ObserveConn(conn net.Conn, peer string, state http.ConnState)
+}
+
 // Serve starts serving HTTP on the tsnet server
 func (ts *TSNetServer) Serve(ctx context.Context, handler http.Handler) error {
 	configuredMode := normalizeTSNetListenMode(ts.config.ListenMode)
@@ -215,12 +222,21 @@ func (ts *TSNetServer) Serve(ctx context.Context, handler http.Handler) error {
 			return ctx
 		},
 		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			if sourceIP, ok := funnelClientIPFromContext(r.Context()); ok {
-				r.Header.Set("Tailscale-Client-IP", sourceIP)
-			}
+			setFunnelSourceHeaders(r)
 			handler.ServeHTTP(w, r)
 		}),
 	}
+	if observer, ok := handler.(ConnObserver); ok {
+		httpServer.ConnState = func(conn net.Conn, state http.ConnState) {
+			peer := ""
+			if state == http.StateNew {
+				if sourceIP, ok := funnelSourceIPFromConn(conn); ok {
+					peer = sourceIP.String()
+				}
+			}
+			observer.ObserveConn(conn, peer, state)
+		}
+	}
 
 	// Start the device
 	serviceURL, err := ts.Start(ctx)
@@ -473,6 +489,22 @@ func funnelSourceIPFromConn(conn net.Conn) (netip.Addr, bool) {
 	return netip.Addr{}, false
 }
 
+// setFunnelSourceHeaders replaces the source and identity headers a client
+// could forge with what the connection says, so the proxy can trust them.
+func setFunnelSourceHeaders(r *http.Request) {
+	r.Header.Del("Tailscale-Client-IP")
+	r.Header.Del("Tailscale-Funnel-Request")
+	sourceIP, ok := funnelClientIPFromContext(r.Context())
+	if !ok {
+		return
+	}
+	for _, header := range []string{"Tailscale-User-Login", "Tailscale-User-Name", "Tailscale-User-Profile-Pic", "Tailscale-Headers-Info"} {
+		r.Header.Del(header)
+	}
+	r.Header.Set("Tailscale-Client-IP", sourceIP)
+	r.Header.Set("Tailscale-Funnel-Request", "?1")
+}
+
 func funnelClientIPFromContext(ctx context.Context) (string, bool) {
 	value, ok := ctx.Value(funnelClientIPContextKey{}).(string)
 	if !ok {
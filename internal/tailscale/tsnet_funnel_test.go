@@ -4,6 +4,8 @@ import (
 	"context"
 	"crypto/tls"
 	"net"
+	"net/http"
+	"net/http/httptest"
 	"net/netip"
 	"testing"
 
@@ -76,3 +78,31 @@ func TestFunnelClientIPFromContext(t *testing.T) {
 		t.Fatalf("unexpected context IP: got %q want %q", got, want)
 	}
 }
+
+func TestSetFunnelSourceHeadersReplacesForgedHeaders(t *testing.T) {
+	funnelReq := httptest.NewRequest(http.MethodGet, "/", nil)
+	funnelReq = funnelReq.WithContext(context.WithValue(funnelReq.Context(), funnelClientIPContextKey{}, "203.0.113.5"))
+	funnelReq.Header.Set("Tailscale-Client-IP", "192.0.2.1")
+	funnelReq.Header.Set("Tailscale-User-Login", "alice@example.com")
+
+	setFunnelSourceHeaders(funnelReq)
+
+	if got := funnelReq.Header.Get("Tailscale-Client-IP"); got != "203.0.113.5" {
+		t.Fatalf("expected connection source IP, got %q", got)
+	}
+	if got := funnelReq.Header.Get("Tailscale-User-Login"); got != "" {
+		t.Fatalf("expected forged identity header to be removed, got %q", got)
+	}
+	if got := funnelReq.Header.Get("Tailscale-Funnel-Request"); got != "?1" {
+		t.Fatalf("expected Funnel marker, got %q", got)
+	}
+
+	tailnetReq := httptest.NewRequest(http.MethodGet, "/", nil)
+	tailnetReq.Header.Set("Tailscale-Client-IP", "192.0.2.1")
+
+	setFunnelSourceHeaders(tailnetReq)
+
+	if got := tailnetReq.Header.Get("Tailscale-Client-IP"); got != "" {
+		t.Fatalf("expected forged source header to be removed, got %q", got)
+	}
+}
@@ -0,0 +1,93 @@
+// Package telemetry configures OpenTelemetry trace export for portal.
+package telemetry
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.37.0"
+)
+
+const (
+	ProtocolGRPC = "grpc"
+	ProtocolHTTP = "http"
+
+	// TracerName identifies spans created by portal's instrumentation.
+	TracerName = "github.com/jaxxstorm/portal"
+
+	serviceName = "portal"
+)
+
+// Config controls OTLP trace export.
+type Config struct {
+	Endpoint string // host:port or URL of the OTLP receiver; empty disables tracing
+	Protocol string // grpc (default) or http
+	Version  string
+}
+
+// Enabled reports whether spans should be exported.
+func (c Config) Enabled() bool {
+	return strings.TrimSpace(c.Endpoint) != ""
+}
+
+// NewTracerProvider builds a tracer provider that batches spans to the
+// configured OTLP endpoint. It returns nil when tracing is disabled.
+// Callers must Shutdown the provider to flush pending spans.
+func NewTracerProvider(ctx context.Context, config Config) (*sdktrace.TracerProvider, error) {
+	if !config.Enabled() {
+		return nil, nil
+	}
+
+	exporter, err := newExporter(ctx, config)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := resource.Merge(resource.Default(), resource.NewWithAttributes(
+		semconv.SchemaURL,
+		semconv.ServiceName(serviceName),
+		semconv.ServiceVersion(config.Version),
+	))
+	if err != nil {
+		return nil, fmt.Errorf("failed to build trace resource: %w", err)
+	}
+
+	return sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+	), nil
+}
+
+// newExporter creates an OTLP exporter. Bare host:port endpoints are dialled
+// without TLS, which suits a local collector; URLs pick TLS from the scheme.
+func newExporter(ctx context.Context, config Config) (*otlptrace.Exporter, error) {
+	endpoint := strings.TrimSpace(config.Endpoint)
+	isURL := strings.Contains(endpoint, "://")
+
+	switch strings.ToLower(strings.TrimSpace(config.Protocol)) {
+	case "", ProtocolGRPC:
+		opts := []otlptracegrpc.Option{}
+		if isURL {
+			opts = append(opts, otlptracegrpc.WithEndpointURL(endpoint))
+		} else {
+			opts = append(opts, otlptracegrpc.WithEndpoint(endpoint), otlptracegrpc.WithInsecure())
+		}
+		return otlptracegrpc.New(ctx, opts...)
+	case ProtocolHTTP:
+		opts := []otlptracehttp.Option{}
+		if isURL {
+			opts = append(opts, otlptracehttp.WithEndpointURL(endpoint))
+		} else {
+			opts = append(opts, otlptracehttp.WithEndpoint(endpoint), otlptracehttp.WithInsecure())
+		}
+		return otlptracehttp.New(ctx, opts...)
+	default:
+		return nil, fmt.Errorf("invalid OTLP protocol %q: must be %q or %q", config.Protocol, ProtocolGRPC, ProtocolHTTP)
+	}
+}
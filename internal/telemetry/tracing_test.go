@@ -0,0 +1,38 @@
+package telemetry
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestNewTracerProviderDisabledWithoutEndpoint(t *testing.T) {
+	provider, err := NewTracerProvider(context.Background(), Config{Protocol: ProtocolHTTP})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if provider != nil {
+		t.Fatal("expected no provider when endpoint is unset")
+	}
+}
+
+func TestNewTracerProviderSupportsProtocols(t *testing.T) {
+	for _, config := range []Config{
+		{Endpoint: "localhost:4317"},
+		{Endpoint: "localhost:4318", Protocol: ProtocolHTTP},
+		{Endpoint: "https://collector.example.com:4318", Protocol: ProtocolHTTP},
+	} {
+		provider, err := NewTracerProvider(context.Background(), config)
+		if err != nil {
+			t.Fatalf("expected provider for %+v, got %v", config, err)
+		}
+		if err := provider.Shutdown(context.Background()); err != nil {
+			t.Fatalf("expected clean shutdown for %+v, got %v", config, err)
+		}
+	}
+
+	_, err := NewTracerProvider(context.Background(), Config{Endpoint: "localhost:4317", Protocol: "thrift"})
+	if err == nil || !strings.Contains(err.Error(), "invalid OTLP protocol") {
+		t.Fatalf("expected invalid protocol error, got %v", err)
+	}
+}
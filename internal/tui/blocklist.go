@@ -0,0 +1,51 @@
+package tui
+
+import (
+	"fmt"
+	"net/netip"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+
+	"github.com/jaxxstorm/portal/internal/blocklist"
+)
+
+// SourceBlocker is implemented by stats providers that manage the runtime
+// source blocklist.
+type SourceBlocker interface {
+	BlockSource(prefix netip.Prefix, reason string) (blocklist.Entry, bool, error)
+}
+
+// blockLastSource returns a command that adds the source of the latest
+// request to the blocklist. Saving the blocklist touches the disk, so it runs
+// off the update loop and reports back with a LogMsg. Successful blocks are
+// reported by the server's own log line; only problems are written to the
+// logs pane here.
+func (m *Model) blockLastSource() tea.Cmd {
+	blocker, ok := m.server.(SourceBlocker)
+	if !ok {
+		m.appendLog(LogMsg{Level: "WARN", Message: "Blocklist not available", Time: time.Now()})
+		return nil
+	}
+	if m.lastRequest == nil || m.lastRequest.SourceIP == "" {
+		m.appendLog(LogMsg{Level: "WARN", Message: "No request source to block", Time: time.Now()})
+		return nil
+	}
+
+	prefix, err := blocklist.ParsePrefix(m.lastRequest.SourceIP)
+	if err != nil {
+		m.appendLog(LogMsg{Level: "ERROR", Message: err.Error(), Time: time.Now()})
+		return nil
+	}
+	return func() tea.Msg {
+		_, added, err := blocker.BlockSource(prefix, "blocked from TUI")
+		switch {
+		case err != nil:
+			return LogMsg{Level: "ERROR", Message: fmt.Sprintf("Failed to block %s: %v", prefix, err), Time: time.Now()}
+		case !added:
+			return LogMsg{Level: "INFO", Message: fmt.Sprintf("%s is already blocked", prefix), Time: time.Now()}
+		default:
+			return nil
+		}
+	}
+}
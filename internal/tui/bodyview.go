@@ -0,0 +1,50 @@
+package tui
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/jaxxstorm/portal/internal/model"
+)
+
+// renderBodyView formats a structured body view as plain text for the request
+// details pane. It returns an empty string when there is nothing structured to
+// show, in which case callers fall back to the raw body.
+func renderBodyView(view *model.BodyView) string {
+	if view == nil || (view.Error != "" && view.Pretty == "" && len(view.Fields) == 0 && len(view.Parts) == 0) {
+		return ""
+	}
+
+	var b strings.Builder
+	switch view.Kind {
+	case model.BodyKindJSON, model.BodyKindXML:
+		b.WriteString(view.Pretty)
+	case model.BodyKindForm:
+		for _, field := range view.Fields {
+			b.WriteString(fmt.Sprintf("%s = %s\n", field.Name, field.Value))
+		}
+	case model.BodyKindMultipart:
+		for i, part := range view.Parts {
+			label := fallbackString(part.Name, fmt.Sprintf("part %d", i+1))
+			details := []string{fmt.Sprintf("%d bytes", part.Size)}
+			if part.ContentType != "" {
+				details = append([]string{part.ContentType}, details...)
+			}
+			if part.Filename != "" {
+				b.WriteString(fmt.Sprintf("[%s] file=%s (%s)\n", label, part.Filename, strings.Join(details, ", ")))
+				continue
+			}
+			b.WriteString(fmt.Sprintf("[%s] (%s)\n", label, strings.Join(details, ", ")))
+			if part.Preview != "" {
+				b.WriteString(fmt.Sprintf("  %s\n", strings.ReplaceAll(strings.TrimRight(part.Preview, "\n"), "\n", "\n  ")))
+			}
+		}
+	default:
+		return ""
+	}
+
+	if view.Error != "" {
+		b.WriteString(fmt.Sprintf("\n[parse error: %s]", view.Error))
+	}
+	return strings.TrimRight(b.String(), "\n")
+}
@@ -0,0 +1,69 @@
+package tui
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jaxxstorm/portal/internal/model"
+)
+
+const (
+	chartWindow = 5 * time.Minute
+	chartStep   = 10 * time.Second
+)
+
+var sparkBlocks = []rune("▁▂▃▄▅▆▇█")
+
+// sparkline renders values as a row of block characters scaled to the
+// largest value. Zero values render as spaces so idle periods stand out.
+func sparkline(values []float64) string {
+	max := 0.0
+	for _, value := range values {
+		if value > max {
+			max = value
+		}
+	}
+
+	var b strings.Builder
+	for _, value := range values {
+		if value <= 0 || max == 0 {
+			b.WriteRune(' ')
+			continue
+		}
+		index := int(value / max * float64(len(sparkBlocks)-1))
+		b.WriteRune(sparkBlocks[index])
+	}
+	return b.String()
+}
+
+// renderTrafficCharts draws request and p95 latency sparklines for the last
+// few minutes of the server's time series.
+func renderTrafficCharts(points []model.TimeSeriesPoint) string {
+	requests := make([]float64, len(points))
+	latency := make([]float64, len(points))
+	peakRequests, peakLatency := 0, 0.0
+	for i, point := range points {
+		requests[i] = float64(point.Requests)
+		latency[i] = point.P95
+		if point.Requests > peakRequests {
+			peakRequests = point.Requests
+		}
+		if point.P95 > peakLatency {
+			peakLatency = point.P95
+		}
+	}
+
+	var b strings.Builder
+	b.WriteString(fmt.Sprintf("Traffic (last %s, %s steps)\n", formatChartDuration(chartWindow), formatChartDuration(chartStep)))
+	b.WriteString(fmt.Sprintf("req %s peak %d\n", sparkline(requests), peakRequests))
+	b.WriteString(fmt.Sprintf("p95 %s peak %.1fms\n", sparkline(latency), peakLatency))
+	return b.String()
+}
+
+func formatChartDuration(d time.Duration) string {
+	if d%time.Minute == 0 {
+		return fmt.Sprintf("%dm", int(d/time.Minute))
+	}
+	return fmt.Sprintf("%ds", int(d/time.Second))
+}
@@ -0,0 +1,46 @@
+package tui
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jaxxstorm/portal/internal/model"
+)
+
+// maxPeerRows is the number of peers listed under the connection counts.
+const maxPeerRows = 3
+
+// renderConnectionStats formats open connection counts and the peers holding
+// the most connections.
+func renderConnectionStats(conns model.ConnectionStats) string {
+	var b strings.Builder
+	b.WriteString(fmt.Sprintf("%-12s %5s %5s %5s %5s %6s %6s\n",
+		"Connections", "open", "act", "idle", "acc", "req/c", "age"))
+	b.WriteString(strings.Repeat("-", 55) + "\n")
+	b.WriteString(fmt.Sprintf("%-12s %5d %5d %5d %5d %6.1f %6s\n",
+		"", conns.Open, conns.Active, conns.Idle, conns.Accepted, conns.RequestsPerConnection, formatAge(conns.MaxAge)))
+
+	for i, peer := range conns.Peers {
+		if i == maxPeerRows {
+			b.WriteString(fmt.Sprintf("  +%d more peers\n", len(conns.Peers)-maxPeerRows))
+			break
+		}
+		b.WriteString(fmt.Sprintf("  %-22s %3d open %5d req %6s\n",
+			truncateString(peer.Address, 22), peer.Open, peer.Requests, formatAge(peer.OldestAge)))
+	}
+	return b.String()
+}
+
+// formatAge renders a duration in seconds using its largest whole unit.
+func formatAge(seconds float64) string {
+	age := time.Duration(seconds * float64(time.Second))
+	switch {
+	case age >= time.Hour:
+		return fmt.Sprintf("%dh", int(age/time.Hour))
+	case age >= time.Minute:
+		return fmt.Sprintf("%dm", int(age/time.Minute))
+	default:
+		return fmt.Sprintf("%ds", int(age/time.Second))
+	}
+}
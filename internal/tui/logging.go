@@ -126,13 +126,14 @@ func (l *TUIOnlyLogger) logWithFields(level, msg string, fields ...zap.Field) {
 	})
 }
 
-// CreateTUIZapLogger creates a zap logger that sends output to the TUI
-func CreateTUIZapLogger(program *tea.Program) *zap.Logger {
+// CreateTUIZapLogger creates a zap logger that sends output to the TUI at
+// the levels enabled by level
+func CreateTUIZapLogger(program *tea.Program, level zapcore.LevelEnabler) *zap.Logger {
 	// Create a TUIOnlyLogger instance
 	tuiLogger := NewTUIOnlyLogger(program)
 
 	// Create a custom core that routes directly to TUIOnlyLogger
-	tuiCore := &tuiZapCore{tuiLogger: tuiLogger}
+	tuiCore := &tuiZapCore{tuiLogger: tuiLogger, level: level}
 
 	// Create logger with custom core
 	logger := zap.New(tuiCore)
@@ -663,11 +664,11 @@ func (w *simpleTUIZapWriter) Sync() error {
 // tuiZapCore implements zapcore.Core for direct TUIOnlyLogger integration
 type tuiZapCore struct {
 	tuiLogger *TUIOnlyLogger
-	level     zapcore.Level
+	level     zapcore.LevelEnabler
 }
 
 func (c *tuiZapCore) Enabled(level zapcore.Level) bool {
-	return level >= zapcore.InfoLevel // Always show INFO and above
+	return c.level.Enabled(level)
 }
 
 func (c *tuiZapCore) With(fields []zapcore.Field) zapcore.Core {
@@ -14,6 +14,7 @@ import (
 	"github.com/charmbracelet/x/ansi"
 
 	"github.com/jaxxstorm/portal/internal/model"
+	"github.com/jaxxstorm/portal/internal/stats"
 )
 
 const (
@@ -50,6 +51,11 @@ type layoutSpec struct {
 // and endpoint startup state.
 type StatsProvider interface {
 	GetStats() (ttl, opn int, rt1, rt5, p50, p90 float64)
+	GetLatencyWindows() []model.LatencyWindow
+	GetRouteStats() []model.RouteStats
+	GetTimeSeries(window, step time.Duration) ([]model.TimeSeriesPoint, error)
+	GetConnectionStats() model.ConnectionStats
+	GetTopTalkers() []model.Talker
 	GetEndpointState() model.EndpointState
 }
 
@@ -67,8 +73,18 @@ type Model struct {
 	lastRequest *model.RequestLog
 	ready       bool
 	server      StatsProvider
+	detailsView int
+	routeSort   int
+	talkerSort  int
 }
 
+// Views shown in the request details pane.
+const (
+	detailsRequest = iota
+	detailsRoutes
+	detailsTalkers
+)
+
 // Message types for TUI updates
 type LogMsg struct {
 	Level   string
@@ -130,6 +146,34 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		switch msg.String() {
 		case "ctrl+c", "q":
 			return m, tea.Quit
+		case "r", "t":
+			view := detailsRoutes
+			if msg.String() == "t" {
+				view = detailsTalkers
+			}
+			if m.detailsView == view {
+				view = detailsRequest
+			}
+			m.detailsView = view
+			if m.ready {
+				m.updateHeadersPane()
+			}
+			return m, nil
+		case "s":
+			switch m.detailsView {
+			case detailsRoutes:
+				m.routeSort = (m.routeSort + 1) % len(stats.RouteSortKeys)
+			case detailsTalkers:
+				m.talkerSort = (m.talkerSort + 1) % len(stats.TalkerSortKeys)
+			default:
+				return m, nil
+			}
+			if m.ready {
+				m.updateHeadersPane()
+			}
+			return m, nil
+		case "b":
+			return m, m.blockLastSource()
 		case "up", "k", "down", "j", "pgup", "pgdown":
 			if m.ready {
 				m.appLogs, _ = m.appLogs.Update(msg)
@@ -424,24 +468,58 @@ func (m *Model) updateStatsPane() {
 	b.WriteString("\n\n")
 
 	b.WriteString(fmt.Sprintf("%-12s %5s %5s %6s %6s %6s %6s\n",
-		"Connections", "ttl", "opn", "rt1", "rt5", "p50", "p90"))
+		"Requests", "ttl", "opn", "rt1", "rt5", "p50", "p90"))
 	b.WriteString(strings.Repeat("-", 55) + "\n")
 	b.WriteString(fmt.Sprintf("%-12s %5d %5d %6.1f %6.1f %6.1f %6.1f\n\n",
 		"", ttl, opn, rt1, rt5, p50, p90))
 
+	b.WriteString(renderConnectionStats(m.server.GetConnectionStats()))
+	b.WriteString("\n")
+
+	b.WriteString(fmt.Sprintf("%-7s %5s %6s %6s %6s %6s %6s\n",
+		"Latency", "n", "p50", "p90", "p95", "p99", "max"))
+	b.WriteString(strings.Repeat("-", 55) + "\n")
+	for _, window := range m.server.GetLatencyWindows() {
+		b.WriteString(fmt.Sprintf("%-7s %5d %6.1f %6.1f %6.1f %6.1f %6.1f\n",
+			window.Window, window.Count, window.P50, window.P90, window.P95, window.P99, window.Max))
+	}
+	b.WriteString("\n")
+
+	if points, err := m.server.GetTimeSeries(chartWindow, chartStep); err == nil {
+		b.WriteString(renderTrafficCharts(points))
+		b.WriteString("\n")
+	}
+
 	b.WriteString("Legend:\n")
 	b.WriteString("  ttl: Total requests\n")
-	b.WriteString("  opn: Open connections\n")
+	b.WriteString("  opn: In-flight requests\n")
 	b.WriteString("  rt1: Avg response time 1m (ms)\n")
 	b.WriteString("  rt5: Avg response time 5m (ms)\n")
-	b.WriteString("  p50: 50th percentile (ms)\n")
-	b.WriteString("  p90: 90th percentile (ms)\n")
+	b.WriteString("  p50: 50th percentile, 15m (ms)\n")
+	b.WriteString("  p90: 90th percentile, 15m (ms)\n")
+	b.WriteString("  n: Requests in window\n")
+	b.WriteString("  acc: Connections accepted\n")
+	b.WriteString("  req/c: Requests per connection\n")
+	b.WriteString("  age: Oldest open connection\n")
 
 	m.statsPane.SetContent(b.String())
 }
 
 // updateHeadersPane updates the headers pane content
 func (m *Model) updateHeadersPane() {
+	if m.detailsView == detailsRoutes && m.server != nil {
+		routes := m.server.GetRouteStats()
+		_ = stats.SortRouteStats(routes, m.routeSortKey())
+		m.headersPane.SetContent(renderRouteStats(routes, m.routeSortKey(), maxInt(m.headersPane.Width-4, 32)))
+		return
+	}
+	if m.detailsView == detailsTalkers && m.server != nil {
+		talkers := m.server.GetTopTalkers()
+		_ = stats.SortTalkers(talkers, m.talkerSortKey())
+		m.headersPane.SetContent(renderTopTalkers(talkers, m.talkerSortKey(), maxInt(m.headersPane.Width-4, 32), time.Now()))
+		return
+	}
+
 	var b strings.Builder
 	b.WriteString(lipgloss.NewStyle().Bold(true).Render("Latest Request"))
 	b.WriteString("\n\n")
@@ -462,15 +540,40 @@ func (m *Model) updateHeadersPane() {
 	lineWidth := maxInt(m.headersPane.Width-4, 32)
 	headerValueLimit := maxInt(lineWidth-18, 24)
 
-	b.WriteString(fmt.Sprintf("%s %s\n",
-		lipgloss.NewStyle().Bold(true).Render(m.lastRequest.Method),
-		truncateString(m.lastRequest.URL, lineWidth)))
+	if gql := m.lastRequest.GraphQL; gql != nil {
+		b.WriteString(fmt.Sprintf("%s %s\n",
+			lipgloss.NewStyle().Bold(true).Render(gql.OperationType),
+			truncateString(gql.OperationName, lineWidth)))
+		b.WriteString(fmt.Sprintf("Endpoint: %s %s\n", m.lastRequest.Method, truncateString(m.lastRequest.URL, lineWidth)))
+		if len(gql.Errors) > 0 {
+			b.WriteString(fmt.Sprintf("GraphQL Errors: %s\n",
+				lipgloss.NewStyle().Foreground(lipgloss.Color("196")).Render(
+					truncateString(fmt.Sprintf("%d (%s)", len(gql.Errors), gql.Errors[0]), lineWidth))))
+		}
+	} else {
+		b.WriteString(fmt.Sprintf("%s %s\n",
+			lipgloss.NewStyle().Bold(true).Render(m.lastRequest.Method),
+			truncateString(m.lastRequest.URL, lineWidth)))
+	}
 
 	b.WriteString(fmt.Sprintf("Status: %s  Duration: %s\n",
 		lipgloss.NewStyle().Foreground(statusColor).Render(fmt.Sprintf("%d", m.lastRequest.Response.StatusCode)),
 		m.lastRequest.Duration.Round(time.Millisecond).String()))
 
 	b.WriteString(fmt.Sprintf("From: %s\n", truncateString(m.lastRequest.RemoteAddr, lineWidth)))
+	if m.lastRequest.SourceIP != "" {
+		source := m.lastRequest.SourceIP
+		if geo := formatGeo(m.lastRequest.Geo); geo != "" {
+			source += " (" + geo + ")"
+		}
+		b.WriteString(fmt.Sprintf("Source: %s\n", truncateString(source, lineWidth)))
+	}
+	if m.lastRequest.AccessRule != "" {
+		b.WriteString(fmt.Sprintf("Access rule: %s\n", truncateString(m.lastRequest.AccessRule, lineWidth)))
+	}
+	if m.lastRequest.RequestID != "" {
+		b.WriteString(fmt.Sprintf("Request ID: %s\n", truncateString(m.lastRequest.RequestID, lineWidth)))
+	}
 	b.WriteString(fmt.Sprintf("Time: %s\n\n", m.lastRequest.Timestamp.Format("15:04:05")))
 
 	if len(m.lastRequest.Headers) > 0 {
@@ -496,7 +599,7 @@ func (m *Model) updateHeadersPane() {
 		}
 		sort.Strings(otherHeaders)
 
-		currentLines := 7 + len(shown)
+		currentLines := strings.Count(b.String(), "\n")
 		availableLines := m.headersPane.Height - currentLines - 3
 		if availableLines < 0 {
 			availableLines = 0
@@ -519,17 +622,24 @@ func (m *Model) updateHeadersPane() {
 		b.WriteString("\n")
 	}
 
-	if m.lastRequest.Body != "" {
-		b.WriteString(lipgloss.NewStyle().Bold(true).Render("Request Body:"))
+	body := m.lastRequest.Body
+	bodyTitle := "Request Body:"
+	if structured := renderBodyView(m.lastRequest.BodyView); structured != "" {
+		body = structured
+		bodyTitle = fmt.Sprintf("Request Body (%s):", m.lastRequest.BodyView.Kind)
+	}
+
+	if body != "" {
+		b.WriteString(lipgloss.NewStyle().Bold(true).Render(bodyTitle))
 		b.WriteString("\n")
 
 		currentLines := strings.Count(b.String(), "\n")
 		availableLines := m.headersPane.Height - currentLines - 2
 		maxBodyChars := maxInt(availableLines*lineWidth, 160)
 
-		if len(m.lastRequest.Body) > maxBodyChars {
-			b.WriteString(fmt.Sprintf("[%d bytes - showing first %d chars]\n", len(m.lastRequest.Body), maxBodyChars))
-			bodyPreview := m.lastRequest.Body[:maxBodyChars]
+		if len(body) > maxBodyChars {
+			b.WriteString(fmt.Sprintf("[%d bytes - showing first %d chars]\n", len(body), maxBodyChars))
+			bodyPreview := body[:maxBodyChars]
 			if lastNewline := strings.LastIndex(bodyPreview, "\n"); lastNewline > maxBodyChars-100 {
 				bodyPreview = bodyPreview[:lastNewline]
 			} else if lastSpace := strings.LastIndex(bodyPreview, " "); lastSpace > maxBodyChars-50 {
@@ -538,7 +648,7 @@ func (m *Model) updateHeadersPane() {
 			b.WriteString(bodyPreview)
 			b.WriteString("\n...")
 		} else {
-			b.WriteString(m.lastRequest.Body)
+			b.WriteString(body)
 		}
 		b.WriteString("\n")
 	}
@@ -560,6 +670,28 @@ func truncateString(s string, maxLen int) string {
 	return s[:maxLen-3] + "..."
 }
 
+// formatGeo formats GeoIP data as "NL, AS64500 Example Hosting".
+func formatGeo(geo *model.GeoInfo) string {
+	if geo == nil {
+		return ""
+	}
+	var network []string
+	if geo.ASN != 0 {
+		network = append(network, fmt.Sprintf("AS%d", geo.ASN))
+	}
+	if geo.ASOrg != "" {
+		network = append(network, geo.ASOrg)
+	}
+	parts := make([]string, 0, 2)
+	if geo.Country != "" {
+		parts = append(parts, geo.Country)
+	}
+	if len(network) > 0 {
+		parts = append(parts, strings.Join(network, " "))
+	}
+	return strings.Join(parts, ", ")
+}
+
 // View renders the TUI
 func (m Model) View() string {
 	if !m.ready {
@@ -580,7 +712,7 @@ func (m Model) View() string {
 	requestSection := ""
 	if m.layout.headersHeight > 0 {
 		requestSection = lipgloss.JoinVertical(lipgloss.Top,
-			titleStyle.Render("Request Details"),
+			titleStyle.Render(m.detailsTitle()),
 			panelStyle.Width(m.layout.headersWidth).Height(m.layout.headersHeight).Render(m.headersPane.View()),
 		)
 	}
@@ -627,13 +759,24 @@ func (m Model) View() string {
 
 	footer := lipgloss.NewStyle().
 		Foreground(lipgloss.Color("240")).
-		Render("Press 'q' or Ctrl+C to quit | Up/Down or j/k to scroll logs | PgUp/PgDn for faster scrolling")
+		Render("Press 'q' or Ctrl+C to quit | Up/Down or j/k to scroll logs | PgUp/PgDn for faster scrolling | r routes, t talkers, s sort, b block source")
 
 	mainView := lipgloss.JoinVertical(lipgloss.Top, mainSections...)
 	final := lipgloss.JoinVertical(lipgloss.Top, mainView, footer)
 	return sanitizeViewToWindow(strings.TrimRight(final, "\n"), m.width, m.height)
 }
 
+func (m Model) detailsTitle() string {
+	switch m.detailsView {
+	case detailsRoutes:
+		return "Routes"
+	case detailsTalkers:
+		return "Top Talkers"
+	default:
+		return "Request Details"
+	}
+}
+
 func exposureLabel(exposure string) string {
 	switch exposure {
 	case "tailnet":
@@ -1,6 +1,7 @@
 package tui
 
 import (
+	"net/netip"
 	"strings"
 	"testing"
 	"time"
@@ -8,6 +9,7 @@ import (
 	tea "github.com/charmbracelet/bubbletea"
 	"github.com/charmbracelet/x/ansi"
 
+	"github.com/jaxxstorm/portal/internal/blocklist"
 	"github.com/jaxxstorm/portal/internal/model"
 )
 
@@ -16,12 +18,37 @@ type stubStatsProvider struct {
 	ttl, opn      int
 	rt1, rt5, p50 float64
 	p90           float64
+	windows       []model.LatencyWindow
+	routes        []model.RouteStats
+	series        []model.TimeSeriesPoint
+	conns         model.ConnectionStats
+	talkers       []model.Talker
 }
 
 func (s *stubStatsProvider) GetStats() (ttl, opn int, rt1, rt5, p50, p90 float64) {
 	return s.ttl, s.opn, s.rt1, s.rt5, s.p50, s.p90
 }
 
+func (s *stubStatsProvider) GetLatencyWindows() []model.LatencyWindow {
+	return s.windows
+}
+
+func (s *stubStatsProvider) GetRouteStats() []model.RouteStats {
+	return append([]model.RouteStats(nil), s.routes...)
+}
+
+func (s *stubStatsProvider) GetTimeSeries(window, step time.Duration) ([]model.TimeSeriesPoint, error) {
+	return s.series, nil
+}
+
+func (s *stubStatsProvider) GetConnectionStats() model.ConnectionStats {
+	return s.conns
+}
+
+func (s *stubStatsProvider) GetTopTalkers() []model.Talker {
+	return append([]model.Talker(nil), s.talkers...)
+}
+
 func (s *stubStatsProvider) GetEndpointState() model.EndpointState {
 	return s.state
 }
@@ -293,3 +320,240 @@ func TestViewFitsWithinWindowHeight(t *testing.T) {
 		})
 	}
 }
+
+func TestRequestDetailsRenderStructuredBody(t *testing.T) {
+	provider := &stubStatsProvider{}
+	m := NewModel(provider)
+	resizeModel(t, &m, 140, 42)
+
+	updateModel(t, &m, RequestMsg{Log: model.RequestLog{
+		Method:    "POST",
+		URL:       "/hooks",
+		Timestamp: time.Now(),
+		Body:      `{"event":"push"}`,
+		BodyView: &model.BodyView{
+			Kind:   model.BodyKindJSON,
+			Pretty: "{\n  \"event\": \"push\"\n}",
+		},
+		Response: model.ResponseLog{StatusCode: 200},
+	}})
+
+	content := normalizePaneText(m.headersPane.View())
+	for _, required := range []string{"Request Body (json):", "\"event\": \"push\""} {
+		if !strings.Contains(content, required) {
+			t.Fatalf("expected request details to contain %q, got %q", required, content)
+		}
+	}
+}
+
+func TestRequestDetailsLabelGraphQLOperation(t *testing.T) {
+	provider := &stubStatsProvider{}
+	m := NewModel(provider)
+	resizeModel(t, &m, 140, 42)
+
+	updateModel(t, &m, RequestMsg{Log: model.RequestLog{
+		Method:    "POST",
+		URL:       "/graphql",
+		Timestamp: time.Now(),
+		GraphQL: &model.GraphQLOperation{
+			OperationType: "query",
+			OperationName: "GetUser",
+			Errors:        []string{"user not found"},
+		},
+		Response: model.ResponseLog{StatusCode: 200},
+	}})
+
+	content := normalizePaneText(m.headersPane.View())
+	for _, required := range []string{"query GetUser", "Endpoint: POST /graphql", "GraphQL Errors: 1 (user not found)"} {
+		if !strings.Contains(content, required) {
+			t.Fatalf("expected request details to contain %q, got %q", required, content)
+		}
+	}
+}
+
+func TestStatsPaneShowsLatencyWindows(t *testing.T) {
+	provider := &stubStatsProvider{
+		windows: []model.LatencyWindow{
+			{Window: "1m", Count: 4, P50: 12.5, P90: 30, P95: 41.2, P99: 88.8, Max: 90.1},
+			{Window: "5m", Count: 9},
+			{Window: "15m", Count: 20},
+		},
+	}
+	m := NewModel(provider)
+	resizeModel(t, &m, 140, 42)
+	m.updateStatsPane()
+
+	content := normalizePaneText(m.statsPane.View())
+	for _, required := range []string{"p95", "p99", "max", "1m          4   12.5   30.0   41.2   88.8   90.1", "15m        20"} {
+		if !strings.Contains(content, required) {
+			t.Fatalf("expected stats pane to contain %q, got %q", required, content)
+		}
+	}
+}
+
+func TestRoutesViewTogglesAndCyclesSort(t *testing.T) {
+	provider := &stubStatsProvider{routes: []model.RouteStats{
+		{Method: "GET", Route: "/users/{id}", Count: 10, P95: 12, ResponseBytes: 4096},
+		{Method: "POST", Route: "/orders", Count: 2, ErrorRate: 50, P95: 480},
+	}}
+	m := NewModel(provider)
+	resizeModel(t, &m, 140, 42)
+
+	updateModel(t, &m, tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune("r")})
+	content := normalizePaneText(m.headersPane.View())
+	if !strings.Contains(content, "Routes (sorted by count)") || !strings.Contains(content, "4.0K") {
+		t.Fatalf("expected routes table sorted by count, got %q", content)
+	}
+	if strings.Index(content, "/users/{id}") > strings.Index(content, "/orders") {
+		t.Fatalf("expected busiest route first, got %q", content)
+	}
+
+	updateModel(t, &m, tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune("s")})
+	content = normalizePaneText(m.headersPane.View())
+	if !strings.Contains(content, "Routes (sorted by errors)") || strings.Index(content, "/orders") > strings.Index(content, "/users/{id}") {
+		t.Fatalf("expected routes sorted by error rate, got %q", content)
+	}
+	if !strings.Contains(m.View(), "Routes") {
+		t.Fatal("expected pane title to switch to Routes")
+	}
+
+	updateModel(t, &m, tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune("r")})
+	if content := normalizePaneText(m.headersPane.View()); !strings.Contains(content, "Latest Request") {
+		t.Fatalf("expected request details after toggling back, got %q", content)
+	}
+}
+
+func TestStatsPaneShowsTrafficSparklines(t *testing.T) {
+	provider := &stubStatsProvider{series: []model.TimeSeriesPoint{
+		{Requests: 0}, {Requests: 4, P95: 10}, {Requests: 8, P95: 40},
+	}}
+	m := NewModel(provider)
+	resizeModel(t, &m, 140, 60)
+	m.updateStatsPane()
+
+	content := normalizePaneText(m.statsPane.View())
+	for _, required := range []string{"Traffic (last 5m, 10s steps)", "req  ▄█ peak 8", "p95  ▂█ peak 40.0ms"} {
+		if !strings.Contains(content, required) {
+			t.Fatalf("expected stats pane to contain %q, got %q", required, content)
+		}
+	}
+}
+
+func TestStatsPaneShowsConnections(t *testing.T) {
+	provider := &stubStatsProvider{ttl: 12, opn: 1, conns: model.ConnectionStats{
+		Open: 3, Active: 1, Idle: 2, Accepted: 5, RequestsPerConnection: 2.4, MaxAge: 125,
+		Peers: []model.ConnectionPeer{
+			{Address: "100.64.0.7", Open: 2, Requests: 9, OldestAge: 125},
+			{Address: "203.0.113.9", Open: 1, Requests: 3, OldestAge: 4},
+		},
+	}}
+	m := NewModel(provider)
+	resizeModel(t, &m, 140, 60)
+	m.updateStatsPane()
+
+	content := normalizePaneText(m.statsPane.View())
+	for _, required := range []string{
+		"12     1    0.0",
+		"Connections   open   act  idle   acc  req/c    age",
+		"3     1     2     5    2.4     2m",
+		"100.64.0.7               2 open     9 req     2m",
+		"203.0.113.9              1 open     3 req     4s",
+	} {
+		if !strings.Contains(content, required) {
+			t.Fatalf("expected stats pane to contain %q, got %q", required, content)
+		}
+	}
+}
+
+func TestTalkersViewShowsSourcesAndCyclesSort(t *testing.T) {
+	now := time.Now()
+	provider := &stubStatsProvider{talkers: []model.Talker{
+		{IP: "100.64.0.7", Requests: 40, LastSeen: now.Add(-90 * time.Second), Paths: []model.TalkerCount{{Value: "/api/orders", Count: 40}}},
+		{IP: "198.51.100.4", Requests: 3, Denied: 3, ErrorRate: 100, LastSeen: now, Paths: []model.TalkerCount{{Value: "/.env", Count: 2}}},
+	}}
+	m := NewModel(provider)
+	resizeModel(t, &m, 140, 42)
+
+	updateModel(t, &m, tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune("t")})
+	content := normalizePaneText(m.headersPane.View())
+	if !strings.Contains(content, "Top Talkers (sorted by requests)") || !strings.Contains(content, "/api/orders") {
+		t.Fatalf("expected talkers table sorted by requests, got %q", content)
+	}
+	if !strings.Contains(content, "100.64.0.7          40    0.0     0    1m /api/orders") {
+		t.Fatalf("expected talker row, got %q", content)
+	}
+	if !strings.Contains(m.View(), "Top Talkers") {
+		t.Fatal("expected pane title to switch to Top Talkers")
+	}
+
+	updateModel(t, &m, tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune("s")})
+	content = normalizePaneText(m.headersPane.View())
+	if !strings.Contains(content, "Top Talkers (sorted by errors)") || strings.Index(content, "/.env") > strings.Index(content, "/api/orders") {
+		t.Fatalf("expected talkers sorted by error rate, got %q", content)
+	}
+
+	updateModel(t, &m, tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune("r")})
+	if content := normalizePaneText(m.headersPane.View()); !strings.Contains(content, "Routes (sorted by count)") {
+		t.Fatalf("expected routes view after pressing r, got %q", content)
+	}
+	updateModel(t, &m, tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune("r")})
+	if content := normalizePaneText(m.headersPane.View()); !strings.Contains(content, "Latest Request") {
+		t.Fatalf("expected request details after toggling back, got %q", content)
+	}
+}
+
+type stubSourceBlocker struct {
+	stubStatsProvider
+	blocked []netip.Prefix
+}
+
+func (s *stubSourceBlocker) BlockSource(prefix netip.Prefix, reason string) (blocklist.Entry, bool, error) {
+	for _, blocked := range s.blocked {
+		if blocked == prefix {
+			return blocklist.Entry{Prefix: prefix}, false, nil
+		}
+	}
+	s.blocked = append(s.blocked, prefix)
+	return blocklist.Entry{Prefix: prefix, Reason: reason, Source: blocklist.SourceManual}, true, nil
+}
+
+func TestBlockKeyBlocksLatestRequestSource(t *testing.T) {
+	provider := &stubSourceBlocker{}
+	m := NewModel(provider)
+	resizeModel(t, &m, 140, 42)
+
+	updateModel(t, &m, tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune("b")})
+	if len(provider.blocked) != 0 || !strings.Contains(m.renderLogsContent(), "No request source to block") {
+		t.Fatalf("expected no block without a request, got %v", provider.blocked)
+	}
+
+	updateModel(t, &m, RequestMsg{Log: model.RequestLog{
+		Method:     "GET",
+		URL:        "/.env",
+		SourceIP:   "203.0.113.9",
+		Geo:        &model.GeoInfo{Country: "NL", ASN: 64500, ASOrg: "Example Hosting"},
+		AccessRule: "admin",
+		Timestamp:  time.Now(),
+	}})
+	content := normalizePaneText(m.headersPane.View())
+	if !strings.Contains(content, "Source: 203.0.113.9 (NL, AS64500 Example Hosting)") || !strings.Contains(content, "Access rule: admin") {
+		t.Fatalf("expected request details to show the source and access rule, got %q", content)
+	}
+
+	_, cmd := m.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune("b")})
+	if cmd == nil || len(provider.blocked) != 0 {
+		t.Fatalf("expected blocking to run as a command, got %v", provider.blocked)
+	}
+	if msg := cmd(); msg != nil {
+		t.Fatalf("expected no message for a new block, got %#v", msg)
+	}
+	if len(provider.blocked) != 1 || provider.blocked[0].String() != "203.0.113.9/32" {
+		t.Fatalf("expected latest source to be blocked, got %v", provider.blocked)
+	}
+
+	_, cmd = m.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune("b")})
+	updateModel(t, &m, cmd())
+	if !strings.Contains(m.renderLogsContent(), "203.0.113.9/32 is already blocked") {
+		t.Fatalf("expected already-blocked log line, got %q", m.renderLogsContent())
+	}
+}
@@ -0,0 +1,64 @@
+package tui
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/charmbracelet/lipgloss"
+
+	"github.com/jaxxstorm/portal/internal/model"
+	"github.com/jaxxstorm/portal/internal/stats"
+)
+
+// routeSortKey returns the sort key currently selected for the routes view.
+func (m *Model) routeSortKey() string {
+	return stats.RouteSortKeys[m.routeSort%len(stats.RouteSortKeys)]
+}
+
+// renderRouteStats formats per-route statistics as a table that fits width.
+func renderRouteStats(routes []model.RouteStats, sortKey string, width int) string {
+	var b strings.Builder
+	b.WriteString(lipgloss.NewStyle().Bold(true).Render(fmt.Sprintf("Routes (sorted by %s)", sortKey)))
+	b.WriteString("\n\n")
+
+	if len(routes) == 0 {
+		b.WriteString("No requests yet...")
+		return b.String()
+	}
+
+	const fixedColumns = 7 + 6 + 7 + 8 + 9 // method, count, err%, p95, bytes with spacing
+	routeWidth := maxInt(width-fixedColumns, 12)
+
+	b.WriteString(fmt.Sprintf("%-7s%-*s %5s %6s %7s %8s\n", "Method", routeWidth, "Route", "n", "err%", "p95", "bytes"))
+	b.WriteString(strings.Repeat("-", minInt(width, routeWidth+fixedColumns)) + "\n")
+	for _, route := range routes {
+		b.WriteString(fmt.Sprintf("%-7s%-*s %5d %6.1f %7.1f %8s\n",
+			truncateString(route.Method, 6),
+			routeWidth, truncateString(route.Route, routeWidth),
+			route.Count,
+			route.ErrorRate,
+			route.P95,
+			formatByteCount(route.RequestBytes+route.ResponseBytes)))
+	}
+	return b.String()
+}
+
+func formatByteCount(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%dB", n)
+	}
+	div, exp := int64(unit), 0
+	for v := n / unit; v >= unit; v /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f%c", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
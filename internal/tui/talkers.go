@@ -0,0 +1,51 @@
+package tui
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/charmbracelet/lipgloss"
+
+	"github.com/jaxxstorm/portal/internal/model"
+	"github.com/jaxxstorm/portal/internal/stats"
+)
+
+// talkerSortKey returns the sort key currently selected for the talkers view.
+func (m *Model) talkerSortKey() string {
+	return stats.TalkerSortKeys[m.talkerSort%len(stats.TalkerSortKeys)]
+}
+
+// renderTopTalkers formats per-source statistics as a table that fits width,
+// showing each source's most requested path.
+func renderTopTalkers(talkers []model.Talker, sortKey string, width int, now time.Time) string {
+	var b strings.Builder
+	b.WriteString(lipgloss.NewStyle().Bold(true).Render(fmt.Sprintf("Top Talkers (sorted by %s)", sortKey)))
+	b.WriteString("\n\n")
+
+	if len(talkers) == 0 {
+		b.WriteString("No requests yet...")
+		return b.String()
+	}
+
+	const sourceWidth = 16
+	const fixedColumns = sourceWidth + 6 + 7 + 6 + 6 + 1 // source, n, err%, deny, seen with spacing
+	pathWidth := maxInt(width-fixedColumns, 8)
+
+	b.WriteString(fmt.Sprintf("%-*s %5s %6s %5s %5s %s\n", sourceWidth, "Source", "n", "err%", "deny", "seen", "top path"))
+	b.WriteString(strings.Repeat("-", minInt(width, pathWidth+fixedColumns)) + "\n")
+	for _, talker := range talkers {
+		topPath := ""
+		if len(talker.Paths) > 0 {
+			topPath = talker.Paths[0].Value
+		}
+		b.WriteString(fmt.Sprintf("%-*s %5d %6.1f %5d %5s %s\n",
+			sourceWidth, truncateString(talker.IP, sourceWidth),
+			talker.Requests,
+			talker.ErrorRate,
+			talker.Denied,
+			formatAge(now.Sub(talker.LastSeen).Seconds()),
+			truncateString(topPath, pathWidth)))
+	}
+	return b.String()
+}
@@ -3,23 +3,52 @@ package ui
 
 import (
 	"encoding/json"
+	"fmt"
 	"io"
 	"io/fs"
 	"net/http"
+	"net/netip"
 	"net/url"
+	"strconv"
 	"strings"
 	"time"
 
+	"github.com/jaxxstorm/portal/internal/blocklist"
 	"github.com/jaxxstorm/portal/internal/model"
+	"github.com/jaxxstorm/portal/internal/stats"
 )
 
 // LogProvider interface for getting request logs and stats
 type LogProvider interface {
 	GetRequestLogs() []model.RequestLog
 	GetStats() (ttl, opn int, rt1, rt5, p50, p90 float64)
+	GetLatencyWindows() []model.LatencyWindow
+	GetRouteStats() []model.RouteStats
+	GetTimeSeries(window, step time.Duration) ([]model.TimeSeriesPoint, error)
+	GetConnectionStats() model.ConnectionStats
+	GetTopTalkers() []model.Talker
 	ClearRequestLogs()
 }
 
+const (
+	defaultSeriesWindow = 15 * time.Minute
+	defaultSeriesStep   = 10 * time.Second
+)
+
+// MetricsProvider is implemented by log providers that expose Prometheus
+// metrics.
+type MetricsProvider interface {
+	MetricsHandler() http.Handler
+}
+
+// BlocklistProvider is implemented by log providers that manage the runtime
+// source blocklist.
+type BlocklistProvider interface {
+	GetBlocklist() []blocklist.Entry
+	BlockSource(prefix netip.Prefix, reason string) (blocklist.Entry, bool, error)
+	UnblockSource(prefix netip.Prefix) (bool, error)
+}
+
 // Server serves the web dashboard UI
 type Server struct {
 	logProvider LogProvider
@@ -49,10 +78,29 @@ func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if r.URL.Path == "/metrics" || r.URL.Path == "/ui/metrics" {
+		s.handleMetrics(w, r)
+		return
+	}
+
 	// Static files
 	s.handleStatic(w, r)
 }
 
+// handleMetrics serves Prometheus metrics when the log provider exposes them.
+func (s *Server) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	provider, ok := s.logProvider.(MetricsProvider)
+	if !ok {
+		http.Error(w, "metrics not available", http.StatusServiceUnavailable)
+		return
+	}
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	provider.MetricsHandler().ServeHTTP(w, r)
+}
+
 // handleAPI handles API requests for the web dashboard
 func (s *Server) handleAPI(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
@@ -96,6 +144,9 @@ func (s *Server) handleAPI(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 		requests := s.logProvider.GetRequestLogs()
+		if operation := strings.TrimSpace(r.URL.Query().Get("graphql_operation")); operation != "" {
+			requests = filterGraphQLOperation(requests, operation)
+		}
 		json.NewEncoder(w).Encode(requests)
 	case "/api/stats":
 		if r.Method != http.MethodGet {
@@ -116,8 +167,29 @@ func (s *Server) handleAPI(w http.ResponseWriter, r *http.Request) {
 			"avg_response_time_5m": rt5,
 			"p50_response_time":    p50,
 			"p90_response_time":    p90,
+			"latency_windows":      s.logProvider.GetLatencyWindows(),
 		}
 		json.NewEncoder(w).Encode(stats)
+	case "/api/stats/routes":
+		s.handleRouteStats(w, r)
+	case "/api/timeseries":
+		s.handleTimeSeries(w, r)
+	case "/api/talkers":
+		s.handleTopTalkers(w, r)
+	case "/api/blocklist":
+		s.handleBlocklist(w, r)
+	case "/api/connections":
+		if r.Method != http.MethodGet {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			json.NewEncoder(w).Encode(map[string]string{"error": "method not allowed"})
+			return
+		}
+		if s.logProvider == nil {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			json.NewEncoder(w).Encode(map[string]string{"error": "stats provider not available"})
+			return
+		}
+		json.NewEncoder(w).Encode(s.logProvider.GetConnectionStats())
 	case "/api/health":
 		// Health check endpoint
 		health := map[string]interface{}{
@@ -131,10 +203,226 @@ func (s *Server) handleAPI(w http.ResponseWriter, r *http.Request) {
 		}
 		json.NewEncoder(w).Encode(health)
 	default:
+		if id, ok := strings.CutPrefix(apiPath, "/api/requests/"); ok && id != "" {
+			s.handleRequestByID(w, r, id)
+			return
+		}
 		http.NotFound(w, r)
 	}
 }
 
+// handleRouteStats returns per-route statistics, sorted by the optional sort
+// query parameter (count, errors, p95, bytes or route).
+func (s *Server) handleRouteStats(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		json.NewEncoder(w).Encode(map[string]string{"error": "method not allowed"})
+		return
+	}
+	if s.logProvider == nil {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		json.NewEncoder(w).Encode(map[string]string{"error": "stats provider not available"})
+		return
+	}
+
+	routes := s.logProvider.GetRouteStats()
+	if err := stats.SortRouteStats(routes, r.URL.Query().Get("sort")); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+		return
+	}
+	json.NewEncoder(w).Encode(routes)
+}
+
+// handleTopTalkers returns per-source statistics, sorted by the optional sort
+// query parameter (requests, errors, denied or recent) and cut to limit.
+func (s *Server) handleTopTalkers(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		json.NewEncoder(w).Encode(map[string]string{"error": "method not allowed"})
+		return
+	}
+	if s.logProvider == nil {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		json.NewEncoder(w).Encode(map[string]string{"error": "stats provider not available"})
+		return
+	}
+
+	talkers := s.logProvider.GetTopTalkers()
+	if err := stats.SortTalkers(talkers, r.URL.Query().Get("sort")); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+		return
+	}
+	if raw := strings.TrimSpace(r.URL.Query().Get("limit")); raw != "" {
+		limit, err := strconv.Atoi(raw)
+		if err != nil || limit < 1 {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": fmt.Sprintf("invalid limit %q: must be a positive integer", raw)})
+			return
+		}
+		if limit < len(talkers) {
+			talkers = talkers[:limit]
+		}
+	}
+	json.NewEncoder(w).Encode(talkers)
+}
+
+// handleBlocklist lists blocked sources on GET, blocks the IP or CIDR in the
+// JSON body on POST, and unblocks the entry query parameter on DELETE.
+func (s *Server) handleBlocklist(w http.ResponseWriter, r *http.Request) {
+	provider, ok := s.logProvider.(BlocklistProvider)
+	if !ok {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		json.NewEncoder(w).Encode(map[string]string{"error": "blocklist not available"})
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		json.NewEncoder(w).Encode(provider.GetBlocklist())
+	case http.MethodPost:
+		var body struct {
+			Entry  string `json:"entry"`
+			Reason string `json:"reason"`
+		}
+		if err := json.NewDecoder(io.LimitReader(r.Body, 1<<16)).Decode(&body); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": "invalid request body"})
+			return
+		}
+		prefix, err := blocklist.ParsePrefix(body.Entry)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+			return
+		}
+		entry, added, err := provider.BlockSource(prefix, body.Reason)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+			return
+		}
+		if added {
+			w.WriteHeader(http.StatusCreated)
+		}
+		json.NewEncoder(w).Encode(entry)
+	case http.MethodDelete:
+		prefix, err := blocklist.ParsePrefix(r.URL.Query().Get("entry"))
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+			return
+		}
+		removed, err := provider.UnblockSource(prefix)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+			return
+		}
+		if !removed {
+			w.WriteHeader(http.StatusNotFound)
+			json.NewEncoder(w).Encode(map[string]string{"error": "entry not blocked"})
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		json.NewEncoder(w).Encode(map[string]string{"error": "method not allowed"})
+	}
+}
+
+// handleTimeSeries returns request rate, error and latency points for charts.
+// window and step are Go durations and default to 15m and 10s.
+func (s *Server) handleTimeSeries(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		json.NewEncoder(w).Encode(map[string]string{"error": "method not allowed"})
+		return
+	}
+	if s.logProvider == nil {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		json.NewEncoder(w).Encode(map[string]string{"error": "stats provider not available"})
+		return
+	}
+
+	badRequest := func(err error) {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+	}
+
+	window, err := durationParam(r, "window", defaultSeriesWindow)
+	if err != nil {
+		badRequest(err)
+		return
+	}
+	step, err := durationParam(r, "step", defaultSeriesStep)
+	if err != nil {
+		badRequest(err)
+		return
+	}
+	points, err := s.logProvider.GetTimeSeries(window, step)
+	if err != nil {
+		badRequest(err)
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"window": window.String(),
+		"step":   step.String(),
+		"points": points,
+	})
+}
+
+func durationParam(r *http.Request, name string, fallback time.Duration) (time.Duration, error) {
+	raw := strings.TrimSpace(r.URL.Query().Get(name))
+	if raw == "" {
+		return fallback, nil
+	}
+	value, err := time.ParseDuration(raw)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s %q: %w", name, raw, err)
+	}
+	return value, nil
+}
+
+// handleRequestByID returns a single captured request, including its
+// structured body view when one was parsed.
+func (s *Server) handleRequestByID(w http.ResponseWriter, r *http.Request, id string) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		json.NewEncoder(w).Encode(map[string]string{"error": "method not allowed"})
+		return
+	}
+	if s.logProvider == nil {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		json.NewEncoder(w).Encode(map[string]string{"error": "log provider not available"})
+		return
+	}
+
+	for _, request := range s.logProvider.GetRequestLogs() {
+		if request.ID == id {
+			json.NewEncoder(w).Encode(request)
+			return
+		}
+	}
+
+	w.WriteHeader(http.StatusNotFound)
+	json.NewEncoder(w).Encode(map[string]string{"error": "request not found"})
+}
+
+// filterGraphQLOperation keeps GraphQL captures whose operation name matches,
+// ignoring case.
+func filterGraphQLOperation(requests []model.RequestLog, operation string) []model.RequestLog {
+	filtered := make([]model.RequestLog, 0, len(requests))
+	for _, request := range requests {
+		if request.GraphQL != nil && strings.EqualFold(request.GraphQL.OperationName, operation) {
+			filtered = append(filtered, request)
+		}
+	}
+	return filtered
+}
+
 // handleStatic serves static files from the embedded filesystem
 func (s *Server) handleStatic(w http.ResponseWriter, r *http.Request) {
 	if s.uiFS == nil {
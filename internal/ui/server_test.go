@@ -1,29 +1,67 @@
 package ui
 
 import (
+	"encoding/json"
 	"io"
 	"net/http"
 	"net/http/httptest"
+	"net/netip"
 	"os"
 	"path/filepath"
 	"strings"
 	"testing"
+	"time"
 
+	"github.com/jaxxstorm/portal/internal/blocklist"
 	"github.com/jaxxstorm/portal/internal/model"
 )
 
 type stubLogProvider struct {
 	cleared bool
+	logs    []model.RequestLog
+	routes  []model.RouteStats
+
+	seriesWindow, seriesStep time.Duration
 }
 
 func (s *stubLogProvider) GetRequestLogs() []model.RequestLog {
-	return nil
+	return s.logs
 }
 
 func (s *stubLogProvider) GetStats() (ttl, opn int, rt1, rt5, p50, p90 float64) {
 	return 0, 0, 0, 0, 0, 0
 }
 
+func (s *stubLogProvider) GetLatencyWindows() []model.LatencyWindow {
+	return []model.LatencyWindow{{Window: "1m", Count: 2, P50: 12.5, P99: 40}}
+}
+
+func (s *stubLogProvider) GetRouteStats() []model.RouteStats {
+	return s.routes
+}
+
+func (s *stubLogProvider) GetTimeSeries(window, step time.Duration) ([]model.TimeSeriesPoint, error) {
+	s.seriesWindow, s.seriesStep = window, step
+	return []model.TimeSeriesPoint{{Requests: 3, P95: 18}}, nil
+}
+
+func (s *stubLogProvider) GetConnectionStats() model.ConnectionStats {
+	return model.ConnectionStats{
+		Open:   2,
+		Active: 1,
+		Idle:   1,
+		Peers:  []model.ConnectionPeer{{Address: "100.64.0.7", Open: 2, Active: 1, Idle: 1, Requests: 5}},
+	}
+}
+
+func (s *stubLogProvider) GetTopTalkers() []model.Talker {
+	return []model.Talker{
+		{IP: "198.51.100.4", Requests: 3, Denied: 3},
+		{IP: "203.0.113.9", Requests: 12, ErrorRate: 50},
+		{IP: "100.64.0.7", Requests: 40},
+	}
+}
+
 func (s *stubLogProvider) ClearRequestLogs() {
 	s.cleared = true
 }
@@ -137,3 +175,293 @@ func TestHandleStaticRedirectsUIWithoutTrailingSlash(t *testing.T) {
 		t.Fatalf("unexpected redirect location: %q", got)
 	}
 }
+
+func TestHandleAPIRequestByIDIncludesBodyView(t *testing.T) {
+	provider := &stubLogProvider{
+		logs: []model.RequestLog{
+			{ID: "req_1", Method: http.MethodGet},
+			{
+				ID:     "req_2",
+				Method: http.MethodPost,
+				BodyView: &model.BodyView{
+					Kind:   model.BodyKindForm,
+					Fields: []model.BodyField{{Name: "event", Value: "push"}},
+				},
+			},
+		},
+	}
+	srv := testServerWithUIFiles(t, provider)
+
+	req := httptest.NewRequest(http.MethodGet, "/ui/api/requests/req_2", nil)
+	rr := httptest.NewRecorder()
+
+	srv.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d", http.StatusOK, rr.Code)
+	}
+	var got model.RequestLog
+	if err := json.NewDecoder(rr.Body).Decode(&got); err != nil {
+		t.Fatalf("decode response: %v", err)
+	}
+	if got.ID != "req_2" || got.BodyView == nil || got.BodyView.Kind != model.BodyKindForm {
+		t.Fatalf("unexpected request payload: %+v", got)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/api/requests/missing", nil)
+	rr = httptest.NewRecorder()
+	srv.ServeHTTP(rr, req)
+	if rr.Code != http.StatusNotFound {
+		t.Fatalf("expected status %d for unknown request, got %d", http.StatusNotFound, rr.Code)
+	}
+}
+
+func TestHandleAPIRequestsFiltersGraphQLOperation(t *testing.T) {
+	provider := &stubLogProvider{
+		logs: []model.RequestLog{
+			{ID: "req_1", Method: http.MethodPost, GraphQL: &model.GraphQLOperation{OperationType: "query", OperationName: "GetUser"}},
+			{ID: "req_2", Method: http.MethodPost, GraphQL: &model.GraphQLOperation{OperationType: "mutation", OperationName: "Rename"}},
+			{ID: "req_3", Method: http.MethodGet},
+		},
+	}
+	srv := testServerWithUIFiles(t, provider)
+
+	req := httptest.NewRequest(http.MethodGet, "/api/requests?graphql_operation=getuser", nil)
+	rr := httptest.NewRecorder()
+	srv.ServeHTTP(rr, req)
+
+	var got []model.RequestLog
+	if err := json.NewDecoder(rr.Body).Decode(&got); err != nil {
+		t.Fatalf("decode response: %v", err)
+	}
+	if len(got) != 1 || got[0].ID != "req_1" {
+		t.Fatalf("unexpected filtered requests: %+v", got)
+	}
+}
+
+type metricsStubLogProvider struct {
+	stubLogProvider
+}
+
+func (s *metricsStubLogProvider) MetricsHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		io.WriteString(w, "portal_requests_total 1\n")
+	})
+}
+
+func TestHandleMetricsServesProviderMetrics(t *testing.T) {
+	srv := testServerWithUIFiles(t, &metricsStubLogProvider{})
+
+	for _, path := range []string{"/metrics", "/ui/metrics"} {
+		rr := httptest.NewRecorder()
+		srv.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, path, nil))
+
+		if rr.Code != http.StatusOK {
+			t.Fatalf("expected status %d for %s, got %d", http.StatusOK, path, rr.Code)
+		}
+		if !strings.Contains(rr.Body.String(), "portal_requests_total 1") {
+			t.Fatalf("expected metrics body for %s, got %q", path, rr.Body.String())
+		}
+	}
+}
+
+func TestHandleMetricsUnavailableWithoutProvider(t *testing.T) {
+	srv := testServerWithUIFiles(t, &stubLogProvider{})
+
+	rr := httptest.NewRecorder()
+	srv.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+
+	if rr.Code != http.StatusServiceUnavailable {
+		t.Fatalf("expected status %d, got %d", http.StatusServiceUnavailable, rr.Code)
+	}
+}
+
+func TestHandleAPIStatsIncludesLatencyWindows(t *testing.T) {
+	srv := testServerWithUIFiles(t, &stubLogProvider{})
+
+	rr := httptest.NewRecorder()
+	srv.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/api/stats", nil))
+
+	var payload struct {
+		LatencyWindows []model.LatencyWindow `json:"latency_windows"`
+	}
+	if err := json.NewDecoder(rr.Body).Decode(&payload); err != nil {
+		t.Fatalf("decode stats: %v", err)
+	}
+	if len(payload.LatencyWindows) != 1 || payload.LatencyWindows[0].Window != "1m" || payload.LatencyWindows[0].P99 != 40 {
+		t.Fatalf("unexpected latency windows: %+v", payload.LatencyWindows)
+	}
+}
+
+func TestHandleAPIRouteStatsSorts(t *testing.T) {
+	provider := &stubLogProvider{routes: []model.RouteStats{
+		{Method: "GET", Route: "/users/{id}", Count: 10, P95: 12},
+		{Method: "POST", Route: "/orders", Count: 2, P95: 480},
+	}}
+	srv := testServerWithUIFiles(t, provider)
+
+	rr := httptest.NewRecorder()
+	srv.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/api/stats/routes?sort=p95", nil))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d", http.StatusOK, rr.Code)
+	}
+	var routes []model.RouteStats
+	if err := json.NewDecoder(rr.Body).Decode(&routes); err != nil {
+		t.Fatalf("decode routes: %v", err)
+	}
+	if len(routes) != 2 || routes[0].Route != "/orders" {
+		t.Fatalf("expected slowest route first, got %+v", routes)
+	}
+
+	rr = httptest.NewRecorder()
+	srv.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/api/stats/routes?sort=latency", nil))
+	if rr.Code != http.StatusBadRequest {
+		t.Fatalf("expected status %d for unknown sort, got %d", http.StatusBadRequest, rr.Code)
+	}
+}
+
+func TestHandleAPITimeSeriesParsesRange(t *testing.T) {
+	provider := &stubLogProvider{}
+	srv := testServerWithUIFiles(t, provider)
+
+	rr := httptest.NewRecorder()
+	srv.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/api/timeseries", nil))
+	if provider.seriesWindow != 15*time.Minute || provider.seriesStep != 10*time.Second {
+		t.Fatalf("expected default range, got window=%s step=%s", provider.seriesWindow, provider.seriesStep)
+	}
+
+	rr = httptest.NewRecorder()
+	srv.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/ui/api/timeseries?window=5m&step=30s", nil))
+	var payload struct {
+		Window string                  `json:"window"`
+		Step   string                  `json:"step"`
+		Points []model.TimeSeriesPoint `json:"points"`
+	}
+	if err := json.NewDecoder(rr.Body).Decode(&payload); err != nil {
+		t.Fatalf("decode timeseries: %v", err)
+	}
+	if payload.Window != "5m0s" || payload.Step != "30s" || len(payload.Points) != 1 || payload.Points[0].Requests != 3 {
+		t.Fatalf("unexpected payload: %+v", payload)
+	}
+
+	rr = httptest.NewRecorder()
+	srv.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/api/timeseries?step=soon", nil))
+	if rr.Code != http.StatusBadRequest {
+		t.Fatalf("expected status %d for invalid step, got %d", http.StatusBadRequest, rr.Code)
+	}
+}
+
+func TestHandleAPIConnections(t *testing.T) {
+	srv := testServerWithUIFiles(t, &stubLogProvider{})
+
+	rr := httptest.NewRecorder()
+	srv.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/api/connections", nil))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d", http.StatusOK, rr.Code)
+	}
+
+	var payload model.ConnectionStats
+	if err := json.NewDecoder(rr.Body).Decode(&payload); err != nil {
+		t.Fatalf("decode connections: %v", err)
+	}
+	if payload.Open != 2 || len(payload.Peers) != 1 || payload.Peers[0].Address != "100.64.0.7" {
+		t.Fatalf("unexpected payload: %+v", payload)
+	}
+
+	rr = httptest.NewRecorder()
+	srv.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/api/connections", nil))
+	if rr.Code != http.StatusMethodNotAllowed {
+		t.Fatalf("expected status %d, got %d", http.StatusMethodNotAllowed, rr.Code)
+	}
+}
+
+func TestHandleAPITopTalkersSortsAndLimits(t *testing.T) {
+	srv := testServerWithUIFiles(t, &stubLogProvider{})
+
+	rr := httptest.NewRecorder()
+	srv.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/api/talkers?sort=denied&limit=2", nil))
+	var talkers []model.Talker
+	if err := json.NewDecoder(rr.Body).Decode(&talkers); err != nil {
+		t.Fatalf("decode talkers: %v", err)
+	}
+	if len(talkers) != 2 || talkers[0].IP != "198.51.100.4" {
+		t.Fatalf("expected 2 talkers led by the denied source, got %+v", talkers)
+	}
+
+	for _, query := range []string{"sort=loudest", "limit=0", "limit=many"} {
+		rr = httptest.NewRecorder()
+		srv.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/api/talkers?"+query, nil))
+		if rr.Code != http.StatusBadRequest {
+			t.Fatalf("expected status %d for %s, got %d", http.StatusBadRequest, query, rr.Code)
+		}
+	}
+}
+
+type stubBlocklistProvider struct {
+	stubLogProvider
+	list *blocklist.List
+}
+
+func (s *stubBlocklistProvider) GetBlocklist() []blocklist.Entry {
+	return s.list.Entries()
+}
+
+func (s *stubBlocklistProvider) BlockSource(prefix netip.Prefix, reason string) (blocklist.Entry, bool, error) {
+	return s.list.Add(prefix, reason, blocklist.SourceManual)
+}
+
+func (s *stubBlocklistProvider) UnblockSource(prefix netip.Prefix) (bool, error) {
+	return s.list.Remove(prefix)
+}
+
+func TestHandleAPIBlocklistAddsListsAndRemoves(t *testing.T) {
+	srv := testServerWithUIFiles(t, &stubBlocklistProvider{list: blocklist.NewMemory()})
+
+	block := func(body string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		srv.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/api/blocklist", strings.NewReader(body)))
+		return rr
+	}
+
+	if rr := block(`{"entry":"203.0.113.9","reason":"scraping"}`); rr.Code != http.StatusCreated {
+		t.Fatalf("expected status %d, got %d", http.StatusCreated, rr.Code)
+	}
+	if rr := block(`{"entry":"203.0.113.9"}`); rr.Code != http.StatusOK {
+		t.Fatalf("expected status %d for duplicate entry, got %d", http.StatusOK, rr.Code)
+	}
+	if rr := block(`{"entry":"not-an-ip"}`); rr.Code != http.StatusBadRequest {
+		t.Fatalf("expected status %d for invalid entry, got %d", http.StatusBadRequest, rr.Code)
+	}
+
+	rr := httptest.NewRecorder()
+	srv.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/api/blocklist", nil))
+	var entries []blocklist.Entry
+	if err := json.NewDecoder(rr.Body).Decode(&entries); err != nil {
+		t.Fatalf("decode blocklist: %v", err)
+	}
+	if len(entries) != 1 || entries[0].Prefix.String() != "203.0.113.9/32" || entries[0].Reason != "scraping" {
+		t.Fatalf("unexpected blocklist: %+v", entries)
+	}
+
+	rr = httptest.NewRecorder()
+	srv.ServeHTTP(rr, httptest.NewRequest(http.MethodDelete, "/api/blocklist?entry=203.0.113.9", nil))
+	if rr.Code != http.StatusNoContent {
+		t.Fatalf("expected status %d, got %d", http.StatusNoContent, rr.Code)
+	}
+	rr = httptest.NewRecorder()
+	srv.ServeHTTP(rr, httptest.NewRequest(http.MethodDelete, "/api/blocklist?entry=203.0.113.9", nil))
+	if rr.Code != http.StatusNotFound {
+		t.Fatalf("expected status %d, got %d", http.StatusNotFound, rr.Code)
+	}
+}
+
+func TestHandleAPIBlocklistUnavailableWithoutProvider(t *testing.T) {
+	srv := testServerWithUIFiles(t, &stubLogProvider{})
+
+	rr := httptest.NewRecorder()
+	srv.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/api/blocklist", nil))
+	if rr.Code != http.StatusServiceUnavailable {
+		t.Fatalf("expected status %d, got %d", http.StatusServiceUnavailable, rr.Code)
+	}
+}
@@ -8,8 +8,10 @@ import (
 	"fmt"
 	"net"
 	"net/http"
+	"net/netip"
 	"os"
 	"os/signal"
+	"strconv"
 	"syscall"
 	"time"
 
@@ -17,7 +19,9 @@ import (
 	"github.com/spf13/pflag"
 	"go.uber.org/zap"
 
+	"github.com/jaxxstorm/portal/internal/blocklist"
 	"github.com/jaxxstorm/portal/internal/config"
+	"github.com/jaxxstorm/portal/internal/geoip"
 	"github.com/jaxxstorm/portal/internal/httputil"
 	"github.com/jaxxstorm/portal/internal/logging"
 	"github.com/jaxxstorm/portal/internal/model"
@@ -25,6 +29,7 @@ import (
 	"github.com/jaxxstorm/portal/internal/server"
 	"github.com/jaxxstorm/portal/internal/startup"
 	"github.com/jaxxstorm/portal/internal/tailscale"
+	"github.com/jaxxstorm/portal/internal/telemetry"
 	"github.com/jaxxstorm/portal/internal/tui"
 	"github.com/jaxxstorm/portal/internal/ui"
 )
@@ -57,11 +62,22 @@ func main() {
 		os.Exit(0)
 	}
 
-	// Setup initial logger
+	// portal start runs this binary again in the background; that copy
+	// carries on as a normal portal.
+	if cfg.Command == config.CommandStart && !runningInBackground() {
+		os.Exit(runStart(cfg))
+	}
+	if cfg.Command != "" && cfg.Command != config.CommandStart {
+		os.Exit(runCommand(cfg))
+	}
+
+	// Setup initial logger, with a shared level so reloads can change it
+	logLevel := zap.NewAtomicLevelAt(logging.LevelFor(cfg.Verbose))
 	logConfig := logging.Config{
 		Verbose: cfg.Verbose,
 		JSON:    cfg.JSON,
 		LogFile: cfg.LogFile,
+		Level:   &logLevel,
 	}
 
 	logger, err := logging.SetupLogger(logConfig)
@@ -77,6 +93,13 @@ func main() {
 		logging.Component("portal"),
 		logging.Version(Version),
 	)
+	if cfg.Profile != "" {
+		logger.Info("Using config profile",
+			logging.Component("portal"),
+			zap.String("profile", cfg.Profile),
+			zap.String("config_file", cfg.ConfigFile),
+		)
+	}
 
 	// Server mode determination
 	var serverMode model.ServerMode
@@ -165,19 +188,30 @@ func main() {
 	}
 
 	// Create proxy server
-	requestedFunnelProxyProtocol := cfg.UseFunnelProxyProtocol()
-	effectiveFunnelProxyProtocol := requestedFunnelProxyProtocol && useLocalTailscale
-	if cfg.HasFunnelAllowlist() && !requestedFunnelProxyProtocol {
-		logger.Warn("Funnel allowlist active without PROXY protocol",
+	sourceMode := funnelSourceMode(cfg, useLocalTailscale)
+	funnelAccessControl := cfg.HasFunnelAccessControl()
+	if funnelAccessControl {
+		if problem := funnelEnforcementProblem(cfg, cfg.ProxyBindHost()); cfg.FunnelStrict && problem != "" {
+			logger.Fatal("Funnel allowlist cannot be enforced reliably",
+				logging.Component("proxy_server"),
+				zap.String("source_mode", string(sourceMode)),
+				zap.String("set_path", cfg.GetSetPath()),
+				zap.String("reason", problem),
+			)
+		}
+		logger.Info("Funnel access control source",
 			logging.Component("proxy_server"),
+			zap.String("source_mode", string(sourceMode)),
 			zap.String("set_path", cfg.GetSetPath()),
-			zap.String("reason", "non_root_mount_path"),
+			zap.Bool("strict", cfg.FunnelStrict),
+			zap.Int("access_rules", len(cfg.FunnelRules)),
 		)
 	}
-	if cfg.HasFunnelAllowlist() && requestedFunnelProxyProtocol && !useLocalTailscale {
-		logger.Warn("Funnel allowlist active without PROXY protocol",
+
+	if cfg.AutoBan && !sourceMode.Trusted() {
+		logger.Fatal("Auto-ban cannot be enabled without a trusted source IP",
 			logging.Component("proxy_server"),
-			zap.String("reason", "local_tailscale_unavailable"),
+			zap.String("source_mode", string(sourceMode)),
 		)
 	}
 
@@ -188,16 +222,101 @@ func main() {
 		Logger:          logger,
 		FunnelEnabled:   cfg.Funnel,
 		FunnelAllowlist: cfg.FunnelAllowlist,
-		PreferRemoteIP:  effectiveFunnelProxyProtocol,
+		FunnelDenylist:  cfg.FunnelDenylist,
+		FunnelRules:     cfg.FunnelRules,
+		SourceMode:      sourceMode,
+		MountPath:       cfg.GetSetPath(),
 		InitialEndpoint: initialEndpointState(cfg, useLocalTailscale),
+		RequestIDHeader: cfg.RequestIDHeader,
+		Routes:          cfg.Routes,
+		MaxLogs:         cfg.CaptureLimit,
+	}
+
+	geoIP, err := geoip.Open(cfg.GeoIPDB, cfg.GeoIPASNDB)
+	if err != nil {
+		logger.Fatal(logging.MsgRuntimeError,
+			logging.Operation("geoip_load"),
+			logging.Error(err),
+		)
+	}
+	if geoIP != nil {
+		defer geoIP.Close()
+		proxyConfig.GeoIP = geoIP
+		logger.Info("GeoIP enrichment enabled",
+			logging.Component("geoip"),
+			zap.String("geoip_db", cfg.GeoIPDB),
+			zap.String("geoip_asn_db", cfg.GeoIPASNDB),
+		)
+	}
+
+	sourceBlocklist, err := blocklist.Open(cfg.BlocklistFile)
+	if err != nil {
+		logger.Fatal(logging.MsgRuntimeError,
+			logging.Operation("blocklist_load"),
+			logging.Error(err),
+		)
+	}
+	proxyConfig.Blocklist = sourceBlocklist
+	if entries := sourceBlocklist.Entries(); len(entries) > 0 {
+		logger.Info("Blocklist loaded",
+			logging.Component("blocklist"),
+			zap.String("blocklist_file", cfg.BlocklistFile),
+			zap.Int("entries", len(entries)),
+		)
+	}
+	if cfg.AutoBan {
+		proxyConfig.AutoBan = blocklist.NewAutoBan(blocklist.AutoBanConfig{
+			ScannerPaths:      cfg.AutoBanPaths,
+			NotFoundThreshold: cfg.AutoBanThreshold,
+			NotFoundWindow:    cfg.AutoBanWindow,
+		})
+	}
+
+	tracerProvider, err := telemetry.NewTracerProvider(ctx, telemetry.Config{
+		Endpoint: cfg.OTelEndpoint,
+		Protocol: cfg.OTelProtocol,
+		Version:  Version,
+	})
+	if err != nil {
+		logger.Fatal(logging.MsgRuntimeError,
+			logging.Operation("tracing_setup"),
+			logging.Error(err),
+		)
+	}
+	if tracerProvider != nil {
+		proxyConfig.Tracer = tracerProvider.Tracer(telemetry.TracerName)
+		logger.Info("Request tracing enabled",
+			logging.Component("telemetry"),
+			zap.String("otel_endpoint", cfg.OTelEndpoint),
+			zap.String("otel_protocol", cfg.OTelProtocol),
+		)
+		defer func() {
+			shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			if err := tracerProvider.Shutdown(shutdownCtx); err != nil {
+				logger.Error(logging.MsgRuntimeError,
+					logging.Operation("tracing_shutdown"),
+					logging.Error(err),
+				)
+			}
+		}()
 	}
 
 	proxyServer := proxy.NewServer(proxyConfig)
+	reloader := newConfigReloader(os.Args[1:], cfg, logLevel, proxyServer)
+
+	funnelCfg := *cfg
+	funnelCfg.Funnel = true
+	portal := &controlPortal{Server: proxyServer, cancel: cancel, funnelSourceMode: funnelSourceMode(&funnelCfg, useLocalTailscale)}
+	if registration := registerSession(ctx, cfg, proxyServer, logger); registration != nil {
+		defer registration.Close()
+		portal.registration = registration
+	}
 
 	if cfg.NoTUI {
-		runWithoutTUI(ctx, logger, useLocalTailscale, tsClient, proxyServer, cfg)
+		runWithoutTUI(ctx, logger, useLocalTailscale, tsClient, proxyServer, cfg, reloader, portal)
 	} else {
-		runWithTUI(ctx, logger, useLocalTailscale, tsClient, proxyServer, cfg)
+		runWithTUI(ctx, logger, useLocalTailscale, tsClient, proxyServer, cfg, reloader, portal)
 	}
 
 	logger.Info(logging.MsgServerStopped,
@@ -205,10 +324,12 @@ func main() {
 	)
 }
 
-func runWithoutTUI(ctx context.Context, logger *zap.Logger, useLocalTailscale bool, tsClient *tailscale.Client, proxyServer *proxy.Server, cfg *config.Config) {
+func runWithoutTUI(ctx context.Context, logger *zap.Logger, useLocalTailscale bool, tsClient *tailscale.Client, proxyServer *proxy.Server, cfg *config.Config, reloader *configReloader, portal *controlPortal) {
 	logger.Info(logging.MsgConsoleMode,
 		logging.TUIEnabled(false),
 	)
+	reloader.Start(ctx, logger)
+	stopControl := portal.serveControl(logger)
 	proxyServer.SetEndpointState(initialEndpointState(cfg, useLocalTailscale))
 
 	// Set up servers
@@ -219,6 +340,7 @@ func runWithoutTUI(ctx context.Context, logger *zap.Logger, useLocalTailscale bo
 	if useLocalTailscale {
 		cleanup, uiCleanup, serviceInfo = setupLocalTailscale(ctx, tsClient, proxyServer, logger, cfg)
 		if serviceInfo != nil {
+			portal.serveClient.Store(tsClient)
 			summary := startup.BuildReadySummary(
 				cfg,
 				true,
@@ -264,6 +386,8 @@ func runWithoutTUI(ctx context.Context, logger *zap.Logger, useLocalTailscale bo
 
 	// Wait for shutdown signal
 	<-ctx.Done()
+	// No control requests while the serve config is cleaned up.
+	stopControl()
 
 	logger.Info(logging.MsgServerStopping)
 
@@ -286,7 +410,7 @@ func runWithoutTUI(ctx context.Context, logger *zap.Logger, useLocalTailscale bo
 	}
 }
 
-func runWithTUI(ctx context.Context, logger *zap.Logger, useLocalTailscale bool, tsClient *tailscale.Client, proxyServer *proxy.Server, cfg *config.Config) {
+func runWithTUI(ctx context.Context, logger *zap.Logger, useLocalTailscale bool, tsClient *tailscale.Client, proxyServer *proxy.Server, cfg *config.Config, reloader *configReloader, portal *controlPortal) {
 	// TUI MODE - Initialize TUI with proper message routing
 	proxyServer.SetEndpointState(initialEndpointState(cfg, useLocalTailscale))
 
@@ -304,8 +428,16 @@ func runWithTUI(ctx context.Context, logger *zap.Logger, useLocalTailscale bool,
 	})
 
 	// Replace the server's logger to route to TUI instead of console
-	tuiZapLogger := tui.CreateTUIZapLogger(program)
+	tuiZapLogger := tui.CreateTUIZapLogger(program, reloader.level)
 	proxyServer.ReplaceLogger(tuiZapLogger)
+	reloader.Start(ctx, tuiZapLogger)
+	stopControl := portal.serveControl(tuiZapLogger)
+
+	// Quit on a signal or a control API shutdown, as on q or Ctrl+C.
+	go func() {
+		<-ctx.Done()
+		program.Quit()
+	}()
 
 	// Set up servers in background
 	var cleanup func() error
@@ -349,6 +481,7 @@ func runWithTUI(ctx context.Context, logger *zap.Logger, useLocalTailscale bool,
 			var serviceInfo *tailscale.ServiceInfo
 			cleanup, uiCleanup, serviceInfo = server.SetupLocalTailscaleQuiet(ctx, tuiTsClient, proxyServer, tuiOnlyLogger, cfg, uiFiles)
 			if serviceInfo != nil {
+				portal.serveClient.Store(tuiTsClient)
 				summary := startup.BuildReadySummary(
 					cfg,
 					true,
@@ -387,6 +520,7 @@ func runWithTUI(ctx context.Context, logger *zap.Logger, useLocalTailscale bool,
 	if _, err := program.Run(); err != nil {
 		fmt.Printf("TUI error: %v\n", err)
 	}
+	stopControl()
 
 	// Cleanup after TUI exits
 	if cleanup != nil {
@@ -414,6 +548,20 @@ func setupLocalTailscale(ctx context.Context, tsClient *tailscale.Client, proxyS
 		}
 	}
 
+	// Remove entries left behind by portal sessions that did not exit cleanly
+	reclaimed, err := tsClient.ReclaimStale(ctx)
+	if err != nil {
+		logger.Warn("Failed to reclaim stale serve entries",
+			logging.Component("tailscale_serve"),
+			logging.Error(err),
+		)
+	} else if reclaimed > 0 {
+		logger.Info("Reclaimed stale serve entries",
+			logging.Component("tailscale_serve"),
+			zap.Int("entries", reclaimed),
+		)
+	}
+
 	// Find an available port for our local proxy server using random allocation
 	logger.Info("Allocating random proxy port",
 		logging.Component("proxy_server"),
@@ -435,13 +583,14 @@ func setupLocalTailscale(ctx context.Context, tsClient *tailscale.Client, proxyS
 	// Start proxy server
 	logger.Info(logging.MsgProxyStarting,
 		logging.ProxyPort(proxyPort),
-		logging.BindAddress("0.0.0.0"),
+		logging.BindAddress(cfg.ProxyBindHost()),
 	)
 
 	useFunnelProxyProtocol := cfg.UseFunnelProxyProtocol()
 	httpServer := &http.Server{
-		Addr:    fmt.Sprintf(":%d", proxyPort),
-		Handler: proxyServer,
+		Addr:      net.JoinHostPort(cfg.ProxyBindHost(), strconv.Itoa(proxyPort)),
+		Handler:   proxyServer,
+		ConnState: proxyServer.ConnState,
 	}
 
 	proxyListener, err := httputil.NewHTTPListener(httpServer.Addr, useFunnelProxyProtocol)
@@ -585,15 +734,13 @@ func setupLocalTailscale(ctx context.Context, tsClient *tailscale.Client, proxyS
 		cleanupCtx, cleanupCancel := context.WithTimeout(context.Background(), 10*time.Second)
 		defer cleanupCancel()
 
-		// Comprehensive cleanup of all Tailscale serve configs
-		err := tsClient.CleanupAll(cleanupCtx)
-		if err != nil {
-			logger.Warn("Failed to perform comprehensive cleanup, trying specific cleanup",
+		// Remove only the serve entries this session added
+		if err := tsClient.Cleanup(cleanupCtx); err != nil {
+			logger.Warn("Failed to remove serve entries added by portal",
 				logging.Component("tailscale_serve"),
+				logging.Status("run_portal_cleanup_serve"),
 				logging.Error(err),
 			)
-			// Fallback to specific config cleanup
-			tsClient.Cleanup(cleanupCtx, tsConfig)
 		}
 
 		// Shutdown proxy server
@@ -617,7 +764,8 @@ func setupLocalTailscale(ctx context.Context, tsClient *tailscale.Client, proxyS
 	return cleanup, uiCleanup, svcInfo
 }
 
-// handleCleanupServe clears all Tailscale serve configurations
+// handleCleanupServe removes the Tailscale serve entries recorded by portal
+// sessions
 func handleCleanupServe() {
 	ctx := context.Background()
 
@@ -650,8 +798,8 @@ func handleCleanupServe() {
 		os.Exit(1)
 	}
 
-	// Perform comprehensive cleanup
-	err = tsClient.CleanupAll(ctx)
+	// Remove the entries recorded by portal sessions
+	removed, err := tsClient.CleanupAll(ctx)
 	if err != nil {
 		logger.Error("Failed to cleanup Tailscale serve configurations",
 			logging.Component("cleanup"),
@@ -664,7 +812,8 @@ func handleCleanupServe() {
 	logger.Info("Tailscale serve cleanup completed successfully",
 		logging.Component("cleanup"),
 	)
-	fmt.Printf("✅ All Tailscale serve configurations have been cleared.\n")
+	fmt.Printf("✅ Removed %d Tailscale serve entries added by portal.\n", removed)
+	fmt.Printf("Serve configuration portal did not add was left in place.\n")
 	fmt.Printf("You can verify with: tailscale serve status\n")
 }
 
@@ -740,6 +889,31 @@ func logStartupSummaryToTUI(logger *tui.TUIOnlyLogger, summary startup.Summary)
 	logger.Info(logging.MsgStartupReady, summary.Fields()...)
 }
 
+// funnelSourceMode picks the source IP signal that the path requests take
+// into portal lets it trust.
+func funnelSourceMode(cfg *config.Config, useLocalDaemon bool) proxy.SourceMode {
+	switch {
+	case !useLocalDaemon:
+		return proxy.SourceModeTSNet
+	case cfg.UseFunnelProxyProtocol():
+		return proxy.SourceModeProxyProtocol
+	default:
+		return proxy.SourceModeServe
+	}
+}
+
+// funnelEnforcementProblem reports why Funnel access control could not be
+// enforced reliably when the local proxy listens on bindHost, or "" if it can.
+func funnelEnforcementProblem(cfg *config.Config, bindHost string) string {
+	if cfg.IsServiceMode() {
+		return "listen-mode=service cannot carry Funnel traffic"
+	}
+	if addr, err := netip.ParseAddr(bindHost); err != nil || !addr.IsLoopback() {
+		return fmt.Sprintf("the local proxy listens on %s, so clients can reach it without Tailscale and set their own source headers", bindHost)
+	}
+	return ""
+}
+
 func initialEndpointState(cfg *config.Config, useLocalDaemon bool) model.EndpointState {
 	mode := startup.ModeTSNet
 	if useLocalDaemon {
@@ -3,14 +3,17 @@ package main
 import (
 	"bytes"
 	"encoding/json"
+	"net/netip"
 	"strings"
 	"testing"
 
 	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
 
+	"github.com/jaxxstorm/portal/internal/access"
 	"github.com/jaxxstorm/portal/internal/config"
 	"github.com/jaxxstorm/portal/internal/logging"
+	"github.com/jaxxstorm/portal/internal/proxy"
 	"github.com/jaxxstorm/portal/internal/startup"
 )
 
@@ -90,3 +93,46 @@ func TestLogStartupSummarySkipsWhenFunnelStartupNotReady(t *testing.T) {
 		t.Fatalf("expected no startup summary log when funnel startup is not ready, got %q", got)
 	}
 }
+
+func TestFunnelSourceModeTrustsTailscaleForMountedPaths(t *testing.T) {
+	allowlist := []access.Entry{{Prefix: netip.MustParsePrefix("198.51.100.0/24")}}
+	root := &config.Config{Funnel: true, FunnelAllowlist: allowlist, SetPath: "/"}
+	mounted := &config.Config{Funnel: true, FunnelAllowlist: allowlist, SetPath: "/hooks"}
+
+	for _, tc := range []struct {
+		cfg         *config.Config
+		localDaemon bool
+		want        proxy.SourceMode
+	}{
+		{root, true, proxy.SourceModeProxyProtocol},
+		{mounted, true, proxy.SourceModeServe},
+		{mounted, false, proxy.SourceModeTSNet},
+	} {
+		got := funnelSourceMode(tc.cfg, tc.localDaemon)
+		if got != tc.want || !got.Trusted() {
+			t.Fatalf("funnelSourceMode(%q, %v) = %q, want trusted %q", tc.cfg.SetPath, tc.localDaemon, got, tc.want)
+		}
+	}
+}
+
+func TestFunnelEnforcementProblemRejectsUnreliableStrictConfigs(t *testing.T) {
+	allowlist := []access.Entry{{Prefix: netip.MustParsePrefix("198.51.100.0/24")}}
+	strict := &config.Config{Funnel: true, FunnelAllowlist: allowlist, FunnelStrict: true, SetPath: "/hooks"}
+	service := &config.Config{FunnelAllowlist: allowlist, FunnelStrict: true, TSNetListenMode: config.TSNetListenModeService, TSNetServiceName: "svc:web"}
+
+	for _, tc := range []struct {
+		name     string
+		cfg      *config.Config
+		bindHost string
+		wantOK   bool
+	}{
+		{"strict loopback bind", strict, strict.ProxyBindHost(), true},
+		{"non-loopback bind", strict, "0.0.0.0", false},
+		{"service listen mode", service, service.ProxyBindHost(), false},
+	} {
+		problem := funnelEnforcementProblem(tc.cfg, tc.bindHost)
+		if (problem == "") != tc.wantOK {
+			t.Fatalf("%s: funnelEnforcementProblem() = %q, want ok=%v", tc.name, problem, tc.wantOK)
+		}
+	}
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"sync"
+
+	"go.uber.org/zap"
+
+	"github.com/jaxxstorm/portal/internal/access"
+	"github.com/jaxxstorm/portal/internal/config"
+	"github.com/jaxxstorm/portal/internal/filewatch"
+	"github.com/jaxxstorm/portal/internal/logging"
+	"github.com/jaxxstorm/portal/internal/proxy"
+)
+
+// configReloader watches the config files, and the access list files they
+// name, and applies reloadable settings to the running proxy. Settings
+// that need a restart are reported and left as they are.
+type configReloader struct {
+	args        []string
+	level       zap.AtomicLevel
+	proxyServer *proxy.Server
+
+	mu      sync.Mutex
+	current *config.Config
+	logger  *zap.Logger
+	stop    context.CancelFunc
+}
+
+func newConfigReloader(args []string, cfg *config.Config, level zap.AtomicLevel, proxyServer *proxy.Server) *configReloader {
+	return &configReloader{
+		args:        args,
+		level:       level,
+		proxyServer: proxyServer,
+		current:     cfg,
+	}
+}
+
+// Start watches until ctx is done, logging reloads to logger so they reach
+// the app logs pane in TUI mode.
+func (r *configReloader) Start(ctx context.Context, logger *zap.Logger) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.logger = logger
+	r.watch(ctx)
+}
+
+// watch starts watching the files named by the current config, replacing
+// any earlier watch. Callers hold r.mu.
+func (r *configReloader) watch(ctx context.Context) {
+	if r.stop != nil {
+		r.stop()
+	}
+	watchCtx, stop := context.WithCancel(ctx)
+	r.stop = stop
+
+	configFiles := []string{r.current.ConfigFile}
+	if r.current.ProjectConfig != "" {
+		configFiles = append(configFiles, r.current.ProjectConfig)
+	}
+	if r.current.FunnelRulesFile != "" {
+		configFiles = append(configFiles, r.current.FunnelRulesFile)
+	}
+	for _, path := range configFiles {
+		if err := filewatch.Watch(watchCtx, []string{path}, func(string) { r.reload(ctx) }); err != nil {
+			log := r.logger.Warn
+			if errors.Is(err, fs.ErrNotExist) {
+				log = r.logger.Debug
+			}
+			log("Configuration file will not be reloaded",
+				logging.Component("config_reload"),
+				zap.String("config_file", path),
+				logging.Error(err),
+			)
+		}
+	}
+
+	if err := access.Watch(watchCtx, r.current.FunnelFileSets(), r.setReloaded); err != nil {
+		r.logger.Warn("Funnel access list files will not be reloaded",
+			logging.Component("access_sets"),
+			logging.Error(err),
+		)
+	}
+}
+
+func (r *configReloader) setReloaded(set *access.Set, err error) {
+	r.mu.Lock()
+	logger := r.logger
+	r.mu.Unlock()
+
+	if err != nil {
+		logger.Warn("Funnel access list reload failed",
+			logging.Component("access_sets"),
+			zap.String("source", set.String()),
+			logging.Status("keeping_previous_entries"),
+			logging.Error(err),
+		)
+		return
+	}
+	logger.Info("Funnel access list reloaded",
+		logging.Component("access_sets"),
+		zap.String("source", set.String()),
+		zap.Int("entries", len(set.Entries())),
+	)
+}
+
+// reload re-reads the configuration and applies what changed.
+func (r *configReloader) reload(ctx context.Context) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	next, err := config.ParseArgs(r.args)
+	if err != nil {
+		r.logger.Warn("Configuration reload failed",
+			logging.Component("config_reload"),
+			zap.String("config_file", r.current.ConfigFile),
+			logging.Status("keeping_current_config"),
+			logging.Error(err),
+		)
+		return
+	}
+
+	changes := config.Diff(r.current, next)
+	if len(changes) == 0 {
+		return
+	}
+
+	applied, restart := 0, 0
+	for _, change := range changes {
+		fields := []zap.Field{
+			logging.Component("config_reload"),
+			zap.String("setting", change.Key),
+			zap.String("old", change.Old),
+			zap.String("new", change.New),
+		}
+		if change.Reloadable {
+			applied++
+			r.logger.Info("Configuration setting changed", fields...)
+		} else {
+			restart++
+			r.logger.Warn("Configuration change requires restart", fields...)
+		}
+	}
+
+	r.current = r.current.ApplyReloadable(next)
+	r.proxyServer.SetFunnelAccess(r.current.FunnelAllowlist, r.current.FunnelDenylist, r.current.FunnelRules)
+	r.proxyServer.SetRoutes(r.current.Routes)
+	r.proxyServer.SetMaxLogs(r.current.CaptureLimit)
+	r.level.SetLevel(logging.LevelFor(r.current.Verbose))
+
+	r.logger.Info("Configuration reloaded",
+		logging.Component("config_reload"),
+		zap.String("config_file", r.current.ConfigFile),
+		zap.Int("applied", applied),
+		zap.Int("requires_restart", restart),
+	)
+
+	// The new config brings its own file sets, and may name a different
+	// rules file.
+	r.watch(ctx)
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+
+	"github.com/jaxxstorm/portal/internal/config"
+	"github.com/jaxxstorm/portal/internal/model"
+	"github.com/jaxxstorm/portal/internal/proxy"
+)
+
+func TestConfigReloaderAppliesReloadableSettings(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	configPath := filepath.Join(home, ".portal", "config.yml")
+	if err := os.MkdirAll(filepath.Dir(configPath), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(configPath, []byte("funnel: true\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	args := []string{"8080"}
+	cfg, err := config.ParseArgs(args)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	level := zap.NewAtomicLevelAt(zap.InfoLevel)
+	proxyServer := proxy.NewServer(proxy.Config{Mode: model.ModeMock, Logger: zap.NewNop()})
+
+	core, logs := observer.New(zapcore.DebugLevel)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	reloader := newConfigReloader(args, cfg, level, proxyServer)
+	reloader.Start(ctx, zap.New(core))
+
+	if err := os.WriteFile(configPath, []byte("funnel: true\nverbose: true\nserve-port: 8443\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for logs.FilterMessage("Configuration reloaded").Len() == 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected a reload, got logs %+v", logs.All())
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	if level.Level() != zap.DebugLevel {
+		t.Fatalf("expected verbose to lower the log level, got %s", level.Level())
+	}
+	changed := logs.FilterMessage("Configuration setting changed").FilterField(zap.String("setting", "verbose"))
+	if changed.Len() != 1 {
+		t.Fatalf("expected the verbose change to be logged, got %+v", logs.All())
+	}
+	restart := logs.FilterMessage("Configuration change requires restart").FilterField(zap.String("setting", "serve-port"))
+	if restart.Len() != 1 || restart.All()[0].Level != zapcore.WarnLevel {
+		t.Fatalf("expected a restart warning for serve-port, got %+v", logs.All())
+	}
+
+	reloader.mu.Lock()
+	servePort := reloader.current.ServePort
+	reloader.mu.Unlock()
+	if servePort != cfg.ServePort {
+		t.Fatalf("expected serve-port to keep its running value %d, got %d", cfg.ServePort, servePort)
+	}
+}
@@ -0,0 +1,302 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"text/tabwriter"
+	"time"
+
+	"go.uber.org/zap"
+	"tailscale.com/ipn"
+
+	"github.com/jaxxstorm/portal/internal/config"
+	"github.com/jaxxstorm/portal/internal/logging"
+	"github.com/jaxxstorm/portal/internal/model"
+	"github.com/jaxxstorm/portal/internal/proxy"
+	"github.com/jaxxstorm/portal/internal/session"
+	"github.com/jaxxstorm/portal/internal/tailscale"
+)
+
+// sessionRefreshInterval is how often a running portal updates its record
+// in the session registry.
+const sessionRefreshInterval = 5 * time.Second
+
+// registerSession adds this portal to the session registry and keeps its
+// record current until ctx is done. It returns nil if the registry is not
+// available, as portal runs without it, unless the portal is named: portal
+// stop and portal logs find named portals through the registry.
+func registerSession(ctx context.Context, cfg *config.Config, proxyServer *proxy.Server, logger *zap.Logger) *session.Registration {
+	dir, err := session.DefaultDir()
+	if err != nil {
+		if cfg.Name != "" {
+			logger.Fatal("Failed to register named session",
+				logging.Component("session_registry"),
+				logging.Error(err),
+			)
+		}
+		logger.Warn("Session will not be listed by portal status",
+			logging.Component("session_registry"),
+			logging.Error(err),
+		)
+		return nil
+	}
+
+	logFile := cfg.LogFile
+	if logFile != "" {
+		if abs, err := filepath.Abs(logFile); err == nil {
+			logFile = abs
+		}
+	}
+	registration, err := session.Register(dir, session.Record{
+		Name:      cfg.Name,
+		LogFile:   logFile,
+		MountPath: cfg.GetSetPath(),
+		Target:    sessionTarget(proxyServer.Target()),
+		Profile:   cfg.Profile,
+		Readiness: model.EndpointReadinessStarting,
+	})
+	if err != nil {
+		if cfg.Name != "" {
+			logger.Fatal("Failed to register named session",
+				logging.Component("session_registry"),
+				logging.Error(err),
+			)
+		}
+		logger.Warn("Session will not be listed by portal status",
+			logging.Component("session_registry"),
+			logging.Error(err),
+		)
+		return nil
+	}
+
+	// Readiness is saved as soon as it changes, as portal start waits on it.
+	proxyServer.AddEndpointListener(func(state model.EndpointState) {
+		if err := registration.Update(func(record *session.Record) {
+			applyEndpointState(record, state)
+		}); err != nil {
+			logger.Warn("Failed to update session record",
+				logging.Component("session_registry"),
+				logging.Error(err),
+			)
+		}
+	})
+	go registration.Refresh(ctx, sessionRefreshInterval, func(record *session.Record) {
+		applyEndpointState(record, proxyServer.GetEndpointState())
+		record.Target = sessionTarget(proxyServer.Target())
+		record.Requests, _, _, _, _, _ = proxyServer.GetStats()
+	})
+	return registration
+}
+
+// sessionTarget describes where requests go, for the TARGET column.
+func sessionTarget(mode model.ServerMode, port int) string {
+	if mode == model.ModeMock {
+		return "mock"
+	}
+	return fmt.Sprintf("localhost:%d", port)
+}
+
+// applyEndpointState copies what portal status shows of state to record.
+func applyEndpointState(record *session.Record, state model.EndpointState) {
+	record.Readiness = state.Readiness
+	record.Mode = state.Mode
+	record.Exposure = state.Exposure
+	record.URL = state.ServiceURL
+	record.WebUIURL = state.WebUIURL
+	record.Error = ""
+	if state.Readiness == model.EndpointReadinessFailed {
+		// Failures record their reason here.
+		record.Error = state.WebUIReason
+	}
+}
+
+// statusReport is what portal status prints.
+type statusReport struct {
+	Sessions        []statusSession        `json:"sessions"`
+	UnownedEntries  []tailscale.ServeEntry `json:"unowned_serve_entries"`
+	DaemonAvailable bool                   `json:"daemon_available"`
+}
+
+// statusSession is a running portal and the serve entries it added.
+type statusSession struct {
+	session.Record
+	UptimeSeconds int64 `json:"uptime_seconds"`
+	ServeEntries  int   `json:"serve_entries"`
+}
+
+// runStatus prints the running portal sessions and the serve entries no
+// running portal owns, and returns the process exit code.
+func runStatus(cfg *config.Config) int {
+	dir, err := session.DefaultDir()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	records, err := session.List(dir)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// The serve config is only checked with a local daemon; tsnet portals
+	// have their own devices.
+	var sc *ipn.ServeConfig
+	var serveSessions []*tailscale.ServeSession
+	tsClient := tailscale.NewClient(zap.NewNop())
+	if tsClient.IsAvailable(ctx) {
+		sc, err = tsClient.ServeConfig(ctx)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			return 1
+		}
+		if tsClient.StateDir() != "" {
+			serveSessions, err = tailscale.LoadServeSessions(tsClient.StateDir())
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+				return 1
+			}
+		}
+	}
+
+	report := buildStatus(records, serveSessions, sc, time.Now())
+	if cfg.JSONOutput {
+		err = writeStatusJSON(os.Stdout, report)
+	} else {
+		err = writeStatus(os.Stdout, report)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+// buildStatus joins the session registry with the serve config. sc is nil
+// when no local daemon is reachable.
+func buildStatus(records []session.Record, serveSessions []*tailscale.ServeSession, sc *ipn.ServeConfig, now time.Time) statusReport {
+	report := statusReport{
+		Sessions:        make([]statusSession, 0, len(records)),
+		UnownedEntries:  []tailscale.ServeEntry{},
+		DaemonAvailable: sc != nil,
+	}
+
+	for _, record := range records {
+		entries := 0
+		for _, serveSession := range serveSessions {
+			if serveSession.PID == record.PID {
+				entries += len(serveSession.Entries)
+			}
+		}
+		report.Sessions = append(report.Sessions, statusSession{
+			Record:        record,
+			UptimeSeconds: int64(now.Sub(record.StartedAt).Seconds()),
+			ServeEntries:  entries,
+		})
+	}
+
+	if sc != nil {
+		live := slices.DeleteFunc(slices.Clone(serveSessions), func(serveSession *tailscale.ServeSession) bool {
+			return !serveSession.Live()
+		})
+		report.UnownedEntries = tailscale.UnownedServeEntries(sc, live)
+	}
+	return report
+}
+
+func writeStatusJSON(w io.Writer, report statusReport) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(report)
+}
+
+func writeStatus(w io.Writer, report statusReport) error {
+	if len(report.Sessions) == 0 {
+		fmt.Fprintln(w, "No running portal sessions.")
+	} else {
+		tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "NAME\tPID\tURL\tEXPOSURE\tPATH\tTARGET\tUPTIME\tREQUESTS")
+		for _, s := range report.Sessions {
+			url := s.URL
+			if s.Readiness != model.EndpointReadinessReady || url == "" {
+				url = "(" + s.Readiness + ")"
+			}
+			exposure := s.Exposure
+			if exposure == "" {
+				exposure = "-"
+			}
+			name := s.Name
+			if name == "" {
+				name = "-"
+			}
+			uptime := (time.Duration(s.UptimeSeconds) * time.Second).String()
+			fmt.Fprintf(tw, "%s\t%d\t%s\t%s\t%s\t%s\t%s\t%d\n", name, s.PID, url, exposure, s.MountPath, s.Target, uptime, s.Requests)
+		}
+		if err := tw.Flush(); err != nil {
+			return err
+		}
+	}
+
+	if !report.DaemonAvailable {
+		fmt.Fprintln(w, "\nLocal tailscaled is not reachable; serve entries were not checked.")
+		return nil
+	}
+	if len(report.UnownedEntries) == 0 {
+		return nil
+	}
+
+	fmt.Fprintln(w, "\nServe entries not owned by a running portal:")
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	for _, entry := range report.UnownedEntries {
+		fmt.Fprintf(tw, "  %s\t%s\n", entry, describeServeValue(entry))
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	fmt.Fprintln(w, "Set up by hand, or left by a portal that did not exit cleanly; see portal --cleanup-serve.")
+	return nil
+}
+
+// describeServeValue says where a serve entry sends traffic.
+func describeServeValue(entry tailscale.ServeEntry) string {
+	switch entry.Kind {
+	case tailscale.ServeEntryWeb:
+		var handler ipn.HTTPHandler
+		if json.Unmarshal(entry.Value, &handler) == nil {
+			switch {
+			case handler.Proxy != "":
+				return "proxy " + handler.Proxy
+			case handler.Path != "":
+				return "files " + handler.Path
+			case handler.Text != "":
+				return "text"
+			}
+		}
+	case tailscale.ServeEntryTCP:
+		var handler ipn.TCPPortHandler
+		if json.Unmarshal(entry.Value, &handler) == nil {
+			switch {
+			case handler.TCPForward != "":
+				return "forward " + handler.TCPForward
+			case handler.HTTPS:
+				return "https"
+			case handler.HTTP:
+				return "http"
+			}
+		}
+	case tailscale.ServeEntryFunnel:
+		var allowed bool
+		if json.Unmarshal(entry.Value, &allowed) == nil && allowed {
+			return "on"
+		}
+		return "off"
+	}
+	return string(entry.Value)
+}
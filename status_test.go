@@ -0,0 +1,104 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"tailscale.com/ipn"
+
+	"github.com/jaxxstorm/portal/internal/model"
+	"github.com/jaxxstorm/portal/internal/session"
+	"github.com/jaxxstorm/portal/internal/tailscale"
+)
+
+func TestBuildStatusFlagsUnownedServeEntries(t *testing.T) {
+	const host = "node.example.ts.net"
+	portalHandler := &ipn.HTTPHandler{Proxy: "http://localhost:50000"}
+	sc := new(ipn.ServeConfig)
+	sc.SetWebHandler(portalHandler, host, 443, "/", true, "")
+	sc.SetWebHandler(&ipn.HTTPHandler{Proxy: "http://localhost:3000"}, host, 8443, "/", true, "")
+
+	encode := func(v any) json.RawMessage {
+		data, err := json.Marshal(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return data
+	}
+	now := time.Now()
+	records := []session.Record{{
+		PID:       os.Getpid(),
+		Name:      "api",
+		StartedAt: now.Add(-90 * time.Second),
+		Readiness: model.EndpointReadinessReady,
+		Mode:      "local_daemon",
+		Exposure:  "tailnet",
+		URL:       "https://" + host + "/",
+		MountPath: "/",
+		Target:    "localhost:8080",
+		Requests:  7,
+	}}
+	serveSessions := []*tailscale.ServeSession{{
+		PID: os.Getpid(),
+		Entries: []tailscale.ServeEntry{
+			{Kind: tailscale.ServeEntryTCP, Port: 443, Value: encode(sc.TCP[443])},
+			{Kind: tailscale.ServeEntryWeb, HostPort: host + ":443", Path: "/", Value: encode(portalHandler)},
+		},
+	}}
+
+	report := buildStatus(records, serveSessions, sc, now)
+	if len(report.Sessions) != 1 || report.Sessions[0].UptimeSeconds != 90 || report.Sessions[0].ServeEntries != 2 {
+		t.Fatalf("unexpected sessions %+v", report.Sessions)
+	}
+	if len(report.UnownedEntries) != 2 {
+		t.Fatalf("expected the hand-made tcp and web entries on 8443 to be unowned, got %v", report.UnownedEntries)
+	}
+	for _, entry := range report.UnownedEntries {
+		if !strings.Contains(entry.String(), "8443") {
+			t.Fatalf("expected only 8443 entries to be unowned, got %s", entry)
+		}
+	}
+
+	var out bytes.Buffer
+	if err := writeStatus(&out, report); err != nil {
+		t.Fatalf("writeStatus failed: %v", err)
+	}
+	for _, want := range []string{"NAME", "api", "https://" + host + "/", "tailnet", "localhost:8080", "1m30s", "not owned by a running portal", "proxy http://localhost:3000"} {
+		if !strings.Contains(out.String(), want) {
+			t.Fatalf("expected status output to contain %q, got:\n%s", want, out.String())
+		}
+	}
+
+	out.Reset()
+	if err := writeStatusJSON(&out, report); err != nil {
+		t.Fatalf("writeStatusJSON failed: %v", err)
+	}
+	var decoded struct {
+		Sessions []struct {
+			PID      int `json:"pid"`
+			Requests int `json:"requests"`
+		} `json:"sessions"`
+		Unowned         []map[string]any `json:"unowned_serve_entries"`
+		DaemonAvailable bool             `json:"daemon_available"`
+	}
+	if err := json.Unmarshal(out.Bytes(), &decoded); err != nil {
+		t.Fatalf("expected valid JSON, got %v:\n%s", err, out.String())
+	}
+	if len(decoded.Sessions) != 1 || decoded.Sessions[0].Requests != 7 || len(decoded.Unowned) != 2 || !decoded.DaemonAvailable {
+		t.Fatalf("unexpected JSON report %+v", decoded)
+	}
+}
+
+func TestWriteStatusWithoutDaemonOrSessions(t *testing.T) {
+	var out bytes.Buffer
+	if err := writeStatus(&out, buildStatus(nil, nil, nil, time.Now())); err != nil {
+		t.Fatalf("writeStatus failed: %v", err)
+	}
+	if !strings.Contains(out.String(), "No running portal sessions.") || !strings.Contains(out.String(), "not reachable") {
+		t.Fatalf("unexpected output:\n%s", out.String())
+	}
+}
@@ -2,6 +2,10 @@ const state = {
   requests: [],
   stats: null,
   health: null,
+  timeseries: null,
+  connections: null,
+  talkers: null,
+  blocklist: null,
   filter: "",
   selectedId: null,
   requestTab: "summary",
@@ -17,6 +21,7 @@ document.addEventListener("DOMContentLoaded", init)
 function init() {
   wireNavigation()
   wireInspectControls()
+  wireBlocklistControls()
   wireTabs("request-tabs", (tab) => {
     state.requestTab = tab
     renderDetail()
@@ -67,6 +72,55 @@ function wireInspectControls() {
   })
 }
 
+function wireBlocklistControls() {
+  const form = document.getElementById("blocklist-form")
+  const input = document.getElementById("blocklist-entry")
+  form.addEventListener("submit", async (event) => {
+    event.preventDefault()
+    const entry = input.value.trim()
+    if (entry === "") {
+      return
+    }
+    try {
+      const response = await fetch(apiURL("blocklist"), {
+        method: "POST",
+        headers: { "Content-Type": "application/json" },
+        body: JSON.stringify({ entry, reason: "blocked from web UI" })
+      })
+      const payload = await response.json()
+      if (!response.ok) {
+        throw new Error(payload.error || "block failed")
+      }
+      input.value = ""
+      setBlocklistStatus(response.status === 201 ? `Blocked ${payload.prefix}` : `${payload.prefix} is already blocked`)
+      poll()
+    } catch (error) {
+      setBlocklistStatus(error.message)
+    }
+  })
+
+  document.getElementById("blocklist-table").addEventListener("click", async (event) => {
+    const button = event.target.closest("button[data-entry]")
+    if (!button) {
+      return
+    }
+    try {
+      const response = await fetch(apiURL(`blocklist?entry=${encodeURIComponent(button.dataset.entry)}`), { method: "DELETE" })
+      if (!response.ok && response.status !== 204) {
+        throw new Error("unblock failed")
+      }
+      setBlocklistStatus(`Unblocked ${button.dataset.entry}`)
+      poll()
+    } catch (error) {
+      setBlocklistStatus(error.message)
+    }
+  })
+}
+
+function setBlocklistStatus(message) {
+  document.getElementById("blocklist-status").textContent = message
+}
+
 function wireTabs(containerId, onSelect) {
   const container = document.getElementById(containerId)
   if (!container) {
@@ -83,15 +137,23 @@ function wireTabs(containerId, onSelect) {
 
 async function poll() {
   try {
-    const [requests, stats, health] = await Promise.all([
+    const [requests, stats, health, timeseries, connections, talkers, blocklist] = await Promise.all([
       fetchJSON(apiURL("requests")),
       fetchJSON(apiURL("stats")),
-      fetchJSON(apiURL("health"))
+      fetchJSON(apiURL("health")),
+      fetchJSON(apiURL("timeseries?window=15m&step=10s")),
+      fetchJSON(apiURL("connections")),
+      fetchJSON(apiURL("talkers?limit=10")),
+      fetchJSON(apiURL("blocklist")).catch(() => null)
     ])
 
     state.requests = (Array.isArray(requests) ? requests : []).slice().reverse()
     state.stats = stats || {}
     state.health = health || {}
+    state.timeseries = timeseries || {}
+    state.connections = connections || {}
+    state.talkers = Array.isArray(talkers) ? talkers : []
+    state.blocklist = Array.isArray(blocklist) ? blocklist : null
     state.lastUpdatedAt = Date.now()
 
     const hasCurrentSelection = state.requests.some((request) => request.id === state.selectedId)
@@ -154,11 +216,11 @@ function renderRequestList() {
     const statusCode = Number(request.status_code || request.response?.status_code || 0)
     const statusClass = statusCode >= 400 ? "status-err" : "status-ok"
     const durationMs = nsToMs(request.duration)
-    const rowLabel = `${request.method || "-"} ${request.url || "/"} status ${statusCode || "unknown"} duration ${formatMs(durationMs)} milliseconds`
+    const rowLabel = `${request.method || "-"} ${requestLabel(request)} status ${statusCode || "unknown"} duration ${formatMs(durationMs)} milliseconds`
     return `
       <button type="button" class="request-row ${isActive}" data-id="${escapeHtml(request.id)}" aria-pressed="${request.id === state.selectedId}" aria-label="${escapeHtml(rowLabel)}">
         <span class="method-badge">${escapeHtml(request.method || "-")}</span>
-        <div class="request-path">${escapeHtml(request.url || "/")}</div>
+        <div class="request-path" title="${escapeHtml(request.url || "/")}">${escapeHtml(requestLabel(request))}</div>
         <div class="status-pill ${statusClass}">${escapeHtml(String(statusCode || "-"))}</div>
         <div class="request-meta">${formatMs(durationMs)} ms</div>
       </button>
@@ -191,7 +253,9 @@ function renderDetail() {
   detailNode.classList.remove("hidden")
 
   const statusCode = Number(selected.status_code || selected.response?.status_code || 0)
-  document.getElementById("selected-title").textContent = `${selected.method || "-"} ${selected.url || "/"}`
+  document.getElementById("selected-title").textContent = selected.graphql
+    ? graphQLLabel(selected.graphql)
+    : `${selected.method || "-"} ${selected.url || "/"}`
   document.getElementById("selected-meta").textContent = [
     statusCode > 0 ? `status ${statusCode}` : "status n/a",
     `${formatMs(nsToMs(selected.duration))} ms`,
@@ -211,16 +275,23 @@ function renderRequestTab(request, tab) {
     case "raw":
       return `<pre class="mono-block">${escapeHtml(renderRawRequest(request))}</pre>`
     case "body":
-      return `<pre class="mono-block">${escapeHtml(renderRequestBody(request))}</pre>`
+      return `<pre class="mono-block">${escapeHtml(renderStructuredBody(request))}</pre>`
     default:
       return renderSummaryGrid([
         ["ID", request.id || "-"],
+        ["Request ID", request.request_id || "-"],
+        ["Trace ID", request.trace_id || "-"],
         ["Method", request.method || "-"],
         ["URL", request.url || "-"],
         ["Remote", request.remote_addr || "-"],
+        ["Source IP", request.source_ip || "-"],
+        ["Source Network", formatGeo(request.geo) || "-"],
+        ["Access Rule", request.access_rule || "-"],
         ["User-Agent", request.user_agent || "-"],
         ["Content-Type", request.content_type || "-"],
-        ["Body Size", `${request.size || 0} bytes`]
+        ["Body Size", `${request.size || 0} bytes`],
+        ["Body Format", request.body_view?.kind || "raw"],
+        ...renderGraphQLSummary(request.graphql)
       ])
   }
 }
@@ -240,6 +311,8 @@ function renderResponseTab(request, tab) {
         ["Duration", `${formatMs(nsToMs(request.duration))} ms`],
         ["Response Size", `${response.size || 0} bytes`],
         ["Content-Type", response.headers?.["Content-Type"] || "-"],
+        ["Content-Encoding", response.content_encoding || "-"],
+        ["Decoded Size", `${response.decoded_size || 0} bytes`],
         ["Body Captured", response.body ? "yes" : "no"],
         ["Body Truncated", response.body_truncated ? "yes" : "no"]
       ])
@@ -288,6 +361,41 @@ function renderRequestBody(request) {
   return body === "" ? "(empty request body)" : body
 }
 
+function renderStructuredBody(request) {
+  const view = request.body_view
+  if (!view) {
+    return renderRequestBody(request)
+  }
+
+  let text = ""
+  switch (view.kind) {
+    case "json":
+    case "xml":
+      text = view.pretty || ""
+      break
+    case "form":
+      text = (view.fields || []).map((field) => `${field.name} = ${field.value}`).join("\n")
+      break
+    case "multipart":
+      text = (view.parts || []).map((part, index) => {
+        const label = part.name || `part ${index + 1}`
+        const details = [part.content_type, `${part.size || 0} bytes`].filter(Boolean).join(", ")
+        if (part.filename) {
+          return `[${label}] file=${part.filename} (${details})`
+        }
+        return part.preview ? `[${label}] (${details})\n  ${part.preview}` : `[${label}] (${details})`
+      }).join("\n")
+      break
+    default:
+      return renderRequestBody(request)
+  }
+
+  if (view.error) {
+    text = `${text}\n\n[parse error: ${view.error}]`
+  }
+  return text.trim() === "" ? renderRequestBody(request) : text
+}
+
 function renderResponseBody(response) {
   const body = typeof response.body === "string" ? response.body : ""
   if (body === "") {
@@ -315,7 +423,7 @@ function renderStatusView() {
 
   const metricsTable = document.getElementById("metrics-table")
   metricsTable.innerHTML = [
-    ["Open Connections", String(stats.open_connections || 0)],
+    ["In-Flight Requests", String(stats.open_connections || 0)],
     ["Avg Latency 1m", `${formatMs(stats.avg_response_time_1m)} ms`],
     ["Avg Latency 5m", `${formatMs(stats.avg_response_time_5m)} ms`],
     ["P50 Latency", `${formatMs(stats.p50_response_time)} ms`],
@@ -327,10 +435,135 @@ function renderStatusView() {
     ["Error Rate", `${formatPercent(metrics.errorRate)}%`]
   ].map(([k, v]) => `<tr><td>${escapeHtml(k)}</td><td>${escapeHtml(v)}</td></tr>`).join("")
 
+  document.getElementById("latency-table").innerHTML = renderLatencyWindows(stats.latency_windows)
+  renderTimeSeries(state.timeseries)
+  renderConnections(state.connections || {})
+  document.getElementById("talkers-table").innerHTML = renderTopTalkers(state.talkers || [])
+  document.getElementById("blocklist-table").innerHTML = renderBlocklist(state.blocklist)
+
   document.getElementById("method-breakdown").innerHTML = renderBreakdown(metrics.methodCounts)
   document.getElementById("status-breakdown").innerHTML = renderBreakdown(metrics.statusCounts)
 }
 
+function renderTimeSeries(series) {
+  const points = Array.isArray(series?.points) ? series.points : []
+  const requests = points.map((point) => point.requests || 0)
+  const errors = points.map((point) => (point.client_errors || 0) + (point.server_errors || 0))
+  const p95 = points.map((point) => point.p95_ms || 0)
+
+  document.getElementById("throughput-chart").innerHTML = renderBarChart(requests, errors)
+  document.getElementById("latency-chart").innerHTML = renderLineChart(p95)
+
+  const totalRequests = requests.reduce((sum, value) => sum + value, 0)
+  const peakP95 = p95.reduce((max, value) => Math.max(max, value), 0)
+  document.getElementById("timeseries-meta").textContent =
+    `last ${series?.window || "15m"} by ${series?.step || "10s"} · ${totalRequests} requests · peak p95 ${formatMs(peakP95)} ms`
+}
+
+function renderBarChart(values, highlights) {
+  if (values.length === 0) {
+    return ""
+  }
+  const max = Math.max(1, ...values)
+  const width = 100 / values.length
+  return values.map((value, index) => {
+    const height = (value / max) * 100
+    const errorHeight = (Math.min(highlights[index] || 0, value) / max) * 100
+    const x = index * width
+    return `<rect class="chart-bar" x="${x}" y="${100 - height}" width="${width * 0.8}" height="${height}"></rect>` +
+      `<rect class="chart-bar-error" x="${x}" y="${100 - errorHeight}" width="${width * 0.8}" height="${errorHeight}"></rect>`
+  }).join("")
+}
+
+function renderLineChart(values) {
+  if (values.length === 0) {
+    return ""
+  }
+  const max = Math.max(1, ...values)
+  const step = values.length > 1 ? 100 / (values.length - 1) : 0
+  const coords = values.map((value, index) => `${index * step},${100 - (value / max) * 100}`).join(" ")
+  return `<polyline class="chart-line" points="${coords}"></polyline>`
+}
+
+function renderConnections(connections) {
+  document.getElementById("connections-table").innerHTML = [
+    ["Open", String(connections.open || 0)],
+    ["Active / Idle", `${connections.active || 0} / ${connections.idle || 0}`],
+    ["Accepted", String(connections.accepted_total || 0)],
+    ["Hijacked", String(connections.hijacked_total || 0)],
+    ["Requests / Connection", Number(connections.requests_per_connection || 0).toFixed(1)],
+    ["Oldest Connection", formatUptime((connections.max_age_seconds || 0) * 1000)]
+  ].map(([k, v]) => `<tr><td>${escapeHtml(k)}</td><td>${escapeHtml(v)}</td></tr>`).join("")
+
+  const peers = Array.isArray(connections.peers) ? connections.peers : []
+  document.getElementById("peers-table").innerHTML = peers.length === 0
+    ? `<tr><td colspan="4" class="muted">No open connections.</td></tr>`
+    : peers.map((peer) => {
+      const cells = [
+        peer.address,
+        String(peer.open || 0),
+        String(peer.requests || 0),
+        formatUptime((peer.oldest_age_seconds || 0) * 1000)
+      ]
+      return `<tr>${cells.map((cell) => `<td>${escapeHtml(cell)}</td>`).join("")}</tr>`
+    }).join("")
+}
+
+function renderTopTalkers(talkers) {
+  if (talkers.length === 0) {
+    return `<tr><td colspan="6" class="muted">No data yet.</td></tr>`
+  }
+  return talkers.map((talker) => {
+    const topPath = Array.isArray(talker.paths) && talker.paths.length > 0 ? talker.paths[0].value : ""
+    const cells = [
+      talker.ip,
+      String(talker.requests || 0),
+      `${formatPercent(talker.error_rate)}%`,
+      String(talker.allowlist_denied || 0),
+      timeAgo(toMs(talker.last_seen)),
+      topPath
+    ]
+    return `<tr>${cells.map((cell) => `<td>${escapeHtml(cell)}</td>`).join("")}</tr>`
+  }).join("")
+}
+
+function renderBlocklist(entries) {
+  if (entries === null) {
+    return `<tr><td colspan="5" class="muted">Blocklist not available.</td></tr>`
+  }
+  if (entries.length === 0) {
+    return `<tr><td colspan="5" class="muted">No blocked sources.</td></tr>`
+  }
+  return entries.map((entry) => {
+    const cells = [
+      entry.prefix,
+      entry.source,
+      entry.reason || "",
+      timeAgo(toMs(entry.created_at))
+    ]
+    return `<tr>${cells.map((cell) => `<td>${escapeHtml(cell)}</td>`).join("")}` +
+      `<td><button type="button" class="btn-secondary" data-entry="${escapeHtml(entry.prefix)}">Unblock</button></td></tr>`
+  }).join("")
+}
+
+function renderLatencyWindows(windows) {
+  if (!Array.isArray(windows) || windows.length === 0) {
+    return `<tr><td colspan="7" class="muted">No data yet.</td></tr>`
+  }
+  return windows.map((window) => {
+    const cells = [
+      window.window,
+      String(window.count || 0),
+      `${formatMs(window.p50_ms)} ms`,
+      `${formatMs(window.p90_ms)} ms`,
+      `${formatMs(window.p95_ms)} ms`,
+      `${formatMs(window.p99_ms)} ms`,
+      `${formatMs(window.max_ms)} ms`
+    ]
+    return `<tr>${cells.map((cell) => `<td>${escapeHtml(cell)}</td>`).join("")}</tr>`
+  }).join("")
+}
+
 function renderBreakdown(counts) {
   const entries = Object.entries(counts || {}).sort((a, b) => b[1] - a[1])
   if (entries.length === 0) {
@@ -353,13 +586,39 @@ function filteredRequests() {
       request.method || "",
       request.url || "",
       request.remote_addr || "",
+      request.source_ip || "",
+      request.geo?.country || "",
       request.user_agent || "",
+      request.graphql?.operation_type || "",
+      request.graphql?.operation_name || "",
       statusCode
     ].join(" ").toLowerCase()
     return haystack.includes(query)
   })
 }
 
+function requestLabel(request) {
+  return request.graphql ? graphQLLabel(request.graphql) : request.url || "/"
+}
+
+function graphQLLabel(operation) {
+  return [operation.operation_type || "query", operation.operation_name].filter(Boolean).join(" ")
+}
+
+function renderGraphQLSummary(operation) {
+  if (!operation) {
+    return []
+  }
+  const rows = [
+    ["GraphQL Operation", graphQLLabel(operation)],
+    ["GraphQL Variables", operation.variables ? JSON.stringify(operation.variables) : "-"]
+  ]
+  if (Array.isArray(operation.errors) && operation.errors.length > 0) {
+    rows.push(["GraphQL Errors", operation.errors.join("; ")])
+  }
+  return rows
+}
+
 function currentSelectedRequest() {
   if (!state.selectedId) {
     return null
@@ -484,6 +743,14 @@ function formatUptime(ms) {
   return `${seconds}s`
 }
 
+function formatGeo(geo) {
+  if (!geo) {
+    return ""
+  }
+  const network = [geo.asn ? `AS${geo.asn}` : "", geo.as_org || ""].filter(Boolean).join(" ")
+  return [geo.country || "", network].filter(Boolean).join(" · ")
+}
+
 function escapeHtml(value) {
   return String(value)
     .replaceAll("&", "&amp;")